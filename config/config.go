@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The config file read when -config isn't given. It's fine for it not to exist.
+const DefaultFile = "sfwr.toml"
+
+// Everything about a site that used to be hard-coded. Anything left out of the file keeps its default.
+type Config struct {
+	Site   Site   `toml:"site"`
+	Paths  Paths  `toml:"paths"`
+	Lists  Lists  `toml:"lists"`
+	Pages  Pages  `toml:"pages"`
+	Covers Covers `toml:"covers"`
+	Auth   Auth   `toml:"auth"`
+	Trash  Trash  `toml:"trash"`
+
+	File string `toml:"-"` // Where the config was read from, empty when using the defaults
+}
+
+type Site struct {
+	Title string `toml:"title"`
+	Url   string `toml:"url"` // The public address of the site, needed for feeds
+}
+
+type Paths struct {
+	Database       string `toml:"database"`
+	OutputDir      string `toml:"output_dir"`
+	SavedImagesDir string `toml:"saved_images_dir"` // Cover images kept with the database
+	SiteImagesDir  string `toml:"site_images_dir"`  // Where covers go within the output directory
+	TemplateDir    string `toml:"template_dir"`
+}
+
+type Lists struct {
+	HomePage      int    `toml:"home_page"`
+	HomePageOrder string `toml:"home_page_order"` // "added" or "finished"
+	Feed          int    `toml:"feed"`
+	SearchResults int    `toml:"search_results"`
+}
+
+// Which optional page families -build generates. Book pages and the home page are always made.
+type Pages struct {
+	BookLists  bool `toml:"book_lists"` // All books by publication date, as a list and a grid
+	Authors    bool `toml:"authors"`
+	Decades    bool `toml:"decades"`
+	Series     bool `toml:"series"`
+	Tags       bool `toml:"tags"`
+	Formats    bool `toml:"formats"` // Books by how they were published, like ebook only
+	ReadingLog bool `toml:"reading_log"`
+	Search     bool `toml:"search"`
+	Feeds      bool `toml:"feeds"`
+}
+
+// How -getimages downloads covers from Open Library.
+type Covers struct {
+	Workers           int     `toml:"workers"`
+	RequestsPerSecond float64 `toml:"requests_per_second"`
+	Burst             int     `toml:"burst"` // Requests allowed at once after a pause
+}
+
+// Who may use the web server. The passwords and API tokens are kept, hashed, in the credentials
+// file rather than here, so this file can be committed with the site.
+type Auth struct {
+	CredentialsFile string `toml:"credentials_file"`
+	SessionHours    int    `toml:"session_hours"` // How long a login lasts
+}
+
+// How long deleted books and authors can be restored before the web server purges them.
+type Trash struct {
+	PurgeAfterDays int `toml:"purge_after_days"` // 0 keeps them until they're purged by hand
+}
+
+func Default() Config {
+	return Config{
+		Site: Site{
+			Title: "SF Worth Reading",
+		},
+		Paths: Paths{
+			Database:       "sfwr_database.db",
+			OutputDir:      "output/public",
+			SavedImagesDir: "saved_cover_images",
+			SiteImagesDir:  "images/cover_images",
+			TemplateDir:    "templates",
+		},
+		Lists: Lists{
+			HomePage:      25,
+			HomePageOrder: "added",
+			Feed:          25,
+			SearchResults: 50,
+		},
+		Pages: Pages{
+			BookLists:  true,
+			Authors:    true,
+			Decades:    true,
+			Series:     true,
+			Tags:       true,
+			Formats:    true,
+			ReadingLog: true,
+			Search:     true,
+			Feeds:      true,
+		},
+		Covers: Covers{
+			Workers:           4,
+			RequestsPerSecond: 1,
+			Burst:             3,
+		},
+		Auth: Auth{
+			CredentialsFile: ".sfwr_credentials.toml",
+			SessionHours:    168,
+		},
+		Trash: Trash{
+			PurgeAfterDays: 30,
+		},
+	}
+}
+
+// Reads the config file over the defaults. A missing file is only an error when required,
+// which it is when the user named it with -config.
+func Load(file string, required bool) (Config, error) {
+	cfg := Default()
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) && !required {
+		return cfg, nil
+	}
+	meta, err := toml.DecodeFile(file, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("can't read config %s: %w", file, err)
+	}
+	// Misspelled settings would otherwise be silently ignored
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		var keys []string
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return cfg, fmt.Errorf("unknown settings in config %s: %s", file, strings.Join(keys, ", "))
+	}
+	cfg.File = file
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	var problems []string
+	for name, value := range map[string]string{
+		"paths.database":         c.Paths.Database,
+		"paths.output_dir":       c.Paths.OutputDir,
+		"paths.saved_images_dir": c.Paths.SavedImagesDir,
+		"paths.site_images_dir":  c.Paths.SiteImagesDir,
+		"paths.template_dir":     c.Paths.TemplateDir,
+		"auth.credentials_file":  c.Auth.CredentialsFile,
+	} {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, name+" is empty")
+		}
+	}
+	if path.IsAbs(c.Paths.SiteImagesDir) || strings.HasPrefix(path.Clean(c.Paths.SiteImagesDir), "..") {
+		problems = append(problems, "paths.site_images_dir must be inside the output directory")
+	}
+	if c.Lists.HomePageOrder != "added" && c.Lists.HomePageOrder != "finished" {
+		problems = append(problems, fmt.Sprintf("lists.home_page_order is '%s', use 'added' or 'finished'", c.Lists.HomePageOrder))
+	}
+	for name, size := range map[string]int{
+		"lists.home_page":      c.Lists.HomePage,
+		"lists.feed":           c.Lists.Feed,
+		"lists.search_results": c.Lists.SearchResults,
+		"covers.workers":       c.Covers.Workers,
+		"covers.burst":         c.Covers.Burst,
+		"auth.session_hours":   c.Auth.SessionHours,
+	} {
+		if size < 1 {
+			problems = append(problems, fmt.Sprintf("%s must be at least 1", name))
+		}
+	}
+	if c.Trash.PurgeAfterDays < 0 {
+		problems = append(problems, "trash.purge_after_days can't be negative")
+	}
+	if c.Covers.RequestsPerSecond <= 0 {
+		problems = append(problems, "covers.requests_per_second must be more than 0")
+	}
+	if c.Site.Url != "" && !strings.HasPrefix(c.Site.Url, "http://") && !strings.HasPrefix(c.Site.Url, "https://") {
+		problems = append(problems, fmt.Sprintf("site.url '%s' must start with http:// or https://", c.Site.Url))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	// Map iteration order varies, keep the message stable
+	sort.Strings(problems)
+	return errors.New("config problems: " + strings.Join(problems, "; "))
+}
+
+// Feeds need absolute links, so they're only made, and linked to, when the site's public URL is known.
+func (c Config) FeedsEnabled() bool {
+	return c.Pages.Feeds && c.Site.Url != ""
+}
+
+func (c Config) Template(name string) string {
+	return path.Join(c.Paths.TemplateDir, name)
+}
+
+func (c Config) WebTemplate(name string) string {
+	return path.Join(c.Paths.TemplateDir, "web", name)
+}
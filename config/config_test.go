@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	file := filepath.Join(t.TempDir(), "sfwr.toml")
+	if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "sfwr.toml")
+
+	cfg, err := Load(missing, false)
+	if err != nil {
+		t.Fatalf("A missing default config should give the defaults, got %v", err)
+	}
+	if cfg != Default() {
+		t.Errorf("Expected the defaults, got %+v", cfg)
+	}
+
+	if _, err := Load(missing, true); err == nil {
+		t.Error("Expected an error for a missing config named with -config")
+	}
+}
+
+func TestLoadOverridesDefaults(t *testing.T) {
+	file := writeConfig(t, `
+[site]
+title = "Books I Liked"
+url = "https://books.example.com"
+
+[paths]
+output_dir = "public"
+
+[lists]
+home_page = 10
+
+[pages]
+tags = false
+`)
+	cfg, err := Load(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Site.Title != "Books I Liked" || cfg.Site.Url != "https://books.example.com" {
+		t.Errorf("Site settings not read: %+v", cfg.Site)
+	}
+	if cfg.Paths.OutputDir != "public" || cfg.Lists.HomePage != 10 || cfg.Pages.Tags {
+		t.Errorf("Settings not read: %+v", cfg)
+	}
+	// Anything not in the file keeps its default
+	if cfg.Paths.Database != "sfwr_database.db" || cfg.Lists.Feed != 25 || !cfg.Pages.Authors {
+		t.Errorf("Defaults lost: %+v", cfg)
+	}
+	if cfg.File != file {
+		t.Errorf("Expected File to be %s, got %s", file, cfg.File)
+	}
+	if cfg.Template("index.html") != "templates/index.html" || cfg.WebTemplate("base.html") != "templates/web/base.html" {
+		t.Errorf("Unexpected template paths %s, %s", cfg.Template("index.html"), cfg.WebTemplate("base.html"))
+	}
+}
+
+func TestLoadRejectsMistakes(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		{"misspelled setting", "[pages]\ntagz = false\n", "pages.tagz"},
+		{"bad toml", "[site\n", "can't read config"},
+		{"wrong type", "[lists]\nhome_page = \"ten\"\n", "can't read config"},
+		{"unknown home page order", "[lists]\nhome_page_order = \"rating\"\n", "home_page_order"},
+		{"empty list", "[lists]\nfeed = 0\n", "lists.feed must be at least 1"},
+		{"relative url", "[site]\nurl = \"books.example.com\"\n", "site.url"},
+		{"images outside site", "[paths]\nsite_images_dir = \"../images\"\n", "site_images_dir"},
+		{"empty path", "[paths]\ndatabase = \"\"\n", "paths.database is empty"},
+		{"no cover workers", "[covers]\nworkers = 0\n", "covers.workers must be at least 1"},
+		{"unlimited covers", "[covers]\nrequests_per_second = 0.0\n", "covers.requests_per_second"},
+		{"sessions that never last", "[auth]\nsession_hours = 0\n", "auth.session_hours must be at least 1"},
+		{"no credentials file", "[auth]\ncredentials_file = \"\"\n", "auth.credentials_file is empty"},
+		{"negative trash age", "[trash]\npurge_after_days = -1\n", "trash.purge_after_days"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, test.contents), true)
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("Expected an error mentioning %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
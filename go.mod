@@ -4,6 +4,19 @@ go 1.22.5
 
 require gorm.io/gorm v1.25.11
 
+require github.com/BurntSushi/toml v1.4.0
+
+require (
+	github.com/chai2010/webp v1.4.0
+	golang.org/x/image v0.18.0
+	golang.org/x/time v0.6.0
+)
+
+require (
+	golang.org/x/crypto v0.26.0
+	golang.org/x/term v0.23.0
+)
+
 require (
 	github.com/Jeffail/gabs/v2 v2.6.1 // indirect
 	github.com/Open-pi/gol v0.1.1 // direct
@@ -11,7 +24,7 @@ require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/sys v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 	gorm.io/driver/sqlite v1.5.6 // direct
-
 )
@@ -40,12 +40,7 @@ func setupIntegrationTest(t *testing.T) (string, *gorm.DB, func()) {
 	}
 
 	// Migrate schema
-	err = db.AutoMigrate(
-		&models.Book{},
-		&models.Author{},
-		&models.OpenLibraryBookAuthor{},
-		&models.OpenLibraryBookIsbn{},
-	)
+	err = models.MigrateSchema(db)
 	if err != nil {
 		t.Fatal("Failed to migrate test database:", err)
 	}
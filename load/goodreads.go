@@ -0,0 +1,112 @@
+package load
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// One row of a Goodreads library export (My Books > Import and export). Only the
+// columns sfwr can use are kept, as the raw text from the file.
+type GoodreadsBook struct {
+	Line                    int // Line in the CSV file, the header being line 1
+	Title                   string
+	Author                  string
+	Isbn                    string
+	Isbn13                  string
+	MyRating                string
+	Binding                 string
+	DateRead                string
+	DateAdded               string
+	Bookshelves             string
+	ExclusiveShelf          string
+	MyReview                string
+	OriginalPublicationYear string
+	YearPublished           string
+}
+
+func (b GoodreadsBook) Print() {
+	fmt.Println("----------------------------------------------")
+	fmt.Println("Title: " + b.Title)
+	fmt.Println("by " + b.Author)
+	fmt.Println("First published: " + b.OriginalPublicationYear)
+	fmt.Println("My rating: " + b.MyRating)
+	fmt.Println()
+}
+
+func LoadGoodreadsCsv(filename string) ([]GoodreadsBook, error) {
+	csvFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer csvFile.Close()
+	return ParseGoodreadsCsv(csvFile)
+}
+
+// Columns are found by their header names, so the order of columns in the export doesn't matter.
+// Only Title and Author are required.
+func ParseGoodreadsCsv(r io.Reader) ([]GoodreadsBook, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read Goodreads CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, required := range []string{"Title", "Author"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errors.New("not a Goodreads export, missing column: " + required)
+		}
+	}
+
+	var books []GoodreadsBook
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return books, fmt.Errorf("can't read Goodreads CSV line %d: %w", line, err)
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return cleanGoodreadsValue(record[i])
+		}
+		books = append(books, GoodreadsBook{
+			Line:                    line,
+			Title:                   field("Title"),
+			Author:                  field("Author"),
+			Isbn:                    field("ISBN"),
+			Isbn13:                  field("ISBN13"),
+			MyRating:                field("My Rating"),
+			Binding:                 field("Binding"),
+			DateRead:                field("Date Read"),
+			DateAdded:               field("Date Added"),
+			Bookshelves:             field("Bookshelves"),
+			ExclusiveShelf:          field("Exclusive Shelf"),
+			MyReview:                field("My Review"),
+			OriginalPublicationYear: field("Original Publication Year"),
+			YearPublished:           field("Year Published"),
+		})
+	}
+	return books, nil
+}
+
+// Goodreads writes ISBNs as spreadsheet formulas like ="0441013597" so leading zeros survive.
+func cleanGoodreadsValue(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "=\"") && strings.HasSuffix(value, "\"") {
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "=\""), "\"")
+	}
+	return strings.TrimSpace(value)
+}
@@ -1,6 +1,7 @@
 package load
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,20 +10,60 @@ import (
 
 const Verbose bool = false
 
+// Optional fields are omitted when empty so exported files stay close to hand-written ones.
 type RawBook struct {
-	PubDate          json.Number `json:"pub_date"`
-	Author           string
-	Title            []string
-	Review           string
-	Rating           string
-	AmazonLink       string `json:"amazon_link"`
-	CoverImage       string `json:"cover_image"`
-	OpenLibrary      string `json:"open_library"`
-	Isfdb            string
-	Isbn             []string
-	OlCoverId        json.Number `json:"ol_cover_id"`
-	OlAuthorId       []string    `json:"ol_author_id"`
-	OlCoverEditionId string      `json:"ol_cover_edition_id"`
+	Author           string              `json:"author"`
+	Title            []string            `json:"title"`
+	Review           string              `json:"review,omitempty"`
+	Rating           string              `json:"rating"`
+	Format           string              `json:"format,omitempty"`
+	PubDate          json.Number         `json:"pub_date,omitempty"`
+	AmazonLink       string              `json:"amazon_link,omitempty"`
+	CoverImage       string              `json:"cover_image,omitempty"`
+	OpenLibrary      string              `json:"open_library,omitempty"`
+	Isfdb            string              `json:"isfdb,omitempty"`
+	Isbn             []string            `json:"isbn,omitempty"`
+	OlCoverId        json.Number         `json:"ol_cover_id,omitempty"`
+	OlAuthorId       []string            `json:"ol_author_id,omitempty"`
+	OlCoverEditionId string              `json:"ol_cover_edition_id,omitempty"`
+	UploadedCoverId  json.Number         `json:"uploaded_cover_id,omitempty"` // Set when the cover shown was uploaded
+	DateAdded        string              `json:"date_added,omitempty"`        // RFC 3339; missing in older files
+	Tags             []string            `json:"tags,omitempty"`
+	Series           []RawSeriesEntry    `json:"series,omitempty"`
+	ReadingSessions  []RawReadingSession `json:"reading_sessions,omitempty"`
+	Credits          []RawCredit         `json:"credits,omitempty"`  // Only when there's more than the one author
+	Contents         []RawContentsEntry  `json:"contents,omitempty"` // An anthology or collection's stories, in order
+}
+
+// A story in a book's contents. Stories are matched by title and first author, so one reprinted in
+// several books is only made once.
+type RawContentsEntry struct {
+	Title   string      `json:"title"`
+	Authors []string    `json:"authors"`
+	PubDate json.Number `json:"pub_date,omitempty"`
+	Length  string      `json:"length,omitempty"` // "short_story", "novelette" or "novella"; short_story when left out
+	Rating  string      `json:"rating,omitempty"` // Left out when the story isn't rated
+}
+
+// Someone credited for a book, in the order the book gives them. The first is the book's author.
+type RawCredit struct {
+	Name string `json:"name"`
+	Role string `json:"role"` // Like "author", "co_author", "editor", "translator" or "illustrator"
+}
+
+// A book's place in a series. Series are matched by name, and made when there's none by that name.
+type RawSeriesEntry struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Position    float64 `json:"position"`
+}
+
+// One reading of a book. Dates are YYYY-MM-DD and left out when unknown.
+type RawReadingSession struct {
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Note     string `json:"note,omitempty"`
 }
 
 func (b RawBook) Print() {
@@ -42,6 +83,55 @@ func (b RawBook) Print() {
 // The keys are author names, the values are their books
 type BookMap map[string][]RawBook
 
+// The rating of a book that hasn't been rated yet, like an unstarred Goodreads book. It's never
+// one of the scheme's levels.
+const NotRated = "Not Rated"
+
+// Exported files keep the rating scheme their books were rated with under this key, which sorts
+// before any author. Files without it use the scheme of the database they're loaded into.
+const RatingLevelsKey = "$rating_levels"
+
+// One step of the rating scheme, best first by sort order.
+type RawRatingLevel struct {
+	Slug        string `json:"slug"`
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
+	SortOrder   int    `json:"sort_order"`
+	Score       int    `json:"score,omitempty"`
+	CssClass    string `json:"css_class,omitempty"`
+}
+
+// Exported files keep authors' other names under this key, listed by author, since an author with
+// aliases may have no books of their own.
+const AuthorAliasesKey = "$author_aliases"
+
+// Another name of an author's.
+type RawAuthorAlias struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`                // "pseudonym", "variant" or "birth_name"
+	SiteName string `json:"site_name,omitempty"` // The page the name had on the site as an author of its own
+}
+
+// The keys are author names, the values their aliases.
+type AliasMap map[string][]RawAuthorAlias
+
+// The top level of a book file, with each author's books in one map and the rating scheme and
+// aliases in the other, all left undecoded.
+func splitBookJson(data []byte) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	extras := make(map[string]json.RawMessage)
+	for _, key := range []string{RatingLevelsKey, AuthorAliasesKey} {
+		if raw, ok := doc[key]; ok {
+			extras[key] = raw
+			delete(doc, key)
+		}
+	}
+	return doc, extras, nil
+}
+
 func loadBooks(bookDatabase string) BookMap {
 	json_file, file_err := os.Open(bookDatabase)
 	check("Error opening JSON book database file.", file_err)
@@ -49,12 +139,48 @@ func loadBooks(bookDatabase string) BookMap {
 	defer json_file.Close()
 	byteValue, _ := io.ReadAll(json_file)
 
-	bookData := make(BookMap)
-	err := json.Unmarshal(byteValue, &bookData)
+	doc, _, err := splitBookJson(byteValue)
 	check("Error unmarshalling book data.", err)
+	bookData := make(BookMap)
+	for author, books := range doc {
+		var rawBooks []RawBook
+		err := json.Unmarshal(books, &rawBooks)
+		check("Error unmarshalling book data.", err)
+		bookData[author] = rawBooks
+	}
 	return bookData
 }
 
+// The rating scheme saved in a book file, or nothing if it doesn't have one.
+func RatingLevelsFromJsonFile(bookFile string) ([]RawRatingLevel, error) {
+	data, err := os.ReadFile(bookFile)
+	if err != nil {
+		return nil, err
+	}
+	_, extras, err := splitBookJson(data)
+	if err != nil || extras[RatingLevelsKey] == nil {
+		return nil, err
+	}
+	var levels []RawRatingLevel
+	err = json.Unmarshal(extras[RatingLevelsKey], &levels)
+	return levels, err
+}
+
+// The authors' aliases saved in a book file, or nothing if it doesn't have any.
+func AuthorAliasesFromJsonFile(bookFile string) (AliasMap, error) {
+	data, err := os.ReadFile(bookFile)
+	if err != nil {
+		return nil, err
+	}
+	_, extras, err := splitBookJson(data)
+	if err != nil || extras[AuthorAliasesKey] == nil {
+		return nil, err
+	}
+	var aliases AliasMap
+	err = json.Unmarshal(extras[AuthorAliasesKey], &aliases)
+	return aliases, err
+}
+
 func DumpMarshalledBookData(bookData BookMap) {
 	for author, books := range bookData {
 		if Verbose {
@@ -68,10 +194,42 @@ func DumpMarshalledBookData(bookData BookMap) {
 	}
 }
 
-func MarshalledBookDataFromJsonFile(bookFile string) BookMap {
-	bookData := loadBooks(bookFile)
-	// TODO Do some checking on the data
-	return bookData
+// Validates the whole file before loading it, and if there are any errors prints every
+// problem found before giving up. Ratings are checked against the file's own scheme when it has one.
+func MarshalledBookDataFromJsonFile(bookFile string, knownRatings []string) BookMap {
+	problems, err := ValidateBookJsonFile(bookFile, knownRatings)
+	check("Error reading JSON book database file.", err)
+	if errorCount := CountValidationErrors(problems); errorCount > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		check("Invalid JSON book database file.", fmt.Errorf("%d errors in %s", errorCount, bookFile))
+	}
+	return loadBooks(bookFile)
+}
+
+// Writes book data in the same shape MarshalledBookDataFromJsonFile reads, with the rating scheme
+// and aliases when there are any. Authors come out in alphabetical order, so exports of the same
+// data are identical and diff cleanly.
+func SaveBookDataToJsonFile(bookData BookMap, ratingLevels []RawRatingLevel, aliases AliasMap, bookFile string) error {
+	contents := make(map[string]any, len(bookData)+2)
+	for author, books := range bookData {
+		contents[author] = books
+	}
+	if len(ratingLevels) > 0 {
+		contents[RatingLevelsKey] = ratingLevels
+	}
+	if len(aliases) > 0 {
+		contents[AuthorAliasesKey] = aliases
+	}
+	var doc bytes.Buffer
+	encoder := json.NewEncoder(&doc)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(contents); err != nil {
+		return err
+	}
+	return os.WriteFile(bookFile, doc.Bytes(), 0644)
 }
 
 func check(msg string, err error) {
@@ -0,0 +1,368 @@
+package load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Something wrong with one book in a book file. Errors would break loading the file;
+// warnings are worth fixing but the book still loads.
+type ValidationProblem struct {
+	Author  string // The key the book is listed under
+	Index   int    // Position of the book in the author's list
+	Field   string
+	Message string
+	Warning bool
+}
+
+func (p ValidationProblem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	location := fmt.Sprintf("%q[%d]", p.Author, p.Index)
+	if p.Field != "" {
+		location += "." + p.Field
+	}
+	return fmt.Sprintf("%s: %s: %s", severity, location, p.Message)
+}
+
+func CountValidationErrors(problems []ValidationProblem) int {
+	count := 0
+	for _, p := range problems {
+		if !p.Warning {
+			count++
+		}
+	}
+	return count
+}
+
+var knownBookFields = map[string]bool{
+	"author": true, "title": true, "review": true, "rating": true, "pub_date": true,
+	"amazon_link": true, "cover_image": true, "open_library": true, "isfdb": true, "isbn": true,
+	"ol_cover_id": true, "ol_author_id": true, "ol_cover_edition_id": true, "date_added": true,
+	"uploaded_cover_id": true, "format": true, "tags": true, "series": true, "reading_sessions": true,
+	"credits": true, "contents": true,
+}
+
+var optionalTextFields = []string{"review", "amazon_link", "cover_image", "open_library", "isfdb", "ol_cover_edition_id"}
+
+func ValidateBookJsonFile(bookFile string, knownRatings []string) ([]ValidationProblem, error) {
+	data, err := os.ReadFile(bookFile)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateBookJson(data, knownRatings)
+}
+
+// Checks every book rather than stopping at the first problem. The error is only for files
+// that aren't a JSON object of author names to lists of books at all. Ratings must be in the
+// file's own rating scheme, or in knownRatings when it doesn't have one.
+func ValidateBookJson(data []byte, knownRatings []string) ([]ValidationProblem, error) {
+	const notBooks = "not a book file, expected author names mapped to lists of books: %w"
+	doc, extras, err := splitBookJson(data)
+	if err != nil {
+		return nil, fmt.Errorf(notBooks, err)
+	}
+	authors := make(map[string][]map[string]json.RawMessage, len(doc))
+	for author, books := range doc {
+		var list []map[string]json.RawMessage
+		if err := json.Unmarshal(books, &list); err != nil {
+			return nil, fmt.Errorf(notBooks, err)
+		}
+		authors[author] = list
+	}
+
+	var problems []ValidationProblem
+	if rawLevels := extras[RatingLevelsKey]; rawLevels != nil {
+		var levels []RawRatingLevel
+		if err := json.Unmarshal(rawLevels, &levels); err != nil {
+			problems = append(problems, ValidationProblem{Author: RatingLevelsKey, Message: "must be a list of rating levels"})
+		}
+		knownRatings = nil
+		for i, level := range levels {
+			if strings.TrimSpace(level.Slug) == "" || strings.TrimSpace(level.Label) == "" {
+				problems = append(problems, ValidationProblem{Author: RatingLevelsKey, Index: i, Message: "needs a slug and a label"})
+			}
+			knownRatings = append(knownRatings, level.Slug)
+		}
+	}
+
+	if rawAliases := extras[AuthorAliasesKey]; rawAliases != nil {
+		var aliases AliasMap
+		if err := json.Unmarshal(rawAliases, &aliases); err != nil {
+			problems = append(problems, ValidationProblem{Author: AuthorAliasesKey, Message: "must map author names to lists of aliases"})
+		}
+		var names []string
+		for author := range aliases {
+			names = append(names, author)
+		}
+		sort.Strings(names)
+		for _, author := range names {
+			for i, alias := range aliases[author] {
+				if strings.TrimSpace(alias.Name) == "" {
+					problems = append(problems, ValidationProblem{Author: AuthorAliasesKey, Index: i, Field: author, Message: "needs a name"})
+				}
+			}
+		}
+	}
+
+	ratings := map[string]bool{NotRated: true}
+	for _, r := range knownRatings {
+		ratings[r] = true
+	}
+
+	var keys []string
+	for author := range authors {
+		keys = append(keys, author)
+	}
+	sort.Strings(keys)
+
+	for _, author := range keys {
+		for index, book := range authors[author] {
+			v := bookValidator{author: author, index: index, book: book}
+			v.validate(ratings)
+			problems = append(problems, v.problems...)
+		}
+	}
+	return problems, nil
+}
+
+type bookValidator struct {
+	author   string
+	index    int
+	book     map[string]json.RawMessage
+	problems []ValidationProblem
+}
+
+func (v *bookValidator) report(field string, warning bool, format string, args ...any) {
+	v.problems = append(v.problems, ValidationProblem{
+		Author:  v.author,
+		Index:   v.index,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+		Warning: warning,
+	})
+}
+
+func (v *bookValidator) validate(ratings map[string]bool) {
+	if v.book == nil {
+		v.report("", false, "book is null")
+		return
+	}
+
+	var author string
+	if !v.has("author") {
+		v.report("author", false, "is missing")
+	} else if err := v.decode("author", &author); err != nil {
+		v.report("author", false, "must be text")
+	} else if author != v.author {
+		v.report("author", false, "%q doesn't match the author it's listed under", author)
+	}
+
+	var title []*string
+	if err := v.decode("title", &title); err != nil {
+		v.report("title", false, "must be a list of text, the title then an optional subtitle")
+	} else if len(title) == 0 || title[0] == nil || strings.TrimSpace(*title[0]) == "" {
+		v.report("title", false, "is empty")
+	} else if len(title) > 2 {
+		v.report("title", true, "has %d parts, only the title and subtitle are used", len(title))
+	}
+
+	var rating string
+	if !v.has("rating") {
+		v.report("rating", false, "is missing")
+	} else if err := v.decode("rating", &rating); err != nil {
+		v.report("rating", false, "must be text")
+	} else if !ratings[rating] {
+		v.report("rating", false, "unknown rating %q", rating)
+	}
+
+	v.validateWholeNumber("pub_date", "year")
+	v.validateWholeNumber("ol_cover_id", "cover ID")
+	v.validateWholeNumber("uploaded_cover_id", "uploaded cover ID")
+
+	for _, field := range optionalTextFields {
+		var text *string
+		if err := v.decode(field, &text); err != nil {
+			v.report(field, false, "must be text")
+		}
+	}
+
+	var olAuthorIds []*string
+	if err := v.decode("ol_author_id", &olAuthorIds); err != nil {
+		v.report("ol_author_id", false, "must be a list of text")
+	}
+
+	var isbns []*string
+	if err := v.decode("isbn", &isbns); err != nil {
+		v.report("isbn", false, "must be a list of text")
+	} else {
+		for _, isbn := range isbns {
+			if isbn == nil {
+				v.report("isbn", false, "contains null")
+			} else if message := checkIsbn(*isbn); message != "" {
+				v.report("isbn", true, "%q %s", *isbn, message)
+			}
+		}
+	}
+
+	var dateAdded *string
+	if err := v.decode("date_added", &dateAdded); err != nil {
+		v.report("date_added", false, "must be text")
+	} else if dateAdded != nil {
+		if _, err := time.Parse(time.RFC3339Nano, *dateAdded); err != nil {
+			v.report("date_added", false, "%q isn't an RFC 3339 date and time", *dateAdded)
+		}
+	}
+
+	var tags []*string
+	if err := v.decode("tags", &tags); err != nil {
+		v.report("tags", false, "must be a list of text")
+	}
+
+	var series []*RawSeriesEntry
+	if err := v.decode("series", &series); err != nil {
+		v.report("series", false, "must be a list of series names and positions")
+	} else {
+		for _, s := range series {
+			if s == nil || strings.TrimSpace(s.Name) == "" {
+				v.report("series", false, "has a series without a name")
+			}
+		}
+	}
+
+	var sessions []*RawReadingSession
+	if err := v.decode("reading_sessions", &sessions); err != nil {
+		v.report("reading_sessions", false, "must be a list of reading sessions")
+	} else {
+		for _, s := range sessions {
+			if s == nil || (s.Started == "" && s.Finished == "") {
+				v.report("reading_sessions", false, "has a session without a start or finish date")
+				continue
+			}
+			for _, date := range []string{s.Started, s.Finished} {
+				if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+					v.report("reading_sessions", false, "%q isn't a YYYY-MM-DD date", date)
+				}
+			}
+		}
+	}
+
+	var credits []*RawCredit
+	if err := v.decode("credits", &credits); err != nil {
+		v.report("credits", false, "must be a list of names and roles")
+	} else {
+		for i, c := range credits {
+			if c == nil || strings.TrimSpace(c.Name) == "" {
+				v.report("credits", false, "has a credit without a name")
+			} else if i == 0 && c.Name != v.author {
+				v.report("credits", false, "the first credit, %q, doesn't match the author it's listed under", c.Name)
+			}
+		}
+	}
+
+	var contents []*RawContentsEntry
+	if err := v.decode("contents", &contents); err != nil {
+		v.report("contents", false, "must be a list of stories with their titles and authors")
+	} else {
+		for _, c := range contents {
+			if c == nil || strings.TrimSpace(c.Title) == "" || len(c.Authors) == 0 {
+				v.report("contents", false, "has a story without a title or authors")
+			} else if _, err := c.PubDate.Int64(); c.PubDate != "" && err != nil {
+				v.report("contents", false, "%q has a year that isn't a whole number: %s", c.Title, c.PubDate)
+			} else if c.Rating != "" && (!ratings[c.Rating] || c.Rating == NotRated) {
+				v.report("contents", false, "%q has an unknown rating %q", c.Title, c.Rating)
+			}
+		}
+	}
+
+	var unknown []string
+	for field := range v.book {
+		if !knownBookFields[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		v.report(field, true, "unknown field, it will be ignored")
+	}
+}
+
+func (v *bookValidator) has(field string) bool {
+	_, ok := v.book[field]
+	return ok
+}
+
+// Missing fields and nulls decode to nothing without an error.
+func (v *bookValidator) decode(field string, target any) error {
+	raw, ok := v.book[field]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, target)
+}
+
+// Numbers may be written bare or quoted; either way they must be whole. Null means unknown.
+func (v *bookValidator) validateWholeNumber(field string, description string) {
+	raw, ok := v.book[field]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		v.report(field, false, "%s isn't a number: %s", description, raw)
+		return
+	}
+	if _, err := number.Int64(); err != nil {
+		v.report(field, false, "%s isn't a whole number: %s", description, number)
+	}
+}
+
+// Returns what's wrong with an ISBN-10 or ISBN-13, or nothing if it's fine. Hyphens and spaces are allowed.
+func checkIsbn(isbn string) string {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, c := range digits {
+			var value int
+			switch {
+			case c >= '0' && c <= '9':
+				value = int(c - '0')
+			case (c == 'X' || c == 'x') && i == 9:
+				value = 10
+			default:
+				return "has characters that aren't allowed in an ISBN-10"
+			}
+			sum += (10 - i) * value
+		}
+		if sum%11 != 0 {
+			return "fails the ISBN-10 checksum"
+		}
+	case 13:
+		if _, err := strconv.ParseUint(digits, 10, 64); err != nil {
+			return "has characters that aren't allowed in an ISBN-13"
+		}
+		sum := 0
+		for i, c := range digits {
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += weight * int(c-'0')
+		}
+		if sum%10 != 0 {
+			return "fails the ISBN-13 checksum"
+		}
+	default:
+		return fmt.Sprintf("has %d digits, expected 10 or 13", len(digits))
+	}
+	return ""
+}
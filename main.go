@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 
+	"github.com/ccdavis/sfwr/config"
+	"github.com/ccdavis/sfwr/load"
 	"github.com/ccdavis/sfwr/models"
 	"github.com/ccdavis/sfwr/pages"
 	"github.com/ccdavis/sfwr/tui"
 	"github.com/ccdavis/sfwr/web"
+	"golang.org/x/term"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -24,7 +30,6 @@ func check(e error) {
 }
 
 const Verbose bool = false
-const GeneratedSiteDir string = "output/public"
 
 func readBooksJson(filename string) ([]models.Book, []string) {
 	var allBooks []models.Book
@@ -38,50 +43,133 @@ func readBooksJson(filename string) ([]models.Book, []string) {
 	return allBooks, authors
 }
 
-func generateSite(books []models.Book, authors []models.Author, outputDir string) {
+func generateSite(books []models.Book, authors []models.Author, series []models.Series, cfg config.Config) {
+	outputDir := cfg.Paths.OutputDir
 	err := os.MkdirAll(outputDir, 0775)
 	if err != nil {
 		log.Fatal("Can't create output directory for generated site: ", outputDir)
 	}
 
 	fmt.Println("Generate static pages...")
-	indexPage := pages.RenderBookListPage("templates/index.html", pages.BooksMostRecentlyAdded(books, 25))
+	homePage, err := pages.HomePageBooks(books, cfg.Lists.HomePageOrder, cfg.Lists.HomePage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexPage := pages.RenderHomePage(cfg.Template("index.html"), homePage)
 	check(os.WriteFile(path.Join(outputDir, "index.html"), []byte(indexPage), 0644))
 
-	byPubDate := pages.RenderBookListPage("templates/book_list.html", pages.BooksByPublicationDate(books))
-	check(os.WriteFile(path.Join(outputDir, "book_list_by_pub_date.html"), []byte(byPubDate), 0644))
+	if cfg.Pages.BookLists {
+		byPubDate := pages.RenderBookListPage(cfg.Template("book_list.html"), pages.BooksByPublicationDate(books))
+		check(os.WriteFile(path.Join(outputDir, "book_list_by_pub_date.html"), []byte(byPubDate), 0644))
 
-	bookGrid := pages.RenderBookListPage("templates/book_boxes.html", pages.BooksByPublicationDate(books))
-	check(os.WriteFile(path.Join(outputDir, "book_boxes_by_pub_date.html"), []byte(bookGrid), 0644))
+		bookGrid := pages.RenderBookListPage(cfg.Template("book_boxes.html"), pages.BooksByPublicationDate(books))
+		check(os.WriteFile(path.Join(outputDir, "book_boxes_by_pub_date.html"), []byte(bookGrid), 0644))
+	}
 
-	authorIndex := pages.RenderAuthorIndexPage("templates/author_index.html", authors)
-	check(os.WriteFile(path.Join(outputDir, "author_index.html"), []byte(authorIndex), 0644))
-	for _, a := range authors {
-		authorPage := pages.RenderAuthorPage("templates/author.html", a)
-		err = os.MkdirAll(path.Join(outputDir, "authors"), 0775)
-		if err != nil {
-			log.Fatal("Can't create output directory for generated site: ", outputDir)
+	if cfg.Pages.Authors {
+		authorIndex := pages.RenderAuthorIndexPage(cfg.Template("author_index.html"), authors)
+		check(os.WriteFile(path.Join(outputDir, "author_index.html"), []byte(authorIndex), 0644))
+		for _, a := range pages.AuthorPages(authors) {
+			authorPage := pages.RenderAuthorPage(cfg.Template("author.html"), a)
+			err = os.MkdirAll(path.Join(outputDir, "authors"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for generated site: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "authors", a.SiteName()), []byte(authorPage), 0644))
+			// Links to the pages of authors merged into this one still work
+			for _, alias := range a.Aliases {
+				if alias.SiteName != "" && alias.SiteName != a.SiteName() {
+					check(os.WriteFile(path.Join(outputDir, "authors", alias.SiteName), []byte(pages.RenderRedirectPage(a.SiteName())), 0644))
+				}
+			}
 		}
-		check(os.WriteFile(path.Join(outputDir, "authors", a.SiteName()), []byte(authorPage), 0644))
 	}
 
-	decadesIndex := pages.RenderDecadesIndexPage("templates/decades_index.html", books)
-	check(os.WriteFile(path.Join(outputDir, "decades_index.html"), []byte(decadesIndex), 0644))
-	groupedBooks := pages.BooksByDecade(books)
-	for decade, decadeBooks := range groupedBooks {
-		decadePage := pages.RenderDecadePage("templates/decade.html", decadeBooks, decade)
-		err = os.MkdirAll(path.Join(outputDir, "decades"), 0775)
-		if err != nil {
-			log.Fatal("Can't create output directory for decades: ", outputDir)
+	if cfg.Pages.Decades {
+		decadesIndex := pages.RenderDecadesIndexPage(cfg.Template("decades_index.html"), books)
+		check(os.WriteFile(path.Join(outputDir, "decades_index.html"), []byte(decadesIndex), 0644))
+		groupedBooks := pages.BooksByDecade(books)
+		for decade, decadeBooks := range groupedBooks {
+			decadePage := pages.RenderDecadePage(cfg.Template("decade.html"), decadeBooks, decade)
+			err = os.MkdirAll(path.Join(outputDir, "decades"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for decades: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "decades", decade+".html"), []byte(decadePage), 0644))
+		}
+	}
+
+	if cfg.Pages.Tags {
+		tagsIndex := pages.RenderTagsIndexPage(cfg.Template("tags_index.html"), books)
+		check(os.WriteFile(path.Join(outputDir, "tags_index.html"), []byte(tagsIndex), 0644))
+		for _, tagInfo := range pages.GroupBooksByTag(books) {
+			tagPage := pages.RenderTagPage(cfg.Template("tag.html"), tagInfo)
+			err = os.MkdirAll(path.Join(outputDir, "tags"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for tags: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "tags", tagInfo.Tag.SiteName()), []byte(tagPage), 0644))
+		}
+	}
+
+	if cfg.Pages.Formats {
+		formatsIndex := pages.RenderFormatsIndexPage(cfg.Template("formats_index.html"), books)
+		check(os.WriteFile(path.Join(outputDir, "formats_index.html"), []byte(formatsIndex), 0644))
+		for _, formatInfo := range pages.GroupBooksByFormat(books) {
+			formatPage := pages.RenderFormatPage(cfg.Template("format.html"), formatInfo)
+			err = os.MkdirAll(path.Join(outputDir, "formats"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for formats: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "formats", formatInfo.Format.SiteName()), []byte(formatPage), 0644))
+		}
+	}
+
+	if cfg.Pages.ReadingLog {
+		readingIndex := pages.RenderReadingYearsIndexPage(cfg.Template("read_index.html"), books)
+		check(os.WriteFile(path.Join(outputDir, "read_index.html"), []byte(readingIndex), 0644))
+		for _, yearInfo := range pages.GroupReadingsByYear(books) {
+			yearPage := pages.RenderReadingYearPage(cfg.Template("read_year.html"), yearInfo)
+			err = os.MkdirAll(path.Join(outputDir, "read"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for reading log: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "read", yearInfo.Year+".html"), []byte(yearPage), 0644))
+		}
+	}
+
+	if cfg.FeedsEnabled() {
+		generateFeeds(books, authors, cfg)
+	} else if cfg.Pages.Feeds {
+		fmt.Println("No site url in the config or -site-url, so the Atom and RSS feeds are left out.")
+	}
+
+	if cfg.Pages.Search {
+		searchPage := pages.RenderSearchPage(cfg.Template("search.html"))
+		check(os.WriteFile(path.Join(outputDir, "search.html"), []byte(searchPage), 0644))
+		searchIndex, err := pages.SearchIndexJson(books)
+		check(err)
+		check(os.WriteFile(path.Join(outputDir, "search_index.json"), searchIndex, 0644))
+	}
+
+	if cfg.Pages.Series {
+		seriesIndex := pages.RenderSeriesIndexPage(cfg.Template("series_index.html"), series)
+		check(os.WriteFile(path.Join(outputDir, "series_index.html"), []byte(seriesIndex), 0644))
+		for _, s := range series {
+			seriesPage := pages.RenderSeriesPage(cfg.Template("series.html"), s)
+			err = os.MkdirAll(path.Join(outputDir, "series"), 0775)
+			if err != nil {
+				log.Fatal("Can't create output directory for series: ", outputDir)
+			}
+			check(os.WriteFile(path.Join(outputDir, "series", s.SiteName()), []byte(seriesPage), 0644))
 		}
-		check(os.WriteFile(path.Join(outputDir, "decades", decade+".html"), []byte(decadePage), 0644))
 	}
 
 	for _, b := range books {
 		//fmt.Println("Make page for ", b.AuthorFullName, ": ", b.FormatTitle())
 		//fmt.Println("Rating ", b.Rating)
 
-		bookPage := pages.RenderBookPage("templates/book.html", b)
+		bookPage := pages.RenderBookPage(cfg.Template("book.html"), b)
 		err = os.MkdirAll(path.Join(outputDir, "books"), 0775)
 		if err != nil {
 			log.Fatal("Can't create output directory for generated site: ", outputDir)
@@ -90,6 +178,34 @@ func generateSite(books []models.Book, authors []models.Author, outputDir string
 	}
 }
 
+// Writes the site's feeds and one for each author. Only called when cfg.FeedsEnabled().
+func generateFeeds(books []models.Book, authors []models.Author, cfg config.Config) {
+	outputDir := cfg.Paths.OutputDir
+	title := cfg.Site.Title
+	siteFeed := pages.NewFeedInfo(title+": New Books", title, cfg.Site.Url, "feed.xml", "index.html", books, cfg.Lists.Feed)
+	atom, err := pages.RenderAtomFeed(siteFeed)
+	check(err)
+	check(os.WriteFile(path.Join(outputDir, "feed.xml"), atom, 0644))
+
+	siteFeed.FeedPath = "rss.xml"
+	rss, err := pages.RenderRssFeed(siteFeed)
+	check(err)
+	check(os.WriteFile(path.Join(outputDir, "rss.xml"), rss, 0644))
+
+	// Author feeds are linked from the author pages
+	if !cfg.Pages.Authors {
+		return
+	}
+	check(os.MkdirAll(path.Join(outputDir, "authors"), 0775))
+	for _, a := range authors {
+		authorFeed := pages.NewFeedInfo(title+": "+a.FullName, title, cfg.Site.Url,
+			pages.AuthorFeedPath(a), "authors/"+a.SiteName(), a.Books, cfg.Lists.Feed)
+		authorAtom, err := pages.RenderAtomFeed(authorFeed)
+		check(err)
+		check(os.WriteFile(path.Join(outputDir, pages.AuthorFeedPath(a)), authorAtom, 0644))
+	}
+}
+
 func loadAllBooks(db *gorm.DB) []models.Book {
 	allBooks, err := models.LoadAllBooks(db)
 	if err != nil {
@@ -105,111 +221,385 @@ func main() {
 		bookFilePtr      = flag.String("load-books", "book_database.json", "A JSON file of book data")
 		databaseNamePtr  = flag.String("createdb", "", "Create new database")
 		webPortPtr       = flag.String("web", "", "Start web server on specified port (e.g., -web=8080)")
+		validateJsonPtr  = flag.String("validate-json", "", "Check a JSON book file for problems, exiting non-zero if it has errors")
+		searchPtr        = flag.String("search", "", "Search titles, authors and reviews for the given words")
+		exportJsonPtr    = flag.String("export-json", "", "Export all books to a JSON file in the -load-books format")
+		goodreadsFilePtr = flag.String("import-goodreads", "", "Import books from a Goodreads library export CSV file")
+		siteUrlPtr       = flag.String("site-url", "", "The public address of the site, like https://example.com, used for links in the Atom and RSS feeds, overriding the config")
+		indexOrderPtr    = flag.String("index-order", "", "List books on the home page by most recently 'added' or most recently 'finished', overriding the config")
+		configFilePtr    = flag.String("config", config.DefaultFile, "The site config file")
+		setPasswordPtr   = flag.String("set-password", "", "Set the web server password for 'admin' or 'readonly', reading it from the terminal")
+		newApiTokenPtr   = flag.String("new-api-token", "", "Make a new API token for 'admin' or 'readonly', replacing the role's old one")
+		mergeAuthorPtr   = flag.Uint("merge-author", 0, "Merge the author with this ID into the one given with -into, moving their books and keeping their name as an alias")
+		intoAuthorPtr    = flag.Uint("into", 0, "With -merge-author, the ID of the author to keep")
+		convertKindlePtr = flag.String("convert-kindle", "", "Give every Kindle-rated book this rating instead, moving 'Kindle' to the book's format, then remove the Kindle rating")
+		kindleFormatPtr  = flag.String("kindle-format", "ebook_only", "With -convert-kindle, the format for Kindle-rated books that don't have one, like 'ebook_only' or 'self_published'")
+		migratePtr       = flag.String("migrate", "", "Migrate the database schema: 'up' applies pending migrations, 'down' undoes the last one and 'status' lists them")
 		saveImagesFlag   bool
 		addBookFlag      bool
 		generateSiteFlag bool
+		dryRunFlag       bool
+		resumeFlag       bool
+		duplicatesFlag   bool
 	)
 	flag.BoolVar(&saveImagesFlag, "getimages", false, "Save small, medium, and large cover images for all books with OLIDs.")
 	flag.BoolVar(&addBookFlag, "new", false, "Add a new book using the basic text interface.")
 	flag.BoolVar(&generateSiteFlag, "build", false, "Generate static site")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "With -import-goodreads, report what would be imported without saving anything.")
+	flag.BoolVar(&duplicatesFlag, "suggest-duplicate-authors", false, "List authors whose names only differ by how their initials are written, with their IDs for -merge-author.")
+	flag.BoolVar(&resumeFlag, "resume", false, "With -getimages, carry on from an interrupted run, skipping covers it found Open Library doesn't have.")
 	flag.Parse()
 	bookFile := *bookFilePtr
 
+	cfg := loadConfig(*configFilePtr, *siteUrlPtr, *indexOrderPtr)
+
+	if *validateJsonPtr != "" {
+		os.Exit(validateJson(*validateJsonPtr, cfg.Paths.Database))
+	}
+
+	if *setPasswordPtr != "" || *newApiTokenPtr != "" {
+		updateCredentials(cfg.Auth.CredentialsFile, *setPasswordPtr, *newApiTokenPtr)
+		return
+	}
+
 	if *databaseNamePtr != "" {
 		var db *gorm.DB = models.CreateBooksDatabase(*databaseNamePtr)
 		fmt.Println("Created new database.")
-		check(models.TransferJsonBooksToDatabase(bookFile, db))
+		check(models.TransferJsonBooksToDatabase(bookFile, models.WithAuditSource(db, models.AuditSourceJSON)))
 		fmt.Println("Saved all books to database.")
 	}
 
-	databaseName := "sfwr_database.db"
+	databaseName := cfg.Paths.Database
 	db, err := gorm.Open(sqlite.Open(databaseName), &gorm.Config{})
 	if err != nil {
 		log.Fatal("can't open sfwr db. Maybe you need to make it first.")
 	}
+	if err := models.LoadRatingLevels(db); err != nil {
+		log.Fatal("can't read the rating scheme: ", err)
+	}
+	db = models.WithAuditSource(db, models.AuditSourceCommandLine)
+
+	// Every command needs the current schema, so bring the database up to date first unless the
+	// migrations themselves are what's asked for.
+	if *migratePtr != "" {
+		runMigrateCommand(db, databaseName, *migratePtr)
+	} else {
+		migrateDatabase(db, databaseName)
+	}
 
-	siteCoverImagesDir := path.Join(GeneratedSiteDir, models.ImageDir)
-	savedCoverImagesDir := "saved_cover_images"
+	siteCoverImagesDir := path.Join(cfg.Paths.OutputDir, models.ImageDir)
+	savedCoverImagesDir := cfg.Paths.SavedImagesDir
+	openLibrary := models.NewOpenLibrary()
 	
+	if *searchPtr != "" {
+		setupSearch(db)
+		results, err := models.SearchBooks(db, *searchPtr, cfg.Lists.SearchResults)
+		if err != nil {
+			log.Fatal("search failed: ", err)
+		}
+		for _, r := range results {
+			fmt.Printf("%5d  %s by %s (%s)\n", r.Book.ID, r.Book.FormatTitle(), r.Book.AuthorFullName, strings.TrimSpace(r.Book.FormatPubDate()))
+			if r.Snippet != "" {
+				fmt.Println("       " + r.PlainSnippet())
+			}
+		}
+		fmt.Printf("%d books found.\n", len(results))
+	}
+
+	if *exportJsonPtr != "" {
+		check(models.TransferDatabaseBooksToJson(db, *exportJsonPtr))
+		fmt.Println("Exported all books to " + *exportJsonPtr)
+	}
+
+	if *goodreadsFilePtr != "" {
+		rows, err := load.LoadGoodreadsCsv(*goodreadsFilePtr)
+		if err != nil {
+			log.Fatal("can't read Goodreads export: ", err)
+		}
+		report, err := models.ImportGoodreadsBooks(models.WithAuditSource(db, models.AuditSourceGoodreads), rows, dryRunFlag)
+		if err != nil {
+			log.Fatal("Goodreads import failed, nothing was saved: ", err)
+		}
+		report.Print(os.Stdout)
+	}
+
+	if *mergeAuthorPtr != 0 {
+		if *intoAuthorPtr == 0 {
+			log.Fatal("-merge-author needs the ID of the author to keep with -into")
+		}
+		report, err := models.MergeAuthors(db, *intoAuthorPtr, *mergeAuthorPtr)
+		if err != nil {
+			log.Fatal("can't merge authors: ", err)
+		}
+		report.Print(os.Stdout)
+	}
+
+	if *convertKindlePtr != "" {
+		format, err := models.ParseBookFormat(*kindleFormatPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := models.ConvertKindleRatings(db, *convertKindlePtr, format)
+		if err != nil {
+			log.Fatal("can't convert Kindle ratings: ", err)
+		}
+		report.Print(os.Stdout)
+	}
+
+	if duplicatesFlag {
+		duplicates, err := models.SuggestDuplicateAuthors(db)
+		if err != nil {
+			log.Fatal("can't look for duplicate authors: ", err)
+		}
+		models.PrintDuplicateAuthors(os.Stdout, duplicates)
+	}
+
 	if saveImagesFlag {
 		allBooks := loadAllBooks(db)
 		fmt.Println("Saving cover images...")
-		models.CaptureCoverImages(allBooks, siteCoverImagesDir)
+		syncCovers(openLibrary, allBooks, savedCoverImagesDir, cfg.Covers, resumeFlag)
 	}
 
 	if generateSiteFlag {
 		allBooks := loadAllBooks(db)
 		var authors []models.Author
-		result := db.Preload("Books").Find(&authors)
+		result := db.Preload("Books.Credits.Author").Preload("Aliases").Preload("Works.Appearances.Book").Find(&authors)
 		if result.Error != nil {
 			log.Fatal("can't retrieve authors from sfwr db: ", result.Error)
 		} else {
 			fmt.Println("Retrieved ", result.RowsAffected, " author records.")
 		}
-		generateSite(allBooks, authors, GeneratedSiteDir)
-		
-		// Copy all cover images from saved_cover_images to the output directory
-		err := copyAllCoverImages(savedCoverImagesDir, siteCoverImagesDir)
+		series, seriesErr := models.LoadAllSeries(db)
+		if seriesErr != nil {
+			log.Fatal("can't retrieve series from sfwr db: ", seriesErr)
+		}
+		generateSite(allBooks, authors, series, cfg)
+
+		// The site's cover sizes are made from the saved covers, which are left as they are
+		processed, failed := models.MakeSiteCovers(savedCoverImagesDir, siteCoverImagesDir, allBooks)
+		for _, f := range failed {
+			log.Printf("Warning: can't make sizes of cover %d for \"%s\": %v", f.CoverId, f.Title, f.Err)
+		}
+		if processed > 0 {
+			fmt.Println("Made sizes of", processed, "covers for the site.")
+		}
+		removed, err := models.RemoveUnusedSiteCovers(siteCoverImagesDir, allBooks)
 		if err != nil {
-			log.Printf("Warning: Failed to copy cover images: %v", err)
+			log.Printf("Warning: can't remove unused covers from the site: %v", err)
+		}
+		if removed > 0 {
+			fmt.Println("Removed", removed, "cover files no book uses from the site.")
 		}
 	}
 
 	if *webPortPtr != "" {
-		server := web.NewWebServer(db, savedCoverImagesDir)
+		setupSearch(db)
+		credentials, err := web.LoadCredentials(cfg.Auth.CredentialsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := web.NewConfiguredWebServer(db, cfg, openLibrary)
+		server.SetCredentials(credentials)
 		log.Fatal(server.ServeHTTP(*webPortPtr))
 	}
 
 	if addBookFlag {
-		tui.MainMenuTui(db, savedCoverImagesDir)
+		tui.MainMenuTui(models.WithAuditSource(db, models.AuditSourceTUI), openLibrary, savedCoverImagesDir)
 	}
 }
 
-func copyAllCoverImages(srcDir, destDir string) error {
-	// Create destination directory if it doesn't exist
-	err := os.MkdirAll(destDir, 0775)
+// Ctrl-C stops handing out covers, lets the ones being fetched finish and still prints the summary.
+func syncCovers(provider models.MetadataProvider, books []models.Book, imageDir string, settings config.Covers, resume bool) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	covers := models.NewCoverSync(provider, imageDir, settings.Workers, settings.RequestsPerSecond, settings.Burst)
+	covers.Resume = resume
+	covers.Progress = func(done int, total int) {
+		fmt.Printf("\r%d of %d covers", done, total)
+		if done == total {
+			fmt.Println()
+		}
+	}
+	report, err := covers.Run(ctx, books)
+	if report.Interrupted {
+		fmt.Println()
+	}
+	report.Print(os.Stdout)
 	if err != nil {
-		return fmt.Errorf("failed to create destination directory %s: %v", destDir, err)
+		log.Fatal("cover download failed: ", err)
 	}
-	
-	// Read all files from source directory
-	files, err := os.ReadDir(srcDir)
+}
+
+// Reads the config, letting flags given on the command line override it, and hands it to the
+// packages that use it. The default config file doesn't have to exist; one named with -config does.
+func loadConfig(configFile string, siteUrl string, indexOrder string) config.Config {
+	required := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			required = true
+		}
+	})
+	cfg, err := config.Load(configFile, required)
 	if err != nil {
-		return fmt.Errorf("failed to read source directory %s: %v", srcDir, err)
+		log.Fatal(err)
 	}
-	
-	// Copy each .jpg file
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".jpg") {
-			srcFile := path.Join(srcDir, file.Name())
-			destFile := path.Join(destDir, file.Name())
-			
-			// Open source file
-			src, err := os.Open(srcFile)
-			if err != nil {
-				log.Printf("Warning: Failed to open source image %s: %v", srcFile, err)
-				continue
-			}
-			
-			// Create destination file
-			dest, err := os.Create(destFile)
-			if err != nil {
-				src.Close()
-				log.Printf("Warning: Failed to create destination file %s: %v", destFile, err)
-				continue
-			}
-			
-			// Copy file
-			_, err = io.Copy(dest, src)
-			src.Close()
-			dest.Close()
-			
-			if err != nil {
-				log.Printf("Warning: Failed to copy image %s: %v", srcFile, err)
-			}
+	if siteUrl != "" {
+		cfg.Site.Url = siteUrl
+	}
+	if indexOrder != "" {
+		cfg.Lists.HomePageOrder = indexOrder
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.File != "" {
+		fmt.Println("Using config " + cfg.File)
+	}
+	pages.SiteConfig = cfg
+	models.ImageDir = cfg.Paths.SiteImagesDir
+	return cfg
+}
+
+func runMigrateCommand(db *gorm.DB, databaseName string, command string) {
+	switch command {
+	case "up":
+		migrateDatabase(db, databaseName)
+	case "down":
+		backup := models.MigrationBackupName(databaseName)
+		undone, err := models.MigrateDown(db, backup)
+		if err != nil {
+			log.Fatal("can't undo the last migration: ", err)
 		}
+		fmt.Println("Backed up the database to " + backup)
+		fmt.Printf("Undid migration %d, %s\n", undone.Version, undone.Name)
+	case "status":
+	default:
+		log.Fatal("-migrate must be 'up', 'down' or 'status', not ", command)
 	}
-	
-	fmt.Printf("Copied cover images from %s to %s\n", srcDir, destDir)
-	return nil
+	if err := models.PrintMigrationStatus(os.Stdout, db); err != nil {
+		log.Fatal("can't read the applied migrations: ", err)
+	}
+}
+
+// Applies any pending migrations, backing the database up first.
+func migrateDatabase(db *gorm.DB, databaseName string) {
+	pending, err := models.PendingMigrations(db)
+	if err != nil {
+		log.Fatal("can't read the applied migrations: ", err)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	backup := models.MigrationBackupName(databaseName)
+	fmt.Printf("Backing up the database to %s before applying %d migrations\n", backup, len(pending))
+	applied, err := models.MigrateUp(db, backup)
+	for _, m := range applied {
+		fmt.Printf("Applied migration %d, %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatal("can't migrate the database, the backup has it as it was: ", err)
+	}
+	if err := models.LoadRatingLevels(db); err != nil {
+		log.Fatal("can't read the rating scheme: ", err)
+	}
+}
+
+func setupSearch(db *gorm.DB) {
+	hasFts, err := models.SetupBookSearch(db)
+	if err != nil {
+		log.Fatal("can't set up the search index: ", err)
+	}
+	if !hasFts {
+		fmt.Println("SQLite was built without FTS5 so searches won't be ranked. Build with -tags sqlite_fts5 to enable it.")
+	}
+}
+
+// Sets a password or makes an API token, keeping whatever else is in the credentials file.
+func updateCredentials(credentialsFile string, passwordRole string, tokenRole string) {
+	credentials, err := web.LoadCredentials(credentialsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if passwordRole != "" {
+		role, err := web.ParseRole(passwordRole)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := credentials.SetPassword(role, readPassword()); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Set the %s password.\n", role)
+	}
+	if tokenRole != "" {
+		role, err := web.ParseRole(tokenRole)
+		if err != nil {
+			log.Fatal(err)
+		}
+		token, err := credentials.NewAPIToken(role)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("New %s API token, it won't be shown again:\n%s\n", role, token)
+	}
+	if err := credentials.Save(credentialsFile); err != nil {
+		log.Fatal("can't save credentials: ", err)
+	}
+	fmt.Println("Saved to " + credentialsFile + ". Restart the web server to use them.")
+}
+
+// Asks twice without echoing when run from a terminal. Otherwise the password is the first line
+// of input, for scripts.
+func readPassword() string {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			log.Fatal("can't read the password: ", err)
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+	fmt.Print("Password: ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		log.Fatal("can't read the password: ", err)
+	}
+	fmt.Print("Again: ")
+	again, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		log.Fatal("can't read the password: ", err)
+	}
+	if string(password) != string(again) {
+		log.Fatal("the passwords don't match")
+	}
+	return string(password)
+}
+
+// Prints every problem in the book file and returns the exit code, so it can serve as a pre-commit check.
+// Files without their own rating scheme are checked against the database's, if there is one yet.
+func validateJson(bookFile string, databaseName string) int {
+	if _, err := os.Stat(databaseName); err == nil {
+		db, err := gorm.Open(sqlite.Open(databaseName), &gorm.Config{})
+		if err == nil {
+			err = models.LoadRatingLevels(db)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read the rating scheme from %s: %v\n", databaseName, err)
+			return 2
+		}
+	}
+	problems, err := models.ValidateJsonBooks(bookFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't validate %s: %v\n", bookFile, err)
+		return 2
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	errorCount := load.CountValidationErrors(problems)
+	fmt.Printf("%s: %d errors, %d warnings\n", bookFile, errorCount, len(problems)-errorCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// One field of a book or author changing. Hooks on Book and Author record an entry for every
+// field a save or delete changes, comparing the row before and after. A book's credits, tags and
+// series are kept in other tables, so the functions that change them record the whole list as one
+// field. Changes to many rows at once, made without the record's ID, aren't recorded.
+type AuditEntry struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time // When the change was made
+	Entity    string    `gorm:"index:idx_audit_record"` // AuditBook or AuditAuthor
+	EntityID  uint      `gorm:"index:idx_audit_record"`
+	Field     string    // The column that changed, or credits, tags or series
+	OldValue  string    // Empty when the record was created
+	NewValue  string    // Empty when the record was purged
+	Source    string    // What made the change, like "web" or "goodreads import"
+}
+
+const (
+	AuditBook   = "book"
+	AuditAuthor = "author"
+)
+
+// Where changes come from, set on the database handle with WithAuditSource.
+const (
+	AuditSourceWeb         = "web"
+	AuditSourceAPI         = "api"
+	AuditSourceTUI         = "tui"
+	AuditSourceCommandLine = "command line"
+	AuditSourceGoodreads   = "goodreads import"
+	AuditSourceJSON        = "json import"
+	AuditSourcePurge       = "trash purge"
+)
+
+type auditSourceKey struct{}
+
+// Changes made through the returned handle are recorded as coming from the source. It's kept in the
+// context rather than the statement's settings because GORM keeps the context when it saves
+// associations and opens transactions.
+func WithAuditSource(db *gorm.DB, source string) *gorm.DB {
+	return db.WithContext(context.WithValue(db.Statement.Context, auditSourceKey{}, source))
+}
+
+func auditSource(db *gorm.DB) string {
+	source, _ := db.Statement.Context.Value(auditSourceKey{}).(string)
+	return source
+}
+
+const auditTable = "audit_entries"
+
+// The columns of a table whose changes are recorded.
+type auditedTable struct {
+	entity  string
+	table   string
+	columns []string
+}
+
+var (
+	bookAudit = auditedTable{AuditBook, "books", []string{
+		"main_title", "sub_title", "author_full_name", "pub_date", "review", "rating", "format", "amazon_link",
+		"open_library_url", "isfdb_url", "ol_cover_id", "ol_cover_edition_id", "cover_source", "uploaded_cover_id",
+		"deleted_at",
+	}}
+	authorAudit = auditedTable{AuditAuthor, "authors", []string{"full_name", "surname", "deleted_at"}}
+)
+
+// Fields that follow from something else and so can't be put back on their own.
+var unrevertableFields = map[string]string{
+	"deleted_at":       "restore or delete it through the trash instead",
+	"author_full_name": "it comes from the book's credits, change those instead",
+	"credits":          "change them on the book instead",
+	"series":           "change them on the book instead",
+}
+
+// How a book's lists in other tables are written in its history, each as a single value.
+var bookListFields = map[string]func(db *gorm.DB, bookId uint) (string, error){
+	"credits": creditsAuditValue,
+	"tags":    tagsAuditValue,
+	"series":  seriesAuditValue,
+}
+
+// Everyone credited in order, with their role unless they're the author: "Larry Niven, Jerry Pournelle (co-author)".
+func creditsAuditValue(db *gorm.DB, bookId uint) (string, error) {
+	var rows []struct {
+		FullName string
+		Role     CreditRole
+	}
+	err := db.Table("book_authors").Select("authors.full_name, book_authors.role").
+		Joins("JOIN authors ON authors.id = book_authors.author_id").
+		Where("book_authors.book_id = ?", bookId).Order("book_authors.position").Scan(&rows).Error
+	var names []string
+	for _, row := range rows {
+		if row.Role != CreditAuthor {
+			row.FullName += " (" + row.Role.Label() + ")"
+		}
+		names = append(names, row.FullName)
+	}
+	return strings.Join(names, ", "), err
+}
+
+// Tag names can't contain commas, so the list can be split up again to revert it.
+func tagsAuditValue(db *gorm.DB, bookId uint) (string, error) {
+	var names []string
+	err := db.Table("book_tags").Joins("JOIN tags ON tags.id = book_tags.tag_id").
+		Where("book_tags.book_id = ?", bookId).Order("tags.name").Pluck("tags.name", &names).Error
+	return strings.Join(names, ", "), err
+}
+
+func seriesAuditValue(db *gorm.DB, bookId uint) (string, error) {
+	var rows []struct {
+		Name     string
+		Position float64
+	}
+	err := db.Table("series_entries").Select("series.name, series_entries.position").
+		Joins("JOIN series ON series.id = series_entries.series_id").
+		Where("series_entries.book_id = ? AND series_entries.deleted_at IS NULL", bookId).
+		Order("series.name").Scan(&rows).Error
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.Name+" #"+FormatSeriesPosition(row.Position))
+	}
+	return strings.Join(names, ", "), err
+}
+
+// Makes a change to one of a book's lists and records the list before and after, if it changed.
+// Call it inside a transaction, so the change and its entry are kept or lost together.
+func auditBookList(tx *gorm.DB, bookId uint, field string, change func() error) error {
+	value := bookListFields[field]
+	if bookId == 0 || !tableExists(tx, auditTable) {
+		return change()
+	}
+	before, err := value(tx, bookId)
+	if err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	after, err := value(tx, bookId)
+	if err != nil || before == after {
+		return err
+	}
+	entry := AuditEntry{Entity: AuditBook, EntityID: bookId, Field: field, OldValue: before, NewValue: after, Source: auditSource(tx)}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&entry).Error
+}
+
+// The row's audited values as text, or nil when there's no such row.
+func (a auditedTable) snapshot(db *gorm.DB, id uint) (auditSnapshot, error) {
+	row := map[string]any{}
+	result := db.Session(&gorm.Session{NewDB: true}).Table(a.table).Select(a.columns).Where("id = ?", id).Limit(1).Find(&row)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	values := make(auditSnapshot, len(a.columns))
+	for _, column := range a.columns {
+		values[column] = auditValue(row[column])
+	}
+	return values, nil
+}
+
+func auditValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// The audited values of a book or author as they were before a save or delete. Hooks get a new
+// statement each time they're called, so it's kept on the record rather than with the statement.
+type auditSnapshot map[string]string
+
+// Called before a save or delete, to keep the row as it was.
+func (a auditedTable) before(tx *gorm.DB, id uint, snapshot *auditSnapshot) error {
+	*snapshot = nil
+	if id == 0 || !tableExists(tx, auditTable) {
+		return nil
+	}
+	values, err := a.snapshot(tx, id)
+	*snapshot = values
+	return err
+}
+
+// Called after a save or delete to record the fields that changed. A row that wasn't there before
+// was created, and one that's gone was purged.
+func (a auditedTable) after(tx *gorm.DB, id uint, snapshot *auditSnapshot) error {
+	before := *snapshot
+	*snapshot = nil
+	if id == 0 || !tableExists(tx, auditTable) {
+		return nil
+	}
+	after, err := a.snapshot(tx, id)
+	if err != nil {
+		return err
+	}
+	source := auditSource(tx)
+	var entries []AuditEntry
+	for _, column := range a.columns {
+		if after == nil && column == "deleted_at" {
+			continue
+		}
+		// A new record's empty fields and zero numbers aren't worth a line of history
+		created := before == nil && (after[column] == "" || after[column] == "0")
+		if before[column] != after[column] && !created {
+			entries = append(entries, AuditEntry{Entity: a.entity, EntityID: id, Field: column,
+				OldValue: before[column], NewValue: after[column], Source: source})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&entries).Error
+}
+
+func (b *Book) BeforeSave(tx *gorm.DB) error {
+	return bookAudit.before(tx, b.ID, &b.auditBefore)
+}
+
+func (b *Book) BeforeDelete(tx *gorm.DB) error {
+	return bookAudit.before(tx, b.ID, &b.auditBefore)
+}
+
+func (a *Author) BeforeSave(tx *gorm.DB) error {
+	return authorAudit.before(tx, a.ID, &a.auditBefore)
+}
+
+// The author's name is indexed with every book they're credited on, so those are re-indexed too.
+func (a *Author) AfterSave(tx *gorm.DB) error {
+	if err := authorAudit.after(tx, a.ID, &a.auditBefore); err != nil {
+		return err
+	}
+	return updateAuthorSearch(tx, a.ID)
+}
+
+func (a *Author) BeforeDelete(tx *gorm.DB) error {
+	return authorAudit.before(tx, a.ID, &a.auditBefore)
+}
+
+func (a *Author) AfterDelete(tx *gorm.DB) error {
+	if err := authorAudit.after(tx, a.ID, &a.auditBefore); err != nil {
+		return err
+	}
+	return updateAuthorSearch(tx, a.ID)
+}
+
+func (e AuditEntry) FieldLabel() string {
+	label := strings.ReplaceAll(e.Field, "_", " ")
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// Long values, like reviews, are cut short for the history table.
+func (e AuditEntry) ShortOldValue() string {
+	return shortenAuditValue(e.OldValue)
+}
+
+func (e AuditEntry) ShortNewValue() string {
+	return shortenAuditValue(e.NewValue)
+}
+
+func shortenAuditValue(value string) string {
+	const most = 120
+	if utf8.RuneCountInString(value) <= most {
+		return value
+	}
+	return string([]rune(value)[:most]) + "…"
+}
+
+func (e AuditEntry) FormatTime() string {
+	return e.CreatedAt.Local().Format("2006-01-02 15:04")
+}
+
+func (e AuditEntry) Revertable() bool {
+	_, fixed := unrevertableFields[e.Field]
+	return !fixed
+}
+
+// The changes to one book or author, newest first.
+func LoadAuditHistory(db *gorm.DB, entity string, id uint) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if !tableExists(db, auditTable) {
+		return entries, nil
+	}
+	err := db.Where("entity = ? AND entity_id = ?", entity, id).Order("id DESC").Find(&entries).Error
+	return entries, err
+}
+
+// Puts one field back to what it was before the change. The revert is recorded like any other change.
+func RevertAuditEntry(db *gorm.DB, entryId uint) (AuditEntry, error) {
+	var entry AuditEntry
+	if err := db.First(&entry, entryId).Error; err != nil {
+		return entry, fmt.Errorf("change %d: %w", entryId, err)
+	}
+	if reason, fixed := unrevertableFields[entry.Field]; fixed {
+		return entry, fmt.Errorf("the %s can't be reverted, %s", strings.ToLower(entry.FieldLabel()), reason)
+	}
+	if entry.Entity == AuditBook && entry.Field == "rating" && entry.OldValue != "" {
+		var count int64
+		if err := db.Model(&RatingLevel{}).Where("slug = ?", entry.OldValue).Count(&count).Error; err != nil {
+			return entry, err
+		}
+		if count == 0 {
+			return entry, fmt.Errorf("the %q rating no longer exists", entry.OldValue)
+		}
+	}
+	if entry.Entity == AuditBook && entry.Field == "tags" {
+		return entry, revertBookTags(db, entry)
+	}
+	var record any
+	var table auditedTable
+	switch entry.Entity {
+	case AuditBook:
+		record, table = &Book{Model: gorm.Model{ID: entry.EntityID}}, bookAudit
+	case AuditAuthor:
+		record, table = &Author{Model: gorm.Model{ID: entry.EntityID}}, authorAudit
+	default:
+		return entry, fmt.Errorf("unknown kind of record %q", entry.Entity)
+	}
+
+	current := map[string]any{}
+	result := db.Table(table.table).Select(entry.Field).Where("id = ? AND deleted_at IS NULL", entry.EntityID).Limit(1).Find(&current)
+	if result.Error != nil {
+		return entry, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return entry, fmt.Errorf("the %s is no longer in the catalog", entry.Entity)
+	}
+	value, err := revertedValue(current[entry.Field], entry.OldValue)
+	if err != nil {
+		return entry, fmt.Errorf("can't revert the %s: %w", strings.ToLower(entry.FieldLabel()), err)
+	}
+	tx := WithAuditSource(db, fmt.Sprintf("revert of change %d", entry.ID))
+	return entry, tx.Model(record).Update(entry.Field, value).Error
+}
+
+func revertBookTags(db *gorm.DB, entry AuditEntry) error {
+	var book Book
+	if err := db.First(&book, entry.EntityID).Error; err != nil {
+		return fmt.Errorf("the book is no longer in the catalog: %w", err)
+	}
+	tx := WithAuditSource(db, fmt.Sprintf("revert of change %d", entry.ID))
+	return SetBookTags(tx, &book, ParseTagNames(entry.OldValue))
+}
+
+// The old value as the type the column holds now. Numbers that were empty, because the record was
+// just created, go back to 0.
+func revertedValue(current any, old string) (any, error) {
+	switch current.(type) {
+	case int64:
+		if old == "" {
+			return int64(0), nil
+		}
+		return strconv.ParseInt(old, 10, 64)
+	case float64:
+		if old == "" {
+			return float64(0), nil
+		}
+		return strconv.ParseFloat(old, 64)
+	}
+	return old, nil
+}
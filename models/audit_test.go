@@ -0,0 +1,187 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+// The entries for one field of a record, oldest first.
+func fieldHistory(t *testing.T, entries []AuditEntry, field string) []AuditEntry {
+	t.Helper()
+	var history []AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Field == field {
+			history = append(history, entries[i])
+		}
+	}
+	return history
+}
+
+func TestBookChangesAreRecorded(t *testing.T) {
+	db := setupTestDB(t)
+	web := WithAuditSource(db, AuditSourceWeb)
+	book := Book{MainTitle: "Nova", Rating: "Very-Good", PubDate: 1968}
+	if err := WithAuditSource(db, AuditSourceJSON).Create(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	book.Rating = "Excellent"
+	book.Review = "Space opera as myth."
+	if err := web.Save(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := web.Model(&book).Update("pub_date", 1969).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := web.Delete(&book).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadAuditHistory(db, AuditBook, book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rating := fieldHistory(t, entries, "rating")
+	if len(rating) != 2 {
+		t.Fatalf("Expected the rating to be recorded when created and changed, got %+v", rating)
+	}
+	if rating[0].OldValue != "" || rating[0].NewValue != "Very-Good" || rating[0].Source != AuditSourceJSON {
+		t.Errorf("Unexpected entry for the new book's rating %+v", rating[0])
+	}
+	if rating[1].OldValue != "Very-Good" || rating[1].NewValue != "Excellent" || rating[1].Source != AuditSourceWeb {
+		t.Errorf("Unexpected entry for the rating change %+v", rating[1])
+	}
+	pubDate := fieldHistory(t, entries, "pub_date")
+	if len(pubDate) != 2 || pubDate[1].OldValue != "1968" || pubDate[1].NewValue != "1969" {
+		t.Errorf("Expected the single field update to be recorded, got %+v", pubDate)
+	}
+	if deleted := fieldHistory(t, entries, "deleted_at"); len(deleted) != 1 || deleted[0].NewValue == "" {
+		t.Errorf("Expected the deletion to be recorded, got %+v", deleted)
+	}
+	if len(fieldHistory(t, entries, "sub_title")) != 0 {
+		t.Error("Fields that didn't change shouldn't be recorded")
+	}
+}
+
+// Credits, tags and series are recorded as whole lists, and tags can be put back.
+func TestBookListChangesAreRecorded(t *testing.T) {
+	db := setupTestDB(t)
+	web := WithAuditSource(db, AuditSourceWeb)
+	niven := Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	db.Create(&niven)
+	db.Create(&pournelle)
+	series, err := CreateSeries(db, "Motie", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := Book{MainTitle: "The Mote in God's Eye", Rating: "Excellent"}
+	db.Create(&book)
+
+	if err := SetBookCredits(web, &book, []Credit{{AuthorID: niven.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBookCredits(web, &book, []Credit{{AuthorID: niven.ID}, {AuthorID: pournelle.ID, Role: CreditCoAuthor}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBookTags(web, &book, []string{"first contact", "aliens"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBookTags(web, &book, []string{"first contact"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddBookToSeries(web, series.ID, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveBookFromSeries(web, series.ID, book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadAuditHistory(db, AuditBook, book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credits := fieldHistory(t, entries, "credits")
+	if len(credits) != 2 || credits[1].OldValue != "Larry Niven" || credits[1].NewValue != "Larry Niven, Jerry Pournelle (co-author)" ||
+		credits[1].Source != AuditSourceWeb || credits[1].Revertable() {
+		t.Errorf("Unexpected history of the credits %+v", credits)
+	}
+	tags := fieldHistory(t, entries, "tags")
+	if len(tags) != 2 || tags[0].NewValue != "aliens, first contact" || tags[1].NewValue != "first contact" {
+		t.Fatalf("Unexpected history of the tags %+v", tags)
+	}
+	seriesHistory := fieldHistory(t, entries, "series")
+	if len(seriesHistory) != 2 || seriesHistory[0].NewValue != "Motie #1" || seriesHistory[1].OldValue != "Motie #1" || seriesHistory[1].NewValue != "" {
+		t.Errorf("Unexpected history of the series %+v", seriesHistory)
+	}
+
+	if _, err := RevertAuditEntry(db, tags[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Preload("Tags").First(&book, book.ID)
+	if book.TagNames() != "aliens, first contact" {
+		t.Errorf("Expected the removed tag to be back, got %q", book.TagNames())
+	}
+	if _, err := RevertAuditEntry(db, credits[1].ID); err == nil {
+		t.Error("Expected the credits not to be revertable")
+	}
+}
+
+func TestAuthorChangesAreRecorded(t *testing.T) {
+	db := setupTestDB(t)
+	keep := createAuthorWithBooks(t, db, "C. J. Cherryh", "Downbelow Station")
+	merge := createAuthorWithBooks(t, db, "CJ Cherryh", "Cyteen")
+	keep.FullName = "C.J. Cherryh"
+	db.Save(&keep)
+	if _, err := MergeAuthors(WithAuditSource(db, AuditSourceCommandLine), keep.ID, merge.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := LoadAuditHistory(db, AuditAuthor, keep.ID)
+	if name := fieldHistory(t, entries, "full_name"); len(name) != 2 || name[1].OldValue != "C. J. Cherryh" {
+		t.Errorf("Expected the rename to be recorded, got %+v", name)
+	}
+	entries, _ = LoadAuditHistory(db, AuditAuthor, merge.ID)
+	if deleted := fieldHistory(t, entries, "deleted_at"); len(deleted) != 1 || deleted[0].Source != AuditSourceCommandLine {
+		t.Errorf("Expected the merged author's deletion to be recorded, got %+v", deleted)
+	}
+}
+
+func TestRevertAuditEntry(t *testing.T) {
+	db := setupTestDB(t)
+	book := Book{MainTitle: "Dhalgren", Rating: "Interesting", PubDate: 1975}
+	db.Create(&book)
+	db.Model(&book).Updates(map[string]interface{}{"rating": "Excellent", "pub_date": 1974})
+
+	entries, _ := LoadAuditHistory(db, AuditBook, book.ID)
+	for _, field := range []string{"rating", "pub_date"} {
+		change := fieldHistory(t, entries, field)[1]
+		if _, err := RevertAuditEntry(db, change.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var reverted Book
+	db.First(&reverted, book.ID)
+	if reverted.Rating != "Interesting" || reverted.PubDate != 1975 {
+		t.Errorf("Expected the rating and year to be put back, got %s and %d", reverted.Rating, reverted.PubDate)
+	}
+
+	entries, _ = LoadAuditHistory(db, AuditBook, book.ID)
+	if !strings.HasPrefix(entries[0].Source, "revert of change") {
+		t.Errorf("Expected the revert to be recorded, got %+v", entries[0])
+	}
+
+	db.Model(&book).Update("author_full_name", "Samuel R. Delany")
+	entries, _ = LoadAuditHistory(db, AuditBook, book.ID)
+	if entries[0].Revertable() {
+		t.Error("The author name comes from the credits and shouldn't be revertable")
+	}
+	if _, err := RevertAuditEntry(db, entries[0].ID); err == nil {
+		t.Error("Expected reverting the author name to fail")
+	}
+
+	db.Delete(&book)
+	if _, err := RevertAuditEntry(db, fieldHistory(t, entries, "rating")[1].ID); err == nil || !strings.Contains(err.Error(), "no longer") {
+		t.Errorf("Expected a deleted book's changes not to be revertable, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// What sort of other name an alias is.
+type AliasType string
+
+const (
+	AliasPseudonym AliasType = "pseudonym"  // Like James Tiptree Jr. for Alice Sheldon
+	AliasVariant   AliasType = "variant"    // Another spelling, like CJ Cherryh for C. J. Cherryh
+	AliasBirthName AliasType = "birth_name" // Like Alice Sheldon for James Tiptree Jr.
+)
+
+var AliasTypes = []AliasType{AliasPseudonym, AliasVariant, AliasBirthName}
+
+func ParseAliasType(s string) (AliasType, error) {
+	for _, t := range AliasTypes {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown alias type: %q, expected pseudonym, variant or birth_name", s)
+}
+
+func (t AliasType) Label() string {
+	switch t {
+	case AliasVariant:
+		return "variant spelling"
+	case AliasBirthName:
+		return "birth name"
+	}
+	return string(t)
+}
+
+// Another name an author's books have appeared under.
+type AuthorAlias struct {
+	gorm.Model
+	AuthorID uint `gorm:"index"`
+	Name     string
+	Type     AliasType `gorm:"default:variant"`
+	// The page the name had on the generated site as an author of its own, if any. It's
+	// kept so -build can redirect links to it.
+	SiteName string
+}
+
+// The author's other names worth showing readers, leaving out variant spellings.
+func (a Author) PenNames() []AuthorAlias {
+	var names []AuthorAlias
+	for _, alias := range a.Aliases {
+		if alias.Type != AliasVariant {
+			names = append(names, alias)
+		}
+	}
+	sort.Slice(names, func(left, right int) bool {
+		return names[left].Name < names[right].Name
+	})
+	return names
+}
+
+func AddAuthorAlias(db *gorm.DB, author Author, name string, aliasType AliasType) (AuthorAlias, error) {
+	alias := AuthorAlias{AuthorID: author.ID, Name: strings.Join(strings.Fields(name), " "), Type: aliasType}
+	if alias.Name == "" {
+		return alias, errors.New("the alias needs a name")
+	}
+	if strings.EqualFold(alias.Name, author.FullName) {
+		return alias, fmt.Errorf("%s is already the author's name", alias.Name)
+	}
+	var count int64
+	err := db.Model(&AuthorAlias{}).Where("author_id = ? AND name = ?", author.ID, alias.Name).Count(&count).Error
+	if err != nil {
+		return alias, err
+	}
+	if count > 0 {
+		return alias, fmt.Errorf("%s is already an alias of %s", alias.Name, author.FullName)
+	}
+	err = db.Create(&alias).Error
+	return alias, err
+}
+
+// Authors whose name or one of whose aliases matches the pattern, in SQL LIKE syntax.
+func FindAuthorsByName(db *gorm.DB, pattern string) ([]Author, error) {
+	var authors []Author
+	err := db.Preload("Aliases").
+		Where("full_name LIKE ? OR id IN (SELECT author_id FROM author_aliases WHERE name LIKE ? AND deleted_at IS NULL)", pattern, pattern).
+		Order("full_name ASC").Find(&authors).Error
+	return authors, err
+}
+
+// The alias the author was found by, if it wasn't their own name.
+func (a Author) AliasMatching(pattern string) (AuthorAlias, bool) {
+	if strings.EqualFold(a.FullName, pattern) {
+		return AuthorAlias{}, false
+	}
+	for _, alias := range a.Aliases {
+		if strings.EqualFold(alias.Name, pattern) {
+			return alias, true
+		}
+	}
+	return AuthorAlias{}, false
+}
+
+// Matches names to authors the way the importers do: any spelling of the initials, ignoring
+// case, and then their aliases. An author's own name always wins over someone's alias.
+type AuthorIndex struct {
+	byName  map[string]Author
+	byAlias map[string]Author
+}
+
+// An index of every author, with their aliases.
+func NewAuthorIndex(db *gorm.DB) (*AuthorIndex, error) {
+	var authors []Author
+	if err := db.Preload("Aliases").Order("id ASC").Find(&authors).Error; err != nil {
+		return nil, err
+	}
+	index := &AuthorIndex{byName: make(map[string]Author), byAlias: make(map[string]Author)}
+	for _, a := range authors {
+		index.Add(a)
+	}
+	return index, nil
+}
+
+// Every spelling of the name's initials AlternateFullNames would try, along with the name itself.
+func authorNameVariants(fullName string) []string {
+	return append(alternateFullNames(fullName, ExtractSurname(fullName)), fullName)
+}
+
+func (x *AuthorIndex) Add(a Author) {
+	addNameVariants(x.byName, a.FullName, a)
+	for _, alias := range a.Aliases {
+		addNameVariants(x.byAlias, alias.Name, a)
+	}
+}
+
+func addNameVariants(names map[string]Author, fullName string, a Author) {
+	for _, name := range authorNameVariants(fullName) {
+		key := strings.ToLower(name)
+		if _, taken := names[key]; !taken {
+			names[key] = a
+		}
+	}
+}
+
+func (x *AuthorIndex) Find(fullName string) (Author, bool) {
+	for _, names := range []map[string]Author{x.byName, x.byAlias} {
+		for _, name := range authorNameVariants(fullName) {
+			if a, ok := names[strings.ToLower(name)]; ok {
+				return a, true
+			}
+		}
+	}
+	return Author{}, false
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ccdavis/sfwr/load"
+)
+
+func TestAliasTypes(t *testing.T) {
+	for _, aliasType := range AliasTypes {
+		parsed, err := ParseAliasType(string(aliasType))
+		if err != nil || parsed != aliasType {
+			t.Errorf("Expected %s to parse, got %v %v", aliasType, parsed, err)
+		}
+	}
+	if _, err := ParseAliasType("nickname"); err == nil {
+		t.Error("Expected an unknown type to be an error")
+	}
+	if AliasBirthName.Label() != "birth name" || AliasVariant.Label() != "variant spelling" || AliasPseudonym.Label() != "pseudonym" {
+		t.Error("Unexpected labels")
+	}
+}
+
+func TestAddAuthorAlias(t *testing.T) {
+	db := setupTestDB(t)
+	sheldon := createAuthorWithBooks(t, db, "Alice Sheldon")
+
+	if _, err := AddAuthorAlias(db, sheldon, "  James   Tiptree Jr. ", AliasPseudonym); err != nil {
+		t.Fatal(err)
+	}
+	AddAuthorAlias(db, sheldon, "Raccoona Sheldon", AliasPseudonym)
+	AddAuthorAlias(db, sheldon, "Alice B. Sheldon", AliasVariant)
+	for _, name := range []string{"", "alice sheldon", "James Tiptree Jr."} {
+		if _, err := AddAuthorAlias(db, sheldon, name, AliasPseudonym); err == nil {
+			t.Errorf("Expected %q to be refused", name)
+		}
+	}
+
+	db.Preload("Aliases").First(&sheldon, sheldon.ID)
+	if len(sheldon.Aliases) != 3 {
+		t.Fatalf("Expected 3 aliases, got %+v", sheldon.Aliases)
+	}
+	penNames := sheldon.PenNames()
+	if len(penNames) != 2 || penNames[0].Name != "James Tiptree Jr." || penNames[1].Name != "Raccoona Sheldon" {
+		t.Errorf("Expected the pseudonyms without the variant spelling, got %+v", penNames)
+	}
+}
+
+func TestFindAuthorsByName(t *testing.T) {
+	db := setupTestDB(t)
+	sheldon := createAuthorWithBooks(t, db, "Alice Sheldon")
+	AddAuthorAlias(db, sheldon, "James Tiptree Jr.", AliasPseudonym)
+	createAuthorWithBooks(t, db, "James White")
+
+	found, err := FindAuthorsByName(db, "james tiptree jr.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != sheldon.ID {
+		t.Fatalf("Expected the pseudonym to find Alice Sheldon, got %+v", found)
+	}
+	if alias, ok := found[0].AliasMatching("james tiptree jr."); !ok || alias.Type != AliasPseudonym {
+		t.Errorf("Expected to know which alias matched, got %+v", alias)
+	}
+
+	found, _ = FindAuthorsByName(db, "James%")
+	if len(found) != 2 {
+		t.Errorf("Expected a pattern to match names and aliases, got %+v", found)
+	}
+	if _, ok := found[0].AliasMatching("Alice Sheldon"); ok {
+		t.Error("Expected the author's own name not to count as an alias")
+	}
+}
+
+func TestAuthorIndex(t *testing.T) {
+	db := setupTestDB(t)
+	banks := createAuthorWithBooks(t, db, "Iain M. Banks")
+	AddAuthorAlias(db, banks, "Iain Banks", AliasPseudonym)
+	cherryh := createAuthorWithBooks(t, db, "C. J. Cherryh")
+	// Someone else's alias never hides an author's own name
+	AddAuthorAlias(db, cherryh, "Isaac Asimov", AliasVariant)
+	asimov := createAuthorWithBooks(t, db, "Isaac Asimov")
+
+	index, err := NewAuthorIndex(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		expected uint
+	}{
+		{"Iain Banks", banks.ID},
+		{"iain m. banks", banks.ID},
+		{"CJ Cherryh", cherryh.ID},
+		{"Isaac Asimov", asimov.ID},
+	}
+	for _, tt := range tests {
+		if found, ok := index.Find(tt.name); !ok || found.ID != tt.expected {
+			t.Errorf("Expected %s to find author %d, got %d", tt.name, tt.expected, found.ID)
+		}
+	}
+	if _, ok := index.Find("Dan Simmons"); ok {
+		t.Error("Expected an unknown author not to be found")
+	}
+}
+
+func TestImportersMatchAliases(t *testing.T) {
+	db := setupTestDB(t)
+	leGuin := createAuthorWithBooks(t, db, "Ursula Le Guin")
+	AddAuthorAlias(db, leGuin, "Ursula K. Le Guin", AliasVariant)
+
+	rows, err := load.ParseGoodreadsCsv(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportGoodreadsBooks(db, rows, false); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&Author{}).Where("full_name LIKE ?", "%Le Guin").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the Goodreads import to use the alias, got %d Le Guins", count)
+	}
+
+	bookFile := path.Join(t.TempDir(), "books.json")
+	books := load.BookMap{"Ursula K. Le Guin": {{Author: "Ursula K. Le Guin", Title: []string{"The Dispossessed"}, Rating: "Excellent"}}}
+	if err := load.SaveBookDataToJsonFile(books, nil, nil, bookFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := TransferJsonBooksToDatabase(bookFile, db); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&Author{}).Where("full_name LIKE ?", "%Le Guin").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the JSON load to use the alias, got %d Le Guins", count)
+	}
+	var dispossessed Book
+	db.Preload("Authors").Where("main_title = ?", "The Dispossessed").First(&dispossessed)
+	if len(dispossessed.Authors) != 1 || dispossessed.Authors[0].ID != leGuin.ID {
+		t.Errorf("Expected the book linked to Le Guin, got %+v", dispossessed.Authors)
+	}
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type AuthorMergeReport struct {
+	Kept         Author
+	Merged       Author
+	BooksMoved   int // Books of the merged author now linked to the kept one
+	BooksRenamed int // Books that were listed under the merged author's name
+	WorksMoved   int // Short fiction by the merged author now credited to the kept one
+}
+
+func (r AuthorMergeReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Merged %s (%d) into %s (%d).\n", r.Merged.FullName, r.Merged.ID, r.Kept.FullName, r.Kept.ID)
+	fmt.Fprintf(w, "%d books moved, %d renamed, %d stories moved. \"%s\" is kept as an alias.\n",
+		r.BooksMoved, r.BooksRenamed, r.WorksMoved, r.Merged.FullName)
+}
+
+// Moves all the books of one author to another and removes the first, keeping its name as an alias
+// of the author that's left.
+func MergeAuthors(db *gorm.DB, keepId uint, mergeId uint) (AuthorMergeReport, error) {
+	var report AuthorMergeReport
+	if keepId == mergeId {
+		return report, errors.New("can't merge an author into themselves")
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&report.Kept, keepId).Error; err != nil {
+			return fmt.Errorf("author %d: %w", keepId, err)
+		}
+		if err := tx.Preload("Books").First(&report.Merged, mergeId).Error; err != nil {
+			return fmt.Errorf("author %d: %w", mergeId, err)
+		}
+
+		// Books already linked to both just lose the link to the merged author
+		err := tx.Exec(`DELETE FROM book_authors WHERE author_id = ? AND book_id IN
+			(SELECT book_id FROM book_authors WHERE author_id = ?)`, mergeId, keepId).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Exec(`UPDATE book_authors SET author_id = ? WHERE author_id = ?`, keepId, mergeId)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.BooksMoved = int(result.RowsAffected)
+
+		// The same for the stories they wrote
+		err = tx.Exec(`DELETE FROM work_authors WHERE author_id = ? AND work_id IN
+			(SELECT work_id FROM work_authors WHERE author_id = ?)`, mergeId, keepId).Error
+		if err != nil {
+			return err
+		}
+		result = tx.Exec(`UPDATE work_authors SET author_id = ? WHERE author_id = ?`, keepId, mergeId)
+		if result.Error != nil {
+			return result.Error
+		}
+		report.WorksMoved = int(result.RowsAffected)
+
+		for _, book := range report.Merged.Books {
+			if book.AuthorFullName != report.Merged.FullName {
+				continue
+			}
+			err := tx.Model(&book).Updates(map[string]interface{}{
+				"author_full_name": report.Kept.FullName,
+				"author_surname":   report.Kept.Surname,
+			}).Error
+			if err != nil {
+				return err
+			}
+			report.BooksRenamed++
+		}
+
+		err = tx.Model(&AuthorAlias{}).Where("author_id = ?", mergeId).Update("author_id", keepId).Error
+		if err != nil {
+			return err
+		}
+		alias := AuthorAlias{AuthorID: keepId, Name: report.Merged.FullName, Type: AliasVariant, SiteName: report.Merged.SiteName()}
+		if err := tx.Create(&alias).Error; err != nil {
+			return err
+		}
+		// The moved credits now carry the kept author's name
+		if err := updateAuthorSearch(tx, keepId); err != nil {
+			return err
+		}
+		return tx.Delete(&report.Merged).Error
+	})
+	return report, err
+}
+
+// Authors whose names only differ by how their initials are written, or by case and
+// spacing, like "CJ Cherryh" and "C. J. Cherryh". Aliases count as names too.
+type DuplicateAuthors struct {
+	Name    string   // The name they share, normalised
+	Authors []Author // Most books first, so the first is the one to keep
+}
+
+// The name with its initials spelled the way AlternateFullNames spells them first, in lower case
+// and without any other periods, so "Iain M Banks" and "Iain M. Banks" match too.
+func NormalizedAuthorName(fullName string) string {
+	words := strings.Fields(fullName)
+	if len(words) == 0 {
+		return ""
+	}
+	surname := words[len(words)-1]
+	name := alternateFullNames(strings.Join(words, " "), surname)[0]
+	return strings.ToLower(strings.ReplaceAll(name, ".", ""))
+}
+
+func SuggestDuplicateAuthors(db *gorm.DB) ([]DuplicateAuthors, error) {
+	var authors []Author
+	if err := db.Preload("Books").Preload("Aliases").Order("id ASC").Find(&authors).Error; err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]Author)
+	for _, author := range authors {
+		names := map[string]bool{NormalizedAuthorName(author.FullName): true}
+		for _, alias := range author.Aliases {
+			names[NormalizedAuthorName(alias.Name)] = true
+		}
+		for name := range names {
+			byName[name] = append(byName[name], author)
+		}
+	}
+
+	var duplicates []DuplicateAuthors
+	for name, matches := range byName {
+		if len(matches) < 2 {
+			continue
+		}
+		sort.SliceStable(matches, func(left, right int) bool {
+			return len(matches[left].Books) > len(matches[right].Books)
+		})
+		duplicates = append(duplicates, DuplicateAuthors{Name: name, Authors: matches})
+	}
+	sort.Slice(duplicates, func(left, right int) bool {
+		return duplicates[left].Name < duplicates[right].Name
+	})
+	return duplicates, nil
+}
+
+func PrintDuplicateAuthors(w io.Writer, duplicates []DuplicateAuthors) {
+	for _, d := range duplicates {
+		fmt.Fprintf(w, "%s:\n", d.Name)
+		for _, a := range d.Authors {
+			fmt.Fprintf(w, "  %5d  %s (%d books)\n", a.ID, a.FullName, len(a.Books))
+		}
+	}
+	fmt.Fprintf(w, "%d possible duplicates found.\n", len(duplicates))
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func createAuthorWithBooks(t *testing.T, db *gorm.DB, fullName string, titles ...string) Author {
+	t.Helper()
+	author := Author{FullName: fullName, Surname: ExtractSurname(fullName)}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range titles {
+		book := Book{MainTitle: title, AuthorFullName: author.FullName, AuthorSurname: author.Surname, Authors: []Author{author}}
+		if err := db.Create(&book).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return author
+}
+
+func TestNormalizedAuthorName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"CJ Cherryh", "cj cherryh"},
+		{"C.J. Cherryh", "cj cherryh"},
+		{"C. J. Cherryh", "cj cherryh"},
+		{"c.j.  cherryh", "cj cherryh"},
+		{"Iain M. Banks", "iain m banks"},
+		{"Iain M Banks", "iain m banks"},
+		{"Iain Banks", "iain banks"},
+		{"Moebius", "moebius"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizedAuthorName(tt.name); got != tt.expected {
+			t.Errorf("NormalizedAuthorName(%q) = %q, expected %q", tt.name, got, tt.expected)
+		}
+	}
+
+	spaced := Author{FullName: "C. J. Cherryh", Surname: "Cherryh"}
+	expected := []string{"CJ Cherryh", "C.J. Cherryh", "C. J. Cherryh"}
+	if got := spaced.AlternateFullNames(); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected spaced initials to give %v, got %v", expected, got)
+	}
+}
+
+func TestMergeAuthors(t *testing.T) {
+	db := setupTestDB(t)
+	keep := createAuthorWithBooks(t, db, "C. J. Cherryh", "Downbelow Station", "Cyteen")
+	merge := createAuthorWithBooks(t, db, "CJ Cherryh", "Foreigner")
+	other := createAuthorWithBooks(t, db, "Janet Morris")
+
+	// A book by both spellings keeps a single link
+	var shared Book
+	db.Where("main_title = ?", "Cyteen").First(&shared)
+	db.Model(&shared).Association("Authors").Append(&merge)
+	// A collaboration listed under someone else keeps their name
+	collaboration := Book{MainTitle: "The Gates of Hell", AuthorFullName: other.FullName, AuthorSurname: other.Surname, Authors: []Author{other, merge}}
+	db.Create(&collaboration)
+	db.Create(&AuthorAlias{AuthorID: merge.ID, Name: "Carolyn Janice Cherry"})
+
+	report, err := MergeAuthors(db, keep.ID, merge.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BooksMoved != 2 || report.BooksRenamed != 1 {
+		t.Errorf("Expected 2 books moved and 1 renamed, got %+v", report)
+	}
+	var out bytes.Buffer
+	report.Print(&out)
+	if !strings.Contains(out.String(), "Merged CJ Cherryh") {
+		t.Errorf("Unexpected report: %s", out.String())
+	}
+
+	var kept Author
+	db.Preload("Books").Preload("Aliases").First(&kept, keep.ID)
+	if len(kept.Books) != 4 {
+		t.Errorf("Expected all 4 books with the kept author, got %d", len(kept.Books))
+	}
+	var foreigner Book
+	db.Where("main_title = ?", "Foreigner").First(&foreigner)
+	if foreigner.AuthorFullName != "C. J. Cherryh" || foreigner.AuthorSurname != "Cherryh" {
+		t.Errorf("Expected the book renamed, got %s / %s", foreigner.AuthorFullName, foreigner.AuthorSurname)
+	}
+	db.First(&collaboration, collaboration.ID)
+	if collaboration.AuthorFullName != "Janet Morris" {
+		t.Errorf("Expected the collaboration to keep its first author, got %s", collaboration.AuthorFullName)
+	}
+
+	aliases := make(map[string]string)
+	for _, a := range kept.Aliases {
+		aliases[a.Name] = a.SiteName
+	}
+	if aliases["CJ Cherryh"] != merge.SiteName() {
+		t.Errorf("Expected the merged name kept with its page, got %v", aliases)
+	}
+	if _, ok := aliases["Carolyn Janice Cherry"]; !ok {
+		t.Errorf("Expected the merged author's aliases to move, got %v", aliases)
+	}
+
+	var count int64
+	db.Model(&Author{}).Where("id = ?", merge.ID).Count(&count)
+	if count != 0 {
+		t.Error("Expected the merged author to be gone")
+	}
+	db.Table("book_authors").Where("author_id = ?", merge.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no links left to the merged author, got %d", count)
+	}
+
+	if _, err := MergeAuthors(db, keep.ID, keep.ID); err == nil {
+		t.Error("Expected merging an author into themselves to fail")
+	}
+	if _, err := MergeAuthors(db, keep.ID, merge.ID); err == nil {
+		t.Error("Expected merging a missing author to fail")
+	}
+}
+
+func TestMergeAuthorsMovesStories(t *testing.T) {
+	db := setupTestDB(t)
+	keep := createAuthorWithBooks(t, db, "C. J. Cherryh")
+	merge := createAuthorWithBooks(t, db, "CJ Cherryh")
+	cassandra := Work{Title: "Cassandra", Authors: []Author{merge}}
+	pots := Work{Title: "Pots", Authors: []Author{keep}}
+	shared := Work{Title: "A Thief in Korianth", Authors: []Author{keep, merge}}
+	for _, w := range []*Work{&cassandra, &pots, &shared} {
+		if err := db.Create(w).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := MergeAuthors(db, keep.ID, merge.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.WorksMoved != 1 {
+		t.Errorf("Expected 1 story moved, got %d", report.WorksMoved)
+	}
+	var kept Author
+	db.Preload("Works").First(&kept, keep.ID)
+	if len(kept.Works) != 3 {
+		t.Errorf("Expected all 3 stories with the kept author, got %d", len(kept.Works))
+	}
+	var count int64
+	db.Table("work_authors").Where("author_id = ?", merge.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no stories left with the merged author, got %d", count)
+	}
+	db.Table("work_authors").Where("work_id = ?", shared.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected the shared story to have the kept author once, got %d", count)
+	}
+}
+
+func TestSuggestDuplicateAuthors(t *testing.T) {
+	db := setupTestDB(t)
+	createAuthorWithBooks(t, db, "CJ Cherryh", "Foreigner")
+	most := createAuthorWithBooks(t, db, "C. J. Cherryh", "Downbelow Station", "Cyteen")
+	createAuthorWithBooks(t, db, "Iain M. Banks", "Excession")
+	createAuthorWithBooks(t, db, "Iain Banks", "The Crow Road")
+	pseudonym := createAuthorWithBooks(t, db, "Ursula K. Le Guin")
+	db.Create(&AuthorAlias{AuthorID: pseudonym.ID, Name: "Ursula K Le Guin"})
+	createAuthorWithBooks(t, db, "Ursula K Le Guin", "The Dispossessed")
+
+	duplicates, err := SuggestDuplicateAuthors(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 2 {
+		t.Fatalf("Expected 2 groups of duplicates, got %+v", duplicates)
+	}
+	if duplicates[0].Name != "cj cherryh" || len(duplicates[0].Authors) != 2 || duplicates[0].Authors[0].ID != most.ID {
+		t.Errorf("Expected the Cherryhs with the most books first, got %+v", duplicates[0])
+	}
+	if duplicates[1].Name != "ursula k le guin" || len(duplicates[1].Authors) != 2 {
+		t.Errorf("Expected an alias to match, got %+v", duplicates[1])
+	}
+
+	var out bytes.Buffer
+	PrintDuplicateAuthors(&out, duplicates)
+	if !strings.Contains(out.String(), "2 possible duplicates found") {
+		t.Errorf("Unexpected report: %s", out.String())
+	}
+}
@@ -2,12 +2,13 @@ package models
 
 import (
 	"bytes"
-	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,7 +21,10 @@ import (
 )
 
 const Missing int64 = -999998
-const ImageDir string = "images/cover_images"
+
+// Where cover images go within the generated site. Set from the site config at startup.
+var ImageDir string = "images/cover_images"
+
 const Verbose bool = false
 
 type BooksByAuthor map[string][]Book
@@ -29,56 +33,6 @@ const SmallCover = "S"
 const MediumCover = "M"
 const LargeCover = "L"
 
-type Rating struct {
-	slug string
-}
-
-// For Gorm
-func (r Rating) Value() (driver.Value, error) {
-	return r.slug, nil
-}
-
-func (r *Rating) Scan(value interface{}) error {
-	rating, err := StringToRating(value.(string))
-	if err != nil {
-		*r = rating
-	}
-	return err
-}
-
-func (r Rating) String() string {
-	return r.slug
-}
-
-func (r Rating) Display() string {
-	return strings.ReplaceAll(r.slug, "-", " ")
-}
-
-var (
-	Unknown     = Rating{"Not Rated"}
-	VeryGood    = Rating{"Very-Good"}
-	Excellent   = Rating{"Excellent"}
-	Kindle      = Rating{"Kindle"}
-	Interesting = Rating{"Interesting"}
-	NotGood     = Rating{"Not-Good"}
-)
-
-func StringToRating(s string) (Rating, error) {
-	switch s {
-	case VeryGood.slug:
-		return VeryGood, nil
-	case Excellent.slug:
-		return Excellent, nil
-	case Kindle.slug:
-		return Kindle, nil
-	case Interesting.slug:
-		return Interesting, nil
-	case NotGood.slug:
-		return NotGood, nil
-	}
-	return Unknown, errors.New("unknown rating: " + s)
-}
-
 type OpenLibraryBookAuthor struct {
 	gorm.Model
 	BookId     uint
@@ -101,6 +55,7 @@ type Book struct {
 	SubTitle               string
 	Review                 string
 	Rating                 string
+	Format                 BookFormat // How it was published or is available, or empty if not known
 	AmazonLink             string
 	CoverImageUrl          string
 	OpenLibraryUrl         string
@@ -109,7 +64,16 @@ type Book struct {
 	OlCoverId              int64 // Used as the base ID for the image (add suffix -M, -S, -L for sizing.)
 	OpenLibraryBookAuthors []OpenLibraryBookAuthor
 	OlCoverEditionId       string   // Used to pull up an entry based on a cover
+	CoverSource            string   `gorm:"default:openlibrary"` // CoverSourceOpenLibrary or CoverSourceUploaded
+	UploadedCoverId        int64    // Assigned locally when a cover is uploaded
 	Authors                []Author `gorm:"many2many:book_authors;"`
+	Credits                []Credit // The same people as Authors, with their roles and order
+	Contents               []ContentsEntry
+	SeriesEntries          []SeriesEntry
+	Tags                   []Tag `gorm:"many2many:book_tags;"`
+	ReadingSessions        []ReadingSession
+
+	auditBefore auditSnapshot // The row before a save, for its history
 }
 
 type Author struct {
@@ -117,6 +81,10 @@ type Author struct {
 	FullName string
 	Surname  string
 	Books    []Book `gorm:"many2many:book_authors;"`
+	Aliases  []AuthorAlias
+	Works    []Work `gorm:"many2many:work_authors;"`
+
+	auditBefore auditSnapshot // The row before a save, for its history
 }
 
 func (a Author) GetBooks() []Book {
@@ -140,12 +108,49 @@ func (a Author) SiteName() string {
 	return fmt.Sprint(strings.Replace(name, " ", "-", -1), ".html")
 }
 
+// The author's Atom feed, written next to their page.
+func (a Author) FeedFileName() string {
+	return strings.TrimSuffix(a.SiteName(), ".html") + ".xml"
+}
+
 func LoadAllBooks(db *gorm.DB) ([]Book, error) {
 	var allBooks []Book
-	result := db.Preload("Authors").Find(&allBooks)
+	result := db.Preload("Authors").Preload("Credits.Author").Preload("Contents.Work.Authors").Preload("SeriesEntries.Series.Entries.Book").Preload("Tags").Preload("ReadingSessions").Find(&allBooks)
 	return allBooks, result.Error
 }
 
+// Restricts a book query to books with the given rating slug.
+func WithRating(slug string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("books.rating = ?", slug)
+	}
+}
+
+// Restricts a book query to books by the author, whether or not they're the first one listed.
+func ByAuthor(authorId uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN book_authors ON book_authors.book_id = books.id").
+			Where("book_authors.author_id = ?", authorId)
+	}
+}
+
+// Restricts a book query to a decade named the way the site names them, like "1970s", or "Unknown"
+// for books without a publication date.
+func InDecade(decade string) (func(*gorm.DB) *gorm.DB, error) {
+	if strings.EqualFold(decade, "Unknown") {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("books.pub_date = ? OR books.pub_date = 0", Missing)
+		}, nil
+	}
+	start, err := strconv.ParseInt(strings.TrimSuffix(decade, "s"), 10, 64)
+	if err != nil || start%10 != 0 || !strings.HasSuffix(decade, "s") {
+		return nil, fmt.Errorf("not a decade: %q, expected one like 1970s", decade)
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("books.pub_date >= ? AND books.pub_date < ? AND books.pub_date != 0", start, start+10)
+	}, nil
+}
+
 func (b Book) UpdateFromOpenLibrary(db *gorm.DB, olSearchResult BookSearchResult) (Book, error) {
 	if olSearchResult.FirstYearPublished != 0 {
 		b.PubDate = int64(olSearchResult.FirstYearPublished)
@@ -182,7 +187,9 @@ func (b Book) HasCoverImageId() bool {
 
 func (b Book) DisplayRating() string {
 	r, err := StringToRating(b.Rating)
-	if err != nil {
+	if b.Rating == Unknown.slug {
+		return Unknown.Display()
+	} else if err != nil {
 		return "Unknown rating"
 	} else {
 		return r.Display()
@@ -234,7 +241,7 @@ func initialisedName(firstName string) ([]string, bool) {
 	initials := strings.Split(firstName, ".")
 	periods := strings.Count(firstName, ".")
 	if periods == 2 {
-		return []string{initials[0], initials[1]}, true
+		return []string{strings.TrimSpace(initials[0]), strings.TrimSpace(initials[1])}, true
 	} else {
 		if len(initials) == 1 {
 			if len(initials[0]) == 2 {
@@ -249,24 +256,33 @@ func initialisedName(firstName string) ([]string, bool) {
 }
 
 func (b Book) AlternateAuthorFullNames() []string {
-	removeSurname := " " + b.AuthorSurname
-	firstName, success := strings.CutSuffix(b.AuthorFullName, removeSurname)
+	return alternateFullNames(b.AuthorFullName, b.AuthorSurname)
+}
+
+func (a Author) AlternateFullNames() []string {
+	return alternateFullNames(a.FullName, a.Surname)
+}
+
+// The initials spelled without periods first, then "C.J." and "C. J." style.
+func alternateFullNames(fullName string, surname string) []string {
+	removeSurname := " " + surname
+	firstName, success := strings.CutSuffix(fullName, removeSurname)
 	if success {
 		var names []string
 		initials, hasInitials := initialisedName(firstName)
 		if !hasInitials {
-			return []string{b.AuthorFullName}
+			return []string{fullName}
 		} else {
 			combined := strings.Join(initials, "")
 			periodNoSpace := strings.Join(initials, ".") + "."
 			periodWithSpacing := strings.Join(initials, ". ") + "."
-			names = append(names, combined+" "+b.AuthorSurname)
-			names = append(names, periodNoSpace+" "+b.AuthorSurname)
-			names = append(names, periodWithSpacing+" "+b.AuthorSurname)
+			names = append(names, combined+" "+surname)
+			names = append(names, periodNoSpace+" "+surname)
+			names = append(names, periodWithSpacing+" "+surname)
 			return names
 		}
 	} else {
-		return []string{b.AuthorFullName}
+		return []string{fullName}
 	}
 }
 
@@ -309,11 +325,11 @@ func (b Book) MakeCoverImageUrl(size string) string {
 }
 
 func (b Book) MakeCoverImageFilename(imageDir string, size string) string {
-	if b.OlCoverId == Missing || b.OlCoverId == 0 {
+	if !b.HasCover() {
 		filename := fmt.Sprintf("placeholder-%s.jpg", size)
 		return path.Join(imageDir, filename)
 	}
-	filename := fmt.Sprintf("%d-%s.jpg", b.OlCoverId, size)
+	filename := fmt.Sprintf("%s-%s.jpg", b.coverFileId(), size)
 	return path.Join(imageDir, filename)
 }
 
@@ -322,11 +338,26 @@ func makeCoverImageUrlForIsbn(isbn string, size string) string {
 	return url
 }
 
+// Browsers choose the WebP if they can, and whichever size suits the screen; the width and height
+// hold the cover's place on the page while it loads.
 func (b Book) makeImageTagForCover(size string, relativeToImageDir string) template.HTML {
 	completePath := relativeToImageDir + "/" + ImageDir
 	link := b.MakeCoverImageFilename(completePath, size)
 	label := "Open Library"
-	tag := fmt.Sprintf("<img src=\"%s\" alt=\"%s\" />", link, label)
+	dimensions := CoverDimensions[size]
+	img := fmt.Sprintf("<img src=\"%s\" alt=\"%s\" width=\"%d\" height=\"%d\" />", link, label, dimensions.X, dimensions.Y)
+	if !b.HasCover() {
+		return template.HTML(img)
+	}
+	var jpegs, webps []string
+	for _, s := range CoverSizes {
+		width := CoverDimensions[s].X
+		jpegs = append(jpegs, fmt.Sprintf("%s %dw", b.MakeCoverImageFilename(completePath, s), width))
+		webps = append(webps, fmt.Sprintf("%s %dw", b.MakeCoverWebpFilename(completePath, s), width))
+	}
+	sizes := fmt.Sprintf("%dpx", dimensions.X)
+	tag := fmt.Sprintf("<picture><source type=\"image/webp\" srcset=\"%s\" sizes=\"%s\" /><source type=\"image/jpeg\" srcset=\"%s\" sizes=\"%s\" />%s</picture>",
+		strings.Join(webps, ", "), sizes, strings.Join(jpegs, ", "), sizes, img)
 	return template.HTML(tag)
 }
 
@@ -378,9 +409,21 @@ func fromRawBook(book load.RawBook) Book {
 		year_published = Missing
 	}
 	dateAdded := time.Now()
+	if book.DateAdded != "" {
+		dateAdded, err = time.Parse(time.RFC3339Nano, book.DateAdded)
+		exitOnError("Error reading date added.", err)
+	}
 	surname := ExtractSurname(book.Author)
-	rating, err := StringToRating(book.Rating)
-	exitOnError("Error extracting author's surname.", err)
+	rating := Unknown
+	if book.Rating != Unknown.slug {
+		rating, err = StringToRating(book.Rating)
+		exitOnError("Error reading rating.", err)
+	}
+	var format BookFormat
+	if book.Format != "" {
+		format, err = ParseBookFormat(book.Format)
+		exitOnError("Error reading format.", err)
+	}
 
 	var subTitle = ""
 	if len(book.Title) > 1 {
@@ -400,6 +443,14 @@ func fromRawBook(book load.RawBook) Book {
 		olCoverId = Missing
 	}
 
+	coverSource := CoverSourceOpenLibrary
+	uploadedCoverId, err := book.UploadedCoverId.Int64()
+	if err == nil && uploadedCoverId > 0 {
+		coverSource = CoverSourceUploaded
+	} else {
+		uploadedCoverId = 0
+	}
+
 	var olIsbns []OpenLibraryBookIsbn
 	for _, i := range book.Isbn {
 		newIsbn := OpenLibraryBookIsbn{Isbn: i}
@@ -412,6 +463,51 @@ func fromRawBook(book load.RawBook) Book {
 		olAuthors = append(olAuthors, newAuthor)
 	}
 
+	// Tags and series are looked up by name when the book is saved
+	var tags []Tag
+	for _, name := range book.Tags {
+		tags = append(tags, Tag{Name: name})
+	}
+	var seriesEntries []SeriesEntry
+	for _, s := range book.Series {
+		seriesEntries = append(seriesEntries, SeriesEntry{Position: s.Position, Series: Series{Name: s.Name, Description: s.Description}})
+	}
+	var sessions []ReadingSession
+	for _, s := range book.ReadingSessions {
+		session := ReadingSession{Format: s.Format, Note: s.Note}
+		session.StartDate, err = ParseReadingDate(s.Started)
+		exitOnError("Error reading the start of a reading session.", err)
+		session.FinishDate, err = ParseReadingDate(s.Finished)
+		exitOnError("Error reading the finish of a reading session.", err)
+		sessions = append(sessions, session)
+	}
+
+	// Credited people are looked up by name when the book is saved
+	var credits []Credit
+	for _, c := range book.Credits {
+		role, err := ParseCreditRole(c.Role)
+		exitOnError("Error reading a credit.", err)
+		credits = append(credits, Credit{Role: role, Author: Author{FullName: c.Name}})
+	}
+
+	// Stories and their authors are looked up when the book is saved
+	var contents []ContentsEntry
+	for i, c := range book.Contents {
+		work := Work{Title: c.Title, PubDate: Missing}
+		if c.PubDate != "" {
+			work.PubDate, err = c.PubDate.Int64()
+			exitOnError("Error reading a story's year.", err)
+		}
+		if c.Length != "" {
+			work.Length, err = ParseWorkLength(c.Length)
+			exitOnError("Error reading a story's length.", err)
+		}
+		for _, name := range c.Authors {
+			work.Authors = append(work.Authors, Author{FullName: name})
+		}
+		contents = append(contents, ContentsEntry{Position: i + 1, Rating: c.Rating, Work: work})
+	}
+
 	newBook := Book{
 		PubDate:                year_published,
 		DateAdded:              dateAdded,
@@ -421,6 +517,7 @@ func fromRawBook(book load.RawBook) Book {
 		SubTitle:               subTitle,
 		Review:                 book.Review,
 		Rating:                 rating.slug,
+		Format:                 format,
 		AmazonLink:             book.AmazonLink,
 		CoverImageUrl:          book.CoverImage,
 		OpenLibraryUrl:         book.OpenLibrary,
@@ -429,15 +526,232 @@ func fromRawBook(book load.RawBook) Book {
 		OlCoverId:              olCoverId,
 		OpenLibraryBookAuthors: olAuthors,
 		OlCoverEditionId:       book.OlCoverEditionId,
+		CoverSource:            coverSource,
+		UploadedCoverId:        uploadedCoverId,
 		Authors:                authorObjects,
+		Tags:                   tags,
+		SeriesEntries:          seriesEntries,
+		ReadingSessions:        sessions,
+		Credits:                credits,
+		Contents:               contents,
 	}
 
 	return newBook
 }
 
+// The reverse of fromRawBook.
+func toRawBook(b Book) load.RawBook {
+	title := []string{b.MainTitle}
+	if b.SubTitle != "" {
+		title = append(title, b.SubTitle)
+	}
+
+	var isbns []string
+	for _, i := range b.OpenLibraryBookIsbns {
+		isbns = append(isbns, i.Isbn)
+	}
+
+	var olAuthorIds []string
+	for _, a := range b.OpenLibraryBookAuthors {
+		olAuthorIds = append(olAuthorIds, a.OlAuthorId)
+	}
+
+	raw := load.RawBook{
+		Author:           b.AuthorFullName,
+		Title:            title,
+		Review:           b.Review,
+		Rating:           b.Rating,
+		Format:           string(b.Format),
+		AmazonLink:       b.AmazonLink,
+		CoverImage:       b.CoverImageUrl,
+		OpenLibrary:      b.OpenLibraryUrl,
+		Isfdb:            b.IsfdbUrl,
+		Isbn:             isbns,
+		OlAuthorId:       olAuthorIds,
+		OlCoverEditionId: b.OlCoverEditionId,
+	}
+	// Missing values are left out; they load back in as Missing.
+	if b.PubDate != Missing {
+		raw.PubDate = json.Number(strconv.FormatInt(b.PubDate, 10))
+	}
+	if b.OlCoverId != Missing {
+		raw.OlCoverId = json.Number(strconv.FormatInt(b.OlCoverId, 10))
+	}
+	if b.HasUploadedCover() {
+		raw.UploadedCoverId = json.Number(strconv.FormatInt(b.UploadedCoverId, 10))
+	}
+	raw.DateAdded = b.DateAdded.Format(time.RFC3339Nano)
+
+	// A lone author is already in the author field
+	credits := b.OrderedCredits()
+	if len(credits) > 1 || (len(credits) == 1 && credits[0].Role != CreditAuthor) {
+		for _, c := range credits {
+			raw.Credits = append(raw.Credits, load.RawCredit{Name: c.Author.FullName, Role: string(c.Role)})
+		}
+	}
+	for _, t := range b.SortedTags() {
+		raw.Tags = append(raw.Tags, t.Name)
+	}
+	entries := make([]SeriesEntry, len(b.SeriesEntries))
+	copy(entries, b.SeriesEntries)
+	sort.Slice(entries, func(left, right int) bool {
+		return entries[left].Series.Name < entries[right].Series.Name
+	})
+	for _, e := range entries {
+		raw.Series = append(raw.Series, load.RawSeriesEntry{Name: e.Series.Name, Description: e.Series.Description, Position: e.Position})
+	}
+	for _, s := range b.ReadingSessions {
+		raw.ReadingSessions = append(raw.ReadingSessions, load.RawReadingSession{
+			Started:  formatReadingDate(s.StartDate),
+			Finished: formatReadingDate(s.FinishDate),
+			Format:   s.Format,
+			Note:     s.Note,
+		})
+	}
+	for _, e := range b.OrderedContents() {
+		story := load.RawContentsEntry{Title: e.Work.Title, Length: string(e.Work.Length), Rating: e.Rating}
+		if e.Work.FormatPubDate() != "" {
+			story.PubDate = json.Number(e.Work.FormatPubDate())
+		}
+		for _, a := range e.Work.Authors {
+			story.Authors = append(story.Authors, a.FullName)
+		}
+		raw.Contents = append(raw.Contents, story)
+	}
+	return raw
+}
+
+// All books in the database in the book_database.json format, keyed by author.
+func BookMapFromDatabase(db *gorm.DB) (load.BookMap, error) {
+	var books []Book
+	result := db.Preload("OpenLibraryBookIsbns", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("OpenLibraryBookAuthors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("ReadingSessions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Preload("Tags").Preload("SeriesEntries.Series").Preload("Credits.Author").Preload("Contents.Work.Authors", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped() // A story keeps its author even while the author is in the trash
+	}).Order("id ASC").Find(&books)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	bookData := make(load.BookMap)
+	for _, b := range books {
+		bookData[b.AuthorFullName] = append(bookData[b.AuthorFullName], toRawBook(b))
+	}
+	return bookData, nil
+}
+
+// Writes a file that -load-books with -createdb turns back into the same books, rated with the same scheme.
+func TransferDatabaseBooksToJson(db *gorm.DB, jsonFileName string) error {
+	bookData, err := BookMapFromDatabase(db)
+	if err != nil {
+		return err
+	}
+	var levels []load.RawRatingLevel
+	for _, level := range RatingLevels() {
+		levels = append(levels, load.RawRatingLevel{Slug: level.Slug, Label: level.Label, Description: level.Description,
+			SortOrder: level.SortOrder, Score: level.Score, CssClass: level.CssClass})
+	}
+	aliases, err := aliasMapFromDatabase(db)
+	if err != nil {
+		return err
+	}
+	return load.SaveBookDataToJsonFile(bookData, levels, aliases, jsonFileName)
+}
+
+// Every author's aliases, keyed by the author's name.
+func aliasMapFromDatabase(db *gorm.DB) (load.AliasMap, error) {
+	var authors []Author
+	err := db.Preload("Aliases", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name ASC")
+	}).Where("id IN (SELECT author_id FROM author_aliases WHERE deleted_at IS NULL)").Find(&authors).Error
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(load.AliasMap)
+	for _, a := range authors {
+		for _, alias := range a.Aliases {
+			aliases[a.FullName] = append(aliases[a.FullName], load.RawAuthorAlias{Name: alias.Name, Type: string(alias.Type), SiteName: alias.SiteName})
+		}
+	}
+	return aliases, nil
+}
+
+// Gives the authors the aliases saved in a book file, making any author who isn't in the catalog
+// yet. It runs after the books are loaded, so no one's books are taken for someone else's alias.
+func importAuthorAliases(db *gorm.DB, jsonFileName string) error {
+	aliases, err := load.AuthorAliasesFromJsonFile(jsonFileName)
+	if err != nil || len(aliases) == 0 {
+		return err
+	}
+	var names []string
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		author := fromRawAuthor(name)
+		if err := db.Where("full_name = ?", name).FirstOrCreate(&author).Error; err != nil {
+			return err
+		}
+		for _, raw := range aliases[name] {
+			aliasType, err := ParseAliasType(raw.Type)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			alias := AuthorAlias{AuthorID: author.ID, Name: raw.Name}
+			if err := db.Where(alias).Assign(AuthorAlias{Type: aliasType, SiteName: raw.SiteName}).FirstOrCreate(&alias).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Makes the database's rating scheme the one saved in a book file, if it has one. Levels the file
+// doesn't have are removed unless something is still rated with them.
+func importRatingLevels(db *gorm.DB, jsonFileName string) error {
+	raw, err := load.RatingLevelsFromJsonFile(jsonFileName)
+	if err != nil || len(raw) == 0 {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing []RatingLevel
+		if err := tx.Find(&existing).Error; err != nil {
+			return err
+		}
+		bySlug := make(map[string]RatingLevel, len(existing))
+		for _, level := range existing {
+			bySlug[level.Slug] = level
+		}
+		for _, r := range raw {
+			level := bySlug[r.Slug]
+			delete(bySlug, r.Slug)
+			level.Slug, level.Label, level.Description = r.Slug, r.Label, r.Description
+			level.SortOrder, level.Score, level.CssClass = r.SortOrder, r.Score, r.CssClass
+			if err := tx.Save(&level).Error; err != nil {
+				return err
+			}
+		}
+		for _, unused := range bySlug {
+			if err := DeleteRatingLevel(tx, unused.ID); err != nil && !errors.Is(err, ErrRatingInUse) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return LoadRatingLevels(db)
+}
+
 func AllBooksFromJson(bookFile string) BooksByAuthor {
 	ret := make(BooksByAuthor)
-	loadedBooks := load.MarshalledBookDataFromJsonFile(bookFile)
+	loadedBooks := load.MarshalledBookDataFromJsonFile(bookFile, RatingSlugs())
 	for author, rawBooks := range loadedBooks {
 		fmt.Println("Loading books for author ", author)
 
@@ -450,18 +764,33 @@ func AllBooksFromJson(bookFile string) BooksByAuthor {
 	return ret
 }
 
+// Checks a book file without loading it.
+func ValidateJsonBooks(jsonFileName string) ([]load.ValidationProblem, error) {
+	return load.ValidateBookJsonFile(jsonFileName, RatingSlugs())
+}
+
 func TransferJsonBooksToDatabase(jsonFileName string, db *gorm.DB) error {
+	if err := importRatingLevels(db, jsonFileName); err != nil {
+		return err
+	}
 	parsedBookData := AllBooksFromJson(jsonFileName)
-	for a, books := range parsedBookData {
+	authors, err := NewAuthorIndex(db)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for a := range parsedBookData {
+		names = append(names, a)
+	}
+	sort.Strings(names)
+	for _, a := range names {
+		books := parsedBookData[a]
 		if Verbose {
 			fmt.Println("Save books by ", a)
 		}
-		// Right now all books have a single author but that should not be
-		// the way we model it.
-		author := fromRawAuthor(a)
-		result := db.Create(&author)
-		if result.Error != nil {
-			return result.Error
+		author, err := findOrCreateJsonAuthor(db, authors, a)
+		if err != nil {
+			return err
 		}
 
 		var authorObjects []Author
@@ -469,24 +798,97 @@ func TransferJsonBooksToDatabase(jsonFileName string, db *gorm.DB) error {
 
 		for _, b := range books {
 			b.Authors = authorObjects
+			var tagNames []string
+			for _, t := range b.Tags {
+				tagNames = append(tagNames, t.Name)
+			}
+			seriesEntries, credits, contents := b.SeriesEntries, b.Credits, b.Contents
+			b.Tags, b.SeriesEntries, b.Credits, b.Contents = nil, nil, nil, nil
 			result := db.Create(&b)
 			if result.Error != nil {
 				return result.Error
 			}
-
+			if len(credits) > 0 {
+				for i := range credits {
+					credited, err := findOrCreateJsonAuthor(db, authors, credits[i].Author.FullName)
+					if err != nil {
+						return err
+					}
+					credits[i].AuthorID = credited.ID
+				}
+				if err := SetBookCredits(db, &b, credits); err != nil {
+					return err
+				}
+			}
+			if len(tagNames) > 0 {
+				if err := SetBookTags(db, &b, tagNames); err != nil {
+					return err
+				}
+			}
+			for _, e := range seriesEntries {
+				series, err := findOrCreateSeries(db, e.Series.Name, e.Series.Description)
+				if err != nil {
+					return err
+				}
+				if err := AddBookToSeries(db, series.ID, b.ID, e.Position); err != nil {
+					return err
+				}
+			}
+			for _, e := range contents {
+				work := e.Work
+				for i := range work.Authors {
+					work.Authors[i], err = findOrCreateJsonAuthor(db, authors, work.Authors[i].FullName)
+					if err != nil {
+						return err
+					}
+				}
+				if work, err = findOrCreateWork(db, work); err != nil {
+					return err
+				}
+				if _, err := AddToContents(db, b, work, e.Rating); err != nil {
+					return err
+				}
+			}
 		}
 	}
-	return nil
+	return importAuthorAliases(db, jsonFileName)
+}
+
+// The author with the name, or the alias, made if there isn't one yet.
+func findOrCreateJsonAuthor(db *gorm.DB, authors *AuthorIndex, name string) (Author, error) {
+	author, known := authors.Find(name)
+	if known {
+		return author, nil
+	}
+	author = fromRawAuthor(name)
+	if err := db.Create(&author).Error; err != nil {
+		return author, err
+	}
+	authors.Add(author)
+	return author, nil
 }
 
 func CreateBooksDatabase(databaseName string) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(databaseName), &gorm.Config{})
 	exitOnError("can't connect to Sqlite database.", err)
-	e := db.AutoMigrate(&Book{}, &Author{}, &OpenLibraryBookAuthor{}, &OpenLibraryBookIsbn{})
+	e := MigrateSchema(db)
 	exitOnError("error running migrations: ", e)
 	return db
 }
 
+// Brings the database up to the current schema by applying any pending migrations, then loads the
+// rating levels and sets up search.
+func MigrateSchema(db *gorm.DB) error {
+	if _, err := MigrateUp(db, ""); err != nil {
+		return err
+	}
+	if err := LoadRatingLevels(db); err != nil {
+		return err
+	}
+	_, err := SetupBookSearch(db)
+	return err
+}
+
 func exitOnError(msg string, err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "\n", msg)
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Masters can be GIF, PNG or WebP as well as JPEG
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/chai2010/webp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Every cover is cut to the same 2:3 shape and scaled to these sizes, so pages can give an image's
+// dimensions before it has loaded.
+var CoverDimensions = map[string]image.Point{
+	SmallCover:  {X: 60, Y: 90},
+	MediumCover: {X: 180, Y: 270},
+	LargeCover:  {X: 400, Y: 600},
+}
+
+const (
+	jpegQuality = 85
+	webpQuality = 80
+)
+
+// The image the sizes are made from, kept as it was fetched or uploaded so they can be remade.
+// It stays out of git, which only keeps the JPEG sizes the admin pages show.
+func CoverMasterFile(imageDir string, b Book) string {
+	return path.Join(imageDir, b.coverFileId()+"-master")
+}
+
+func (b Book) MakeCoverWebpFilename(imageDir string, size string) string {
+	return path.Join(imageDir, fmt.Sprintf("%s-%s.webp", b.coverFileId(), size))
+}
+
+// Saves a cover as fetched or uploaded and makes its JPEG sizes from it.
+func SaveCoverMaster(imageDir string, b Book, master []byte) error {
+	if _, _, err := image.DecodeConfig(bytes.NewReader(master)); err != nil {
+		return fmt.Errorf("cover %s isn't an image: %w", b.coverFileId(), err)
+	}
+	if err := os.MkdirAll(imageDir, 0775); err != nil {
+		return fmt.Errorf("can't create directory for saved cover images: %w", err)
+	}
+	if err := writeFileAtomic(CoverMasterFile(imageDir, b), master); err != nil {
+		return err
+	}
+	return ProcessCover(imageDir, b)
+}
+
+// Makes the JPEG of every size from the saved master.
+func ProcessCover(imageDir string, b Book) error {
+	original, err := decodeCoverFile(CoverMasterFile(imageDir, b), b)
+	if err != nil {
+		return err
+	}
+	return writeCoverSizes(imageDir, b, original, false)
+}
+
+// Whether every JPEG size is saved and the large one can be read, so there's nothing to fetch.
+// Covers saved before there were masters count too; they're only cut to shape on the site.
+func HaveSavedCover(imageDir string, b Book) bool {
+	for _, size := range CoverSizes {
+		if !haveCoverFile(b.MakeCoverImageFilename(imageDir, size)) {
+			return false
+		}
+	}
+	large, err := os.Open(b.MakeCoverImageFilename(imageDir, LargeCover))
+	if err != nil {
+		return false
+	}
+	defer large.Close()
+	_, _, err = image.DecodeConfig(large)
+	return err == nil
+}
+
+// Makes the JPEG and WebP of every size of the books' saved covers in the site's image directory,
+// from the master or, for covers saved before there were masters, the large JPEG. The saved covers
+// are kept in git, so they're never changed here. Sizes already made since the saved image last
+// changed are kept. Covers that can't be read are copied as they are, and the placeholders are copied too.
+func MakeSiteCovers(savedDir string, siteDir string, books []Book) (int, []CoverFailure) {
+	processed := 0
+	var failed []CoverFailure
+	// If it can't be made, each cover fails on its own
+	os.MkdirAll(siteDir, 0775)
+	placeholder := Book{OlCoverId: Missing}
+	for _, size := range CoverSizes {
+		copyCoverFile(placeholder.MakeCoverImageFilename(savedDir, size), placeholder.MakeCoverImageFilename(siteDir, size))
+	}
+
+	seen := make(map[string]bool)
+	for _, b := range books {
+		if !b.HasCover() || seen[b.coverFileId()] {
+			continue
+		}
+		seen[b.coverFileId()] = true
+		source := CoverMasterFile(savedDir, b)
+		if !haveCoverFile(source) {
+			source = b.MakeCoverImageFilename(savedDir, LargeCover)
+		}
+		info, err := os.Stat(source)
+		if err != nil || info.Size() == 0 || haveSiteCover(siteDir, b, info.ModTime()) {
+			continue
+		}
+		original, err := decodeCoverFile(source, b)
+		if err == nil {
+			err = writeCoverSizes(siteDir, b, original, true)
+		}
+		if err != nil {
+			failed = append(failed, CoverFailure{b.OlCoverId, b.FormatTitle(), err})
+			for _, size := range CoverSizes {
+				copyCoverFile(b.MakeCoverImageFilename(savedDir, size), b.MakeCoverImageFilename(siteDir, size))
+			}
+			continue
+		}
+		processed++
+	}
+	return processed, failed
+}
+
+// Site cover files, as opposed to anything else that might be in the directory.
+var siteCoverFile = regexp.MustCompile(`^(uploaded-)?\d+-[SML]\.(jpg|webp)$`)
+
+// Removes covers from the site that none of the books use any more, like those of books that were
+// purged or replaced. Returns how many files were removed.
+func RemoveUnusedSiteCovers(siteDir string, books []Book) (int, error) {
+	used := make(map[string]bool)
+	for _, b := range books {
+		if !b.HasCover() {
+			continue
+		}
+		for _, size := range CoverSizes {
+			used[path.Base(b.MakeCoverImageFilename(siteDir, size))] = true
+			used[path.Base(b.MakeCoverWebpFilename(siteDir, size))] = true
+		}
+	}
+	files, err := os.ReadDir(siteDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var problems []error
+	for _, file := range files {
+		if file.IsDir() || used[file.Name()] || !siteCoverFile.MatchString(file.Name()) {
+			continue
+		}
+		if err := os.Remove(path.Join(siteDir, file.Name())); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		removed++
+	}
+	return removed, errors.Join(problems...)
+}
+
+// Whether every size is in the site in both formats, made no earlier than the saved image changed.
+func haveSiteCover(siteDir string, b Book, since time.Time) bool {
+	for _, size := range CoverSizes {
+		for _, file := range []string{b.MakeCoverImageFilename(siteDir, size), b.MakeCoverWebpFilename(siteDir, size)} {
+			info, err := os.Stat(file)
+			if err != nil || info.Size() == 0 || info.ModTime().Before(since) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func decodeCoverFile(file string, b Book) (image.Image, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("no image to make cover %s from: %w", b.coverFileId(), err)
+	}
+	original, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("can't read the image for cover %s: %w", b.coverFileId(), err)
+	}
+	return original, nil
+}
+
+// Writes the JPEG of every size into the directory, and the WebP too when withWebp is set.
+func writeCoverSizes(dir string, b Book, original image.Image, withWebp bool) error {
+	for _, size := range CoverSizes {
+		scaled := fillCover(original, CoverDimensions[size])
+		var jpegData bytes.Buffer
+		if err := jpeg.Encode(&jpegData, scaled, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return fmt.Errorf("can't make the %s JPEG of cover %s: %w", size, b.coverFileId(), err)
+		}
+		if err := writeFileAtomic(b.MakeCoverImageFilename(dir, size), jpegData.Bytes()); err != nil {
+			return err
+		}
+		if !withWebp {
+			continue
+		}
+		var webpData bytes.Buffer
+		if err := webp.Encode(&webpData, scaled, &webp.Options{Quality: webpQuality}); err != nil {
+			return fmt.Errorf("can't make the %s WebP of cover %s: %w", size, b.coverFileId(), err)
+		}
+		if err := writeFileAtomic(b.MakeCoverWebpFilename(dir, size), webpData.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing files are left out; there's nothing better to put on the site.
+func copyCoverFile(from string, to string) {
+	if data, err := os.ReadFile(from); err == nil {
+		writeFileAtomic(to, data)
+	}
+}
+
+// Scales the image to cover the whole size, trimming whatever sticks out equally from both sides,
+// the way CSS object-fit: cover does. Covers are nearly all close to 2:3, so little is lost.
+func fillCover(original image.Image, size image.Point) image.Image {
+	bounds := original.Bounds()
+	crop := bounds
+	if bounds.Dx()*size.Y > bounds.Dy()*size.X {
+		width := bounds.Dy() * size.X / size.Y
+		crop.Min.X += (bounds.Dx() - width) / 2
+		crop.Max.X = crop.Min.X + width
+	} else {
+		height := bounds.Dx() * size.Y / size.X
+		crop.Min.Y += (bounds.Dy() - height) / 2
+		crop.Max.Y = crop.Min.Y + height
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), original, crop, draw.Src, nil)
+	return scaled
+}
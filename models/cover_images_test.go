@@ -0,0 +1,196 @@
+package models
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chai2010/webp"
+)
+
+// A wide image, red in the middle with blue bands down the sides that cropping to 2:3 cuts off.
+func wideCoverPng(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			if x < 80 || x >= 220 {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func tinyPng(t *testing.T) []byte {
+	var out bytes.Buffer
+	if err := png.Encode(&out, image.NewRGBA(image.Rect(0, 0, 30, 45))); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// Checks every size of the cover in the directory is cut to shape, in WebP too when expected.
+func checkCoverSizes(t *testing.T, dir string, b Book, withWebp bool) {
+	t.Helper()
+	for _, size := range CoverSizes {
+		files := []string{b.MakeCoverImageFilename(dir, size)}
+		if withWebp {
+			files = append(files, b.MakeCoverWebpFilename(dir, size))
+		} else if _, err := os.Stat(b.MakeCoverWebpFilename(dir, size)); err == nil {
+			t.Errorf("Didn't expect a WebP in %s", dir)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var img image.Image
+			if strings.HasSuffix(file, ".webp") {
+				img, err = webp.Decode(bytes.NewReader(data))
+			} else {
+				img, _, err = image.Decode(bytes.NewReader(data))
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+			if img.Bounds().Size() != CoverDimensions[size] {
+				t.Errorf("%s is %v, expected %v", file, img.Bounds().Size(), CoverDimensions[size])
+			}
+			// The blue sides were cropped off
+			r, _, b, _ := img.At(1, img.Bounds().Dy()/2).RGBA()
+			if r>>8 < 200 || b>>8 > 60 {
+				t.Errorf("%s: expected the edge to be red after cropping, got r=%d b=%d", file, r>>8, b>>8)
+			}
+		}
+	}
+}
+
+func TestProcessCover(t *testing.T) {
+	imageDir := t.TempDir()
+	if err := SaveCoverMaster(imageDir, Book{OlCoverId: 42}, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	checkCoverSizes(t, imageDir, Book{OlCoverId: 42}, false)
+	if !HaveSavedCover(imageDir, Book{OlCoverId: 42}) {
+		t.Error("Expected the cover to count as saved")
+	}
+}
+
+func TestSaveCoverMasterRejectsNonImages(t *testing.T) {
+	imageDir := t.TempDir()
+	if err := SaveCoverMaster(imageDir, Book{OlCoverId: 42}, []byte("<html>Not found</html>")); err == nil {
+		t.Error("Expected an error saving a page as a cover")
+	}
+	if _, err := os.Stat(CoverMasterFile(imageDir, Book{OlCoverId: 42})); err == nil {
+		t.Error("A page was saved as a cover")
+	}
+}
+
+func TestCoverImageTag(t *testing.T) {
+	ImageDir = "images/cover_images"
+
+	tag := string(Book{OlCoverId: 42}.makeImageTagForCover(MediumCover, ".."))
+	for _, expected := range []string{
+		`<picture><source type="image/webp" srcset="../images/cover_images/42-S.webp 60w, ../images/cover_images/42-M.webp 180w, ../images/cover_images/42-L.webp 400w" sizes="180px" />`,
+		`srcset="../images/cover_images/42-S.jpg 60w, ../images/cover_images/42-M.jpg 180w, ../images/cover_images/42-L.jpg 400w"`,
+		`<img src="../images/cover_images/42-M.jpg" alt="Open Library" width="180" height="270" /></picture>`,
+	} {
+		if !strings.Contains(tag, expected) {
+			t.Errorf("Expected %s in %s", expected, tag)
+		}
+	}
+
+	placeholder := string(Book{OlCoverId: Missing}.makeImageTagForCover(LargeCover, "."))
+	if placeholder != `<img src="images/cover_images/placeholder-L.jpg" alt="Open Library" width="400" height="600" />` {
+		t.Errorf("Unexpected placeholder tag %s", placeholder)
+	}
+}
+
+func TestMakeSiteCovers(t *testing.T) {
+	savedDir := t.TempDir()
+	siteDir := filepath.Join(t.TempDir(), "images")
+	legacy := Book{OlCoverId: 42}.MakeCoverImageFilename(savedDir, LargeCover)
+	os.WriteFile(legacy, wideCoverPng(t), 0644)
+	pointer := []byte("version https://git-lfs.github.com/spec/v1")
+	os.WriteFile(Book{OlCoverId: 43}.MakeCoverImageFilename(savedDir, LargeCover), pointer, 0644)
+	uploaded := Book{MainTitle: "Uploaded", CoverSource: CoverSourceUploaded, UploadedCoverId: 1}
+	if err := SaveCoverMaster(savedDir, uploaded, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(Book{OlCoverId: Missing}.MakeCoverImageFilename(savedDir, SmallCover), tinyPng(t), 0644)
+	before, _ := os.ReadDir(savedDir)
+
+	books := []Book{{MainTitle: "Saved", OlCoverId: 42}, {MainTitle: "Pointer", OlCoverId: 43}, uploaded,
+		{MainTitle: "Never saved", OlCoverId: 44}, {MainTitle: "No cover", OlCoverId: Missing}}
+	processed, failed := MakeSiteCovers(savedDir, siteDir, books)
+	if processed != 2 || len(failed) != 1 || failed[0].CoverId != 43 {
+		t.Errorf("Expected two covers made and one failure, got %d, %+v", processed, failed)
+	}
+	checkCoverSizes(t, siteDir, Book{OlCoverId: 42}, true)
+	checkCoverSizes(t, siteDir, uploaded, true)
+	if data, _ := os.ReadFile(Book{OlCoverId: 43}.MakeCoverImageFilename(siteDir, LargeCover)); !bytes.Equal(data, pointer) {
+		t.Error("Expected the unreadable cover to be copied as it is")
+	}
+	if _, err := os.Stat(Book{OlCoverId: Missing}.MakeCoverImageFilename(siteDir, SmallCover)); err != nil {
+		t.Error("Expected the placeholder to be copied")
+	}
+
+	// The saved covers are kept in git, so nothing is added to them or changed
+	after, _ := os.ReadDir(savedDir)
+	if len(after) != len(before) {
+		t.Errorf("Expected nothing added to the saved covers, had %d files, now %d", len(before), len(after))
+	}
+	if data, _ := os.ReadFile(legacy); !bytes.Equal(data, wideCoverPng(t)) {
+		t.Error("The saved cover was changed")
+	}
+
+	// Nothing to do the second time, until the saved cover changes
+	if processed, _ := MakeSiteCovers(savedDir, siteDir, books[:1]); processed != 0 {
+		t.Errorf("Expected nothing made again, got %d", processed)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(legacy, later, later)
+	if processed, _ := MakeSiteCovers(savedDir, siteDir, books[:1]); processed != 1 {
+		t.Errorf("Expected the changed cover to be made again, got %d", processed)
+	}
+}
+
+func TestRemoveUnusedSiteCovers(t *testing.T) {
+	siteDir := t.TempDir()
+	kept := Book{OlCoverId: 42}
+	purged := Book{CoverSource: CoverSourceUploaded, UploadedCoverId: 7}
+	placeholder := Book{OlCoverId: Missing}
+	for _, b := range []Book{kept, purged, placeholder} {
+		for _, size := range CoverSizes {
+			os.WriteFile(b.MakeCoverImageFilename(siteDir, size), []byte("cover"), 0644)
+			os.WriteFile(b.MakeCoverWebpFilename(siteDir, size), []byte("cover"), 0644)
+		}
+	}
+	os.WriteFile(filepath.Join(siteDir, "notes.txt"), []byte("not a cover"), 0644)
+
+	removed, err := RemoveUnusedSiteCovers(siteDir, []Book{kept, {MainTitle: "No cover", OlCoverId: Missing}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 6 || HaveSavedCover(siteDir, purged) {
+		t.Errorf("Expected the purged cover's 6 files to be removed, got %d", removed)
+	}
+	for _, file := range []string{kept.MakeCoverWebpFilename(siteDir, LargeCover), placeholder.MakeCoverImageFilename(siteDir, SmallCover),
+		filepath.Join(siteDir, "notes.txt")} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s to be kept", file)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+var CoverSizes = []string{SmallCover, MediumCover, LargeCover}
+
+// Where a sync records its progress, inside the image directory. It's ignored by git.
+const CoverSyncJournal = ".cover_sync.json"
+
+// Downloads the cover of every book that doesn't have one saved and makes its sizes. Several workers
+// fetch at once but all of them share one rate limit, so Open Library sees a steady trickle of requests.
+type CoverSync struct {
+	Provider MetadataProvider
+	ImageDir string
+	Workers  int
+	Limiter  *rate.Limiter
+	// Also skip covers the last run found Open Library doesn't have, so an interrupted run
+	// picks up where it stopped rather than asking for them again.
+	Resume bool
+	// Called as each cover finishes, for showing progress
+	Progress func(done int, total int)
+}
+
+// Open Library asks for no more than about one request a second from scripts like this one.
+func NewCoverSync(provider MetadataProvider, imageDir string, workers int, requestsPerSecond float64, burst int) *CoverSync {
+	return &CoverSync{
+		Provider: provider,
+		ImageDir: imageDir,
+		Workers:  workers,
+		Limiter:  rate.NewLimiter(rate.Limit(requestsPerSecond), burst),
+	}
+}
+
+type CoverFailure struct {
+	CoverId int64
+	Title   string
+	Err     error
+}
+
+type CoverSyncReport struct {
+	Fetched     int
+	Processed   int // Sizes made from a master already saved, without fetching it
+	Skipped     int // Already saved, or known to be missing when resuming
+	NoCoverId   int // Books Open Library hasn't given a cover ID
+	Failed      []CoverFailure
+	Interrupted bool
+}
+
+func (r CoverSyncReport) Print(w io.Writer) {
+	for _, f := range r.Failed {
+		fmt.Fprintf(w, "Failed %d for \"%s\": %v\n", f.CoverId, f.Title, f.Err)
+	}
+	fmt.Fprintf(w, "Fetched %d, processed %d, skipped %d, failed %d covers. %d books have no cover ID.\n",
+		r.Fetched, r.Processed, r.Skipped, len(r.Failed), r.NoCoverId)
+	if r.Interrupted {
+		fmt.Fprintln(w, "Interrupted, run -getimages -resume to carry on.")
+	}
+}
+
+// What a run has learned, saved after every cover so it survives being killed.
+type coverSyncJournal struct {
+	Missing []int64 `json:"missing"` // Cover IDs Open Library answered with a 404
+}
+
+type coverJob struct {
+	coverId int64
+	title   string
+}
+
+type coverOutcome int
+
+const (
+	coverFetched coverOutcome = iota
+	coverProcessed
+)
+
+type coverResult struct {
+	coverJob
+	outcome coverOutcome
+	err     error
+}
+
+func (cs *CoverSync) Run(ctx context.Context, books []Book) (CoverSyncReport, error) {
+	var report CoverSyncReport
+	if err := os.MkdirAll(cs.ImageDir, 0775); err != nil {
+		return report, fmt.Errorf("can't create directory for saved cover images: %w", err)
+	}
+	removeStaleTempFiles(cs.ImageDir)
+
+	journalFile := filepath.Join(cs.ImageDir, CoverSyncJournal)
+	knownMissing := make(map[int64]bool)
+	if cs.Resume {
+		journal, err := readCoverSyncJournal(journalFile)
+		if err != nil {
+			return report, err
+		}
+		for _, id := range journal.Missing {
+			knownMissing[id] = true
+		}
+	}
+
+	// Books sharing a cover, like different printings, only need it fetched once
+	var jobs []coverJob
+	queued := make(map[int64]bool)
+	for _, b := range books {
+		// Never replaced with Open Library's
+		if b.HasUploadedCover() {
+			report.Skipped++
+			continue
+		}
+		if !b.HasCoverImageId() {
+			report.NoCoverId++
+			continue
+		}
+		if queued[b.OlCoverId] {
+			continue
+		}
+		queued[b.OlCoverId] = true
+		if knownMissing[b.OlCoverId] || HaveSavedCover(cs.ImageDir, Book{OlCoverId: b.OlCoverId}) {
+			report.Skipped++
+			continue
+		}
+		jobs = append(jobs, coverJob{coverId: b.OlCoverId, title: b.FormatTitle()})
+	}
+
+	// Cancelled to stop the workers early if the journal can't be written
+	work, stop := context.WithCancel(ctx)
+	defer stop()
+	pending := make(chan coverJob)
+	results := make(chan coverResult)
+	var workers sync.WaitGroup
+	for i := 0; i < max(cs.Workers, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range pending {
+				outcome, err := cs.fetchCover(work, job.coverId)
+				results <- coverResult{coverJob: job, outcome: outcome, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(pending)
+		for _, job := range jobs {
+			select {
+			case pending <- job:
+			case <-work.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	journal := coverSyncJournal{}
+	for id := range knownMissing {
+		journal.Missing = append(journal.Missing, id)
+	}
+	done := 0
+	for result := range results {
+		done++
+		switch {
+		case result.err == nil && result.outcome == coverFetched:
+			report.Fetched++
+		case result.err == nil:
+			report.Processed++
+		case ctx.Err() != nil && errors.Is(result.err, ctx.Err()):
+			// Stopped part way through, the cover will be fetched next time
+		default:
+			report.Failed = append(report.Failed, CoverFailure{result.coverId, result.title, result.err})
+			if errors.Is(result.err, ErrNotFound) {
+				journal.Missing = append(journal.Missing, result.coverId)
+			}
+		}
+		if err := writeCoverSyncJournal(journalFile, journal); err != nil {
+			// Workers block until their results are taken, so let them finish before returning
+			stop()
+			for range results {
+			}
+			return report, err
+		}
+		if cs.Progress != nil {
+			cs.Progress(done, len(jobs))
+		}
+	}
+	report.Interrupted = ctx.Err() != nil
+	sort.Slice(report.Failed, func(i, j int) bool { return report.Failed[i].CoverId < report.Failed[j].CoverId })
+	return report, writeCoverSyncJournal(journalFile, journal)
+}
+
+// Makes the sizes from the master if it's saved, only fetching the cover when it isn't or it can't be read.
+func (cs *CoverSync) fetchCover(ctx context.Context, coverId int64) (coverOutcome, error) {
+	b := Book{OlCoverId: coverId}
+	if haveCoverFile(CoverMasterFile(cs.ImageDir, b)) && ProcessCover(cs.ImageDir, b) == nil {
+		return coverProcessed, nil
+	}
+	if err := cs.Limiter.Wait(ctx); err != nil {
+		return coverFetched, err
+	}
+	master, err := cs.Provider.FetchCover(coverId, LargeCover)
+	if err != nil {
+		return coverFetched, err
+	}
+	return coverFetched, SaveCoverMaster(cs.ImageDir, b, master)
+}
+
+func haveCoverFile(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && info.Size() > 0
+}
+
+const tempFilePattern = ".cover-*.tmp"
+
+// Writes to a temporary file first, so an interrupted download never leaves a truncated image
+// that later runs would take for a finished one.
+func writeFileAtomic(file string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(file), tempFilePattern)
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("can't save %s: %w", file, err)
+	}
+	return nil
+}
+
+// Left behind if the process was killed part way through a write.
+func removeStaleTempFiles(dir string) {
+	stale, _ := filepath.Glob(filepath.Join(dir, tempFilePattern))
+	for _, file := range stale {
+		os.Remove(file)
+	}
+}
+
+func readCoverSyncJournal(file string) (coverSyncJournal, error) {
+	var journal coverSyncJournal
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return journal, nil
+	}
+	if err != nil {
+		return journal, err
+	}
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return journal, fmt.Errorf("can't read cover sync progress from %s: %w", file, err)
+	}
+	return journal, nil
+}
+
+func writeCoverSyncJournal(file string, journal coverSyncJournal) error {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data)
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccdavis/sfwr/openlibrarytest"
+	"golang.org/x/time/rate"
+)
+
+func countRequests(requests []string, path string) int {
+	count := 0
+	for _, r := range requests {
+		if strings.HasPrefix(r, path) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCoverSync(t *testing.T) {
+	ol, server := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+
+	// The Left Hand of Darkness was saved before covers were processed, with only Open Library's sizes
+	for _, size := range CoverSizes {
+		os.WriteFile(filepath.Join(imageDir, "8231856-"+size+".jpg"), openlibrarytest.Cover(8231856, size), 0644)
+	}
+	// Foundation's master was saved but not its sizes
+	os.WriteFile(CoverMasterFile(imageDir, Book{OlCoverId: 9999}), openlibrarytest.Cover(8231856, LargeCover), 0644)
+	// Left over from a run that was killed
+	os.WriteFile(filepath.Join(imageDir, ".cover-123.tmp"), []byte("partial"), 0644)
+
+	books := []Book{
+		{MainTitle: "Dune", OlCoverId: 11481354},
+		{MainTitle: "The Dispossessed", OlCoverId: 8220836},
+		{MainTitle: "The Dispossessed", SubTitle: "An Ambiguous Utopia", OlCoverId: 8220836},
+		{MainTitle: "The Left Hand of Darkness", OlCoverId: 8231856},
+		{MainTitle: "Foundation", OlCoverId: 9999},
+		{MainTitle: "Unknown Cover", OlCoverId: 12345},
+		{MainTitle: "No Cover", OlCoverId: Missing},
+	}
+	server.FailNext("/b/id/8220836-L.jpg", 1)
+
+	covers := &CoverSync{Provider: ol, ImageDir: imageDir, Workers: 3, Limiter: rate.NewLimiter(rate.Inf, 1)}
+	report, err := covers.Run(context.Background(), books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fetched != 1 || report.Processed != 1 || report.Skipped != 1 || report.NoCoverId != 1 || len(report.Failed) != 2 || report.Interrupted {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if report.Failed[0].CoverId != 12345 || !errors.Is(report.Failed[0].Err, ErrNotFound) || report.Failed[1].CoverId != 8220836 {
+		t.Errorf("Unexpected failures %+v", report.Failed)
+	}
+	if !HaveSavedCover(imageDir, Book{OlCoverId: 11481354}) || !HaveSavedCover(imageDir, Book{OlCoverId: 9999}) {
+		t.Error("Covers weren't processed")
+	}
+	requests := server.Requests()
+	if countRequests(requests, "/b/id/8231856-") != 0 || countRequests(requests, "/b/id/9999-") != 0 {
+		t.Error("A cover already saved was downloaded again")
+	}
+	if countRequests(requests, "/b/id/8220836-L") != 1 {
+		t.Error("A cover shared by two books should be downloaded once")
+	}
+	for _, size := range []string{SmallCover, MediumCover} {
+		if countRequests(requests, "/b/id/11481354-"+size) != 0 {
+			t.Errorf("Only the large cover should be downloaded, got %v", requests)
+		}
+	}
+	if stale, _ := filepath.Glob(filepath.Join(imageDir, ".cover-*.tmp")); len(stale) != 0 {
+		t.Errorf("Temporary files left behind: %v", stale)
+	}
+
+	// Resuming skips the cover Open Library doesn't have and fetches the one that failed
+	covers.Resume = true
+	report, err = covers.Run(context.Background(), books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fetched != 1 || report.Skipped != 4 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected report when resuming %+v", report)
+	}
+	requests = server.Requests()[len(requests):]
+	if len(requests) != 1 || countRequests(requests, "/b/id/8220836-L") != 1 {
+		t.Errorf("Unexpected requests when resuming %v", requests)
+	}
+
+	// Without -resume missing covers are tried again
+	covers.Resume = false
+	report, _ = covers.Run(context.Background(), books)
+	if len(report.Failed) != 1 || report.Failed[0].CoverId != 12345 {
+		t.Errorf("Expected the missing cover to be tried again, got %+v", report)
+	}
+}
+
+// Covers checked out without Git LFS are text files standing in for the images.
+func TestCoverSyncReplacesUnreadableCovers(t *testing.T) {
+	ol, _ := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+	pointer := "version https://git-lfs.github.com/spec/v1\noid sha256:0123\nsize 1234\n"
+	os.WriteFile(filepath.Join(imageDir, "11481354-L.jpg"), []byte(pointer), 0644)
+
+	covers := &CoverSync{Provider: ol, ImageDir: imageDir, Workers: 1, Limiter: rate.NewLimiter(rate.Inf, 1)}
+	report, err := covers.Run(context.Background(), []Book{{MainTitle: "Dune", OlCoverId: 11481354}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fetched != 1 || !HaveSavedCover(imageDir, Book{OlCoverId: 11481354}) {
+		t.Errorf("Expected the cover to be fetched again, got %+v", report)
+	}
+}
+
+func TestCoverSyncRejectsPages(t *testing.T) {
+	ol, server := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+	server.SendPageNext("/b/id/11481354-L.jpg", 1)
+
+	covers := &CoverSync{Provider: ol, ImageDir: imageDir, Workers: 1, Limiter: rate.NewLimiter(rate.Inf, 1)}
+	report, err := covers.Run(context.Background(), []Book{{MainTitle: "Dune", OlCoverId: 11481354}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 1 || !strings.Contains(report.Failed[0].Err.Error(), "expected an image") {
+		t.Errorf("Expected the HTML page to be rejected, got %+v", report)
+	}
+	if _, err := os.Stat(CoverMasterFile(imageDir, Book{OlCoverId: 11481354})); err == nil {
+		t.Error("An HTML page was saved as a cover")
+	}
+}
+
+func TestCoverSyncRateLimit(t *testing.T) {
+	ol, _ := newFakeOpenLibrary(t)
+	books := []Book{
+		{MainTitle: "Dune", OlCoverId: 11481354},
+		{MainTitle: "The Dispossessed", OlCoverId: 8220836},
+		{MainTitle: "The Left Hand of Darkness", OlCoverId: 8231856},
+	}
+
+	// Three requests at 20 a second with no burst take at least 100ms however many workers there are
+	covers := NewCoverSync(ol, t.TempDir(), 4, 20, 1)
+	start := time.Now()
+	report, err := covers.Run(context.Background(), books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Fetched != 3 {
+		t.Errorf("Expected 3 covers fetched, got %+v", report)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Requests weren't rate limited, took %v", elapsed)
+	}
+}
+
+func TestCoverSyncInterrupted(t *testing.T) {
+	ol, server := newFakeOpenLibrary(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	covers := NewCoverSync(ol, t.TempDir(), 2, 1, 1)
+	report, err := covers.Run(ctx, []Book{{MainTitle: "Dune", OlCoverId: 11481354}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Interrupted || report.Fetched != 0 || len(report.Failed) != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("Expected no requests once interrupted, got %v", server.Requests())
+	}
+}
+
+// Failing to write the journal stops the run without leaving the workers stuck.
+func TestCoverSyncJournalFailure(t *testing.T) {
+	ol, _ := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+	// The journal can't replace a directory
+	os.Mkdir(filepath.Join(imageDir, CoverSyncJournal), 0755)
+	books := []Book{{MainTitle: "Dune", OlCoverId: 11481354}, {MainTitle: "The Dispossessed", OlCoverId: 8220836},
+		{MainTitle: "The Left Hand of Darkness", OlCoverId: 8231856}}
+
+	covers := &CoverSync{Provider: ol, ImageDir: imageDir, Workers: 1, Limiter: rate.NewLimiter(rate.Inf, 1)}
+	done := make(chan error)
+	go func() {
+		_, err := covers.Run(context.Background(), books)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the journal failure to be returned")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run didn't return after the journal failed")
+	}
+	for start := time.Now(); runGoroutinesLeft(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Run's workers are still waiting after it returned")
+		}
+	}
+}
+
+func runGoroutinesLeft() bool {
+	stacks := make([]byte, 1<<20)
+	n := runtime.Stack(stacks, true)
+	return strings.Contains(string(stacks[:n]), "(*CoverSync).Run.func")
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	"gorm.io/gorm"
+)
+
+// Where a book's cover comes from. Uploaded covers are for books Open Library has none for, and
+// nothing fetched from Open Library replaces them.
+const (
+	CoverSourceOpenLibrary = "openlibrary"
+	CoverSourceUploaded    = "uploaded"
+)
+
+const MaxCoverUploadSize = 10 << 20
+
+var ErrBadCoverImage = errors.New("not a usable cover image")
+
+func (b Book) HasUploadedCover() bool {
+	return b.CoverSource == CoverSourceUploaded && b.UploadedCoverId > 0
+}
+
+// Whether there's any cover to show, uploaded or from Open Library.
+func (b Book) HasCover() bool {
+	return b.HasUploadedCover() || b.HasCoverImageId()
+}
+
+// Uploaded covers get their own names so they can't collide with Open Library's cover IDs.
+func (b Book) coverFileId() string {
+	if b.HasUploadedCover() {
+		return fmt.Sprintf("uploaded-%d", b.UploadedCoverId)
+	}
+	return fmt.Sprint(b.OlCoverId)
+}
+
+// The image must be a JPEG, PNG, GIF or WebP at least as big as the small cover size.
+func ValidateCoverImage(data []byte) error {
+	if len(data) > MaxCoverUploadSize {
+		return fmt.Errorf("%w: larger than %d MB", ErrBadCoverImage, MaxCoverUploadSize>>20)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: use a JPEG, PNG, GIF or WebP", ErrBadCoverImage)
+	}
+	smallest := CoverDimensions[SmallCover]
+	if config.Width < smallest.X || config.Height < smallest.Y {
+		return fmt.Errorf("%w: the %s is only %dx%d, it needs to be at least %dx%d",
+			ErrBadCoverImage, format, config.Width, config.Height, smallest.X, smallest.Y)
+	}
+	return nil
+}
+
+// Saves the image as the book's cover. Every upload gets a new ID, so a replaced cover never shows
+// up from a browser's or the static site's cache.
+func UploadCover(db *gorm.DB, imageDir string, b *Book, data []byte) error {
+	if err := ValidateCoverImage(data); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var lastId int64
+		if err := tx.Unscoped().Model(&Book{}).Select("COALESCE(MAX(uploaded_cover_id), 0)").Scan(&lastId).Error; err != nil {
+			return err
+		}
+		uploaded := *b
+		uploaded.CoverSource = CoverSourceUploaded
+		uploaded.UploadedCoverId = lastId + 1
+		if err := SaveCoverMaster(imageDir, uploaded, data); err != nil {
+			return err
+		}
+		if err := tx.Model(b).Updates(map[string]any{
+			"cover_source":      CoverSourceUploaded,
+			"uploaded_cover_id": uploaded.UploadedCoverId,
+		}).Error; err != nil {
+			return err
+		}
+		b.CoverSource = uploaded.CoverSource
+		b.UploadedCoverId = uploaded.UploadedCoverId
+		return nil
+	})
+}
+
+// Goes back to the Open Library cover. The uploaded files are kept, since the static site may
+// still be showing them until it's next built.
+func UseOpenLibraryCover(db *gorm.DB, b *Book) error {
+	if err := db.Model(b).Update("cover_source", CoverSourceOpenLibrary).Error; err != nil {
+		return err
+	}
+	b.CoverSource = CoverSourceOpenLibrary
+	return nil
+}
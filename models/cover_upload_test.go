@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestUploadCover(t *testing.T) {
+	db := setupTestDB(t)
+	imageDir := t.TempDir()
+
+	book := Book{MainTitle: "Self Published", Rating: "Kindle", OlCoverId: Missing}
+	db.Create(&book)
+	if book.CoverSource != CoverSourceOpenLibrary || book.HasCover() {
+		t.Fatalf("A new book should have no cover from Open Library, got %q", book.CoverSource)
+	}
+
+	if err := UploadCover(db, imageDir, &book, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	var saved Book
+	db.First(&saved, book.ID)
+	if !saved.HasUploadedCover() || saved.UploadedCoverId != 1 {
+		t.Errorf("Expected uploaded cover 1, got %q %d", saved.CoverSource, saved.UploadedCoverId)
+	}
+	if saved.MakeCoverImageFilename("images", MediumCover) != "images/uploaded-1-M.jpg" {
+		t.Errorf("Unexpected cover file %s", saved.MakeCoverImageFilename("images", MediumCover))
+	}
+	if !HaveSavedCover(imageDir, saved) {
+		t.Error("Uploaded cover sizes weren't made")
+	}
+
+	// Replacing it gives a new ID, so no cached copy of the first is shown
+	if err := UploadCover(db, imageDir, &book, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	if book.UploadedCoverId != 2 {
+		t.Errorf("Expected the second upload to be 2, got %d", book.UploadedCoverId)
+	}
+}
+
+func TestUploadCoverRejectsBadImages(t *testing.T) {
+	db := setupTestDB(t)
+	imageDir := t.TempDir()
+	book := Book{MainTitle: "Self Published", Rating: "Kindle"}
+	db.Create(&book)
+
+	for name, data := range map[string][]byte{
+		"not an image": []byte("<html></html>"),
+		"too small":    tinyPng(t),
+	} {
+		if err := UploadCover(db, imageDir, &book, data); !errors.Is(err, ErrBadCoverImage) {
+			t.Errorf("%s: expected ErrBadCoverImage, got %v", name, err)
+		}
+	}
+	var saved Book
+	db.First(&saved, book.ID)
+	if saved.HasUploadedCover() {
+		t.Error("A rejected image was recorded as the cover")
+	}
+	if files, _ := os.ReadDir(imageDir); len(files) != 0 {
+		t.Errorf("Rejected images were saved: %v", files)
+	}
+}
+
+func TestOpenLibraryNeverReplacesUploadedCover(t *testing.T) {
+	db := setupTestDB(t)
+	ol, server := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+
+	book := Book{MainTitle: "Dune", Rating: "Excellent", OlCoverId: Missing}
+	db.Create(&book)
+	if err := UploadCover(db, imageDir, &book, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating from Open Library records its cover but keeps showing the upload
+	updated, err := book.UpdateFromOpenLibrary(db, BookSearchResult{CoverEditionKey: "OL26242482M", CoverImageId: "11481354"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	CaptureAllSizeCovers(ol, updated, imageDir)
+	covers := &CoverSync{Provider: ol, ImageDir: imageDir, Workers: 1, Limiter: rate.NewLimiter(rate.Inf, 1)}
+	report, err := covers.Run(context.Background(), []Book{updated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 1 || report.Fetched != 0 {
+		t.Errorf("Expected the uploaded cover to be skipped, got %+v", report)
+	}
+	if len(server.Requests()) != 0 {
+		t.Errorf("Expected nothing fetched, got %v", server.Requests())
+	}
+
+	var saved Book
+	db.First(&saved, book.ID)
+	if !saved.HasUploadedCover() || saved.OlCoverId != 11481354 {
+		t.Errorf("Expected the uploaded cover kept with the Open Library ID recorded, got %+v", saved)
+	}
+	if saved.MakeCoverImageFilename("images", LargeCover) != "images/uploaded-1-L.jpg" {
+		t.Errorf("Expected the uploaded cover to be shown, got %s", saved.MakeCoverImageFilename("images", LargeCover))
+	}
+
+	// Until it's switched back
+	if err := UseOpenLibraryCover(db, &saved); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&saved, book.ID)
+	if saved.MakeCoverImageFilename("images", LargeCover) != "images/11481354-L.jpg" {
+		t.Errorf("Expected the Open Library cover, got %s", saved.MakeCoverImageFilename("images", LargeCover))
+	}
+}
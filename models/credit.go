@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The part someone had in a book.
+type CreditRole string
+
+const (
+	CreditAuthor      CreditRole = "author"
+	CreditCoAuthor    CreditRole = "co_author"
+	CreditEditor      CreditRole = "editor"
+	CreditTranslator  CreditRole = "translator"
+	CreditIllustrator CreditRole = "illustrator"
+)
+
+var CreditRoles = []CreditRole{CreditAuthor, CreditCoAuthor, CreditEditor, CreditTranslator, CreditIllustrator}
+
+func ParseCreditRole(s string) (CreditRole, error) {
+	for _, role := range CreditRoles {
+		if string(role) == s {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("unknown role: %q, expected author, co_author, editor, translator or illustrator", s)
+}
+
+func (r CreditRole) Label() string {
+	if r == CreditCoAuthor {
+		return "co-author"
+	}
+	return string(r)
+}
+
+// Writers are who a book is by; editors only count when there are no writers.
+func (r CreditRole) isWriter() bool {
+	return r == CreditAuthor || r == CreditCoAuthor
+}
+
+// Links a book to one of the people credited for it. It's the book_authors join table of
+// Book.Authors, so a book's authors include everyone credited, whatever their role.
+type Credit struct {
+	BookID   uint       `gorm:"primaryKey"`
+	AuthorID uint       `gorm:"primaryKey"`
+	Position int        // Order on the cover, from 0
+	Role     CreditRole `gorm:"default:author"`
+	Author   Author
+}
+
+func (Credit) TableName() string {
+	return "book_authors"
+}
+
+// The credits in the order the book gives them.
+func (b Book) OrderedCredits() []Credit {
+	credits := make([]Credit, len(b.Credits))
+	copy(credits, b.Credits)
+	sort.SliceStable(credits, func(left, right int) bool {
+		return credits[left].Position < credits[right].Position
+	})
+	return credits
+}
+
+// Who the book is by, as readers see it: "Larry Niven", "Niven & Pournelle" or "ed. Gardner Dozois".
+// Translators and illustrators are left out. It's AuthorFullName when the credits aren't loaded.
+func (b Book) AuthorDisplayName() string {
+	var writers, editors []Author
+	for _, c := range b.OrderedCredits() {
+		if c.Role.isWriter() {
+			writers = append(writers, c.Author)
+		} else if c.Role == CreditEditor {
+			editors = append(editors, c.Author)
+		}
+	}
+	switch {
+	case len(writers) > 0:
+		return joinCreditNames(writers)
+	case len(editors) == 1:
+		return "ed. " + joinCreditNames(editors)
+	case len(editors) > 1:
+		return "eds. " + joinCreditNames(editors)
+	}
+	return b.AuthorFullName
+}
+
+// A single person by their full name, several by surname: "Niven, Pournelle & Barnes".
+func joinCreditNames(people []Author) string {
+	if len(people) == 1 {
+		return people[0].FullName
+	}
+	var surnames []string
+	for _, p := range people {
+		surnames = append(surnames, p.Surname)
+	}
+	return joinNames(surnames)
+}
+
+// Lists names the way a cover does: "A", "A & B" or "A, B & C".
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	last := len(names) - 1
+	return strings.Join(names[:last], ", ") + " & " + names[last]
+}
+
+// The full names of everyone credited with the role, like "Ken Liu" for a book's translator.
+func (b Book) CreditNames(role string) string {
+	var names []string
+	for _, c := range b.OrderedCredits() {
+		if string(c.Role) == role {
+			names = append(names, c.Author.FullName)
+		}
+	}
+	return joinNames(names)
+}
+
+// Replaces everyone credited for the book with the credits, in the order given. The first person
+// credited is who the book is filed under, in AuthorFullName and AuthorSurname.
+func SetBookCredits(db *gorm.DB, book *Book, credits []Credit) error {
+	if len(credits) == 0 {
+		return errors.New("a book needs at least one person credited")
+	}
+	seen := make(map[uint]bool)
+	for _, c := range credits {
+		if seen[c.AuthorID] {
+			return fmt.Errorf("author %d is credited twice", c.AuthorID)
+		}
+		seen[c.AuthorID] = true
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		saved := make([]Credit, len(credits))
+		err := auditBookList(tx, book.ID, "credits", func() error {
+			if err := tx.Where("book_id = ?", book.ID).Delete(&Credit{}).Error; err != nil {
+				return err
+			}
+			for i, c := range credits {
+				if c.Role == "" {
+					c.Role = CreditAuthor
+				}
+				if err := tx.First(&c.Author, c.AuthorID).Error; err != nil {
+					return fmt.Errorf("author %d: %w", c.AuthorID, err)
+				}
+				c.BookID = book.ID
+				c.Position = i
+				if err := tx.Omit("Author").Create(&c).Error; err != nil {
+					return err
+				}
+				saved[i] = c
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Saving the book re-indexes it for search with the new credits. Its old credits, if they
+		// were loaded, mustn't be saved with it
+		first := saved[0].Author
+		err = tx.Model(book).Omit(clause.Associations).Updates(map[string]interface{}{
+			"author_full_name": first.FullName,
+			"author_surname":   first.Surname,
+		}).Error
+		if err != nil {
+			return err
+		}
+		book.Credits = saved
+		return nil
+	})
+}
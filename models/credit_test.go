@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestCreditRoles(t *testing.T) {
+	for _, role := range CreditRoles {
+		parsed, err := ParseCreditRole(string(role))
+		if err != nil || parsed != role {
+			t.Errorf("Expected %s to parse, got %v %v", role, parsed, err)
+		}
+	}
+	if _, err := ParseCreditRole("ghostwriter"); err == nil {
+		t.Error("Expected an unknown role to be an error")
+	}
+	if CreditCoAuthor.Label() != "co-author" || CreditEditor.Label() != "editor" {
+		t.Error("Unexpected labels")
+	}
+}
+
+func TestAuthorDisplayName(t *testing.T) {
+	niven := Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	barnes := Author{FullName: "Steven Barnes", Surname: "Barnes"}
+	dozois := Author{FullName: "Gardner Dozois", Surname: "Dozois"}
+	dann := Author{FullName: "Jack Dann", Surname: "Dann"}
+	liu := Author{FullName: "Ken Liu", Surname: "Liu"}
+
+	tests := []struct {
+		name     string
+		credits  []Credit
+		expected string
+	}{
+		{"single author", []Credit{{Author: niven, Role: CreditAuthor}}, "Larry Niven"},
+		{"co-authors", []Credit{{Author: niven, Role: CreditAuthor}, {Author: pournelle, Role: CreditCoAuthor, Position: 1}}, "Niven & Pournelle"},
+		{"order by position", []Credit{
+			{Author: barnes, Role: CreditCoAuthor, Position: 2},
+			{Author: pournelle, Role: CreditCoAuthor, Position: 1},
+			{Author: niven, Role: CreditAuthor},
+		}, "Niven, Pournelle & Barnes"},
+		{"editor", []Credit{{Author: dozois, Role: CreditEditor}}, "ed. Gardner Dozois"},
+		{"editors", []Credit{{Author: dann, Role: CreditEditor}, {Author: dozois, Role: CreditEditor, Position: 1}}, "eds. Dann & Dozois"},
+		{"translator left out", []Credit{{Author: niven, Role: CreditAuthor}, {Author: liu, Role: CreditTranslator, Position: 1}}, "Larry Niven"},
+		{"no credits loaded", nil, "Filed Under"},
+	}
+	for _, tt := range tests {
+		book := Book{AuthorFullName: "Filed Under", Credits: tt.credits}
+		if got := book.AuthorDisplayName(); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+
+	book := Book{Credits: []Credit{{Author: niven, Role: CreditAuthor}, {Author: liu, Role: CreditTranslator, Position: 1}}}
+	if book.CreditNames("translator") != "Ken Liu" || book.CreditNames("illustrator") != "" {
+		t.Errorf("Unexpected translator %q", book.CreditNames("translator"))
+	}
+}
+
+func TestSetBookCredits(t *testing.T) {
+	db := setupTestDB(t)
+	pournelle := createAuthorWithBooks(t, db, "Jerry Pournelle")
+	niven := createAuthorWithBooks(t, db, "Larry Niven", "The Mote in God's Eye")
+	var mote Book
+	db.Where("main_title = ?", "The Mote in God's Eye").First(&mote)
+
+	err := SetBookCredits(db, &mote, []Credit{{AuthorID: pournelle.ID, Role: CreditAuthor}, {AuthorID: niven.ID, Role: CreditCoAuthor}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Book
+	db.Preload("Credits.Author").Preload("Authors").First(&saved, mote.ID)
+	if saved.AuthorDisplayName() != "Pournelle & Niven" {
+		t.Errorf("Expected the order given, got %q", saved.AuthorDisplayName())
+	}
+	if saved.AuthorFullName != "Jerry Pournelle" || saved.AuthorSurname != "Pournelle" {
+		t.Errorf("Expected the book filed under the first person credited, got %s", saved.AuthorFullName)
+	}
+	if len(saved.Authors) != 2 {
+		t.Errorf("Expected everyone credited among the authors, got %+v", saved.Authors)
+	}
+
+	var byNiven []Book
+	db.Scopes(ByAuthor(niven.ID)).Find(&byNiven)
+	if len(byNiven) != 1 {
+		t.Errorf("Expected the co-author to still list the book, got %d", len(byNiven))
+	}
+
+	if err := SetBookCredits(db, &mote, nil); err == nil {
+		t.Error("Expected a book with no one credited to be refused")
+	}
+	if err := SetBookCredits(db, &mote, []Credit{{AuthorID: niven.ID}, {AuthorID: niven.ID}}); err == nil {
+		t.Error("Expected the same person credited twice to be refused")
+	}
+	if err := SetBookCredits(db, &mote, []Credit{{AuthorID: niven.ID}, {AuthorID: 999}}); err == nil {
+		t.Error("Expected an unknown author to be refused")
+	}
+	db.Preload("Credits.Author").First(&saved, mote.ID)
+	if saved.AuthorDisplayName() != "Pournelle & Niven" {
+		t.Errorf("Expected a refused change to leave the credits alone, got %q", saved.AuthorDisplayName())
+	}
+}
+
+func TestAuthorsAssociationStillCredits(t *testing.T) {
+	db := setupTestDB(t)
+	createAuthorWithBooks(t, db, "Ursula Le Guin", "The Dispossessed")
+	var book Book
+	db.Preload("Credits.Author").First(&book)
+	if len(book.Credits) != 1 || book.Credits[0].Role != CreditAuthor || book.AuthorDisplayName() != "Ursula Le Guin" {
+		t.Errorf("Expected books linked through Authors to credit an author, got %+v", book.Credits)
+	}
+}
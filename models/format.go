@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// How a book was published or is available. It's kept apart from the rating, which only says how
+// good the book is.
+type BookFormat string
+
+const (
+	Hardcover     BookFormat = "hardcover"
+	Paperback     BookFormat = "paperback"
+	EbookOnly     BookFormat = "ebook_only"
+	Audiobook     BookFormat = "audiobook"
+	SelfPublished BookFormat = "self_published"
+)
+
+var BookFormats = []BookFormat{Hardcover, Paperback, EbookOnly, Audiobook, SelfPublished}
+
+func ParseBookFormat(s string) (BookFormat, error) {
+	for _, format := range BookFormats {
+		if string(format) == s {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format: %q, expected hardcover, paperback, ebook_only, audiobook or self_published", s)
+}
+
+func (f BookFormat) Label() string {
+	switch f {
+	case EbookOnly:
+		return "Ebook only"
+	case SelfPublished:
+		return "Self-published"
+	case "":
+		return ""
+	}
+	return strings.ToUpper(string(f[:1])) + string(f[1:])
+}
+
+func (f BookFormat) SiteName() string {
+	return strings.ReplaceAll(string(f), "_", "-") + ".html"
+}
+
+// Restricts a book query to books with the given format.
+func WithFormat(format BookFormat) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("books.format = ?", format)
+	}
+}
+
+type KindleConversionReport struct {
+	Format     BookFormat
+	Rating     string
+	Books      int64  // Books that were rated Kindle
+	RatingKept string // Why the Kindle rating is still in the scheme, or empty if it was removed
+}
+
+func (r KindleConversionReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d Kindle-rated books are now rated %s, and those without a format are %s.\n", r.Books, r.Rating, r.Format.Label())
+	if r.RatingKept != "" {
+		fmt.Fprintf(w, "The Kindle rating was kept: %s.\n", r.RatingKept)
+	} else {
+		fmt.Fprintln(w, "The Kindle rating was removed.")
+	}
+}
+
+// Converts books rated Kindle, which was both a rating and a note about how the book was published,
+// to the format and a quality rating. Books that already have a format keep it. The Kindle rating is
+// then removed, unless stories still have it.
+func ConvertKindleRatings(db *gorm.DB, rating string, format BookFormat) (KindleConversionReport, error) {
+	report := KindleConversionReport{Format: format, Rating: rating}
+	if rating == Kindle.String() {
+		return report, errors.New("Kindle-rated books need another rating")
+	}
+	if _, err := StringToRating(rating); err != nil {
+		return report, err
+	}
+	if _, err := ParseBookFormat(string(format)); err != nil {
+		return report, err
+	}
+	// Each book is saved on its own so the change goes in its history. Books in the trash are
+	// converted too, or they would keep the Kindle rating in use.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var books []Book
+		if err := tx.Unscoped().Where("rating = ?", Kindle.String()).Order("id").Find(&books).Error; err != nil {
+			return err
+		}
+		for _, book := range books {
+			changes := map[string]interface{}{"rating": rating}
+			if book.Format == "" {
+				changes["format"] = format
+			}
+			if err := tx.Unscoped().Model(&book).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		report.Books = int64(len(books))
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var kindle RatingLevel
+	result := db.Where("slug = ?", Kindle.String()).Limit(1).Find(&kindle)
+	if result.Error != nil {
+		return report, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return report, nil
+	}
+	if err := DeleteRatingLevel(db, kindle.ID); err != nil {
+		report.RatingKept = err.Error()
+	}
+	return report, nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBookFormat(t *testing.T) {
+	if format, err := ParseBookFormat("ebook_only"); err != nil || format != EbookOnly {
+		t.Errorf("Expected ebook_only, got %q %v", format, err)
+	}
+	if _, err := ParseBookFormat("scroll"); err == nil {
+		t.Error("Expected an unknown format to be an error")
+	}
+	if Hardcover.Label() != "Hardcover" || SelfPublished.Label() != "Self-published" || BookFormat("").Label() != "" {
+		t.Error("Unexpected labels")
+	}
+	if EbookOnly.SiteName() != "ebook-only.html" {
+		t.Errorf("Unexpected site name %q", EbookOnly.SiteName())
+	}
+}
+
+func TestConvertKindleRatings(t *testing.T) {
+	db := setupTestDB(t)
+	defer UseRatingLevels(DefaultRatingLevels())
+	db.Create(&Book{MainTitle: "Wool", Rating: "Kindle"})
+	db.Create(&Book{MainTitle: "The Martian", Rating: "Kindle", Format: SelfPublished})
+	db.Create(&Book{MainTitle: "Dune", Rating: "Excellent", Format: Hardcover})
+	trashed := Book{MainTitle: "Sand", Rating: "Kindle"}
+	db.Create(&trashed)
+	db.Delete(&trashed)
+
+	if _, err := ConvertKindleRatings(db, "Kindle", EbookOnly); err == nil {
+		t.Error("Expected Kindle to be refused as the new rating")
+	}
+	if _, err := ConvertKindleRatings(db, "Meh", EbookOnly); err == nil {
+		t.Error("Expected an unknown rating to be refused")
+	}
+	if _, err := ConvertKindleRatings(db, "Very-Good", "scroll"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+
+	report, err := ConvertKindleRatings(WithAuditSource(db, AuditSourceCommandLine), "Very-Good", EbookOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Books != 3 || report.RatingKept != "" {
+		t.Errorf("Expected 3 books converted, one in the trash, and the Kindle rating removed, got %+v", report)
+	}
+	if _, err := StringToRating("Kindle"); err == nil {
+		t.Error("Expected Kindle to no longer be a rating")
+	}
+	var wool, martian Book
+	db.Where("main_title = ?", "Wool").First(&wool)
+	db.Where("main_title = ?", "The Martian").First(&martian)
+	if wool.Format != EbookOnly || wool.Rating != "Very-Good" {
+		t.Errorf("Unexpected conversion %q %q", wool.Format, wool.Rating)
+	}
+	if martian.Format != SelfPublished || martian.Rating != "Very-Good" {
+		t.Errorf("Expected a book's format to be kept, got %q %q", martian.Format, martian.Rating)
+	}
+
+	entries, _ := LoadAuditHistory(db, AuditBook, wool.ID)
+	rating := fieldHistory(t, entries, "rating")
+	format := fieldHistory(t, entries, "format")
+	if len(rating) != 2 || rating[1].OldValue != "Kindle" || rating[1].Source != AuditSourceCommandLine || len(format) != 1 {
+		t.Errorf("Expected the conversion in the book's history, got %+v", entries)
+	}
+	if _, err := RevertAuditEntry(db, rating[1].ID); err == nil || !strings.Contains(err.Error(), "no longer exists") {
+		t.Errorf("Expected reverting to the removed Kindle rating to fail, got %v", err)
+	}
+
+	var books []Book
+	db.Scopes(WithFormat(Hardcover)).Find(&books)
+	if len(books) != 1 || books[0].MainTitle != "Dune" {
+		t.Errorf("Expected only Dune in hardcover, got %+v", books)
+	}
+}
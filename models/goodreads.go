@@ -0,0 +1,350 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/ccdavis/sfwr/load"
+)
+
+const (
+	ImportCreate = "create"
+	ImportMerge  = "merge"
+	ImportSkip   = "skip"
+)
+
+// What happened, or in a dry run what would happen, to one row of an import.
+type ImportResult struct {
+	Line   int
+	Title  string
+	Author string
+	Action string
+	Reason string
+	BookID uint
+}
+
+type ImportReport struct {
+	DryRun  bool
+	Results []ImportResult
+}
+
+func (r ImportReport) Count(action string) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (r ImportReport) Print(w io.Writer) {
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "Line %d: %-6s \"%s\" by %s", result.Line, result.Action, result.Title, result.Author)
+		if result.Reason != "" {
+			fmt.Fprintf(w, " (%s)", result.Reason)
+		}
+		fmt.Fprintln(w)
+	}
+	if r.DryRun {
+		fmt.Fprintf(w, "Dry run, nothing saved. Would create %d, merge %d, skip %d.\n",
+			r.Count(ImportCreate), r.Count(ImportMerge), r.Count(ImportSkip))
+	} else {
+		fmt.Fprintf(w, "Created %d, merged %d, skipped %d.\n",
+			r.Count(ImportCreate), r.Count(ImportMerge), r.Count(ImportSkip))
+	}
+}
+
+// Goodreads shelves that say where a book is in the reading process rather than what it's about.
+var goodreadsStatusShelves = map[string]bool{
+	"read":              true,
+	"to-read":           true,
+	"currently-reading": true,
+}
+
+// Goodreads puts the series in the title, like "The Warrior's Apprentice (Vorkosigan Saga, #2)".
+var goodreadsSeriesSuffix = regexp.MustCompile(`\s*\([^()]*#[0-9.-]+\)\s*$`)
+
+var errDryRun = errors.New("dry run")
+
+// Adds the books from a Goodreads export. Books already in the database, found by ISBN or by title
+// and author, get the export's ISBNs, read date and shelves merged in rather than being added twice.
+// Authors are matched allowing for differently written initials, the same way Open Library
+// searches are. With dryRun everything is worked out in a transaction that is then rolled back.
+func ImportGoodreadsBooks(db *gorm.DB, rows []load.GoodreadsBook, dryRun bool) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		importer, err := newGoodreadsImporter(tx)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			result, err := importer.importRow(row)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			report.Results = append(report.Results, result)
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return report, err
+}
+
+type goodreadsImporter struct {
+	db      *gorm.DB
+	authors *AuthorIndex
+}
+
+func newGoodreadsImporter(db *gorm.DB) (*goodreadsImporter, error) {
+	authors, err := NewAuthorIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	return &goodreadsImporter{db: db, authors: authors}, nil
+}
+
+func (g *goodreadsImporter) importRow(row load.GoodreadsBook) (ImportResult, error) {
+	result := ImportResult{Line: row.Line, Title: row.Title, Author: row.Author}
+	if row.Title == "" || row.Author == "" {
+		result.Action = ImportSkip
+		result.Reason = "missing title or author"
+		return result, nil
+	}
+	if row.ExclusiveShelf == "to-read" {
+		result.Action = ImportSkip
+		result.Reason = "on the " + row.ExclusiveShelf + " shelf"
+		return result, nil
+	}
+
+	mainTitle, subTitle := splitGoodreadsTitle(row.Title)
+	isbns := goodreadsIsbns(row)
+	author, authorKnown := g.authors.Find(row.Author)
+
+	existing, matchedBy, err := g.findExistingBook(mainTitle, isbns, author, authorKnown)
+	if err != nil {
+		return result, err
+	}
+	if existing != nil {
+		result.Action = ImportMerge
+		result.Reason = matchedBy
+		result.BookID = existing.ID
+		return result, g.mergeInto(existing, row, isbns)
+	}
+
+	stars, _ := strconv.ParseInt(row.MyRating, 10, 64)
+	rating := goodreadsRating(stars)
+
+	if !authorKnown {
+		author = fromRawAuthor(row.Author)
+		if err := g.db.Create(&author).Error; err != nil {
+			return result, err
+		}
+		g.authors.Add(author)
+	}
+
+	dateAdded, err := parseGoodreadsDate(row.DateAdded)
+	if err != nil {
+		return result, err
+	}
+	if dateAdded.IsZero() {
+		dateAdded = time.Now()
+	}
+
+	var olIsbns []OpenLibraryBookIsbn
+	for _, isbn := range isbns {
+		olIsbns = append(olIsbns, OpenLibraryBookIsbn{Isbn: isbn})
+	}
+
+	book := Book{
+		PubDate:              goodreadsPubDate(row),
+		DateAdded:            dateAdded,
+		AuthorFullName:       author.FullName,
+		AuthorSurname:        author.Surname,
+		MainTitle:            mainTitle,
+		SubTitle:             subTitle,
+		Review:               goodreadsReview(row.MyReview),
+		Rating:               rating.String(),
+		Format:               goodreadsFormat(row.Binding),
+		OlCoverId:            Missing,
+		OpenLibraryBookIsbns: olIsbns,
+		Authors:              []Author{author},
+	}
+	if err := g.db.Create(&book).Error; err != nil {
+		return result, err
+	}
+	result.Action = ImportCreate
+	if authorKnown {
+		result.Reason = "existing author " + author.FullName
+	} else {
+		result.Reason = "new author"
+	}
+	result.BookID = book.ID
+	return result, g.addShelvesAndReading(&book, row)
+}
+
+func (g *goodreadsImporter) findExistingBook(mainTitle string, isbns []string, author Author, authorKnown bool) (*Book, string, error) {
+	if len(isbns) > 0 {
+		var match OpenLibraryBookIsbn
+		result := g.db.Where("isbn IN ?", isbns).Limit(1).Find(&match)
+		if result.Error != nil {
+			return nil, "", result.Error
+		}
+		if result.RowsAffected > 0 {
+			var book Book
+			if err := g.db.First(&book, match.BookId).Error; err == nil {
+				return &book, "same ISBN as book " + fmt.Sprint(book.ID), nil
+			}
+		}
+	}
+	if !authorKnown {
+		return nil, "", nil
+	}
+	var book Book
+	result := g.db.Joins("JOIN book_authors ON book_authors.book_id = books.id").
+		Where("book_authors.author_id = ? AND LOWER(books.main_title) = ?", author.ID, strings.ToLower(mainTitle)).
+		Limit(1).Find(&book)
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+	if result.RowsAffected > 0 {
+		return &book, "same title and author as book " + fmt.Sprint(book.ID), nil
+	}
+	return nil, "", nil
+}
+
+// Only fills in what the existing book lacks; the rating and review already in sfwr win.
+func (g *goodreadsImporter) mergeInto(book *Book, row load.GoodreadsBook, isbns []string) error {
+	for _, isbn := range isbns {
+		var count int64
+		if err := g.db.Model(&OpenLibraryBookIsbn{}).Where("book_id = ? AND isbn = ?", book.ID, isbn).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := g.db.Create(&OpenLibraryBookIsbn{BookId: book.ID, Isbn: isbn}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if book.Review == "" && row.MyReview != "" {
+		if err := g.db.Model(book).Update("review", goodreadsReview(row.MyReview)).Error; err != nil {
+			return err
+		}
+	}
+	return g.addShelvesAndReading(book, row)
+}
+
+func (g *goodreadsImporter) addShelvesAndReading(book *Book, row load.GoodreadsBook) error {
+	var shelves []string
+	for _, name := range ParseTagNames(row.Bookshelves) {
+		if !goodreadsStatusShelves[strings.ToLower(name)] {
+			shelves = append(shelves, name)
+		}
+	}
+	if len(shelves) > 0 {
+		tags, err := FindOrCreateTags(g.db, shelves)
+		if err != nil {
+			return err
+		}
+		if err := g.db.Model(book).Association("Tags").Append(tags); err != nil {
+			return err
+		}
+	}
+
+	finished, err := parseGoodreadsDate(row.DateRead)
+	if err != nil || finished.IsZero() {
+		return err
+	}
+	var count int64
+	if err := g.db.Model(&ReadingSession{}).Where("book_id = ? AND finish_date = ?", book.ID, finished).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	session := ReadingSession{BookID: book.ID, FinishDate: finished}
+	return LogReadingSession(g.db, &session)
+}
+
+// Goodreads stars as a rating: the level scored the same, or else the next one down. Kindle says how
+// a book was published rather than how good it is, so stars never become Kindle. Books without stars,
+// or with fewer than any level's score, aren't rated.
+func goodreadsRating(stars int64) Rating {
+	rating, score := Unknown, 0
+	for _, level := range RatingLevels() {
+		if level.Slug == Kindle.slug || level.Score == 0 || int64(level.Score) > stars {
+			continue
+		}
+		if level.Score > score {
+			rating, score = Rating{level.Slug}, level.Score
+		}
+	}
+	return rating
+}
+
+func splitGoodreadsTitle(title string) (string, string) {
+	title = goodreadsSeriesSuffix.ReplaceAllString(title, "")
+	mainTitle, subTitle, _ := strings.Cut(title, ": ")
+	return strings.TrimSpace(mainTitle), strings.TrimSpace(subTitle)
+}
+
+func goodreadsIsbns(row load.GoodreadsBook) []string {
+	var isbns []string
+	for _, isbn := range []string{row.Isbn, row.Isbn13} {
+		if isbn != "" {
+			isbns = append(isbns, isbn)
+		}
+	}
+	return isbns
+}
+
+func goodreadsPubDate(row load.GoodreadsBook) int64 {
+	for _, year := range []string{row.OriginalPublicationYear, row.YearPublished} {
+		if parsed, err := strconv.ParseInt(year, 10, 64); err == nil {
+			return parsed
+		}
+	}
+	return Missing
+}
+
+// Goodreads gives the binding of the edition shelved, like "Mass Market Paperback" or "Audible Audio".
+// An ebook edition doesn't mean the book is only an ebook, so those get no format, like bindings
+// such as "Unknown Binding".
+func goodreadsFormat(binding string) BookFormat {
+	binding = strings.ToLower(binding)
+	switch {
+	case strings.Contains(binding, "hardcover") || strings.Contains(binding, "library binding"):
+		return Hardcover
+	case strings.Contains(binding, "paperback"):
+		return Paperback
+	case strings.Contains(binding, "audio"):
+		return Audiobook
+	}
+	return ""
+}
+
+// Goodreads separates paragraphs in reviews with <br/> tags.
+func goodreadsReview(review string) string {
+	review = strings.ReplaceAll(review, "<br />", "\n")
+	return strings.ReplaceAll(review, "<br/>", "\n")
+}
+
+// Goodreads exports dates as 2023/01/15. A blank date is the zero time.
+func parseGoodreadsDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006/01/02", value)
+}
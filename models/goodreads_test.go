@@ -0,0 +1,171 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ccdavis/sfwr/load"
+)
+
+const goodreadsExport = `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies
+1,"Cyteen (Cyteen, #1-3)",C.J. Cherryh,"Cherryh, C.J.",,"=""0446671274""","=""9780446671279""",5,4.21,Grand Central,Paperback,680,1995,1988,2021/03/04,2021/02/01,"space opera, read",,read,Great.<br/>Really great.,,,1,0
+2,Foundation,Isaac Asimov,"Asimov, Isaac",,"=""""","=""""",3,4.17,Spectra,Kindle Edition,244,2004,1951,2022/07/19,2022/07/01,,,read,,,,1,0
+3,The Left Hand of Darkness,Ursula K. Le Guin,"Guin, Ursula K. Le",,"=""0441478123""","=""9780441478125""",5,4.08,Ace,Paperback,304,1987,1969,2023/01/10,2023/01/01,classics,,read,,,,2,0
+4,Leviathan Wakes (The Expanse #1),James S.A. Corey,"Corey, James S.A.",,"=""""","=""""",0,4.26,Orbit,Paperback,582,2011,2011,,2023/05/01,to-read,,to-read,,,,0,0
+5,Hyperion,Dan Simmons,"Simmons, Dan",,"=""""","=""""",0,4.25,Bantam,Paperback,482,1990,1989,2020/06/01,2020/05/01,,,read,,,,1,0
+`
+
+func TestParseGoodreadsCsv(t *testing.T) {
+	rows, err := load.ParseGoodreadsCsv(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatal("Failed to parse export:", err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("Expected 5 rows, got %d", len(rows))
+	}
+	if rows[0].Isbn != "0446671274" || rows[0].Isbn13 != "9780446671279" {
+		t.Errorf("ISBN formulas not cleaned up: %q %q", rows[0].Isbn, rows[0].Isbn13)
+	}
+	if rows[1].Isbn != "" {
+		t.Errorf("Expected blank ISBN, got %q", rows[1].Isbn)
+	}
+	if rows[2].Line != 4 {
+		t.Errorf("Expected the third book on line 4, got %d", rows[2].Line)
+	}
+
+	if _, err := load.ParseGoodreadsCsv(strings.NewReader("Name,Rating\nDune,5\n")); err == nil {
+		t.Error("Expected an error for a CSV without Goodreads columns")
+	}
+}
+
+func TestSplitGoodreadsTitle(t *testing.T) {
+	tests := []struct {
+		title, main, sub string
+	}{
+		{"Cyteen (Cyteen, #1-3)", "Cyteen", ""},
+		{"The Warrior's Apprentice (Vorkosigan Saga, #2)", "The Warrior's Apprentice", ""},
+		{"Leviathan Wakes (The Expanse #1)", "Leviathan Wakes", ""},
+		{"Accelerando: A Novel", "Accelerando", "A Novel"},
+		{"Consider Phlebas (Culture, #1.5)", "Consider Phlebas", ""},
+	}
+	for _, tt := range tests {
+		main, sub := splitGoodreadsTitle(tt.title)
+		if main != tt.main || sub != tt.sub {
+			t.Errorf("splitGoodreadsTitle(%q) = %q, %q; want %q, %q", tt.title, main, sub, tt.main, tt.sub)
+		}
+	}
+}
+
+func TestImportGoodreadsBooks(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Already in the database: Cherryh under a different spelling of her initials,
+	// and Le Guin's book with one of the export's ISBNs.
+	cherryh := Author{FullName: "C. J. Cherryh", Surname: "Cherryh"}
+	db.Create(&cherryh)
+	leGuin := Author{FullName: "Ursula K. Le Guin", Surname: "Guin"}
+	db.Create(&leGuin)
+	leftHand := Book{
+		MainTitle:            "The Left Hand of Darkness",
+		AuthorFullName:       leGuin.FullName,
+		AuthorSurname:        leGuin.Surname,
+		Rating:               "Excellent",
+		Review:               "Already reviewed",
+		Authors:              []Author{leGuin},
+		OpenLibraryBookIsbns: []OpenLibraryBookIsbn{{Isbn: "0441478123"}},
+	}
+	db.Create(&leftHand)
+
+	rows, err := load.ParseGoodreadsCsv(strings.NewReader(goodreadsExport))
+	if err != nil {
+		t.Fatal("Failed to parse export:", err)
+	}
+
+	dryRun, err := ImportGoodreadsBooks(db, rows, true)
+	if err != nil {
+		t.Fatal("Dry run failed:", err)
+	}
+	expectedActions := []string{ImportCreate, ImportCreate, ImportMerge, ImportSkip, ImportCreate}
+	for i, result := range dryRun.Results {
+		if result.Action != expectedActions[i] {
+			t.Errorf("Line %d: expected %s, got %s (%s)", result.Line, expectedActions[i], result.Action, result.Reason)
+		}
+	}
+	var bookCount int64
+	db.Model(&Book{}).Count(&bookCount)
+	if bookCount != 1 {
+		t.Fatalf("Dry run should not save anything, found %d books", bookCount)
+	}
+
+	report, err := ImportGoodreadsBooks(db, rows, false)
+	if err != nil {
+		t.Fatal("Import failed:", err)
+	}
+	if report.Count(ImportCreate) != 3 || report.Count(ImportMerge) != 1 || report.Count(ImportSkip) != 1 {
+		t.Errorf("Unexpected import counts: %d created, %d merged, %d skipped",
+			report.Count(ImportCreate), report.Count(ImportMerge), report.Count(ImportSkip))
+	}
+
+	var authorCount int64
+	db.Model(&Author{}).Count(&authorCount)
+	if authorCount != 4 {
+		t.Errorf("Expected Cherryh to be matched and only Asimov and Simmons added, got %d authors", authorCount)
+	}
+
+	var cyteen Book
+	db.Preload("Authors").Preload("Tags").Preload("ReadingSessions").Preload("OpenLibraryBookIsbns").
+		Where("main_title = ?", "Cyteen").First(&cyteen)
+	if len(cyteen.Authors) != 1 || cyteen.Authors[0].ID != cherryh.ID || cyteen.AuthorFullName != "C. J. Cherryh" {
+		t.Errorf("Expected Cyteen to belong to the existing Cherryh author, got %+v", cyteen.Authors)
+	}
+	if cyteen.PubDate != 1988 || cyteen.Rating != "Excellent" || cyteen.Review != "Great.\nReally great." {
+		t.Errorf("Cyteen imported incorrectly: %d %s %q", cyteen.PubDate, cyteen.Rating, cyteen.Review)
+	}
+	if cyteen.Format != Paperback {
+		t.Errorf("Expected the binding as the format, got %q", cyteen.Format)
+	}
+	var foundation Book
+	db.Where("main_title = ?", "Foundation").First(&foundation)
+	if foundation.Format != "" {
+		t.Errorf("Expected a Kindle edition to give no format, got %q", foundation.Format)
+	}
+	if foundation.Rating != "Interesting" {
+		t.Errorf("Expected three stars to be the next level down rather than Kindle, got %s", foundation.Rating)
+	}
+	var hyperion Book
+	db.Preload("ReadingSessions").Where("main_title = ?", "Hyperion").First(&hyperion)
+	if hyperion.Rating != Unknown.String() || hyperion.FormatLastFinished() != "2020-06-01" {
+		t.Errorf("Expected a read book without stars to be imported unrated with its read date, got %s, %s",
+			hyperion.Rating, hyperion.FormatLastFinished())
+	}
+	if cyteen.TagNames() != "space opera" {
+		t.Errorf("Expected only the custom shelf as a tag, got '%s'", cyteen.TagNames())
+	}
+	if cyteen.FormatLastFinished() != "2021-03-04" || len(cyteen.OpenLibraryBookIsbns) != 2 {
+		t.Errorf("Expected read date and both ISBNs, got %s and %d ISBNs", cyteen.FormatLastFinished(), len(cyteen.OpenLibraryBookIsbns))
+	}
+
+	var merged Book
+	db.Preload("Tags").Preload("ReadingSessions").Preload("OpenLibraryBookIsbns").First(&merged, leftHand.ID)
+	if merged.Review != "Already reviewed" {
+		t.Errorf("Merging should keep the existing review, got %q", merged.Review)
+	}
+	if len(merged.OpenLibraryBookIsbns) != 2 || merged.TimesRead() != 1 || merged.TagNames() != "classics" {
+		t.Errorf("Expected ISBN-13, read date and shelf merged in, got %d ISBNs, %d reads, tags '%s'",
+			len(merged.OpenLibraryBookIsbns), merged.TimesRead(), merged.TagNames())
+	}
+
+	// Importing the same export again only merges
+	again, err := ImportGoodreadsBooks(db, rows, false)
+	if err != nil {
+		t.Fatal("Second import failed:", err)
+	}
+	if again.Count(ImportCreate) != 0 || again.Count(ImportMerge) != 4 {
+		t.Errorf("Expected a repeat import to merge everything, got %d created, %d merged",
+			again.Count(ImportCreate), again.Count(ImportMerge))
+	}
+	db.Preload("ReadingSessions").First(&merged, leftHand.ID)
+	if merged.TimesRead() != 1 {
+		t.Errorf("A repeat import shouldn't log the same read twice, got %d reads", merged.TimesRead())
+	}
+}
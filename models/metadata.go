@@ -0,0 +1,205 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where book details and cover images come from. OpenLibrary talks to openlibrary.org; pointed at the
+// fake server in openlibrarytest it replays recorded responses, so nothing that uses it needs the network in tests.
+type MetadataProvider interface {
+	SearchBooks(title string, author string) ([]BookSearchResult, error)
+	GetEdition(olid string) (Edition, error)
+	GetEditionByIsbn(isbn string) (Edition, error)
+	// Returns the JPEG for a cover ID in the SmallCover, MediumCover or LargeCover size
+	FetchCover(coverId int64, size string) ([]byte, error)
+}
+
+// One published edition of a book, as Open Library describes it.
+type Edition struct {
+	Olid        string // Like "OL7353617M"
+	Title       string
+	Subtitle    string
+	PublishDate string // Free text, like "1974" or "May 1975"
+	Isbn10      []string
+	Isbn13      []string
+	CoverIds    []int64
+	AuthorIds   []string // Like "OL26320A"
+	WorkIds     []string
+}
+
+var ErrNotFound = errors.New("not found in Open Library")
+
+type OpenLibrary struct {
+	BaseUrl   string
+	CoversUrl string
+	Client    *http.Client
+}
+
+func NewOpenLibrary() *OpenLibrary {
+	return &OpenLibrary{
+		BaseUrl:   "https://openlibrary.org",
+		CoversUrl: "https://covers.openlibrary.org",
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Open Library asks API users to identify themselves.
+const openLibraryUserAgent = "sfwr (https://github.com/ccdavis/sfwr)"
+
+func (ol *OpenLibrary) get(requestUrl string) ([]byte, error) {
+	body, _, err := ol.getWithType(requestUrl)
+	return body, err
+}
+
+func (ol *OpenLibrary) getWithType(requestUrl string) ([]byte, string, error) {
+	request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	request.Header.Set("User-Agent", openLibraryUserAgent)
+	response, err := ol.Client.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("%s: %w", requestUrl, ErrNotFound)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: %s", requestUrl, response.Status)
+	}
+	body, err := io.ReadAll(response.Body)
+	return body, response.Header.Get("Content-Type"), err
+}
+
+type openLibrarySearch struct {
+	Docs []struct {
+		FirstPublishYear int      `json:"first_publish_year"`
+		Title            string   `json:"title"`
+		AuthorName       []string `json:"author_name"`
+		AuthorKey        []string `json:"author_key"`
+		CoverEditionKey  string   `json:"cover_edition_key"`
+		CoverI           int64    `json:"cover_i"`
+		IdIsfdb          []string `json:"id_isfdb"`
+	} `json:"docs"`
+}
+
+func (ol *OpenLibrary) SearchBooks(title string, author string) ([]BookSearchResult, error) {
+	query := url.Values{}
+	query.Set("q", title)
+	query.Set("author", author)
+	body, err := ol.get(ol.BaseUrl + "/search.json?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var search openLibrarySearch
+	if err := json.Unmarshal(body, &search); err != nil {
+		return nil, fmt.Errorf("can't read Open Library search results: %w", err)
+	}
+
+	var results []BookSearchResult
+	for number, doc := range search.Docs {
+		result := BookSearchResult{
+			Number:             number,
+			FirstYearPublished: doc.FirstPublishYear,
+			Title:              doc.Title,
+			Authors:            doc.AuthorName,
+			AuthorIds:          doc.AuthorKey,
+			CoverEditionKey:    doc.CoverEditionKey,
+		}
+		if doc.CoverI != 0 {
+			result.CoverImageId = strconv.FormatInt(doc.CoverI, 10)
+		}
+		if len(doc.IdIsfdb) > 0 {
+			result.isfdb_id = doc.IdIsfdb[0]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+type openLibraryEdition struct {
+	Key         string   `json:"key"`
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle"`
+	PublishDate string   `json:"publish_date"`
+	Isbn10      []string `json:"isbn_10"`
+	Isbn13      []string `json:"isbn_13"`
+	Covers      []int64  `json:"covers"`
+	Authors     []struct {
+		Key string `json:"key"`
+	} `json:"authors"`
+	Works []struct {
+		Key string `json:"key"`
+	} `json:"works"`
+}
+
+func (ol *OpenLibrary) GetEdition(olid string) (Edition, error) {
+	return ol.getEdition("/books/" + url.PathEscape(olid) + ".json")
+}
+
+// Open Library answers ISBN lookups with a redirect to the edition, which the client follows.
+func (ol *OpenLibrary) GetEditionByIsbn(isbn string) (Edition, error) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(isbn)
+	return ol.getEdition("/isbn/" + url.PathEscape(digits) + ".json")
+}
+
+func (ol *OpenLibrary) getEdition(path string) (Edition, error) {
+	body, err := ol.get(ol.BaseUrl + path)
+	if err != nil {
+		return Edition{}, err
+	}
+	var raw openLibraryEdition
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return Edition{}, fmt.Errorf("can't read Open Library edition: %w", err)
+	}
+	edition := Edition{
+		Olid:        strings.TrimPrefix(raw.Key, "/books/"),
+		Title:       raw.Title,
+		Subtitle:    raw.Subtitle,
+		PublishDate: raw.PublishDate,
+		Isbn10:      raw.Isbn10,
+		Isbn13:      raw.Isbn13,
+	}
+	// Open Library uses -1 for a removed cover
+	for _, id := range raw.Covers {
+		if id > 0 {
+			edition.CoverIds = append(edition.CoverIds, id)
+		}
+	}
+	for _, a := range raw.Authors {
+		edition.AuthorIds = append(edition.AuthorIds, strings.TrimPrefix(a.Key, "/authors/"))
+	}
+	for _, w := range raw.Works {
+		edition.WorkIds = append(edition.WorkIds, strings.TrimPrefix(w.Key, "/works/"))
+	}
+	return edition, nil
+}
+
+// Without default=false Open Library sends a blank image rather than a 404 for unknown covers.
+// Anything other than an image, like an error page sent with a 200, is rejected.
+func (ol *OpenLibrary) FetchCover(coverId int64, size string) ([]byte, error) {
+	if coverId <= 0 {
+		return nil, fmt.Errorf("no cover ID: %w", ErrNotFound)
+	}
+	coverUrl := fmt.Sprintf("%s/b/id/%d-%s.jpg?default=false", ol.CoversUrl, coverId, size)
+	body, contentType, err := ol.getWithType(coverUrl)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%s: expected an image, got %s", coverUrl, contentType)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("%s: empty image", coverUrl)
+	}
+	return body, nil
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ccdavis/sfwr/openlibrarytest"
+)
+
+func newFakeOpenLibrary(t *testing.T) (*OpenLibrary, *openlibrarytest.Server) {
+	server := openlibrarytest.NewServer()
+	t.Cleanup(server.Close)
+	return &OpenLibrary{BaseUrl: server.URL, CoversUrl: server.URL, Client: server.Client()}, server
+}
+
+func TestOpenLibrarySearchBooks(t *testing.T) {
+	ol, server := newFakeOpenLibrary(t)
+
+	results, err := ol.SearchBooks("The Dispossessed", "Ursula K. Le Guin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	first := results[0]
+	if first.Title != "The Dispossessed" || first.FirstYearPublished != 1974 || first.CoverEditionKey != "OL24950432M" ||
+		first.CoverImageId != "8220836" || first.Authors[0] != "Ursula K. Le Guin" || first.AuthorIds[0] != "OL26320A" {
+		t.Errorf("Unexpected first result %+v", first)
+	}
+	// The study guide has no cover or publication year
+	if results[1].Number != 1 || results[1].CoverImageId != "" || results[1].FirstYearPublished != 0 {
+		t.Errorf("Unexpected second result %+v", results[1])
+	}
+
+	requests := server.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0], "q=The+Dispossessed") || !strings.Contains(requests[0], "author=Ursula+K.+Le+Guin") {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+
+	none, err := ol.SearchBooks("No Such Book", "Nobody")
+	if err != nil || len(none) != 0 {
+		t.Errorf("Expected no results and no error, got %v, %v", none, err)
+	}
+}
+
+func TestOpenLibraryEditions(t *testing.T) {
+	ol, _ := newFakeOpenLibrary(t)
+
+	edition, err := ol.GetEdition("OL24950432M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edition.Olid != "OL24950432M" || edition.Subtitle != "An Ambiguous Utopia" || edition.Isbn13[0] != "9780060512750" ||
+		edition.CoverIds[0] != 8220836 || edition.AuthorIds[0] != "OL26320A" || edition.WorkIds[0] != "OL59863W" {
+		t.Errorf("Unexpected edition %+v", edition)
+	}
+
+	// Looked up by ISBN, following Open Library's redirect, with hyphens allowed
+	byIsbn, err := ol.GetEditionByIsbn("0-441-47812-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byIsbn.Olid != "OL7274279M" {
+		t.Errorf("Expected OL7274279M, got %s", byIsbn.Olid)
+	}
+	// The removed cover, -1, is dropped
+	if len(byIsbn.CoverIds) != 1 {
+		t.Errorf("Expected one cover, got %v", byIsbn.CoverIds)
+	}
+
+	if _, err := ol.GetEdition("OL1M"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := ol.GetEditionByIsbn("9999999999"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestOpenLibraryFetchCover(t *testing.T) {
+	ol, server := newFakeOpenLibrary(t)
+
+	cover, err := ol.FetchCover(11481354, MediumCover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cover, openlibrarytest.Cover(11481354, MediumCover)) {
+		t.Error("Cover doesn't match the one served")
+	}
+	if _, err := ol.FetchCover(12345, SmallCover); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown cover, got %v", err)
+	}
+	if _, err := ol.FetchCover(Missing, SmallCover); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing cover ID, got %v", err)
+	}
+
+	server.FailNext("/b/id/11481354-S.jpg", 1)
+	if _, err := ol.FetchCover(11481354, SmallCover); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a server error, got %v", err)
+	}
+}
+
+func TestCaptureAllSizeCovers(t *testing.T) {
+	ol, _ := newFakeOpenLibrary(t)
+	imageDir := t.TempDir()
+
+	book := Book{MainTitle: "Dune", OlCoverId: 11481354}
+	CaptureAllSizeCovers(ol, book, imageDir)
+
+	master, err := os.ReadFile(CoverMasterFile(imageDir, Book{OlCoverId: 11481354}))
+	if err != nil {
+		t.Fatal("Cover not saved:", err)
+	}
+	if !bytes.Equal(master, openlibrarytest.Cover(11481354, LargeCover)) {
+		t.Error("The saved cover doesn't match the large one served")
+	}
+	if !HaveSavedCover(imageDir, Book{OlCoverId: 11481354}) {
+		t.Error("Cover sizes weren't made")
+	}
+}
@@ -0,0 +1,497 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// One numbered change to the schema. Databases record the migrations applied to them in
+// schema_migrations, so one made by an older version is brought up to date by applying the rest in order.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error // nil when the migration can't be undone
+}
+
+// A migration that has been applied to the database.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Every migration, oldest first. New ones go on the end with the next version. Once released a
+// migration mustn't change, since databases that have applied it won't apply it again, so each
+// writes out the tables it makes rather than using the models, which keep changing.
+//
+// The first four cover the schema as it was before migrations were kept. Databases made then
+// already have some or all of it, so these only add the tables, columns and indexes missing.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "books, authors and everything about them",
+		Up:      createFrozenTables(catalogTables...),
+	},
+	{
+		Version: 2,
+		Name:    "editable rating levels",
+		Up: func(tx *gorm.DB) error {
+			if err := createFrozenTables(ratingLevelsTable)(tx); err != nil {
+				return err
+			}
+			return seedFrozenRatingLevels(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE `rating_levels`").Error
+		},
+	},
+	{
+		Version: 3,
+		Name:    "book formats",
+		Up: createFrozenTables(frozenTable{name: "books", columns: []frozenColumn{
+			{"format", "text"},
+		}}),
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE `books` DROP COLUMN `format`").Error
+		},
+	},
+	{
+		Version: 4,
+		Name:    "book and author history",
+		Up:      createFrozenTables(auditEntriesTable),
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP TABLE `audit_entries`").Error
+		},
+	},
+	{
+		Version: 5,
+		Name:    "no star score for Kindle",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE `rating_levels` SET `score` = 0 WHERE `slug` = 'Kindle' AND `score` = 3").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE `rating_levels` SET `score` = 3 WHERE `slug` = 'Kindle' AND `score` = 0 AND " +
+				"NOT EXISTS (SELECT 1 FROM `rating_levels` WHERE `score` = 3)").Error
+		},
+	},
+}
+
+// A table as a migration made it. When the table is already there, only the columns and indexes
+// it's missing are added.
+type frozenTable struct {
+	name        string
+	columns     []frozenColumn
+	constraints []string
+	indexes     []frozenIndex
+}
+
+type frozenColumn struct {
+	name       string
+	definition string
+}
+
+type frozenIndex struct {
+	name    string
+	columns []string
+	unique  bool
+}
+
+func createFrozenTables(tables ...frozenTable) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := table.create(tx); err != nil {
+				return fmt.Errorf("table %s: %w", table.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func (t frozenTable) create(tx *gorm.DB) error {
+	if !tableExists(tx, t.name) {
+		var parts []string
+		for _, c := range t.columns {
+			parts = append(parts, "`"+c.name+"` "+c.definition)
+		}
+		parts = append(parts, t.constraints...)
+		if err := tx.Exec("CREATE TABLE `" + t.name + "` (" + strings.Join(parts, ",") + ")").Error; err != nil {
+			return err
+		}
+	} else {
+		for _, c := range t.columns {
+			if tx.Migrator().HasColumn(t.name, c.name) {
+				continue
+			}
+			if err := tx.Exec("ALTER TABLE `" + t.name + "` ADD COLUMN `" + c.name + "` " + c.definition).Error; err != nil {
+				return err
+			}
+		}
+	}
+	for _, index := range t.indexes {
+		create := "CREATE INDEX"
+		if index.unique {
+			create = "CREATE UNIQUE INDEX"
+		}
+		columns := "`" + strings.Join(index.columns, "`,`") + "`"
+		if err := tx.Exec(create + " IF NOT EXISTS `" + index.name + "` ON `" + t.name + "`(" + columns + ")").Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The columns every table with an ID, timestamps and soft deletes starts with.
+func modelColumns(columns ...frozenColumn) []frozenColumn {
+	return append([]frozenColumn{
+		{"id", "integer PRIMARY KEY AUTOINCREMENT"},
+		{"created_at", "datetime"},
+		{"updated_at", "datetime"},
+		{"deleted_at", "datetime"},
+	}, columns...)
+}
+
+func deletedAtIndex(table string) frozenIndex {
+	return frozenIndex{name: "idx_" + table + "_deleted_at", columns: []string{"deleted_at"}}
+}
+
+var catalogTables = []frozenTable{
+	{
+		name: "books",
+		columns: modelColumns(
+			frozenColumn{"pub_date", "integer"},
+			frozenColumn{"date_added", "datetime"},
+			frozenColumn{"author_full_name", "text"},
+			frozenColumn{"author_surname", "text"},
+			frozenColumn{"main_title", "text"},
+			frozenColumn{"sub_title", "text"},
+			frozenColumn{"review", "text"},
+			frozenColumn{"rating", "text"},
+			frozenColumn{"amazon_link", "text"},
+			frozenColumn{"cover_image_url", "text"},
+			frozenColumn{"open_library_url", "text"},
+			frozenColumn{"isfdb_url", "text"},
+			frozenColumn{"ol_cover_id", "integer"},
+			frozenColumn{"ol_cover_edition_id", "text"},
+			frozenColumn{"cover_source", `text DEFAULT "openlibrary"`},
+			frozenColumn{"uploaded_cover_id", "integer"},
+		),
+		indexes: []frozenIndex{deletedAtIndex("books")},
+	},
+	{
+		name:    "authors",
+		columns: modelColumns(frozenColumn{"full_name", "text"}, frozenColumn{"surname", "text"}),
+		indexes: []frozenIndex{deletedAtIndex("authors")},
+	},
+	{
+		name: "book_authors",
+		columns: []frozenColumn{
+			{"book_id", "integer"},
+			{"author_id", "integer"},
+			{"position", "integer"},
+			{"role", `text DEFAULT "author"`},
+		},
+		constraints: []string{
+			"PRIMARY KEY (`book_id`,`author_id`)",
+			"CONSTRAINT `fk_book_authors_author` FOREIGN KEY (`author_id`) REFERENCES `authors`(`id`)",
+			"CONSTRAINT `fk_books_credits` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)",
+		},
+	},
+	{
+		name:    "tags",
+		columns: modelColumns(frozenColumn{"name", "text"}, frozenColumn{"slug", "text"}),
+		indexes: []frozenIndex{{name: "idx_tags_slug", columns: []string{"slug"}, unique: true}, deletedAtIndex("tags")},
+	},
+	{
+		name:    "book_tags",
+		columns: []frozenColumn{{"tag_id", "integer"}, {"book_id", "integer"}},
+		constraints: []string{
+			"PRIMARY KEY (`tag_id`,`book_id`)",
+			"CONSTRAINT `fk_book_tags_tag` FOREIGN KEY (`tag_id`) REFERENCES `tags`(`id`)",
+			"CONSTRAINT `fk_book_tags_book` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)",
+		},
+	},
+	{
+		name: "works",
+		columns: modelColumns(
+			frozenColumn{"title", "text"},
+			frozenColumn{"pub_date", "integer"},
+			frozenColumn{"length", `text DEFAULT "short_story"`},
+		),
+		indexes: []frozenIndex{deletedAtIndex("works")},
+	},
+	{
+		name:    "work_authors",
+		columns: []frozenColumn{{"work_id", "integer"}, {"author_id", "integer"}},
+		constraints: []string{
+			"PRIMARY KEY (`work_id`,`author_id`)",
+			"CONSTRAINT `fk_work_authors_author` FOREIGN KEY (`author_id`) REFERENCES `authors`(`id`)",
+			"CONSTRAINT `fk_work_authors_work` FOREIGN KEY (`work_id`) REFERENCES `works`(`id`)",
+		},
+	},
+	{
+		name:        "open_library_book_authors",
+		columns:     modelColumns(frozenColumn{"book_id", "integer"}, frozenColumn{"ol_author_id", "text"}),
+		constraints: []string{"CONSTRAINT `fk_books_open_library_book_authors` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)"},
+		indexes:     []frozenIndex{deletedAtIndex("open_library_book_authors")},
+	},
+	{
+		name:        "open_library_book_isbns",
+		columns:     modelColumns(frozenColumn{"book_id", "integer"}, frozenColumn{"isbn", "text"}),
+		constraints: []string{"CONSTRAINT `fk_books_open_library_book_isbns` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)"},
+		indexes:     []frozenIndex{deletedAtIndex("open_library_book_isbns")},
+	},
+	{
+		name:    "series",
+		columns: modelColumns(frozenColumn{"name", "text"}, frozenColumn{"slug", "text"}, frozenColumn{"description", "text"}),
+		indexes: []frozenIndex{{name: "idx_series_slug", columns: []string{"slug"}, unique: true}, deletedAtIndex("series")},
+	},
+	{
+		name:    "series_entries",
+		columns: modelColumns(frozenColumn{"series_id", "integer"}, frozenColumn{"book_id", "integer"}, frozenColumn{"position", "real"}),
+		constraints: []string{
+			"CONSTRAINT `fk_series_entries` FOREIGN KEY (`series_id`) REFERENCES `series`(`id`)",
+			"CONSTRAINT `fk_books_series_entries` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)",
+		},
+		indexes: []frozenIndex{deletedAtIndex("series_entries")},
+	},
+	{
+		name: "reading_sessions",
+		columns: modelColumns(
+			frozenColumn{"book_id", "integer"},
+			frozenColumn{"start_date", "datetime"},
+			frozenColumn{"finish_date", "datetime"},
+			frozenColumn{"format", "text"},
+			frozenColumn{"note", "text"},
+		),
+		constraints: []string{"CONSTRAINT `fk_books_reading_sessions` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)"},
+		indexes:     []frozenIndex{deletedAtIndex("reading_sessions")},
+	},
+	{
+		name: "author_aliases",
+		columns: modelColumns(
+			frozenColumn{"author_id", "integer"},
+			frozenColumn{"name", "text"},
+			frozenColumn{"type", `text DEFAULT "variant"`},
+			frozenColumn{"site_name", "text"},
+		),
+		constraints: []string{"CONSTRAINT `fk_authors_aliases` FOREIGN KEY (`author_id`) REFERENCES `authors`(`id`)"},
+		indexes:     []frozenIndex{{name: "idx_author_aliases_author_id", columns: []string{"author_id"}}, deletedAtIndex("author_aliases")},
+	},
+	{
+		name: "contents_entries",
+		columns: modelColumns(
+			frozenColumn{"book_id", "integer"},
+			frozenColumn{"work_id", "integer"},
+			frozenColumn{"position", "integer"},
+			frozenColumn{"rating", "text"},
+		),
+		constraints: []string{
+			"CONSTRAINT `fk_works_appearances` FOREIGN KEY (`work_id`) REFERENCES `works`(`id`)",
+			"CONSTRAINT `fk_books_contents` FOREIGN KEY (`book_id`) REFERENCES `books`(`id`)",
+		},
+		indexes: []frozenIndex{
+			{name: "idx_contents_entries_work_id", columns: []string{"work_id"}},
+			{name: "idx_contents_entries_book_id", columns: []string{"book_id"}},
+			deletedAtIndex("contents_entries"),
+		},
+	},
+}
+
+var ratingLevelsTable = frozenTable{
+	name: "rating_levels",
+	columns: modelColumns(
+		frozenColumn{"slug", "text"},
+		frozenColumn{"label", "text"},
+		frozenColumn{"description", "text"},
+		frozenColumn{"sort_order", "integer"},
+		frozenColumn{"score", "integer"},
+		frozenColumn{"css_class", "text"},
+	),
+	indexes: []frozenIndex{{name: "idx_rating_levels_slug", columns: []string{"slug"}, unique: true}, deletedAtIndex("rating_levels")},
+}
+
+// The built-in levels as they were when the scheme moved into the database.
+func seedFrozenRatingLevels(tx *gorm.DB) error {
+	var count int64
+	if err := tx.Raw("SELECT count(*) FROM `rating_levels`").Scan(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	now := time.Now()
+	levels := [][]any{
+		{"Excellent", "Excellent", "", 1, 5, "rating-excellent"},
+		{"Very-Good", "Very Good", "", 2, 4, "rating-very-good"},
+		{"Kindle", "Kindle", "Kindle only / Self-published", 3, 3, "rating-kindle"},
+		{"Interesting", "Interesting", "What was that?", 4, 2, "rating-interesting"},
+		{"Not-Good", "Not Good", "Had to put it down", 5, 1, "rating-not-good"},
+	}
+	for _, level := range levels {
+		err := tx.Exec("INSERT INTO `rating_levels` (`created_at`,`updated_at`,`slug`,`label`,`description`,`sort_order`,`score`,`css_class`) VALUES (?,?,?,?,?,?,?,?)",
+			append([]any{now, now}, level...)...).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var auditEntriesTable = frozenTable{
+	name: "audit_entries",
+	columns: []frozenColumn{
+		{"id", "integer PRIMARY KEY AUTOINCREMENT"},
+		{"created_at", "datetime"},
+		{"entity", "text"},
+		{"entity_id", "integer"},
+		{"field", "text"},
+		{"old_value", "text"},
+		{"new_value", "text"},
+		{"source", "text"},
+	},
+	indexes: []frozenIndex{{name: "idx_audit_record", columns: []string{"entity", "entity_id"}}},
+}
+
+// The migrations applied to the database, oldest first. A database made before migrations were
+// kept has none.
+func AppliedMigrations(db *gorm.DB) ([]SchemaMigration, error) {
+	var applied []SchemaMigration
+	if !tableExists(db, SchemaMigration{}.TableName()) {
+		return applied, nil
+	}
+	err := db.Order("version").Find(&applied).Error
+	return applied, err
+}
+
+func PendingMigrations(db *gorm.DB) ([]Migration, error) {
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+	var pending []Migration
+	for _, m := range Migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// A copy of the database is made next to it before migrating, named for when it was made.
+func MigrationBackupName(databaseFile string) string {
+	name := databaseFile + ".before-migration-" + time.Now().Format("20060102-150405")
+	backup := name
+	for n := 2; ; n++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			return backup
+		}
+		backup = fmt.Sprintf("%s-%d", name, n)
+	}
+}
+
+// Copies the whole database to a new file, consistently even while it's in use.
+func BackupDatabase(db *gorm.DB, file string) error {
+	return db.Exec("VACUUM INTO ?", file).Error
+}
+
+// Applies every pending migration in order, each in its own transaction, stopping at the first that
+// fails. If there are any to apply and backupFile isn't empty, the database is copied there first.
+func MigrateUp(db *gorm.DB, backupFile string) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if backupFile != "" {
+		if err := BackupDatabase(db, backupFile); err != nil {
+			return nil, fmt.Errorf("can't back up the database before migrating it: %w", err)
+		}
+	}
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d, %s, failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Undoes the most recently applied migration, backing the database up first when backupFile isn't empty.
+func MigrateDown(db *gorm.DB, backupFile string) (Migration, error) {
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		return Migration{}, err
+	}
+	if len(applied) == 0 {
+		return Migration{}, errors.New("no migrations have been applied")
+	}
+	last := applied[len(applied)-1]
+	var m Migration
+	for _, candidate := range Migrations {
+		if candidate.Version == last.Version {
+			m = candidate
+		}
+	}
+	if m.Version == 0 {
+		return m, fmt.Errorf("migration %d, %s, is unknown to this version of sfwr", last.Version, last.Name)
+	}
+	if m.Down == nil {
+		return m, fmt.Errorf("migration %d, %s, can't be undone", m.Version, m.Name)
+	}
+	if backupFile != "" {
+		if err := BackupDatabase(db, backupFile); err != nil {
+			return m, fmt.Errorf("can't back up the database before migrating it: %w", err)
+		}
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := m.Down(tx); err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{}, m.Version).Error
+	})
+	if err != nil {
+		return m, fmt.Errorf("undoing migration %d, %s, failed: %w", m.Version, m.Name, err)
+	}
+	return m, nil
+}
+
+// Lists every migration with when it was applied, or that it's pending.
+func PrintMigrationStatus(w io.Writer, db *gorm.DB) error {
+	applied, err := AppliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	appliedAt := make(map[int]time.Time, len(applied))
+	for _, m := range applied {
+		appliedAt[m.Version] = m.AppliedAt
+	}
+	for _, m := range Migrations {
+		status := "pending"
+		if at, ok := appliedAt[m.Version]; ok {
+			status = "applied " + at.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%3d  %-45s %s\n", m.Version, m.Name, status)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// A database as sfwr made it before ratings were editable, books had formats or history was kept.
+func setupOldDatabase(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(path.Join(dir, "old.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrations[0].Up(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO books (main_title, rating, pub_date) VALUES ('Babel-17', 'Excellent', 1966)").Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, dir
+}
+
+func TestMigrateUpBringsOldDatabaseUpToDate(t *testing.T) {
+	db, dir := setupOldDatabase(t)
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(Migrations) {
+		t.Fatalf("Expected every migration to be pending, got %d", len(pending))
+	}
+
+	backup := path.Join(dir, "backup.db")
+	applied, err := MigrateUp(db, backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(Migrations) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(Migrations), len(applied))
+	}
+	if !db.Migrator().HasColumn(&Book{}, "Format") || !db.Migrator().HasTable(&AuditEntry{}) {
+		t.Error("Expected the format column and the history table to be added")
+	}
+	var levels int64
+	db.Model(&RatingLevel{}).Count(&levels)
+	var kindle RatingLevel
+	if levels == 0 || db.First(&kindle, "slug = ?", "Kindle").Error != nil || kindle.Score != 0 {
+		t.Errorf("Expected the rating levels to be seeded, with no score for Kindle, got %d levels and %+v", levels, kindle)
+	}
+	var book Book
+	if err := db.First(&book, "main_title = ?", "Babel-17").Error; err != nil || book.Rating != "Excellent" {
+		t.Errorf("Expected the book to survive the migration, got %+v, %v", book, err)
+	}
+
+	old, err := gorm.Open(sqlite.Open(backup), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Migrator().HasColumn(&Book{}, "Format") || old.Migrator().HasTable(&SchemaMigration{}) {
+		t.Error("Expected the backup to have the database as it was before migrating")
+	}
+
+	again := path.Join(dir, "again.db")
+	if applied, err := MigrateUp(db, again); err != nil || len(applied) != 0 {
+		t.Errorf("Expected nothing left to apply, got %d, %v", len(applied), err)
+	}
+	if _, err := os.Stat(again); !os.IsNotExist(err) {
+		t.Error("Expected no backup when there's nothing to migrate")
+	}
+}
+
+// A database made by AutoMigrate before migrations were kept already has everything.
+func TestMigrateUpDatabaseMadeBeforeMigrations(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(path.Join(t.TempDir(), "automigrated.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&Book{}, &Author{}, &Credit{}, &OpenLibraryBookAuthor{}, &OpenLibraryBookIsbn{}, &Series{}, &SeriesEntry{},
+		&Tag{}, &ReadingSession{}, &AuthorAlias{}, &Work{}, &ContentsEntry{}, &RatingLevel{}, &AuditEntry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&RatingLevel{Slug: "Classic", Label: "Classic"})
+	if applied, err := MigrateUp(db, ""); err != nil || len(applied) != len(Migrations) {
+		t.Fatalf("Expected every migration to apply, got %d, %v", len(applied), err)
+	}
+	var levels int64
+	db.Model(&RatingLevel{}).Count(&levels)
+	if levels != 1 {
+		t.Errorf("Expected the existing rating scheme to be kept, got %d levels", levels)
+	}
+}
+
+// Every column the models use has to come from a migration, so a model change without one fails here.
+func TestMigrationsMatchModels(t *testing.T) {
+	db := setupTestDB(t)
+	models := []any{&Book{}, &Author{}, &Credit{}, &OpenLibraryBookAuthor{}, &OpenLibraryBookIsbn{}, &Series{}, &SeriesEntry{},
+		&Tag{}, &ReadingSession{}, &AuthorAlias{}, &Work{}, &ContentsEntry{}, &RatingLevel{}, &AuditEntry{}}
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		if !db.Migrator().HasTable(stmt.Schema.Table) {
+			t.Errorf("No migration makes the %s table", stmt.Schema.Table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(stmt.Schema.Table, field.DBName) {
+				t.Errorf("No migration adds %s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+		for _, relation := range stmt.Schema.Relationships.Many2Many {
+			if !db.Migrator().HasTable(relation.JoinTable.Table) {
+				t.Errorf("No migration makes the %s table", relation.JoinTable.Table)
+			}
+		}
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	db, dir := setupOldDatabase(t)
+	if _, err := MigrateUp(db, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	undone, err := MigrateDown(db, path.Join(dir, "backup.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kindle RatingLevel
+	db.First(&kindle, "slug = ?", "Kindle")
+	if undone.Version != len(Migrations) || kindle.Score != 3 {
+		t.Errorf("Expected the last migration to be undone, got %d and a Kindle score of %d", undone.Version, kindle.Score)
+	}
+	pending, _ := PendingMigrations(db)
+	if len(pending) != 1 || pending[0].Version != undone.Version {
+		t.Errorf("Expected the undone migration to be pending again, got %+v", pending)
+	}
+
+	var out bytes.Buffer
+	if err := PrintMigrationStatus(&out, db); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(Migrations) || !strings.Contains(lines[0], "applied") || !strings.HasSuffix(lines[len(lines)-1], "pending") {
+		t.Errorf("Unexpected migration status:\n%s", out.String())
+	}
+
+	for i := len(Migrations) - 1; i > 1; i-- {
+		if _, err := MigrateDown(db, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := MigrateDown(db, ""); err == nil || !strings.Contains(err.Error(), "can't be undone") {
+		t.Errorf("Expected the first migration not to be undoable, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/ccdavis/sfwr/load"
+)
+
+// One step of the rating scheme. The scheme is stored in the database so it can be changed from
+// the admin; new databases start with the five built-in levels.
+type RatingLevel struct {
+	gorm.Model
+	Slug        string `gorm:"uniqueIndex"` // What books store, so it can't change once created
+	Label       string
+	Description string // A longer explanation shown when choosing a rating
+	SortOrder   int    // Best first
+	Score       int    // The number typed for it in -new and matched to Goodreads stars, or 0 for none
+	CssClass    string
+}
+
+func DefaultRatingLevels() []RatingLevel {
+	return []RatingLevel{
+		{Slug: "Excellent", Label: "Excellent", SortOrder: 1, Score: 5, CssClass: "rating-excellent"},
+		{Slug: "Very-Good", Label: "Very Good", SortOrder: 2, Score: 4, CssClass: "rating-very-good"},
+		{Slug: "Kindle", Label: "Kindle", Description: "Kindle only / Self-published", SortOrder: 3, CssClass: "rating-kindle"},
+		{Slug: "Interesting", Label: "Interesting", Description: "What was that?", SortOrder: 4, Score: 2, CssClass: "rating-interesting"},
+		{Slug: "Not-Good", Label: "Not Good", Description: "Had to put it down", SortOrder: 5, Score: 1, CssClass: "rating-not-good"},
+	}
+}
+
+// The rating scheme in use. Starts as the built-in levels and is replaced by LoadRatingLevels.
+var (
+	ratingLevelsLock sync.RWMutex
+	ratingLevels     = DefaultRatingLevels()
+)
+
+// The rating scheme in use, best first.
+func RatingLevels() []RatingLevel {
+	ratingLevelsLock.RLock()
+	defer ratingLevelsLock.RUnlock()
+	levels := make([]RatingLevel, len(ratingLevels))
+	copy(levels, ratingLevels)
+	return levels
+}
+
+func UseRatingLevels(levels []RatingLevel) {
+	sorted := make([]RatingLevel, len(levels))
+	copy(sorted, levels)
+	sort.SliceStable(sorted, func(left, right int) bool {
+		return sorted[left].SortOrder < sorted[right].SortOrder
+	})
+	ratingLevelsLock.Lock()
+	defer ratingLevelsLock.Unlock()
+	ratingLevels = sorted
+}
+
+// Reads the rating scheme from the database. Databases made before the scheme was stored keep
+// the built-in levels.
+func LoadRatingLevels(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&RatingLevel{}) {
+		UseRatingLevels(DefaultRatingLevels())
+		return nil
+	}
+	var levels []RatingLevel
+	if err := db.Order("sort_order").Find(&levels).Error; err != nil {
+		return err
+	}
+	if len(levels) == 0 {
+		levels = DefaultRatingLevels()
+	}
+	UseRatingLevels(levels)
+	return nil
+}
+
+func findRatingLevel(slug string) (RatingLevel, bool) {
+	ratingLevelsLock.RLock()
+	defer ratingLevelsLock.RUnlock()
+	for _, level := range ratingLevels {
+		if level.Slug == slug {
+			return level, true
+		}
+	}
+	return RatingLevel{}, false
+}
+
+// Adds or updates a level and switches to the changed scheme. A level's slug can't be changed
+// once it's saved, since books refer to it.
+func SaveRatingLevel(db *gorm.DB, level *RatingLevel) error {
+	level.Slug = strings.TrimSpace(level.Slug)
+	level.Label = strings.TrimSpace(level.Label)
+	level.Description = strings.TrimSpace(level.Description)
+	level.CssClass = strings.TrimSpace(level.CssClass)
+	if level.Slug == "" || strings.ContainsAny(level.Slug, " \t/") {
+		return errors.New("a rating needs a slug without spaces or slashes, like Very-Good")
+	}
+	if level.Slug == Unknown.slug {
+		return fmt.Errorf("%q is reserved for books without a rating", level.Slug)
+	}
+	if level.Label == "" {
+		return errors.New("a rating needs a label")
+	}
+	if level.Score < 0 {
+		return errors.New("a rating's score can't be negative")
+	}
+	if level.ID != 0 {
+		var saved RatingLevel
+		if err := db.First(&saved, level.ID).Error; err != nil {
+			return err
+		}
+		if saved.Slug != level.Slug {
+			return fmt.Errorf("the slug of %s can't be changed", saved.Label)
+		}
+	}
+	var others []RatingLevel
+	if err := db.Where("id <> ?", level.ID).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.Slug == level.Slug {
+			return fmt.Errorf("there's already a rating with the slug %s", level.Slug)
+		}
+		if level.Score != 0 && other.Score == level.Score {
+			return fmt.Errorf("%s already has the score %d", other.Label, level.Score)
+		}
+	}
+	if err := db.Save(level).Error; err != nil {
+		return err
+	}
+	return LoadRatingLevels(db)
+}
+
+var ErrRatingInUse = errors.New("rating in use")
+
+// Deletes a level no book or story has, and switches to the changed scheme. Books in the trash
+// count, since they can be restored.
+func DeleteRatingLevel(db *gorm.DB, id uint) error {
+	var level RatingLevel
+	if err := db.First(&level, id).Error; err != nil {
+		return err
+	}
+	var books, stories int64
+	if err := db.Unscoped().Model(&Book{}).Where("rating = ?", level.Slug).Count(&books).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&ContentsEntry{}).Where("rating = ?", level.Slug).Count(&stories).Error; err != nil {
+		return err
+	}
+	if books+stories > 0 {
+		return fmt.Errorf("%w: %s is still used by %d books and %d stories", ErrRatingInUse, level.Label, books, stories)
+	}
+	if err := db.Unscoped().Delete(&level).Error; err != nil {
+		return err
+	}
+	return LoadRatingLevels(db)
+}
+
+// A book's rating: one of the rating scheme's slugs, or Unknown.
+type Rating struct {
+	slug string
+}
+
+// For Gorm
+func (r Rating) Value() (driver.Value, error) {
+	return r.slug, nil
+}
+
+func (r *Rating) Scan(value interface{}) error {
+	rating, err := StringToRating(value.(string))
+	if err == nil {
+		*r = rating
+	}
+	return err
+}
+
+func (r Rating) String() string {
+	return r.slug
+}
+
+func (r Rating) Display() string {
+	if level, found := findRatingLevel(r.slug); found && level.Label != "" {
+		return level.Label
+	}
+	return strings.ReplaceAll(r.slug, "-", " ")
+}
+
+func (r Rating) CssClass() string {
+	level, _ := findRatingLevel(r.slug)
+	return level.CssClass
+}
+
+// The built-in ratings.
+var (
+	Unknown     = Rating{load.NotRated}
+	VeryGood    = Rating{"Very-Good"}
+	Excellent   = Rating{"Excellent"}
+	Kindle      = Rating{"Kindle"}
+	Interesting = Rating{"Interesting"}
+	NotGood     = Rating{"Not-Good"}
+)
+
+// Every rating a book can have, best first.
+func Ratings() []Rating {
+	var ratings []Rating
+	for _, level := range RatingLevels() {
+		ratings = append(ratings, Rating{level.Slug})
+	}
+	return ratings
+}
+
+func RatingSlugs() []string {
+	var slugs []string
+	for _, level := range RatingLevels() {
+		slugs = append(slugs, level.Slug)
+	}
+	return slugs
+}
+
+func StringToRating(s string) (Rating, error) {
+	if _, found := findRatingLevel(s); found {
+		return Rating{s}, nil
+	}
+	return Unknown, errors.New("unknown rating: " + s)
+}
+
+// Ratings entered as a number, as in the text interface and Goodreads stars. Numbers no level
+// has as its score are Unknown.
+func RatingFromNumber(n int64) Rating {
+	if n == 0 {
+		return Unknown
+	}
+	for _, level := range RatingLevels() {
+		if int64(level.Score) == n {
+			return Rating{level.Slug}
+		}
+	}
+	return Unknown
+}
+
+// The rating's CSS class for the site's templates, or empty if the book isn't rated.
+func (b Book) RatingClass() string {
+	rating, err := StringToRating(b.Rating)
+	if err != nil {
+		return ""
+	}
+	return rating.CssClass()
+}
+
+func (e ContentsEntry) RatingClass() string {
+	rating, err := StringToRating(e.Rating)
+	if err != nil {
+		return ""
+	}
+	return rating.CssClass()
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRatingScan(t *testing.T) {
+	var r Rating
+	if err := r.Scan("Kindle"); err != nil || r != Kindle {
+		t.Errorf("Expected Kindle, got %v %v", r, err)
+	}
+	if err := r.Scan("Meh"); err == nil || r != Kindle {
+		t.Errorf("Expected an unknown rating to be an error that leaves the rating alone, got %v %v", r, err)
+	}
+}
+
+func TestRatingLevels(t *testing.T) {
+	db := setupTestDB(t)
+	defer UseRatingLevels(DefaultRatingLevels())
+
+	var count int64
+	db.Model(&RatingLevel{}).Count(&count)
+	if count != 5 {
+		t.Fatalf("Expected the built-in levels in a new database, got %d", count)
+	}
+	if RatingFromNumber(2) != Interesting || RatingFromNumber(3) != Unknown || RatingFromNumber(6) != Unknown || Excellent.CssClass() != "rating-excellent" {
+		t.Error("Unexpected built-in levels")
+	}
+
+	masterpiece := RatingLevel{Slug: "Masterpiece", Label: "Masterpiece", SortOrder: 0, Score: 6, CssClass: "rating-masterpiece"}
+	if err := SaveRatingLevel(db, &masterpiece); err != nil {
+		t.Fatal(err)
+	}
+	if slugs := RatingSlugs(); len(slugs) != 6 || slugs[0] != "Masterpiece" {
+		t.Errorf("Expected the new level first, got %v", slugs)
+	}
+	if RatingFromNumber(6).String() != "Masterpiece" {
+		t.Error("Expected the new level's score to find it")
+	}
+	book := Book{MainTitle: "Dhalgren", Rating: "Masterpiece"}
+	db.Create(&book)
+	if book.RatingClass() != "rating-masterpiece" || book.DisplayRating() != "Masterpiece" {
+		t.Errorf("Unexpected display %q %q", book.RatingClass(), book.DisplayRating())
+	}
+
+	invalid := []RatingLevel{
+		{Slug: "", Label: "Blank"},
+		{Slug: "So So", Label: "So so"},
+		{Slug: "Kindle", Label: "Another Kindle"},
+		{Slug: "Fine", Label: "Fine", Score: 5},
+		{Slug: "Fine", Label: ""},
+		{Slug: "Not Rated", Label: "Not rated"},
+	}
+	for _, level := range invalid {
+		if err := SaveRatingLevel(db, &level); err == nil {
+			t.Errorf("Expected %+v to be refused", level)
+		}
+	}
+	masterpiece.Slug = "Classic"
+	if err := SaveRatingLevel(db, &masterpiece); err == nil {
+		t.Error("Expected a level's slug not to change")
+	}
+
+	masterpiece.Slug = "Masterpiece"
+	masterpiece.Label = "A Masterpiece"
+	if err := SaveRatingLevel(db, &masterpiece); err != nil {
+		t.Fatal(err)
+	}
+	if book.DisplayRating() != "A Masterpiece" {
+		t.Errorf("Expected the new label, got %q", book.DisplayRating())
+	}
+	if err := DeleteRatingLevel(db, masterpiece.ID); err == nil {
+		t.Error("Expected a level a book has to be kept")
+	}
+	db.Model(&book).Update("rating", "Excellent")
+	trashed := Book{MainTitle: "Nova", Rating: "Masterpiece"}
+	db.Create(&trashed)
+	db.Delete(&trashed)
+	if err := DeleteRatingLevel(db, masterpiece.ID); !errors.Is(err, ErrRatingInUse) {
+		t.Errorf("Expected a level a book in the trash has to be kept, got %v", err)
+	}
+	db.Unscoped().Model(&trashed).Update("rating", "Excellent")
+	if err := DeleteRatingLevel(db, masterpiece.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StringToRating("Masterpiece"); err == nil {
+		t.Error("Expected the deleted level to be gone")
+	}
+}
+
+func TestLoadRatingLevelsWithoutTable(t *testing.T) {
+	db := setupTestDB(t)
+	defer UseRatingLevels(DefaultRatingLevels())
+
+	UseRatingLevels(nil)
+	if err := db.Migrator().DropTable(&RatingLevel{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadRatingLevels(db); err != nil {
+		t.Fatal(err)
+	}
+	if len(RatingLevels()) != 5 {
+		t.Error("Expected a database from before rating levels to use the built-in ones")
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PaperFormat = "paper"
+	EbookFormat = "ebook"
+	AudioFormat = "audio"
+)
+
+var ReadingFormats = []string{PaperFormat, EbookFormat, AudioFormat}
+
+// Dates in the reading log are days, not moments.
+const ReadingDateLayout = "2006-01-02"
+
+// One read-through of a book. Re-reads get their own session. Either date may be
+// unknown (the zero time), and a session without a finish date is still in progress.
+type ReadingSession struct {
+	gorm.Model
+	BookID     uint
+	StartDate  time.Time
+	FinishDate time.Time
+	Format     string
+	Note       string
+}
+
+func (s ReadingSession) Finished() bool {
+	return !s.FinishDate.IsZero()
+}
+
+func (s ReadingSession) FormatStartDate() string {
+	return formatReadingDate(s.StartDate)
+}
+
+func (s ReadingSession) FormatFinishDate() string {
+	return formatReadingDate(s.FinishDate)
+}
+
+func formatReadingDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(ReadingDateLayout)
+}
+
+// Accepts YYYY-MM-DD as sent by date inputs. An empty string is an unknown date.
+func ParseReadingDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(ReadingDateLayout, value)
+}
+
+func IsReadingFormat(format string) bool {
+	for _, f := range ReadingFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ReadingSession) Validate() error {
+	if s.BookID == 0 {
+		return errors.New("a reading session needs a book")
+	}
+	if s.Format != "" && !IsReadingFormat(s.Format) {
+		return fmt.Errorf("unknown reading format '%s', expected one of %s", s.Format, strings.Join(ReadingFormats, ", "))
+	}
+	if s.StartDate.IsZero() && s.FinishDate.IsZero() {
+		return errors.New("a reading session needs a start or finish date")
+	}
+	if !s.StartDate.IsZero() && !s.FinishDate.IsZero() && s.FinishDate.Before(s.StartDate) {
+		return errors.New("finish date is before the start date")
+	}
+	return nil
+}
+
+func LogReadingSession(db *gorm.DB, session *ReadingSession) error {
+	if err := session.Validate(); err != nil {
+		return err
+	}
+	return db.Create(session).Error
+}
+
+func DeleteReadingSession(db *gorm.DB, id uint) error {
+	return db.Delete(&ReadingSession{}, id).Error
+}
+
+// Most recent first; sessions still in progress come before finished ones.
+func (b Book) SortedReadingSessions() []ReadingSession {
+	sessions := make([]ReadingSession, len(b.ReadingSessions))
+	copy(sessions, b.ReadingSessions)
+	sort.SliceStable(sessions, func(left, right int) bool {
+		l, r := sessions[left], sessions[right]
+		if l.Finished() != r.Finished() {
+			return !l.Finished()
+		}
+		if !l.FinishDate.Equal(r.FinishDate) {
+			return l.FinishDate.After(r.FinishDate)
+		}
+		return l.StartDate.After(r.StartDate)
+	})
+	return sessions
+}
+
+// The most recent finish date across all reads of the book, or the zero time if it was never finished.
+func (b Book) LastFinished() time.Time {
+	var last time.Time
+	for _, s := range b.ReadingSessions {
+		if s.FinishDate.After(last) {
+			last = s.FinishDate
+		}
+	}
+	return last
+}
+
+func (b Book) FormatLastFinished() string {
+	return formatReadingDate(b.LastFinished())
+}
+
+func (b Book) TimesRead() int {
+	count := 0
+	for _, s := range b.ReadingSessions {
+		if s.Finished() {
+			count++
+		}
+	}
+	return count
+}
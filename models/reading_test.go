@@ -0,0 +1,89 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func readingDate(t *testing.T, value string) time.Time {
+	date, err := ParseReadingDate(value)
+	if err != nil {
+		t.Fatal("Failed to parse date:", err)
+	}
+	return date
+}
+
+func TestReadingSessionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		session ReadingSession
+		valid   bool
+	}{
+		{"finished", ReadingSession{BookID: 1, StartDate: readingDate(t, "2023-01-02"), FinishDate: readingDate(t, "2023-01-20"), Format: AudioFormat}, true},
+		{"in progress", ReadingSession{BookID: 1, StartDate: readingDate(t, "2023-01-02")}, true},
+		{"only finish date", ReadingSession{BookID: 1, FinishDate: readingDate(t, "1998-06-01")}, true},
+		{"no book", ReadingSession{FinishDate: readingDate(t, "2023-01-20")}, false},
+		{"no dates", ReadingSession{BookID: 1, Format: PaperFormat}, false},
+		{"finished before started", ReadingSession{BookID: 1, StartDate: readingDate(t, "2023-02-01"), FinishDate: readingDate(t, "2023-01-20")}, false},
+		{"unknown format", ReadingSession{BookID: 1, FinishDate: readingDate(t, "2023-01-20"), Format: "scroll"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.session.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid session, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected a validation error")
+			}
+		})
+	}
+}
+
+func TestReadingSessionsWithRereads(t *testing.T) {
+	db := setupTestDB(t)
+
+	book := Book{MainTitle: "Dune", Rating: "Excellent"}
+	db.Create(&book)
+
+	sessions := []ReadingSession{
+		{BookID: book.ID, FinishDate: readingDate(t, "1995-08-01"), Format: PaperFormat},
+		{BookID: book.ID, StartDate: readingDate(t, "2021-03-01"), FinishDate: readingDate(t, "2021-04-10"), Format: AudioFormat, Note: "Re-read before the movie"},
+		{BookID: book.ID, StartDate: readingDate(t, "2024-01-05")},
+	}
+	for i := range sessions {
+		if err := LogReadingSession(db, &sessions[i]); err != nil {
+			t.Fatal("Failed to log reading session:", err)
+		}
+	}
+
+	books, err := LoadAllBooks(db)
+	if err != nil {
+		t.Fatal("Failed to load books:", err)
+	}
+	loaded := books[0]
+
+	if loaded.TimesRead() != 2 {
+		t.Errorf("Expected the book to have been read twice, got %d", loaded.TimesRead())
+	}
+	if loaded.FormatLastFinished() != "2021-04-10" {
+		t.Errorf("Expected last finished 2021-04-10, got '%s'", loaded.FormatLastFinished())
+	}
+
+	sorted := loaded.SortedReadingSessions()
+	if sorted[0].Finished() {
+		t.Error("Expected the session in progress to be listed first")
+	}
+	if sorted[1].Note != "Re-read before the movie" || sorted[2].FormatFinishDate() != "1995-08-01" {
+		t.Error("Expected finished sessions to be listed most recent first")
+	}
+
+	if err := DeleteReadingSession(db, sessions[1].ID); err != nil {
+		t.Fatal("Failed to delete reading session:", err)
+	}
+	books, _ = LoadAllBooks(db)
+	if books[0].FormatLastFinished() != "1995-08-01" {
+		t.Errorf("Expected last finished 1995-08-01 after deleting the re-read, got '%s'", books[0].FormatLastFinished())
+	}
+}
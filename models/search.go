@@ -0,0 +1,315 @@
+package models
+
+import (
+	"html/template"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// The full text index over books. It needs SQLite's FTS5 module, which go-sqlite3 only
+// includes when built with -tags sqlite_fts5. Without it searches fall back to LIKE matching.
+const searchTable = "book_search"
+
+// The indexed columns, in the order the bm25 weights and highlight column numbers assume. Credits
+// holds the names of everyone credited, so co-authors, editors and translators can be found too.
+const searchColumns = "main_title, sub_title, author_full_name, credits, review"
+
+// Fills the index's columns from books.
+const searchSelect = `SELECT id, main_title, sub_title, author_full_name,
+	(SELECT group_concat(authors.full_name, ' ') FROM book_authors JOIN authors ON authors.id = book_authors.author_id
+		WHERE book_authors.book_id = books.id AND authors.deleted_at IS NULL),
+	review FROM books`
+
+// Search terms are marked with these in snippets until they're turned into HTML or terminal output.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+type SearchResult struct {
+	Book    Book
+	Title   string // Main title with the matched terms marked
+	Snippet string // The best matching part of the book's text with the matched terms marked
+}
+
+func (r SearchResult) HighlightedTitle() template.HTML {
+	return markedToHTML(r.Title)
+}
+
+func (r SearchResult) HighlightedSnippet() template.HTML {
+	return markedToHTML(r.Snippet)
+}
+
+// Matches shown in [brackets] for terminal output.
+func (r SearchResult) PlainSnippet() string {
+	return strings.NewReplacer(matchStart, "[", matchEnd, "]").Replace(r.Snippet)
+}
+
+func markedToHTML(marked string) template.HTML {
+	escaped := template.HTMLEscapeString(marked)
+	return template.HTML(strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>").Replace(escaped))
+}
+
+// Creates the full text index if needed and refills it from the books. Refilling every time catches
+// up on changes made by a build without FTS5, which can't touch the index. Reports false without an
+// error when FTS5 isn't compiled in.
+func SetupBookSearch(db *gorm.DB) (bool, error) {
+	if !fts5Available(db) {
+		return false, nil
+	}
+	// An index made before credits were searched is rebuilt with them; it's refilled below anyway
+	if tableExists(db, searchTable) && !searchIndexHasCredits(db) {
+		if err := db.Exec(`DROP TABLE ` + searchTable).Error; err != nil {
+			return false, err
+		}
+	}
+	if !tableExists(db, searchTable) {
+		err := db.Exec(`CREATE VIRTUAL TABLE ` + searchTable + ` USING fts5(` + searchColumns + `)`).Error
+		if err != nil {
+			return false, err
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`DELETE FROM ` + searchTable).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO ` + searchTable + `(rowid, ` + searchColumns + `) ` + searchSelect + ` WHERE deleted_at IS NULL`).Error
+	})
+	return err == nil, err
+}
+
+// Keeps the search index in step with the books table. These run in the same transaction as the
+// change to the book. Triggers would do the same job but make SQLite open the index while preparing
+// the book's INSERT, before the write lock is taken, and then concurrent writers fail as "database is locked".
+// They also record the change in the book's history.
+func (b *Book) AfterSave(tx *gorm.DB) error {
+	if err := bookAudit.after(tx, b.ID, &b.auditBefore); err != nil {
+		return err
+	}
+	return updateBookSearch(tx, b.ID)
+}
+
+func (b *Book) AfterDelete(tx *gorm.DB) error {
+	if err := bookAudit.after(tx, b.ID, &b.auditBefore); err != nil {
+		return err
+	}
+	return updateBookSearch(tx, b.ID)
+}
+
+func updateBookSearch(db *gorm.DB, bookId uint) error {
+	if bookId == 0 || !hasSearchIndex(db) {
+		return nil
+	}
+	if err := db.Exec(`DELETE FROM `+searchTable+` WHERE rowid = ?`, bookId).Error; err != nil {
+		return err
+	}
+	return db.Exec(`INSERT INTO `+searchTable+`(rowid, `+searchColumns+`) `+searchSelect+` WHERE id = ? AND deleted_at IS NULL`, bookId).Error
+}
+
+// Re-indexes every book the author is credited on, after their name changes or they're removed.
+func updateAuthorSearch(db *gorm.DB, authorId uint) error {
+	if authorId == 0 || !hasSearchIndex(db) {
+		return nil
+	}
+	var bookIds []uint
+	if err := db.Model(&Credit{}).Where("author_id = ?", authorId).Pluck("book_id", &bookIds).Error; err != nil {
+		return err
+	}
+	for _, id := range bookIds {
+		if err := updateBookSearch(db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func searchIndexHasCredits(db *gorm.DB) bool {
+	var count int64
+	db.Raw("SELECT count(*) FROM pragma_table_info(?) WHERE name = 'credits'", searchTable).Scan(&count)
+	return count > 0
+}
+
+// The index is only usable when this build has FTS5; a database indexed by one build may be opened by another.
+func hasSearchIndex(db *gorm.DB) bool {
+	return fts5Available(db) && tableExists(db, searchTable)
+}
+
+func fts5Available(db *gorm.DB) bool {
+	var available bool
+	db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	return available
+}
+
+func tableExists(db *gorm.DB, name string) bool {
+	var count int64
+	db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count > 0
+}
+
+// Splits a search into words, ignoring punctuation so nothing the user types can be
+// mistaken for FTS5 query syntax.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Finds books containing every word of the query, as a prefix, in the title, subtitle,
+// the names of anyone credited, or review. Best matches come first, with title matches counting most.
+func SearchBooks(db *gorm.DB, query string, limit int) ([]SearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if hasSearchIndex(db) {
+		return searchBooksFts(db, terms, limit)
+	}
+	return searchBooksLike(db, terms, limit)
+}
+
+func searchBooksFts(db *gorm.DB, terms []string, limit int) ([]SearchResult, error) {
+	var quoted []string
+	for _, t := range terms {
+		quoted = append(quoted, `"`+t+`"*`)
+	}
+
+	var rows []struct {
+		ID      uint
+		Title   string
+		Snippet string
+	}
+	err := db.Raw(`SELECT books.id AS id,
+			highlight(`+searchTable+`, 0, ?, ?) AS title,
+			snippet(`+searchTable+`, -1, ?, ?, '…', 16) AS snippet
+		FROM `+searchTable+`
+		JOIN books ON books.id = `+searchTable+`.rowid
+		WHERE `+searchTable+` MATCH ? AND books.deleted_at IS NULL
+		ORDER BY bm25(`+searchTable+`, 10.0, 5.0, 5.0, 3.0, 1.0)
+		LIMIT ?`,
+		matchStart, matchEnd, matchStart, matchEnd, strings.Join(quoted, " "), limit).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, row := range rows {
+		var book Book
+		if err := db.Preload("Authors").Preload("Credits.Author").First(&book, row.ID).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Book: book, Title: row.Title, Snippet: row.Snippet})
+	}
+	return results, nil
+}
+
+// Used when SQLite was built without FTS5. Slower and cruder, but finds the same books.
+func searchBooksLike(db *gorm.DB, terms []string, limit int) ([]SearchResult, error) {
+	query := db.Preload("Authors").Preload("Credits.Author")
+	for _, t := range terms {
+		pattern := "%" + t + "%"
+		credited := db.Table("book_authors").Select("book_authors.book_id").
+			Joins("JOIN authors ON authors.id = book_authors.author_id").
+			Where("authors.full_name LIKE ? AND authors.deleted_at IS NULL", pattern)
+		query = query.Where("main_title LIKE ? OR sub_title LIKE ? OR author_full_name LIKE ? OR review LIKE ? OR books.id IN (?)",
+			pattern, pattern, pattern, pattern, credited)
+	}
+	var books []Book
+	if err := query.Find(&books).Error; err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, b := range books {
+		results = append(results, SearchResult{
+			Book:    b,
+			Title:   markTerms(b.MainTitle, terms),
+			Snippet: snippetFor(b, terms),
+		})
+	}
+	// Stable so books with equal scores keep the database order
+	score := func(r SearchResult) int {
+		return 2*strings.Count(r.Title, matchStart) + strings.Count(r.Snippet, matchStart)
+	}
+	sort.SliceStable(results, func(left, right int) bool {
+		return score(results[left]) > score(results[right])
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// About sixteen words of the review around the first match, falling back to the subtitle and
+// the names of those credited.
+func snippetFor(b Book, terms []string) string {
+	texts := []string{b.Review, b.SubTitle, b.AuthorFullName}
+	for _, c := range b.OrderedCredits() {
+		texts = append(texts, c.Author.FullName)
+	}
+	for _, text := range texts {
+		words := strings.Fields(text)
+		for i, w := range words {
+			if !containsTerm(w, terms) {
+				continue
+			}
+			start := max(0, i-4)
+			end := min(len(words), start+16)
+			snippet := markTerms(strings.Join(words[start:end], " "), terms)
+			if start > 0 {
+				snippet = "…" + snippet
+			}
+			if end < len(words) {
+				snippet += "…"
+			}
+			return snippet
+		}
+	}
+	return ""
+}
+
+func containsTerm(word string, terms []string) bool {
+	lower := strings.ToLower(word)
+	for _, t := range terms {
+		if strings.Contains(lower, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Marks every case-insensitive occurrence of the terms in the text.
+func markTerms(text string, terms []string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lowercasing changed byte offsets; leave such text unmarked rather than garble it
+		return text
+	}
+	marked := make([]bool, len(text))
+	for _, t := range terms {
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], t)
+			if i < 0 {
+				break
+			}
+			for j := offset + i; j < offset+i+len(t); j++ {
+				marked[j] = true
+			}
+			offset += i + len(t)
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			out.WriteString(matchStart)
+		}
+		out.WriteByte(text[i])
+		if marked[i] && (i == len(text)-1 || !marked[i+1]) {
+			out.WriteString(matchEnd)
+		}
+	}
+	return out.String()
+}
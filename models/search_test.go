@@ -0,0 +1,125 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	got := searchTerms(`Ringworld's "engineers" -NOT niven:*`)
+	expected := []string{"ringworld", "s", "engineers", "not", "niven"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("searchTerms = %v, want %v", got, expected)
+	}
+}
+
+func TestMarkTerms(t *testing.T) {
+	got := markTerms("The Ringworld Engineers", []string{"ring", "engineer"})
+	expected := "The " + matchStart + "Ring" + matchEnd + "world " + matchStart + "Engineer" + matchEnd + "s"
+	if got != expected {
+		t.Errorf("markTerms = %q, want %q", got, expected)
+	}
+
+	result := SearchResult{Title: markTerms("<Ringworld>", []string{"ring"})}
+	if result.HighlightedTitle() != "&lt;<mark>Ring</mark>world&gt;" {
+		t.Errorf("Expected escaped HTML with marks, got %s", result.HighlightedTitle())
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	db := setupTestDB(t)
+
+	books := []Book{
+		{MainTitle: "Ringworld", AuthorFullName: "Larry Niven", Review: "Big dumb object.", Rating: "Excellent"},
+		{MainTitle: "Protector", AuthorFullName: "Larry Niven", Review: "Pak protectors. Not as good as Ringworld but close.", Rating: "Very-Good"},
+		{MainTitle: "Dune", AuthorFullName: "Frank Herbert", Review: "Spice & sandworms.", Rating: "Excellent"},
+	}
+	for i := range books {
+		db.Create(&books[i])
+	}
+
+	results, err := SearchBooks(db, "ringworld", 10)
+	if err != nil {
+		t.Fatal("Search failed:", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	// The title match ranks above the mention in a review
+	if results[0].Book.MainTitle != "Ringworld" || results[1].Book.MainTitle != "Protector" {
+		t.Errorf("Unexpected ranking: %s, %s", results[0].Book.MainTitle, results[1].Book.MainTitle)
+	}
+	if results[1].HighlightedSnippet() == "" || results[1].PlainSnippet() == results[1].Snippet {
+		t.Errorf("Expected a marked snippet for the review match, got %q", results[1].Snippet)
+	}
+
+	// Every word has to match, and words match as prefixes
+	results, _ = SearchBooks(db, "niv prot", 10)
+	if len(results) != 1 || results[0].Book.MainTitle != "Protector" {
+		t.Errorf("Expected only Protector, got %d results", len(results))
+	}
+
+	// Edits and deletions show up in searches straight away
+	db.Model(&books[2]).Update("review", "Spice, sandworms and a Ringworld cameo.")
+	results, _ = SearchBooks(db, "ringworld", 10)
+	if len(results) != 3 {
+		t.Errorf("Expected the edited review to match, got %d results", len(results))
+	}
+	db.Delete(&books[0])
+	results, _ = SearchBooks(db, "ringworld", 10)
+	if len(results) != 2 {
+		t.Errorf("Expected the deleted book to drop out, got %d results", len(results))
+	}
+
+	results, err = SearchBooks(db, `"* (`, 10)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected punctuation-only searches to find nothing, got %d results and %v", len(results), err)
+	}
+}
+
+// Co-authors, editors and translators are searched, not just who the book is filed under.
+func TestSearchBooksByCredits(t *testing.T) {
+	db := setupTestDB(t)
+
+	niven := Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	dozois := Author{FullName: "Gardner Dozois", Surname: "Dozois"}
+	for _, a := range []*Author{&niven, &pournelle, &dozois} {
+		db.Create(a)
+	}
+	mote := Book{MainTitle: "The Mote in God's Eye", Rating: "Excellent"}
+	anthology := Book{MainTitle: "The Year's Best Science Fiction", Rating: "Very-Good"}
+	db.Create(&mote)
+	db.Create(&anthology)
+	if err := SetBookCredits(db, &mote, []Credit{{AuthorID: niven.ID}, {AuthorID: pournelle.ID, Role: CreditCoAuthor}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetBookCredits(db, &anthology, []Credit{{AuthorID: dozois.ID, Role: CreditEditor}}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := SearchBooks(db, "pournelle", 10)
+	if err != nil {
+		t.Fatal("Search failed:", err)
+	}
+	if len(results) != 1 || results[0].Book.ID != mote.ID || !strings.Contains(results[0].PlainSnippet(), "[Pournelle]") {
+		t.Errorf("Expected the co-author to find The Mote in God's Eye, got %+v", results)
+	}
+	results, _ = SearchBooks(db, "dozois", 10)
+	if len(results) != 1 || results[0].Book.ID != anthology.ID {
+		t.Errorf("Expected the editor to find the anthology, got %d results", len(results))
+	}
+
+	// Changing the credits or renaming someone credited re-indexes the book
+	if err := SetBookCredits(db, &mote, []Credit{{AuthorID: niven.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ = SearchBooks(db, "pournelle", 10); len(results) != 0 {
+		t.Errorf("Expected the removed co-author not to match, got %d results", len(results))
+	}
+	db.Model(&dozois).Update("full_name", "Gardner R. Dozois")
+	if results, _ = SearchBooks(db, "gardner r", 10); len(results) != 1 {
+		t.Errorf("Expected the editor's new name to match, got %d results", len(results))
+	}
+}
@@ -0,0 +1,224 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+type Series struct {
+	gorm.Model
+	Name        string
+	Slug        string `gorm:"uniqueIndex"`
+	Description string
+	Entries     []SeriesEntry
+}
+
+// Links a book to a series. Positions are floats so that novellas and other
+// in-between stories can be slotted in, like 2.5 between volumes two and three.
+type SeriesEntry struct {
+	gorm.Model
+	SeriesID uint
+	BookID   uint
+	Position float64
+	Series   Series
+	Book     Book
+}
+
+// Where one book sits within one of its series, with its neighbors for
+// "previous / next in series" links.
+type SeriesNavigation struct {
+	Series   Series
+	Position float64
+	Previous *Book
+	Next     *Book
+}
+
+func (n SeriesNavigation) FormatPosition() string {
+	return FormatSeriesPosition(n.Position)
+}
+
+func FormatSeriesPosition(position float64) string {
+	return strconv.FormatFloat(position, 'f', -1, 64)
+}
+
+// Makes a lowercase, hyphenated name safe for use in URLs and file names.
+func Slugify(name string) string {
+	var slug strings.Builder
+	lastWasHyphen := true
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug.WriteRune(r)
+			lastWasHyphen = false
+		} else if !lastWasHyphen {
+			slug.WriteRune('-')
+			lastWasHyphen = true
+		}
+	}
+	return strings.TrimSuffix(slug.String(), "-")
+}
+
+func (s Series) SiteName() string {
+	return s.Slug + ".html"
+}
+
+// The books of the series in reading order, leaving out books in the trash. Requires the entries
+// and their books to be loaded.
+func (s Series) OrderedEntries() []SeriesEntry {
+	entries := s.bookEntries()
+	sort.SliceStable(entries, func(left, right int) bool {
+		return entries[left].Position < entries[right].Position
+	})
+	return entries
+}
+
+// Trashed books aren't loaded, leaving their entries with an empty book.
+func (s Series) bookEntries() []SeriesEntry {
+	var entries []SeriesEntry
+	for _, e := range s.Entries {
+		if e.Book.ID != 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func (e SeriesEntry) FormatPosition() string {
+	return FormatSeriesPosition(e.Position)
+}
+
+// Requires the book's series entries to be loaded along with each series' own entries and books,
+// as LoadAllBooks does.
+func (b Book) SeriesNavigation() []SeriesNavigation {
+	var navigation []SeriesNavigation
+	for _, entry := range b.SeriesEntries {
+		nav := SeriesNavigation{Series: entry.Series, Position: entry.Position}
+		ordered := entry.Series.OrderedEntries()
+		for i, e := range ordered {
+			if e.BookID != b.ID {
+				continue
+			}
+			if i > 0 {
+				previous := ordered[i-1].Book
+				nav.Previous = &previous
+			}
+			if i < len(ordered)-1 {
+				next := ordered[i+1].Book
+				nav.Next = &next
+			}
+			break
+		}
+		navigation = append(navigation, nav)
+	}
+	return navigation
+}
+
+func (b Book) InSeries() bool {
+	return len(b.SeriesEntries) > 0
+}
+
+func LoadAllSeries(db *gorm.DB) ([]Series, error) {
+	var allSeries []Series
+	result := db.Preload("Entries.Book.Credits.Author").Order("name ASC").Find(&allSeries)
+	for i := range allSeries {
+		allSeries[i].Entries = allSeries[i].bookEntries()
+	}
+	return allSeries, result.Error
+}
+
+func LoadSeries(db *gorm.DB, id uint) (Series, error) {
+	var series Series
+	result := db.Preload("Entries.Book.Credits.Author").First(&series, id)
+	series.Entries = series.bookEntries()
+	return series, result.Error
+}
+
+// Saves a new series, giving it a slug that no other series uses.
+func CreateSeries(db *gorm.DB, name string, description string) (Series, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Series{}, errors.New("series name is required")
+	}
+	slug, err := uniqueSeriesSlug(db, Slugify(name), 0)
+	if err != nil {
+		return Series{}, err
+	}
+	series := Series{Name: name, Slug: slug, Description: description}
+	result := db.Create(&series)
+	return series, result.Error
+}
+
+// Renames a series. The slug follows the name so generated page names stay readable.
+func (s *Series) Update(db *gorm.DB, name string, description string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("series name is required")
+	}
+	slug, err := uniqueSeriesSlug(db, Slugify(name), s.ID)
+	if err != nil {
+		return err
+	}
+	s.Name = name
+	s.Slug = slug
+	s.Description = description
+	return db.Omit("Entries").Save(s).Error
+}
+
+// The series with the name, made if there isn't one yet.
+func findOrCreateSeries(db *gorm.DB, name string, description string) (Series, error) {
+	var series Series
+	result := db.Where("name = ?", strings.TrimSpace(name)).Limit(1).Find(&series)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return series, result.Error
+	}
+	return CreateSeries(db, name, description)
+}
+
+func uniqueSeriesSlug(db *gorm.DB, base string, ownId uint) (string, error) {
+	if base == "" {
+		base = "series"
+	}
+	slug := base
+	for n := 2; ; n++ {
+		var count int64
+		err := db.Unscoped().Model(&Series{}).Where("slug = ? AND id != ?", slug, ownId).Count(&count).Error
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+		slug = fmt.Sprint(base, "-", n)
+	}
+}
+
+// Adds a book to a series, or moves it if it's already part of that series.
+func AddBookToSeries(db *gorm.DB, seriesId uint, bookId uint, position float64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return auditBookList(tx, bookId, "series", func() error {
+			var entry SeriesEntry
+			result := tx.Where("series_id = ? AND book_id = ?", seriesId, bookId).Limit(1).Find(&entry)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				return tx.Model(&entry).Update("position", position).Error
+			}
+			entry = SeriesEntry{SeriesID: seriesId, BookID: bookId, Position: position}
+			return tx.Create(&entry).Error
+		})
+	})
+}
+
+func RemoveBookFromSeries(db *gorm.DB, seriesId uint, bookId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return auditBookList(tx, bookId, "series", func() error {
+			return tx.Unscoped().Where("series_id = ? AND book_id = ?", seriesId, bookId).Delete(&SeriesEntry{}).Error
+		})
+	})
+}
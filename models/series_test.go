@@ -0,0 +1,187 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Vorkosigan Saga", "vorkosigan-saga"},
+		{"The Expanse!", "the-expanse"},
+		{"  Culture -- Novels  ", "culture-novels"},
+		{"Book of the New Sun", "book-of-the-new-sun"},
+		{"Hainish Cycle (Ekumen)", "hainish-cycle-ekumen"},
+		{"!!!", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := Slugify(tt.input); got != tt.expected {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatSeriesPosition(t *testing.T) {
+	if got := FormatSeriesPosition(3); got != "3" {
+		t.Errorf("Expected '3', got '%s'", got)
+	}
+	if got := FormatSeriesPosition(2.5); got != "2.5" {
+		t.Errorf("Expected '2.5', got '%s'", got)
+	}
+}
+
+func TestCreateSeriesUniqueSlugs(t *testing.T) {
+	db := setupTestDB(t)
+
+	first, err := CreateSeries(db, "Vorkosigan Saga", "")
+	if err != nil {
+		t.Fatal("Failed to create series:", err)
+	}
+	second, err := CreateSeries(db, "Vorkosigan saga", "")
+	if err != nil {
+		t.Fatal("Failed to create series:", err)
+	}
+
+	if first.Slug != "vorkosigan-saga" {
+		t.Errorf("Expected slug 'vorkosigan-saga', got '%s'", first.Slug)
+	}
+	if second.Slug != "vorkosigan-saga-2" {
+		t.Errorf("Expected slug 'vorkosigan-saga-2', got '%s'", second.Slug)
+	}
+	if first.SiteName() != "vorkosigan-saga.html" {
+		t.Errorf("Expected site name 'vorkosigan-saga.html', got '%s'", first.SiteName())
+	}
+
+	if _, err := CreateSeries(db, "   ", ""); err == nil {
+		t.Error("Expected an error for a blank series name")
+	}
+}
+
+func TestSeriesNavigation(t *testing.T) {
+	db := setupTestDB(t)
+
+	series, err := CreateSeries(db, "Vorkosigan Saga", "")
+	if err != nil {
+		t.Fatal("Failed to create series:", err)
+	}
+
+	books := []Book{
+		{MainTitle: "Shards of Honor", Rating: "Very-Good"},
+		{MainTitle: "Barrayar", Rating: "Excellent"},
+		{MainTitle: "The Mountains of Mourning", Rating: "Excellent"},
+		{MainTitle: "The Warrior's Apprentice", Rating: "Excellent"},
+	}
+	positions := []float64{1, 2, 2.5, 3}
+	// Add them out of order to make sure positions, not insertion order, decide the reading order
+	for _, i := range []int{3, 0, 2, 1} {
+		db.Create(&books[i])
+		if err := AddBookToSeries(db, series.ID, books[i].ID, positions[i]); err != nil {
+			t.Fatal("Failed to add book to series:", err)
+		}
+	}
+
+	loaded, err := LoadAllBooks(db)
+	if err != nil {
+		t.Fatal("Failed to load books:", err)
+	}
+
+	byTitle := make(map[string]Book)
+	for _, b := range loaded {
+		byTitle[b.MainTitle] = b
+	}
+
+	novella := byTitle["The Mountains of Mourning"].SeriesNavigation()
+	if len(novella) != 1 {
+		t.Fatalf("Expected 1 series for the novella, got %d", len(novella))
+	}
+	if novella[0].FormatPosition() != "2.5" {
+		t.Errorf("Expected position 2.5, got %s", novella[0].FormatPosition())
+	}
+	if novella[0].Previous == nil || novella[0].Previous.MainTitle != "Barrayar" {
+		t.Errorf("Expected previous book to be Barrayar, got %v", novella[0].Previous)
+	}
+	if novella[0].Next == nil || novella[0].Next.MainTitle != "The Warrior's Apprentice" {
+		t.Errorf("Expected next book to be The Warrior's Apprentice, got %v", novella[0].Next)
+	}
+
+	first := byTitle["Shards of Honor"].SeriesNavigation()
+	if len(first) != 1 || first[0].Previous != nil {
+		t.Error("The first book in a series should have no previous book")
+	}
+
+	last := byTitle["The Warrior's Apprentice"].SeriesNavigation()
+	if len(last) != 1 || last[0].Next != nil {
+		t.Error("The last book in a series should have no next book")
+	}
+
+	// Moving a book re-orders the series rather than adding it twice
+	if err := AddBookToSeries(db, series.ID, books[0].ID, 4); err != nil {
+		t.Fatal("Failed to move book:", err)
+	}
+	reloaded, err := LoadSeries(db, series.ID)
+	if err != nil {
+		t.Fatal("Failed to load series:", err)
+	}
+	ordered := reloaded.OrderedEntries()
+	if len(ordered) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(ordered))
+	}
+	if ordered[3].Book.MainTitle != "Shards of Honor" {
+		t.Errorf("Expected Shards of Honor to be last after moving, got %s", ordered[3].Book.MainTitle)
+	}
+
+	if err := RemoveBookFromSeries(db, series.ID, books[0].ID); err != nil {
+		t.Fatal("Failed to remove book:", err)
+	}
+	reloaded, _ = LoadSeries(db, series.ID)
+	if len(reloaded.Entries) != 3 {
+		t.Errorf("Expected 3 entries after removal, got %d", len(reloaded.Entries))
+	}
+}
+
+func TestSeriesLeavesOutTrashedBooks(t *testing.T) {
+	db := setupTestDB(t)
+	series, err := CreateSeries(db, "Imperial Radch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []Book{{MainTitle: "Ancillary Justice"}, {MainTitle: "Ancillary Sword"}, {MainTitle: "Ancillary Mercy"}}
+	for i := range books {
+		db.Create(&books[i])
+		if err := AddBookToSeries(db, series.ID, books[i].ID, float64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Delete(&books[1])
+
+	loaded, err := LoadSeries(db, series.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != 2 {
+		t.Errorf("Expected the trashed book to be left out, got %d entries", len(loaded.Entries))
+	}
+	all, err := LoadAllSeries(db)
+	if err != nil || len(all) != 1 || len(all[0].Entries) != 2 {
+		t.Errorf("Expected the trashed book to be left out of every series, got %+v, %v", all, err)
+	}
+
+	loadedBooks, err := LoadAllBooks(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range loadedBooks {
+		if b.MainTitle != "Ancillary Justice" {
+			continue
+		}
+		nav := b.SeriesNavigation()
+		if len(nav) != 1 || nav[0].Next == nil || nav[0].Next.MainTitle != "Ancillary Mercy" {
+			t.Errorf("Expected the next book to skip the trashed one, got %+v", nav)
+		}
+	}
+}
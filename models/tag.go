@@ -0,0 +1,99 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Free-form labels like "space opera" or "first contact". A book can have any number of them.
+type Tag struct {
+	gorm.Model
+	Name  string
+	Slug  string `gorm:"uniqueIndex"`
+	Books []Book `gorm:"many2many:book_tags;"`
+}
+
+func (t Tag) SiteName() string {
+	return t.Slug + ".html"
+}
+
+// Splits comma separated tag input, dropping blanks and repeats. Tags that only differ
+// by case or punctuation count as the same tag, and the first spelling wins.
+func ParseTagNames(input string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(input, ",") {
+		name = strings.Join(strings.Fields(name), " ")
+		slug := Slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// Comma separated for editing in a single form field.
+func (b Book) TagNames() string {
+	var names []string
+	for _, t := range b.SortedTags() {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (b Book) SortedTags() []Tag {
+	tags := make([]Tag, len(b.Tags))
+	copy(tags, b.Tags)
+	sort.Slice(tags, func(left, right int) bool {
+		return tags[left].Name < tags[right].Name
+	})
+	return tags
+}
+
+func FindOrCreateTags(db *gorm.DB, names []string) ([]Tag, error) {
+	var tags []Tag
+	for _, name := range names {
+		var tag Tag
+		result := db.Where(Tag{Slug: Slugify(name)}).Attrs(Tag{Name: name}).FirstOrCreate(&tag)
+		if result.Error != nil {
+			return tags, result.Error
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// Replaces all of a book's tags with the named ones, creating any tags that don't exist yet.
+func SetBookTags(db *gorm.DB, book *Book, names []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		tags, err := FindOrCreateTags(tx, names)
+		if err != nil {
+			return err
+		}
+		return auditBookList(tx, book.ID, "tags", func() error {
+			if len(tags) == 0 {
+				return tx.Model(book).Association("Tags").Clear()
+			}
+			return tx.Model(book).Association("Tags").Replace(tags)
+		})
+	})
+}
+
+func LoadAllTags(db *gorm.DB) ([]Tag, error) {
+	var tags []Tag
+	result := db.Preload("Books").Order("name ASC").Find(&tags)
+	return tags, result.Error
+}
+
+// Restricts a book query to books carrying the tag with the given slug.
+func WithTag(slug string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN book_tags ON book_tags.book_id = books.id").
+			Joins("JOIN tags ON tags.id = book_tags.tag_id").
+			Where("tags.slug = ?", slug)
+	}
+}
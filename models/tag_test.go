@@ -0,0 +1,73 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTagNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"space opera, cyberpunk", []string{"space opera", "cyberpunk"}},
+		{"  first   contact ,, ", []string{"first contact"}},
+		{"Space Opera, space opera, space-opera", []string{"Space Opera"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := ParseTagNames(tt.input); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseTagNames(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetBookTags(t *testing.T) {
+	db := setupTestDB(t)
+
+	book := Book{MainTitle: "Neuromancer", Rating: "Excellent"}
+	db.Create(&book)
+	other := Book{MainTitle: "Snow Crash", Rating: "Very-Good"}
+	db.Create(&other)
+
+	if err := SetBookTags(db, &book, []string{"cyberpunk", "AI"}); err != nil {
+		t.Fatal("Failed to set tags:", err)
+	}
+	if err := SetBookTags(db, &other, []string{"Cyberpunk"}); err != nil {
+		t.Fatal("Failed to set tags:", err)
+	}
+
+	var tagCount int64
+	db.Model(&Tag{}).Count(&tagCount)
+	if tagCount != 2 {
+		t.Errorf("Expected tags to be shared between books, got %d tags", tagCount)
+	}
+
+	var loaded Book
+	db.Preload("Tags").First(&loaded, book.ID)
+	if loaded.TagNames() != "AI, cyberpunk" {
+		t.Errorf("Expected 'AI, cyberpunk', got '%s'", loaded.TagNames())
+	}
+
+	var tagged []Book
+	db.Scopes(WithTag("cyberpunk")).Find(&tagged)
+	if len(tagged) != 2 {
+		t.Errorf("Expected 2 books tagged cyberpunk, got %d", len(tagged))
+	}
+
+	// Replacing with nothing removes the tags from the book but keeps the tags themselves
+	if err := SetBookTags(db, &book, nil); err != nil {
+		t.Fatal("Failed to clear tags:", err)
+	}
+	db.Preload("Tags").First(&loaded, book.ID)
+	if len(loaded.Tags) != 0 {
+		t.Errorf("Expected no tags after clearing, got %d", len(loaded.Tags))
+	}
+	db.Scopes(WithTag("cyberpunk")).Find(&tagged)
+	if len(tagged) != 1 {
+		t.Errorf("Expected 1 book tagged cyberpunk after clearing, got %d", len(tagged))
+	}
+}
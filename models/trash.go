@@ -0,0 +1,227 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Deleted books and authors stay in the database, hidden by their DeletedAt, until they're restored
+// or purged. Purging removes them for good along with everything that refers to them.
+type Trash struct {
+	Books   []Book
+	Authors []Author
+}
+
+func (t Trash) Empty() bool {
+	return len(t.Books) == 0 && len(t.Authors) == 0
+}
+
+type PurgeReport struct {
+	Books   int
+	Authors int
+	Kept    []string // Why authors old enough to purge were left in the trash
+}
+
+var (
+	ErrNotInTrash    = errors.New("not in the trash")
+	ErrStillCredited = errors.New("author still has books or stories")
+)
+
+func trashed(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+// Everything in the trash, most recently deleted first.
+func LoadTrash(db *gorm.DB) (Trash, error) {
+	var trash Trash
+	// Their authors may be in the trash too
+	credited := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	if err := trashed(db).Preload("Credits.Author", credited).Order("deleted_at DESC").Find(&trash.Books).Error; err != nil {
+		return trash, err
+	}
+	err := trashed(db).Order("deleted_at DESC").Find(&trash.Authors).Error
+	return trash, err
+}
+
+func loadTrashedBook(db *gorm.DB, id uint) (Book, error) {
+	var book Book
+	result := trashed(db).Limit(1).Find(&book, id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return book, fmt.Errorf("book %d is %w", id, ErrNotInTrash)
+	}
+	return book, result.Error
+}
+
+func loadTrashedAuthor(db *gorm.DB, id uint) (Author, error) {
+	var author Author
+	result := trashed(db).Limit(1).Find(&author, id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return author, fmt.Errorf("author %d is %w", id, ErrNotInTrash)
+	}
+	return author, result.Error
+}
+
+// Puts the book back, along with any of its authors that were deleted, so it isn't left without them.
+func RestoreBook(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		book, err := loadTrashedBook(tx, id)
+		if err != nil {
+			return err
+		}
+		// Updating through the book runs its hooks, which put it back in the search index
+		if err := tx.Unscoped().Model(&book).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		var authors []Author
+		err = trashed(tx).Where("id IN (SELECT author_id FROM book_authors WHERE book_id = ?)", id).Find(&authors).Error
+		if err != nil {
+			return err
+		}
+		for _, author := range authors {
+			if err := tx.Unscoped().Model(&author).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func RestoreAuthor(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		author, err := loadTrashedAuthor(tx, id)
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&author).Update("deleted_at", nil).Error
+	})
+}
+
+// Removes a book in the trash for good: its credits, tags, ISBNs, series places, readings and
+// contents, stories only it had, and its cover files.
+func PurgeBook(db *gorm.DB, imageDir string, id uint) error {
+	var book Book
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if book, err = loadTrashedBook(tx, id); err != nil {
+			return err
+		}
+		for _, table := range []string{"book_authors", "book_tags"} {
+			if err := tx.Exec("DELETE FROM "+table+" WHERE book_id = ?", id).Error; err != nil {
+				return err
+			}
+		}
+		for _, child := range []any{&OpenLibraryBookIsbn{}, &OpenLibraryBookAuthor{}, &SeriesEntry{}, &ReadingSession{}} {
+			if err := tx.Unscoped().Where("book_id = ?", id).Delete(child).Error; err != nil {
+				return err
+			}
+		}
+		var entries []ContentsEntry
+		if err := tx.Where("book_id = ?", id).Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := RemoveFromContents(tx, entry.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&book).Error
+	})
+	if err != nil {
+		return err
+	}
+	return removeCoverFiles(db, imageDir, book)
+}
+
+// Deletes the files of the book's uploaded cover and, when no other book shares it, its
+// Open Library cover.
+func removeCoverFiles(db *gorm.DB, imageDir string, book Book) error {
+	var covers []Book
+	if book.UploadedCoverId > 0 {
+		covers = append(covers, Book{CoverSource: CoverSourceUploaded, UploadedCoverId: book.UploadedCoverId})
+	}
+	if book.HasCoverImageId() {
+		var sharing int64
+		if err := db.Unscoped().Model(&Book{}).Where("ol_cover_id = ?", book.OlCoverId).Count(&sharing).Error; err != nil {
+			return err
+		}
+		if sharing == 0 {
+			covers = append(covers, Book{CoverSource: CoverSourceOpenLibrary, OlCoverId: book.OlCoverId})
+		}
+	}
+	var problems []error
+	for _, cover := range covers {
+		files := []string{CoverMasterFile(imageDir, cover)}
+		for _, size := range CoverSizes {
+			files = append(files, cover.MakeCoverImageFilename(imageDir, size), cover.MakeCoverWebpFilename(imageDir, size))
+		}
+		for _, file := range files {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, err)
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// Removes an author in the trash for good, with their aliases. Authors still credited on a book,
+// even one in the trash, have to wait until the book is purged, and authors of stories in the
+// contents of other books have to be restored so the stories keep their author.
+func PurgeAuthor(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		author, err := loadTrashedAuthor(tx, id)
+		if err != nil {
+			return err
+		}
+		var books int64
+		if err := tx.Table("book_authors").Where("author_id = ?", id).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return fmt.Errorf("%w: %s has %d, purge or restore them first", ErrStillCredited, author.FullName, books)
+		}
+		var stories int64
+		if err := tx.Table("work_authors").Where("author_id = ?", id).Count(&stories).Error; err != nil {
+			return err
+		}
+		if stories > 0 {
+			return fmt.Errorf("%w: %s wrote %d stories still in other books, restore them to keep the stories credited", ErrStillCredited, author.FullName, stories)
+		}
+		if err := tx.Unscoped().Where("author_id = ?", id).Delete(&AuthorAlias{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&author).Error
+	})
+}
+
+// Purges everything deleted before the cutoff. Books go first so their authors can follow.
+func PurgeTrashBefore(db *gorm.DB, imageDir string, cutoff time.Time) (PurgeReport, error) {
+	var report PurgeReport
+	var bookIds, authorIds []uint
+	if err := trashed(db).Model(&Book{}).Where("deleted_at < ?", cutoff).Pluck("id", &bookIds).Error; err != nil {
+		return report, err
+	}
+	for _, id := range bookIds {
+		if err := PurgeBook(db, imageDir, id); err != nil {
+			return report, err
+		}
+		report.Books++
+	}
+	if err := trashed(db).Model(&Author{}).Where("deleted_at < ?", cutoff).Pluck("id", &authorIds).Error; err != nil {
+		return report, err
+	}
+	for _, id := range authorIds {
+		err := PurgeAuthor(db, id)
+		if errors.Is(err, ErrStillCredited) {
+			report.Kept = append(report.Kept, err.Error())
+			continue
+		} else if err != nil {
+			return report, err
+		}
+		report.Authors++
+	}
+	return report, nil
+}
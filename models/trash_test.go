@@ -0,0 +1,228 @@
+package models
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestoreBook(t *testing.T) {
+	db := setupTestDB(t)
+	author := createAuthorWithBooks(t, db, "Ursula K. Le Guin", "The Dispossessed")
+	var book Book
+	db.First(&book, "main_title = ?", "The Dispossessed")
+	db.Delete(&book)
+	db.Delete(&author)
+
+	trash, err := LoadTrash(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Books) != 1 || len(trash.Authors) != 1 || trash.Empty() {
+		t.Fatalf("Expected a book and an author in the trash, got %d and %d", len(trash.Books), len(trash.Authors))
+	}
+
+	if err := RestoreBook(db, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	var restored Book
+	if err := db.Preload("Authors").First(&restored, book.ID).Error; err != nil {
+		t.Fatal("The restored book can't be found:", err)
+	}
+	if len(restored.Authors) != 1 {
+		t.Errorf("The book's deleted author should come back with it, got %d authors", len(restored.Authors))
+	}
+	if trash, _ := LoadTrash(db); !trash.Empty() {
+		t.Errorf("The trash should be empty, got %d books and %d authors", len(trash.Books), len(trash.Authors))
+	}
+	if err := RestoreBook(db, book.ID); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("Restoring a book that isn't in the trash should fail, got %v", err)
+	}
+}
+
+func TestPurgeBook(t *testing.T) {
+	db := setupTestDB(t)
+	imageDir := t.TempDir()
+	createAuthorWithBooks(t, db, "Gene Wolfe", "The Island of Doctor Death", "Endangered Species")
+	var book, other Book
+	db.First(&book, "main_title = ?", "The Island of Doctor Death")
+	db.First(&other, "main_title = ?", "Endangered Species")
+
+	if err := SetBookTags(db, &book, []string{"Collections"}); err != nil {
+		t.Fatal(err)
+	}
+	series, _ := CreateSeries(db, "Wolfe Stories", "")
+	if err := AddBookToSeries(db, series.ID, book.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&OpenLibraryBookIsbn{BookId: book.ID, Isbn: "0312890826"})
+	db.Create(&ReadingSession{BookID: book.ID, StartDate: time.Now()})
+	only, _ := FindOrCreateWork(db, "The Death of Doctor Island", []string{"Gene Wolfe"}, 1973, Novella)
+	shared, _ := FindOrCreateWork(db, "Seven American Nights", []string{"Gene Wolfe"}, 1978, Novella)
+	AddToContents(db, book, only, "")
+	AddToContents(db, book, shared, "")
+	AddToContents(db, other, shared, "")
+	if err := UploadCover(db, imageDir, &book, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	master := CoverMasterFile(imageDir, book)
+
+	if err := PurgeBook(db, imageDir, book.ID); !errors.Is(err, ErrNotInTrash) {
+		t.Fatalf("Only books in the trash can be purged, got %v", err)
+	}
+	db.Delete(&book)
+	if err := PurgeBook(db, imageDir, book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	db.Unscoped().Model(&Book{}).Where("id = ?", book.ID).Count(&count)
+	if count != 0 {
+		t.Error("The book is still in the database")
+	}
+	for table, column := range map[string]string{
+		"book_authors":            "book_id",
+		"book_tags":               "book_id",
+		"series_entries":          "book_id",
+		"open_library_book_isbns": "book_id",
+		"reading_sessions":        "book_id",
+		"contents_entries":        "book_id",
+	} {
+		db.Table(table).Where(column+" = ?", book.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d rows left in %s", count, table)
+		}
+	}
+	var works []Work
+	db.Find(&works)
+	if len(works) != 1 || works[0].ID != shared.ID {
+		t.Errorf("Only the story in another book should be kept, got %v", works)
+	}
+	if _, err := os.Stat(master); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The uploaded cover should be removed, got %v", err)
+	}
+	if err := db.First(&Book{}, other.ID).Error; err != nil {
+		t.Error("The other book was lost:", err)
+	}
+}
+
+func TestPurgeKeepsSharedCovers(t *testing.T) {
+	db := setupTestDB(t)
+	imageDir := t.TempDir()
+	first := Book{MainTitle: "Dune", OlCoverId: 12345}
+	second := Book{MainTitle: "Dune (Another Edition)", OlCoverId: 12345}
+	db.Create(&first)
+	db.Create(&second)
+	if err := SaveCoverMaster(imageDir, first, wideCoverPng(t)); err != nil {
+		t.Fatal(err)
+	}
+	master := CoverMasterFile(imageDir, first)
+
+	db.Delete(&first)
+	if err := PurgeBook(db, imageDir, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(master); err != nil {
+		t.Error("A cover another book uses was removed:", err)
+	}
+
+	db.Delete(&second)
+	if err := PurgeBook(db, imageDir, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(master); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("The cover should go with the last book using it, got %v", err)
+	}
+}
+
+func TestPurgeAuthor(t *testing.T) {
+	db := setupTestDB(t)
+	author := createAuthorWithBooks(t, db, "James Tiptree Jr.", "Up the Walls of the World")
+	db.Create(&AuthorAlias{AuthorID: author.ID, Name: "Raccoona Sheldon", Type: AliasPseudonym})
+	db.Delete(&author)
+
+	if err := PurgeAuthor(db, author.ID); !errors.Is(err, ErrStillCredited) {
+		t.Fatalf("An author still credited on a book shouldn't be purged, got %v", err)
+	}
+
+	var book Book
+	db.First(&book, "main_title = ?", "Up the Walls of the World")
+	db.Delete(&book)
+	if err := PurgeBook(db, "", book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := PurgeAuthor(db, author.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Unscoped().Model(&AuthorAlias{}).Where("author_id = ?", author.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d aliases left behind", count)
+	}
+	db.Unscoped().Model(&Author{}).Where("id = ?", author.ID).Count(&count)
+	if count != 0 {
+		t.Error("The author is still in the database")
+	}
+}
+
+func TestPurgeAuthorWithStories(t *testing.T) {
+	db := setupTestDB(t)
+	author := createAuthorWithBooks(t, db, "Cordwainer Smith", "Norstrilia")
+	createAuthorWithBooks(t, db, "Frederik Pohl", "Star Science Fiction Stories 2")
+	var anthology Book
+	db.First(&anthology, "main_title = ?", "Star Science Fiction Stories 2")
+	work, err := FindOrCreateWork(db, "The Game of Rat and Dragon", []string{"Cordwainer Smith"}, 1955, ShortStory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddToContents(db, anthology, work, ""); err != nil {
+		t.Fatal(err)
+	}
+	var book Book
+	db.First(&book, "main_title = ?", "Norstrilia")
+	db.Delete(&book)
+	if err := PurgeBook(db, "", book.ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Delete(&author)
+
+	if err := PurgeAuthor(db, author.ID); !errors.Is(err, ErrStillCredited) {
+		t.Fatalf("An author of a story in another book shouldn't be purged, got %v", err)
+	}
+	var credits int64
+	db.Table("work_authors").Where("work_id = ? AND author_id = ?", work.ID, author.ID).Count(&credits)
+	if credits != 1 {
+		t.Error("Expected the story to keep its author")
+	}
+}
+
+func TestPurgeTrashBefore(t *testing.T) {
+	db := setupTestDB(t)
+	createAuthorWithBooks(t, db, "Octavia E. Butler", "Kindred", "Dawn")
+	old := createAuthorWithBooks(t, db, "Stanley G. Weinbaum", "A Martian Odyssey")
+	var kindred, dawn, odyssey Book
+	db.First(&kindred, "main_title = ?", "Kindred")
+	db.First(&dawn, "main_title = ?", "Dawn")
+	db.First(&odyssey, "main_title = ?", "A Martian Odyssey")
+
+	db.Delete(&kindred)
+	db.Delete(&odyssey)
+	db.Delete(&old)
+	monthAgo := time.Now().AddDate(0, 0, -31)
+	db.Unscoped().Model(&Book{}).Where("id IN ?", []uint{kindred.ID, odyssey.ID}).Update("deleted_at", monthAgo)
+	db.Unscoped().Model(&old).Update("deleted_at", monthAgo)
+	db.Delete(&dawn)
+
+	report, err := PurgeTrashBefore(db, "", time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Books != 2 || report.Authors != 1 || len(report.Kept) != 0 {
+		t.Errorf("Expected 2 books and 1 author purged, got %+v", report)
+	}
+	trash, _ := LoadTrash(db)
+	if len(trash.Books) != 1 || trash.Books[0].ID != dawn.ID {
+		t.Errorf("Only the recently deleted book should be left in the trash, got %v", trash.Books)
+	}
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// How long a piece of short fiction is, using the award categories' word counts.
+type WorkLength string
+
+const (
+	ShortStory WorkLength = "short_story" // Under 7,500 words
+	Novelette  WorkLength = "novelette"   // 7,500 to 17,500 words
+	Novella    WorkLength = "novella"     // 17,500 to 40,000 words
+)
+
+var WorkLengths = []WorkLength{ShortStory, Novelette, Novella}
+
+func ParseWorkLength(s string) (WorkLength, error) {
+	for _, length := range WorkLengths {
+		if string(length) == s {
+			return length, nil
+		}
+	}
+	return "", fmt.Errorf("unknown length: %q, expected short_story, novelette or novella", s)
+}
+
+func (l WorkLength) Label() string {
+	if l == ShortStory {
+		return "short story"
+	}
+	return string(l)
+}
+
+// A piece of short fiction. The same story can appear in several anthologies and collections.
+type Work struct {
+	gorm.Model
+	Title       string
+	PubDate     int64      // The year first published, or Missing
+	Length      WorkLength `gorm:"default:short_story"`
+	Authors     []Author   `gorm:"many2many:work_authors;"`
+	Appearances []ContentsEntry
+}
+
+// One work in an anthology or collection's table of contents.
+type ContentsEntry struct {
+	gorm.Model
+	BookID   uint `gorm:"index"`
+	WorkID   uint `gorm:"index"`
+	Position int
+	Rating   string // A rating slug, or empty if the story isn't rated
+	Work     Work
+	Book     Book
+}
+
+func (w Work) FormatPubDate() string {
+	if w.PubDate == Missing || w.PubDate == 0 {
+		return ""
+	}
+	return fmt.Sprint(w.PubDate)
+}
+
+// The work's authors' full names, like "Larry Niven & Jerry Pournelle".
+func (w Work) AuthorNames() string {
+	var names []string
+	for _, a := range w.Authors {
+		names = append(names, a.FullName)
+	}
+	return joinNames(names)
+}
+
+func (e ContentsEntry) DisplayRating() string {
+	rating, err := StringToRating(e.Rating)
+	if err != nil {
+		return ""
+	}
+	return rating.Display()
+}
+
+// The book's table of contents in order. Requires the contents and their works to be loaded.
+func (b Book) OrderedContents() []ContentsEntry {
+	contents := make([]ContentsEntry, len(b.Contents))
+	copy(contents, b.Contents)
+	sort.SliceStable(contents, func(left, right int) bool {
+		return contents[left].Position < contents[right].Position
+	})
+	return contents
+}
+
+// The author's works that appear in a book in the catalog, oldest first, each with only the
+// appearances whose books are loaded. Requires Works.Appearances.Book to be loaded.
+func (a Author) ShortFiction() []Work {
+	var works []Work
+	for _, w := range a.Works {
+		var appearances []ContentsEntry
+		for _, e := range w.Appearances {
+			if e.Book.ID != 0 {
+				appearances = append(appearances, e)
+			}
+		}
+		if len(appearances) > 0 {
+			w.Appearances = appearances
+			works = append(works, w)
+		}
+	}
+	sort.SliceStable(works, func(left, right int) bool {
+		if works[left].PubDate != works[right].PubDate {
+			return works[left].PubDate < works[right].PubDate
+		}
+		return works[left].Title < works[right].Title
+	})
+	return works
+}
+
+// Finds the authors by name the way the importers do, adding any the catalog doesn't have yet.
+func findOrCreateAuthors(db *gorm.DB, names []string) ([]Author, error) {
+	index, err := NewAuthorIndex(db)
+	if err != nil {
+		return nil, err
+	}
+	var authors []Author
+	for _, name := range names {
+		author, known := index.Find(name)
+		if !known {
+			author = fromRawAuthor(name)
+			if err := db.Create(&author).Error; err != nil {
+				return nil, err
+			}
+			index.Add(author)
+		}
+		authors = append(authors, author)
+	}
+	return authors, nil
+}
+
+// Splits "Larry Niven & Jerry Pournelle" into each author's name.
+func ParseWorkAuthorNames(names string) []string {
+	var parsed []string
+	for _, name := range strings.Split(names, "&") {
+		if name = strings.Join(strings.Fields(name), " "); name != "" {
+			parsed = append(parsed, name)
+		}
+	}
+	return parsed
+}
+
+// The work with the title by the first of the authors, creating it if the catalog doesn't have
+// it yet. Reprinted stories are found this way rather than added twice.
+func FindOrCreateWork(db *gorm.DB, title string, authorNames []string, pubDate int64, length WorkLength) (Work, error) {
+	work := Work{Title: strings.TrimSpace(title), PubDate: pubDate, Length: length}
+	if work.Title == "" {
+		return work, errors.New("the story needs a title")
+	}
+	if len(authorNames) == 0 {
+		return work, errors.New("the story needs an author")
+	}
+	authors, err := findOrCreateAuthors(db, authorNames)
+	if err != nil {
+		return work, err
+	}
+	work.Authors = authors
+	return findOrCreateWork(db, work)
+}
+
+// The saved work with the same title by the same first author, or else the work itself, saved.
+func findOrCreateWork(db *gorm.DB, work Work) (Work, error) {
+	if work.Length == "" {
+		work.Length = ShortStory
+	}
+	var existing Work
+	result := db.Preload("Authors").
+		Where("LOWER(title) = LOWER(?) AND id IN (SELECT work_id FROM work_authors WHERE author_id = ?)", work.Title, work.Authors[0].ID).
+		Limit(1).Find(&existing)
+	if result.Error != nil {
+		return work, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return existing, nil
+	}
+	err := db.Create(&work).Error
+	return work, err
+}
+
+// Adds the work to the end of the book's contents. The rating is a rating slug, or empty.
+func AddToContents(db *gorm.DB, book Book, work Work, rating string) (ContentsEntry, error) {
+	entry := ContentsEntry{BookID: book.ID, WorkID: work.ID, Rating: rating}
+	if rating != "" {
+		if _, err := StringToRating(rating); err != nil {
+			return entry, err
+		}
+	}
+	var count int64
+	if err := db.Model(&ContentsEntry{}).Where("book_id = ? AND work_id = ?", book.ID, work.ID).Count(&count).Error; err != nil {
+		return entry, err
+	}
+	if count > 0 {
+		return entry, fmt.Errorf("%s is already in the contents of %s", work.Title, book.MainTitle)
+	}
+	var last struct{ Position int }
+	if err := db.Model(&ContentsEntry{}).Select("COALESCE(MAX(position), 0) AS position").Where("book_id = ?", book.ID).Scan(&last).Error; err != nil {
+		return entry, err
+	}
+	entry.Position = last.Position + 1
+	err := db.Create(&entry).Error
+	return entry, err
+}
+
+func RateContentsEntry(db *gorm.DB, entryId uint, rating string) error {
+	if rating != "" {
+		if _, err := StringToRating(rating); err != nil {
+			return err
+		}
+	}
+	return db.Model(&ContentsEntry{}).Where("id = ?", entryId).Update("rating", rating).Error
+}
+
+// Takes the work out of the book's contents. Works no book contains any more are deleted too.
+func RemoveFromContents(db *gorm.DB, entryId uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var entry ContentsEntry
+		if err := tx.First(&entry, entryId).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&entry).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&ContentsEntry{}).Where("work_id = ?", entry.WorkID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		work := Work{Model: gorm.Model{ID: entry.WorkID}}
+		if err := tx.Model(&work).Association("Authors").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&work).Error
+	})
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestParseWorkAuthorNames(t *testing.T) {
+	names := ParseWorkAuthorNames(" Larry  Niven & Jerry Pournelle &")
+	if len(names) != 2 || names[0] != "Larry Niven" || names[1] != "Jerry Pournelle" {
+		t.Errorf("Unexpected names %q", names)
+	}
+	if _, err := ParseWorkLength("epic"); err == nil {
+		t.Error("Expected an unknown length to be an error")
+	}
+	if ShortStory.Label() != "short story" || Novella.Label() != "novella" {
+		t.Error("Unexpected labels")
+	}
+}
+
+func TestAnthologyContents(t *testing.T) {
+	db := setupTestDB(t)
+	leGuin := createAuthorWithBooks(t, db, "Ursula K. Le Guin")
+	createAuthorWithBooks(t, db, "Harlan Ellison", "Again, Dangerous Visions", "Dangerous Visions")
+	var again, dangerous Book
+	db.Where("main_title = ?", "Again, Dangerous Visions").First(&again)
+	db.Where("main_title = ?", "Dangerous Visions").First(&dangerous)
+
+	word, err := FindOrCreateWork(db, "The Word for World Is Forest", []string{"ursula k. le guin"}, 1972, Novella)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(word.Authors) != 1 || word.Authors[0].ID != leGuin.ID {
+		t.Errorf("Expected the story's author found by name, got %+v", word.Authors)
+	}
+	if _, err := AddToContents(db, again, word, "Excellent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddToContents(db, again, word, ""); err == nil {
+		t.Error("Expected a story to be in the contents only once")
+	}
+	if _, err := AddToContents(db, again, Work{}, "Meh"); err == nil {
+		t.Error("Expected an unknown rating to be refused")
+	}
+
+	// A reprint is the same work
+	reprint, err := FindOrCreateWork(db, "the word for world is forest", []string{"Ursula K. Le Guin"}, Missing, ShortStory)
+	if err != nil || reprint.ID != word.ID {
+		t.Errorf("Expected the reprint to find the same work, got %d %v", reprint.ID, err)
+	}
+	aye, err := FindOrCreateWork(db, "Aye, and Gomorrah", []string{"Samuel R. Delany"}, 1967, ShortStory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddToContents(db, dangerous, aye, "")
+	AddToContents(db, dangerous, word, "Very-Good")
+	if _, err := FindOrCreateWork(db, " ", []string{"Samuel R. Delany"}, 1967, ShortStory); err == nil {
+		t.Error("Expected a story without a title to be refused")
+	}
+
+	var book Book
+	db.Preload("Contents.Work.Authors").First(&book, dangerous.ID)
+	contents := book.OrderedContents()
+	if len(contents) != 2 || contents[0].Work.Title != "Aye, and Gomorrah" || contents[0].Work.AuthorNames() != "Samuel R. Delany" {
+		t.Fatalf("Unexpected contents %+v", contents)
+	}
+	if contents[0].DisplayRating() != "" || contents[1].DisplayRating() != "Very Good" {
+		t.Errorf("Unexpected story ratings %q %q", contents[0].DisplayRating(), contents[1].DisplayRating())
+	}
+
+	var author Author
+	db.Preload("Works.Appearances.Book").First(&author, leGuin.ID)
+	stories := author.ShortFiction()
+	if len(stories) != 1 || len(stories[0].Appearances) != 2 {
+		t.Fatalf("Expected Le Guin's novella in both anthologies, got %+v", stories)
+	}
+
+	if err := RateContentsEntry(db, contents[0].ID, "Kindle"); err != nil {
+		t.Error(err)
+	}
+	if err := RemoveFromContents(db, contents[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&Work{}).Where("id = ?", aye.ID).Count(&count)
+	if count != 0 {
+		t.Error("Expected a story no book contains to be deleted")
+	}
+	RemoveFromContents(db, contents[1].ID)
+	db.Model(&Work{}).Where("id = ?", word.ID).Count(&count)
+	if count != 1 {
+		t.Error("Expected a story still in another book to be kept")
+	}
+}
@@ -0,0 +1,230 @@
+package pages
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/ccdavis/sfwr/models"
+)
+
+// A feed of the books most recently added, either to the whole site or for one author.
+// Paths are relative to the site root; feeds need absolute links so SiteUrl is required.
+type FeedInfo struct {
+	Title     string
+	SiteName  string // Used as the feed's author
+	SiteUrl   string
+	FeedPath  string // Where the feed itself is written, like "feed.xml"
+	PagePath  string // The page the feed follows, like "index.html"
+	Books     []models.Book
+	Generated time.Time // Used as the updated date of a feed with no books
+}
+
+// The newest listSize books by DateAdded. The books passed in aren't reordered.
+func NewFeedInfo(title string, siteName string, siteUrl string, feedPath string, pagePath string, books []models.Book, listSize int) FeedInfo {
+	sorted := make([]models.Book, len(books))
+	copy(sorted, books)
+	return FeedInfo{
+		Title:     title,
+		SiteName:  siteName,
+		SiteUrl:   strings.TrimSuffix(siteUrl, "/"),
+		FeedPath:  feedPath,
+		PagePath:  pagePath,
+		Books:     BooksMostRecentlyAdded(sorted, listSize),
+		Generated: time.Now(),
+	}
+}
+
+func (f FeedInfo) url(sitePath string) string {
+	return f.SiteUrl + "/" + sitePath
+}
+
+func (f FeedInfo) bookUrl(b models.Book) string {
+	return f.url("books/" + b.SiteFileName())
+}
+
+// The newest entry's date rather than the build time, so rebuilding without changes gives the same feed.
+func (f FeedInfo) updated() time.Time {
+	var latest time.Time
+	for _, b := range f.Books {
+		if t := bookUpdated(b); t.After(latest) {
+			latest = t
+		}
+	}
+	if latest.IsZero() {
+		return f.Generated
+	}
+	return latest
+}
+
+func bookPublished(b models.Book) time.Time {
+	if b.DateAdded.IsZero() {
+		return b.CreatedAt
+	}
+	return b.DateAdded
+}
+
+// Editing a review after the book was added shows up as an update in feed readers.
+func bookUpdated(b models.Book) time.Time {
+	if b.UpdatedAt.After(bookPublished(b)) {
+		return b.UpdatedAt
+	}
+	return bookPublished(b)
+}
+
+func bookEntryTitle(b models.Book) string {
+	return b.FormatTitle() + " by " + b.AuthorDisplayName()
+}
+
+// The cover, rating and review as HTML, for feed readers to display.
+func (f FeedInfo) bookContent(b models.Book) string {
+	var content strings.Builder
+	coverUrl := f.url(b.MakeCoverImageFilename(models.ImageDir, models.MediumCover))
+	fmt.Fprintf(&content, "<p><a href=\"%s\"><img src=\"%s\" alt=\"Cover of %s\" /></a></p>\n",
+		template.HTMLEscapeString(f.bookUrl(b)), template.HTMLEscapeString(coverUrl), template.HTMLEscapeString(b.MainTitle))
+	fmt.Fprintf(&content, "<p>By %s, %s. Rating: %s</p>\n",
+		template.HTMLEscapeString(b.AuthorDisplayName()), template.HTMLEscapeString(strings.TrimSpace(b.FormatPubDate())),
+		template.HTMLEscapeString(b.FormatRating()))
+	for _, paragraph := range strings.Split(b.Review, "\n") {
+		if strings.TrimSpace(paragraph) != "" {
+			fmt.Fprintf(&content, "<p>%s</p>\n", template.HTMLEscapeString(strings.TrimSpace(paragraph)))
+		}
+	}
+	return content.String()
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string       `xml:"title"`
+	Id        string       `xml:"id"`
+	Link      atomLink     `xml:"link"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Author    atomAuthor   `xml:"author"`
+	Category  atomCategory `xml:"category"`
+	Content   atomContent  `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func RenderAtomFeed(feed FeedInfo) ([]byte, error) {
+	atom := atomFeed{
+		Title: feed.Title,
+		Id:    feed.url(feed.FeedPath),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: feed.url(feed.FeedPath)},
+			{Rel: "alternate", Type: "text/html", Href: feed.url(feed.PagePath)},
+		},
+		Updated: feed.updated().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: feed.SiteName},
+	}
+	for _, b := range feed.Books {
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:     bookEntryTitle(b),
+			Id:        feed.bookUrl(b),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: feed.bookUrl(b)},
+			Published: bookPublished(b).UTC().Format(time.RFC3339),
+			Updated:   bookUpdated(b).UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: b.AuthorDisplayName()},
+			Category:  atomCategory{Term: b.FormatRating()},
+			Content:   atomContent{Type: "html", Body: feed.bookContent(b)},
+		})
+	}
+	return marshalFeed(atom)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomSpace string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+func RenderRssFeed(feed FeedInfo) ([]byte, error) {
+	rss := rssFeed{
+		Version:   "2.0",
+		AtomSpace: "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.url(feed.PagePath),
+			Description:   feed.Title,
+			SelfLink:      atomLink{Rel: "self", Type: "application/rss+xml", Href: feed.url(feed.FeedPath)},
+			LastBuildDate: feed.updated().UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, b := range feed.Books {
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       bookEntryTitle(b),
+			Link:        feed.bookUrl(b),
+			Guid:        rssGuid{IsPermaLink: true, Id: feed.bookUrl(b)},
+			PubDate:     bookPublished(b).UTC().Format(time.RFC1123Z),
+			Category:    b.FormatRating(),
+			Description: feed.bookContent(b),
+		})
+	}
+	return marshalFeed(rss)
+}
+
+func marshalFeed(feed any) ([]byte, error) {
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// Each author's feed sits next to their page, as authors/<name>.xml.
+func AuthorFeedPath(author models.Author) string {
+	return "authors/" + author.FeedFileName()
+}
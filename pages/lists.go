@@ -3,10 +3,10 @@ package pages
 import (
 	"bytes"
 	"fmt"
-	"html/template"
 	"log"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/ccdavis/sfwr/models"
 )
@@ -38,6 +38,23 @@ func BooksMostRecentlyAdded(books []models.Book, listSize int) []models.Book {
 	return books[:listSize]
 }
 
+// Only books with a finished reading session are included, latest finish first.
+func BooksMostRecentlyFinished(books []models.Book, listSize int) []models.Book {
+	var finished []models.Book
+	for _, b := range books {
+		if !b.LastFinished().IsZero() {
+			finished = append(finished, b)
+		}
+	}
+	sort.SliceStable(finished, func(left, right int) bool {
+		return finished[left].LastFinished().After(finished[right].LastFinished())
+	})
+	if listSize > len(finished) {
+		listSize = len(finished)
+	}
+	return finished[:listSize]
+}
+
 func BooksByAuthor(books []models.Book) []models.Book {
 	sort.Slice(books, func(left, right int) bool {
 		return books[left].AuthorSurname < books[right].AuthorSurname
@@ -96,7 +113,7 @@ func RenderAuthorIndexPage(authorTemplateFile string, authors []models.Author) s
 	}
 
 	var doc bytes.Buffer
-	t, _ := template.ParseFiles("templates/base.html", authorTemplateFile)
+	t, _ := parsePage(authorTemplateFile)
 	err := t.Execute(&doc, authorChunks)
 	if err != nil {
 		log.Fatal("Error parsing author index template: %w", err)
@@ -105,9 +122,58 @@ func RenderAuthorIndexPage(authorTemplateFile string, authors []models.Author) s
 	return doc.String()
 }
 
-func RenderAuthorPage(authorTemplateFile string, author models.Author) string {
+// Another name an author writes under, linked to that name's page if it's in the catalog as an author of its own.
+type PenNameLink struct {
+	Name  string
+	Label string // Like "pseudonym". Empty when the link is back from the other name's author.
+	Page  string
+}
+
+type AuthorPageInfo struct {
+	models.Author
+	AlsoWritesAs []PenNameLink
+}
+
+// Every author with their pen names. When one author's pen name is another author's name, their
+// pages link to each other.
+func AuthorPages(authors []models.Author) []AuthorPageInfo {
+	byName := make(map[string]models.Author)
+	for _, a := range authors {
+		byName[strings.ToLower(a.FullName)] = a
+	}
+	linksTo := make(map[uint][]PenNameLink)
+	for _, a := range authors {
+		for _, alias := range a.PenNames() {
+			link := PenNameLink{Name: alias.Name, Label: alias.Type.Label()}
+			if other, ok := byName[strings.ToLower(alias.Name)]; ok && other.ID != a.ID {
+				link.Page = other.SiteName()
+				linksTo[other.ID] = append(linksTo[other.ID], PenNameLink{Name: a.FullName, Page: a.SiteName()})
+			}
+			linksTo[a.ID] = append(linksTo[a.ID], link)
+		}
+	}
+
+	var authorPages []AuthorPageInfo
+	for _, a := range authors {
+		info := AuthorPageInfo{Author: a}
+		seen := make(map[string]bool)
+		for _, link := range linksTo[a.ID] {
+			if !seen[strings.ToLower(link.Name)] {
+				seen[strings.ToLower(link.Name)] = true
+				info.AlsoWritesAs = append(info.AlsoWritesAs, link)
+			}
+		}
+		sort.Slice(info.AlsoWritesAs, func(left, right int) bool {
+			return info.AlsoWritesAs[left].Name < info.AlsoWritesAs[right].Name
+		})
+		authorPages = append(authorPages, info)
+	}
+	return authorPages
+}
+
+func RenderAuthorPage(authorTemplateFile string, author AuthorPageInfo) string {
 	var doc bytes.Buffer
-	t, _ := template.ParseFiles("templates/child_dir_base.html", authorTemplateFile)
+	t, _ := parseChildDirPage(authorTemplateFile)
 	err := t.Execute(&doc, author)
 	if err != nil {
 		log.Fatal("Error parsing author page template: %w", err)
@@ -118,7 +184,7 @@ func RenderAuthorPage(authorTemplateFile string, author models.Author) string {
 
 func RenderBookPage(bookTemplateFile string, book models.Book) string {
 	var doc bytes.Buffer
-	t, parseErr := template.ParseFiles("templates/child_dir_base.html", bookTemplateFile)
+	t, parseErr := parseChildDirPage(bookTemplateFile)
 	if parseErr != nil {
 		log.Fatal("Error parsing book page template: %w", parseErr)
 	}
@@ -130,6 +196,221 @@ func RenderBookPage(bookTemplateFile string, book models.Book) string {
 	return doc.String()
 }
 
+const (
+	IndexByDateAdded    = "added"
+	IndexByDateFinished = "finished"
+)
+
+type HomePageInfo struct {
+	ListName string
+	Books    []models.Book
+}
+
+// The home page lists either the books most recently added to the collection or the ones most
+// recently finished, according to the reading log.
+func HomePageBooks(books []models.Book, indexOrder string, listSize int) (HomePageInfo, error) {
+	switch indexOrder {
+	case IndexByDateAdded:
+		return HomePageInfo{ListName: "Recent Additions", Books: BooksMostRecentlyAdded(books, listSize)}, nil
+	case IndexByDateFinished:
+		return HomePageInfo{ListName: "Recently Finished", Books: BooksMostRecentlyFinished(books, listSize)}, nil
+	}
+	return HomePageInfo{}, fmt.Errorf("unknown home page order '%s', use '%s' or '%s'", indexOrder, IndexByDateAdded, IndexByDateFinished)
+}
+
+func RenderHomePage(pageTemplateFile string, homePage HomePageInfo) string {
+	var doc bytes.Buffer
+	t, parseErr := parsePage(pageTemplateFile)
+	if parseErr != nil {
+		log.Fatal("Error parsing home page template: %w", parseErr)
+	}
+	err := t.Execute(&doc, homePage)
+	if err != nil {
+		log.Fatal("Error rendering home page template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+// One finished read of a book.
+type BookReading struct {
+	Book    models.Book
+	Session models.ReadingSession
+}
+
+type ReadingYearInfo struct {
+	Year     string
+	Readings []BookReading
+}
+
+// Groups finished reading sessions by the year they were finished, newest year first. A book
+// re-read in the same year shows up once per read.
+func GroupReadingsByYear(books []models.Book) []ReadingYearInfo {
+	byYear := make(map[string][]BookReading)
+	for _, b := range books {
+		for _, s := range b.ReadingSessions {
+			if !s.Finished() {
+				continue
+			}
+			year := fmt.Sprint(s.FinishDate.Year())
+			byYear[year] = append(byYear[year], BookReading{Book: b, Session: s})
+		}
+	}
+
+	var yearInfos []ReadingYearInfo
+	for year, readings := range byYear {
+		sort.SliceStable(readings, func(left, right int) bool {
+			return readings[left].Session.FinishDate.Before(readings[right].Session.FinishDate)
+		})
+		yearInfos = append(yearInfos, ReadingYearInfo{Year: year, Readings: readings})
+	}
+	sort.Slice(yearInfos, func(i, j int) bool {
+		return yearInfos[i].Year > yearInfos[j].Year
+	})
+	return yearInfos
+}
+
+func RenderReadingYearsIndexPage(readingTemplateFile string, books []models.Book) string {
+	var doc bytes.Buffer
+	t, _ := parsePage(readingTemplateFile)
+	err := t.Execute(&doc, GroupReadingsByYear(books))
+	if err != nil {
+		log.Fatal("Error parsing reading years index template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+func RenderReadingYearPage(readingTemplateFile string, yearInfo ReadingYearInfo) string {
+	var doc bytes.Buffer
+	t, _ := parseChildDirPage(readingTemplateFile)
+	err := t.Execute(&doc, yearInfo)
+	if err != nil {
+		log.Fatal("Error parsing reading year template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+type TagInfo struct {
+	Tag   models.Tag
+	Books []models.Book
+}
+
+// Groups books under each of their tags, with the tags in alphabetical order. A book with several
+// tags appears in several groups.
+func GroupBooksByTag(books []models.Book) []TagInfo {
+	tagsBySlug := make(map[string]models.Tag)
+	booksBySlug := make(map[string][]models.Book)
+	for _, b := range books {
+		for _, t := range b.Tags {
+			tagsBySlug[t.Slug] = t
+			booksBySlug[t.Slug] = append(booksBySlug[t.Slug], b)
+		}
+	}
+
+	var tagInfos []TagInfo
+	for slug, tag := range tagsBySlug {
+		tagInfos = append(tagInfos, TagInfo{
+			Tag:   tag,
+			Books: BooksByPublicationDate(booksBySlug[slug]),
+		})
+	}
+	sort.Slice(tagInfos, func(i, j int) bool {
+		return strings.ToLower(tagInfos[i].Tag.Name) < strings.ToLower(tagInfos[j].Tag.Name)
+	})
+	return tagInfos
+}
+
+func RenderTagsIndexPage(tagTemplateFile string, books []models.Book) string {
+	var doc bytes.Buffer
+	t, _ := parsePage(tagTemplateFile)
+	err := t.Execute(&doc, GroupBooksByTag(books))
+	if err != nil {
+		log.Fatal("Error parsing tags index template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+func RenderTagPage(tagTemplateFile string, tagInfo TagInfo) string {
+	var doc bytes.Buffer
+	t, _ := parseChildDirPage(tagTemplateFile)
+	err := t.Execute(&doc, tagInfo)
+	if err != nil {
+		log.Fatal("Error parsing tag page template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+type FormatInfo struct {
+	Format models.BookFormat
+	Books  []models.Book
+}
+
+// Groups books by their format, in the order of models.BookFormats. Books without a format
+// and formats without books are left out.
+func GroupBooksByFormat(books []models.Book) []FormatInfo {
+	byFormat := GroupByProperty(books, func(b models.Book) models.BookFormat {
+		return b.Format
+	})
+	var formatInfos []FormatInfo
+	for _, format := range models.BookFormats {
+		if len(byFormat[format]) > 0 {
+			formatInfos = append(formatInfos, FormatInfo{
+				Format: format,
+				Books:  BooksByPublicationDate(byFormat[format]),
+			})
+		}
+	}
+	return formatInfos
+}
+
+func RenderFormatsIndexPage(formatTemplateFile string, books []models.Book) string {
+	var doc bytes.Buffer
+	t, _ := parsePage(formatTemplateFile)
+	err := t.Execute(&doc, GroupBooksByFormat(books))
+	if err != nil {
+		log.Fatal("Error parsing formats index template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+func RenderFormatPage(formatTemplateFile string, formatInfo FormatInfo) string {
+	var doc bytes.Buffer
+	t, _ := parseChildDirPage(formatTemplateFile)
+	err := t.Execute(&doc, formatInfo)
+	if err != nil {
+		log.Fatal("Error parsing format page template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+func RenderSeriesIndexPage(seriesTemplateFile string, series []models.Series) string {
+	var doc bytes.Buffer
+	t, _ := parsePage(seriesTemplateFile)
+	err := t.Execute(&doc, series)
+	if err != nil {
+		log.Fatal("Error parsing series index template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
+func RenderSeriesPage(seriesTemplateFile string, series models.Series) string {
+	var doc bytes.Buffer
+	t, _ := parseChildDirPage(seriesTemplateFile)
+	err := t.Execute(&doc, series)
+	if err != nil {
+		log.Fatal("Error parsing series page template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
+
 type DecadeInfo struct {
 	Decade string
 	Books  []models.Book
@@ -162,7 +443,7 @@ func RenderDecadesIndexPage(decadeTemplateFile string, books []models.Book) stri
 	}
 
 	var doc bytes.Buffer
-	t, _ := template.ParseFiles("templates/base.html", decadeTemplateFile)
+	t, _ := parsePage(decadeTemplateFile)
 	err := t.Execute(&doc, decadeInfos)
 	if err != nil {
 		log.Fatal("Error parsing decades index template: %w", err)
@@ -185,7 +466,7 @@ func RenderDecadePage(decadeTemplateFile string, books []models.Book, decade str
 	}
 
 	var doc bytes.Buffer
-	t, _ := template.ParseFiles("templates/child_dir_base.html", decadeTemplateFile)
+	t, _ := parseChildDirPage(decadeTemplateFile)
 	err := t.Execute(&doc, decadeInfo)
 	if err != nil {
 		log.Fatal("Error parsing decade page template: %w", err)
@@ -196,7 +477,7 @@ func RenderDecadePage(decadeTemplateFile string, books []models.Book, decade str
 
 func RenderBookListPage(pageTemplateFile string, books []models.Book) string {
 	var doc bytes.Buffer
-	t, parseErr := template.ParseFiles("templates/base.html", pageTemplateFile)
+	t, parseErr := parsePage(pageTemplateFile)
 	if parseErr != nil {
 		log.Fatal("Error parsing book list page template: %w", parseErr)
 	}
@@ -1,6 +1,9 @@
 package pages
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -101,6 +104,81 @@ func TestBooksMostRecentlyAdded(t *testing.T) {
 	}
 }
 
+func finishedOn(date string) models.ReadingSession {
+	finished, _ := time.Parse(models.ReadingDateLayout, date)
+	return models.ReadingSession{FinishDate: finished}
+}
+
+func TestBooksMostRecentlyFinished(t *testing.T) {
+	books := createTestBooks()
+	books[0].ReadingSessions = []models.ReadingSession{finishedOn("2019-05-01"), finishedOn("2023-02-11")}
+	books[1].ReadingSessions = []models.ReadingSession{finishedOn("2024-07-30")}
+	books[3].ReadingSessions = []models.ReadingSession{finishedOn("2021-12-31")}
+	// Started but never finished
+	books[4].ReadingSessions = []models.ReadingSession{{StartDate: time.Now()}}
+
+	recent := BooksMostRecentlyFinished(books, 10)
+
+	// Books never finished are left out, and a re-read counts from its latest finish
+	expectedTitles := []string{"Book B", "Book A", "Book D"}
+	if len(recent) != len(expectedTitles) {
+		t.Fatalf("Expected %d books, got %d", len(expectedTitles), len(recent))
+	}
+	for i, book := range recent {
+		if book.MainTitle != expectedTitles[i] {
+			t.Errorf("Position %d: expected %s, got %s", i, expectedTitles[i], book.MainTitle)
+		}
+	}
+
+	if len(BooksMostRecentlyFinished(books, 2)) != 2 {
+		t.Error("Expected the list to be limited to 2 books")
+	}
+}
+
+func TestHomePageBooks(t *testing.T) {
+	books := createTestBooks()
+	books[1].ReadingSessions = []models.ReadingSession{finishedOn("2024-07-30")}
+
+	added, err := HomePageBooks(books, IndexByDateAdded, 25)
+	if err != nil || len(added.Books) != len(books) || added.ListName != "Recent Additions" {
+		t.Errorf("Unexpected home page by date added: %v %d %s", err, len(added.Books), added.ListName)
+	}
+
+	finished, err := HomePageBooks(books, IndexByDateFinished, 25)
+	if err != nil || len(finished.Books) != 1 || finished.ListName != "Recently Finished" {
+		t.Errorf("Unexpected home page by date finished: %v %d %s", err, len(finished.Books), finished.ListName)
+	}
+
+	if _, err := HomePageBooks(books, "alphabetical", 25); err == nil {
+		t.Error("Expected an error for an unknown home page order")
+	}
+}
+
+func TestGroupReadingsByYear(t *testing.T) {
+	books := createTestBooks()
+	books[0].ReadingSessions = []models.ReadingSession{finishedOn("2023-09-01"), finishedOn("2023-02-11")}
+	books[1].ReadingSessions = []models.ReadingSession{finishedOn("2024-07-30")}
+	books[2].ReadingSessions = []models.ReadingSession{{StartDate: time.Now()}}
+
+	years := GroupReadingsByYear(books)
+
+	if len(years) != 2 {
+		t.Fatalf("Expected 2 years, got %d", len(years))
+	}
+	if years[0].Year != "2024" || years[1].Year != "2023" {
+		t.Errorf("Expected years newest first, got %s, %s", years[0].Year, years[1].Year)
+	}
+
+	// Both reads of Book A in 2023 are listed, in the order they were finished
+	readings := years[1].Readings
+	if len(readings) != 2 {
+		t.Fatalf("Expected 2 readings in 2023, got %d", len(readings))
+	}
+	if readings[0].Session.FormatFinishDate() != "2023-02-11" || readings[1].Session.FormatFinishDate() != "2023-09-01" {
+		t.Error("Expected readings within a year in the order they were finished")
+	}
+}
+
 func TestDecadeInfo(t *testing.T) {
 	books := createTestBooks()
 
@@ -291,6 +369,194 @@ func TestAuthorsFromBooks(t *testing.T) {
 	}
 }
 
+func TestGroupBooksByTag(t *testing.T) {
+	spaceOpera := models.Tag{Name: "space opera", Slug: "space-opera"}
+	cyberpunk := models.Tag{Name: "Cyberpunk", Slug: "cyberpunk"}
+
+	books := createTestBooks()
+	books[0].Tags = []models.Tag{spaceOpera}
+	books[1].Tags = []models.Tag{spaceOpera, cyberpunk}
+	books[2].Tags = []models.Tag{cyberpunk}
+
+	tagInfos := GroupBooksByTag(books)
+
+	if len(tagInfos) != 2 {
+		t.Fatalf("Expected 2 tags, got %d", len(tagInfos))
+	}
+
+	// Tags are sorted alphabetically regardless of case
+	if tagInfos[0].Tag.Name != "Cyberpunk" || tagInfos[1].Tag.Name != "space opera" {
+		t.Errorf("Tags in wrong order: %s, %s", tagInfos[0].Tag.Name, tagInfos[1].Tag.Name)
+	}
+
+	for _, info := range tagInfos {
+		if len(info.Books) != 2 {
+			t.Errorf("Expected 2 books tagged %s, got %d", info.Tag.Name, len(info.Books))
+		}
+	}
+}
+
+func TestGroupBooksByFormat(t *testing.T) {
+	books := createTestBooks()
+	books[0].Format = models.SelfPublished
+	books[1].Format = models.Hardcover
+	books[2].Format = models.SelfPublished
+	books[2].Rating = "Excellent"
+
+	formatInfos := GroupBooksByFormat(books)
+	if len(formatInfos) != 2 || formatInfos[0].Format != models.Hardcover || formatInfos[1].Format != models.SelfPublished {
+		t.Fatalf("Expected hardcover then self-published, got %+v", formatInfos)
+	}
+	if len(formatInfos[1].Books) != 2 {
+		t.Errorf("Expected 2 self-published books whatever their ratings, got %d", len(formatInfos[1].Books))
+	}
+
+	defaultConfig := SiteConfig
+	defer func() { SiteConfig = defaultConfig }()
+	SiteConfig.Paths.TemplateDir = "../templates"
+	page := RenderBookPage(SiteConfig.Template("book.html"), books[0])
+	if !strings.Contains(page, `format: <a href="../formats/self-published.html">Self-published</a>`) {
+		t.Errorf("Expected the book page to link to its format, got %s", page)
+	}
+	page = RenderFormatPage(SiteConfig.Template("format.html"), formatInfos[1])
+	if !strings.Contains(page, "Self-published") || !strings.Contains(page, books[2].MainTitle) {
+		t.Errorf("Expected the format page to list its books, got %s", page)
+	}
+}
+
+func TestSearchIndexJson(t *testing.T) {
+	books := createTestBooks()
+	books[1].SubTitle = "A Novel"
+	books[1].Tags = []models.Tag{{Name: "space opera"}, {Name: "Cyberpunk"}}
+	books[2].PubDate = models.Missing
+	books[3].Credits = []models.Credit{
+		{Author: models.Author{FullName: "Author One", Surname: "One"}, Role: models.CreditAuthor},
+		{Author: models.Author{FullName: "Author Five", Surname: "Five"}, Role: models.CreditCoAuthor, Position: 1},
+	}
+
+	data, err := SearchIndexJson(books)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []SearchIndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatalf("Index isn't valid JSON: %v", err)
+	}
+	if len(entries) != len(books) {
+		t.Fatalf("Expected %d entries, got %d", len(books), len(entries))
+	}
+
+	// By author surname, then title
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.Title)
+	}
+	if got := strings.Join(order, ","); got != "Book E,Book A,Book D,Book C,Book B" {
+		t.Errorf("Entries in wrong order: %s", got)
+	}
+
+	if entries[2].Author != "One & Five" {
+		t.Errorf("Expected Book D by both its authors, got %q", entries[2].Author)
+	}
+
+	bookB := entries[4]
+	if bookB.SubTitle != "A Novel" || bookB.Year != 1995 || bookB.Rating != "Very Good" {
+		t.Errorf("Unexpected entry for Book B: %+v", bookB)
+	}
+	if strings.Join(bookB.Tags, ",") != "Cyberpunk,space opera" {
+		t.Errorf("Expected sorted tags, got %v", bookB.Tags)
+	}
+	if bookB.Url != "books/"+books[1].SiteFileName() {
+		t.Errorf("Unexpected book page URL %s", bookB.Url)
+	}
+
+	// Empty and unknown values are left out to keep the index small
+	if strings.Contains(string(data), `"y":0`) || strings.Contains(string(data), `"s":""`) || strings.Contains(string(data), `"g":null`) {
+		t.Errorf("Index contains empty values: %s", data)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	books := createTestBooks()
+	books[0].Review = "First <b>paragraph</b>.\nSecond paragraph."
+	books[2].Credits = []models.Credit{{Author: models.Author{FullName: "Gardner Dozois", Surname: "Dozois"}, Role: models.CreditEditor}}
+	for i := range books {
+		books[i].ID = uint(i + 1)
+	}
+
+	feed := NewFeedInfo("New Books", "SF Worth Reading", "https://example.com/", "feed.xml", "index.html", books, 3)
+	if books[0].MainTitle != "Book A" {
+		t.Error("Making a feed reordered the books passed in")
+	}
+
+	atom, err := RenderAtomFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsedAtom struct {
+		Id      string `xml:"id"`
+		Entries []struct {
+			Title   string `xml:"title"`
+			Id      string `xml:"id"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(atom, &parsedAtom); err != nil {
+		t.Fatalf("Atom feed isn't valid XML: %v", err)
+	}
+	if parsedAtom.Id != "https://example.com/feed.xml" {
+		t.Errorf("Unexpected feed id %s", parsedAtom.Id)
+	}
+	// Newest three by date added
+	if len(parsedAtom.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(parsedAtom.Entries))
+	}
+	var titles []string
+	for _, e := range parsedAtom.Entries {
+		titles = append(titles, e.Title)
+	}
+	if got := strings.Join(titles, ","); got != "Book C by ed. Gardner Dozois,Book E by Author Four,Book A by Author One" {
+		t.Errorf("Entries in wrong order: %s", got)
+	}
+	bookA := parsedAtom.Entries[2]
+	if bookA.Id != "https://example.com/books/"+books[0].SiteFileName() {
+		t.Errorf("Entry id isn't the book's permalink: %s", bookA.Id)
+	}
+	for _, expected := range []string{
+		"<p>First &lt;b&gt;paragraph&lt;/b&gt;.</p>",
+		"<p>Second paragraph.</p>",
+		"Rating: Excellent",
+		`src="https://example.com/images/cover_images/placeholder-M.jpg"`,
+	} {
+		if !strings.Contains(bookA.Content, expected) {
+			t.Errorf("Entry content missing %s: %s", expected, bookA.Content)
+		}
+	}
+
+	feed.FeedPath = "rss.xml"
+	rss, err := RenderRssFeed(feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsedRss struct {
+		Channel struct {
+			Items []struct {
+				Link    string `xml:"link"`
+				PubDate string `xml:"pubDate"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(rss, &parsedRss); err != nil {
+		t.Fatalf("RSS feed isn't valid XML: %v", err)
+	}
+	if len(parsedRss.Channel.Items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(parsedRss.Channel.Items))
+	}
+	if _, err := time.Parse(time.RFC1123Z, parsedRss.Channel.Items[0].PubDate); err != nil {
+		t.Errorf("Bad RSS date: %v", err)
+	}
+}
+
 func TestRenderBookListPage(t *testing.T) {
 	// This test would require template files to exist
 	// For now, we'll just test that the function doesn't panic with empty data
@@ -392,4 +658,96 @@ func TestBookSiteFileName(t *testing.T) {
 			t.Skip("SiteFileName method implementation may vary")
 		})
 	}
-}
\ No newline at end of file
+}
+func TestRenderRedirectPage(t *testing.T) {
+	page := RenderRedirectPage("12_C.-J.-Cherryh.html")
+	if !strings.Contains(page, `content="0; url=12_C.-J.-Cherryh.html"`) || !strings.Contains(page, `href="12_C.-J.-Cherryh.html"`) {
+		t.Errorf("Expected the page to send browsers on, got %s", page)
+	}
+	if page := RenderRedirectPage(`a"><script>.html`); strings.Contains(page, "<script>") {
+		t.Errorf("Expected the target to be escaped, got %s", page)
+	}
+}
+
+func TestAuthorPages(t *testing.T) {
+	sheldon := models.Author{FullName: "Alice Sheldon", Surname: "Sheldon", Aliases: []models.AuthorAlias{
+		{Name: "James Tiptree Jr.", Type: models.AliasPseudonym},
+		{Name: "Raccoona Sheldon", Type: models.AliasPseudonym},
+		{Name: "Alice B. Sheldon", Type: models.AliasVariant},
+	}}
+	sheldon.ID = 1
+	tiptree := models.Author{FullName: "James Tiptree Jr.", Surname: "Jr."}
+	tiptree.ID = 2
+	banks := models.Author{FullName: "Iain M. Banks", Surname: "Banks"}
+	banks.ID = 3
+
+	authorPages := AuthorPages([]models.Author{sheldon, tiptree, banks})
+	if len(authorPages) != 3 {
+		t.Fatalf("Expected a page for every author, got %d", len(authorPages))
+	}
+	expected := []PenNameLink{
+		{Name: "James Tiptree Jr.", Label: "pseudonym", Page: tiptree.SiteName()},
+		{Name: "Raccoona Sheldon", Label: "pseudonym"},
+	}
+	if fmt.Sprint(authorPages[0].AlsoWritesAs) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, authorPages[0].AlsoWritesAs)
+	}
+	back := []PenNameLink{{Name: "Alice Sheldon", Page: sheldon.SiteName()}}
+	if fmt.Sprint(authorPages[1].AlsoWritesAs) != fmt.Sprint(back) {
+		t.Errorf("Expected the pseudonym's page to link back, got %v", authorPages[1].AlsoWritesAs)
+	}
+	if len(authorPages[2].AlsoWritesAs) != 0 || authorPages[2].FullName != "Iain M. Banks" {
+		t.Errorf("Expected no pen names for Banks, got %v", authorPages[2].AlsoWritesAs)
+	}
+
+	defaultConfig := SiteConfig
+	defer func() { SiteConfig = defaultConfig }()
+	SiteConfig.Paths.TemplateDir = "../templates"
+	page := RenderAuthorPage(SiteConfig.Template("author.html"), authorPages[0])
+	if !strings.Contains(page, `Also writes as <a href="2_James-Tiptree-Jr..html">James Tiptree Jr.</a> (pseudonym), Raccoona Sheldon (pseudonym)`) {
+		t.Errorf("Expected the page to list the pen names, got %s", page)
+	}
+}
+
+func TestAnthologyPages(t *testing.T) {
+	delany := models.Author{FullName: "Samuel R. Delany", Surname: "Delany"}
+	delany.ID = 4
+	anthology := models.Book{MainTitle: "Dangerous Visions", AuthorFullName: "Harlan Ellison", Rating: "Excellent"}
+	anthology.ID = 7
+	story := models.Work{Title: "Aye, and Gomorrah", PubDate: 1967, Length: models.ShortStory, Authors: []models.Author{delany}}
+	anthology.Contents = []models.ContentsEntry{{Work: story, Rating: "Very-Good", Position: 1}}
+	story.Appearances = []models.ContentsEntry{{Book: anthology}}
+	delany.Works = []models.Work{story}
+
+	defaultConfig := SiteConfig
+	defer func() { SiteConfig = defaultConfig }()
+	SiteConfig.Paths.TemplateDir = "../templates"
+	page := RenderBookPage(SiteConfig.Template("book.html"), anthology)
+	if !strings.Contains(page, `by <a href="../authors/4_Samuel-R.-Delany.html">Samuel R. Delany</a> (short story, 1967) &mdash; <span class="rating-very-good">Very Good</span>`) {
+		t.Errorf("Expected the anthology page to list its contents, got %s", page)
+	}
+	page = RenderAuthorPage(SiteConfig.Template("author.html"), AuthorPages([]models.Author{delany})[0])
+	if !strings.Contains(page, `(short story, 1967) in <a href="../books/`+anthology.SiteFileName()+`">Dangerous Visions</a>`) {
+		t.Errorf("Expected the author page to list the story, got %s", page)
+	}
+	if strings.Contains(page, "Nothing to see here") {
+		t.Error("Expected an author with only stories to have something to see")
+	}
+}
+
+func TestFeedLinksNeedSiteUrl(t *testing.T) {
+	author := AuthorPages([]models.Author{{FullName: "Ursula K. Le Guin", Surname: "Le Guin"}})[0]
+	defaultConfig := SiteConfig
+	defer func() { SiteConfig = defaultConfig }()
+	SiteConfig.Paths.TemplateDir = "../templates"
+
+	page := RenderAuthorPage(SiteConfig.Template("author.html"), author)
+	if strings.Contains(page, "feed.xml") || strings.Contains(page, "Follow new books") {
+		t.Error("Expected no feed links when the feeds aren't made")
+	}
+	SiteConfig.Site.Url = "https://example.com"
+	page = RenderAuthorPage(SiteConfig.Template("author.html"), author)
+	if !strings.Contains(page, author.FeedFileName()) || !strings.Contains(page, "Follow new books by Ursula K. Le Guin") {
+		t.Errorf("Expected the feed links once there's a site url, got %s", page)
+	}
+}
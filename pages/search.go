@@ -0,0 +1,81 @@
+package pages
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ccdavis/sfwr/models"
+)
+
+// One book in the static site's search index. The keys are single letters because the whole
+// index is downloaded by the search page.
+type SearchIndexEntry struct {
+	Title    string   `json:"t"`
+	SubTitle string   `json:"s,omitempty"`
+	Author   string   `json:"a"`
+	Year     int64    `json:"y,omitempty"`
+	Rating   string   `json:"r"`
+	Tags     []string `json:"g,omitempty"`
+	Url      string   `json:"u"` // Relative to the site root
+}
+
+// Books in the index are ordered by author surname, then title, which is the order the search page
+// shows matches of equal rank in.
+func BuildSearchIndex(books []models.Book) []SearchIndexEntry {
+	sorted := make([]models.Book, len(books))
+	copy(sorted, books)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].AuthorSurname != sorted[j].AuthorSurname {
+			return sorted[i].AuthorSurname < sorted[j].AuthorSurname
+		}
+		return strings.ToLower(sorted[i].MainTitle) < strings.ToLower(sorted[j].MainTitle)
+	})
+
+	entries := make([]SearchIndexEntry, 0, len(sorted))
+	for _, b := range sorted {
+		entry := SearchIndexEntry{
+			Title:    b.MainTitle,
+			SubTitle: b.SubTitle,
+			Author:   b.AuthorDisplayName(),
+			Rating:   b.FormatRating(),
+			Url:      "books/" + b.SiteFileName(),
+		}
+		if b.PubDate != models.Missing {
+			entry.Year = b.PubDate
+		}
+		for _, t := range b.SortedTags() {
+			entry.Tags = append(entry.Tags, t.Name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// The index as written to search_index.json, without indentation to keep it small.
+func SearchIndexJson(books []models.Book) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(BuildSearchIndex(books)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func RenderSearchPage(searchTemplateFile string) string {
+	var doc bytes.Buffer
+	t, parseErr := parsePage(searchTemplateFile)
+	if parseErr != nil {
+		log.Fatal("Error parsing search page template: %w", parseErr)
+	}
+	err := t.Execute(&doc, nil)
+	if err != nil {
+		log.Fatal("Error rendering search page template: %w", err)
+		os.Exit(1)
+	}
+	return doc.String()
+}
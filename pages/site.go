@@ -0,0 +1,58 @@
+package pages
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+
+	"github.com/ccdavis/sfwr/config"
+)
+
+// The config pages are rendered with. main replaces it with the one read from sfwr.toml.
+var SiteConfig = config.Default()
+
+// Templates can call config to get at the site title or leave out links to pages that aren't generated.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"config": func() config.Config { return SiteConfig },
+	}
+}
+
+// Pages at the top of the site.
+func parsePage(pageTemplateFile string) (*template.Template, error) {
+	return parseWithBase("base.html", pageTemplateFile)
+}
+
+// Pages in subdirectories like authors/ and books/, whose links need to go up a level.
+func parseChildDirPage(pageTemplateFile string) (*template.Template, error) {
+	return parseWithBase("child_dir_base.html", pageTemplateFile)
+}
+
+func parseWithBase(baseTemplate string, pageTemplateFile string) (*template.Template, error) {
+	return template.New(baseTemplate).Funcs(templateFuncs()).ParseFiles(SiteConfig.Template(baseTemplate), pageTemplateFile)
+}
+
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="utf-8" />
+    <meta http-equiv="refresh" content="0; url={{.}}" />
+    <link rel="canonical" href="{{.}}" />
+    <title>Moved</title>
+</head>
+<body>
+    <p>This page has moved to <a href="{{.}}">{{.}}</a>.</p>
+</body>
+</html>
+`))
+
+// A page that sends browsers on to another one, for pages that no longer exist, like those of merged authors.
+// GitHub Pages can't send real redirects.
+func RenderRedirectPage(target string) string {
+	var doc bytes.Buffer
+	err := redirectPage.Execute(&doc, target)
+	if err != nil {
+		log.Fatal("Error rendering redirect page: ", err)
+	}
+	return doc.String()
+}
@@ -37,7 +37,7 @@ func takeLabeledInput(label string, def string) (string, error) {
 	}
 }
 
-func searchBookTui() {
+func searchBookTui(metadata models.MetadataProvider) {
 	var title string
 	var err error
 	title, err = takeLabeledInput("Search title", title)
@@ -51,7 +51,11 @@ func searchBookTui() {
 		fmt.Println("Error eading author.")
 		return
 	}
-	searchResults := models.SearchBook(title, author)
+	searchResults, err := metadata.SearchBooks(title, author)
+	if err != nil {
+		fmt.Println("Could not search Open Library: ", err)
+		return
+	}
 	for _, ed := range searchResults {
 		fmt.Println(ed.Print())
 	}
@@ -96,21 +100,26 @@ func findBookTui(db *gorm.DB) (models.Book, error) {
 	return author.Books[choice], err
 }
 
-func updateBookTui(db *gorm.DB, siteCoverImagesDir string) {
+func updateBookTui(db *gorm.DB, metadata models.MetadataProvider, siteCoverImagesDir string) {
 	book, err := findBookTui(db)
 	if err != nil {
 		fmt.Println("No book found, not updating.")
 		return
 	}
-	updateBookFromOpenLibrary(db, book, siteCoverImagesDir)
+	updateBookFromOpenLibrary(db, metadata, book, siteCoverImagesDir)
 }
 
-func updateBookFromOpenLibrary(db *gorm.DB, book models.Book, siteCoverImagesDir string) {
+func updateBookFromOpenLibrary(db *gorm.DB, metadata models.MetadataProvider, book models.Book, siteCoverImagesDir string) {
 	// Account for inconsistent spelling when authors use their first and middle initials.
 	var searchResults []models.BookSearchResult
 	for _, authorName := range book.AlternateAuthorFullNames() {
 		fmt.Println("Search using author: ", authorName)
-		searchResults = models.SearchBook(book.FormatTitle(), authorName)
+		var err error
+		searchResults, err = metadata.SearchBooks(book.FormatTitle(), authorName)
+		if err != nil {
+			fmt.Println("Could not search Open Library: ", err)
+			return
+		}
 		if len(searchResults) > 0 {
 			break
 		}
@@ -123,7 +132,7 @@ func updateBookFromOpenLibrary(db *gorm.DB, book models.Book, siteCoverImagesDir
 		if err != nil {
 			fmt.Println("Error saving updated book record: ", err)
 		} else {
-			models.CaptureAllSizeCovers(b, siteCoverImagesDir)
+			models.CaptureAllSizeCovers(metadata, b, siteCoverImagesDir)
 			fmt.Println("Book updated to ", b)
 			fmt.Println()
 		}
@@ -132,18 +141,18 @@ func updateBookFromOpenLibrary(db *gorm.DB, book models.Book, siteCoverImagesDir
 	}
 }
 
-func UpdateMissingCoversAndBookData(db *gorm.DB, books []models.Book, imageDir string) {
+func UpdateMissingCoversAndBookData(db *gorm.DB, metadata models.MetadataProvider, books []models.Book, imageDir string) {
 	var booksMissingCovers []models.Book
 	for _, b := range books {
-		if !b.HasOpenLibraryId() || !b.HasCoverImageId() {
+		if !b.HasOpenLibraryId() || !b.HasCover() {
 			booksMissingCovers = append(booksMissingCovers, b)
 			fmt.Println(b.FormatTitle(), " by ", b.AuthorFullName, " has no cover image ID.")
-			updateBookFromOpenLibrary(db, b, imageDir)
+			updateBookFromOpenLibrary(db, metadata, b, imageDir)
 		}
 	}
 }
 
-func addBookWithAuthorTui(db *gorm.DB, author models.Author, siteCoverImagesDir string) error {
+func addBookWithAuthorTui(db *gorm.DB, metadata models.MetadataProvider, author models.Author, siteCoverImagesDir string) error {
 	var newBook models.Book
 	var err error
 	fmt.Println("\nAdd New Book by ", author.FullName, "--------------------")
@@ -188,11 +197,16 @@ func addBookWithAuthorTui(db *gorm.DB, author models.Author, siteCoverImagesDir
 		}
 
 		fmt.Println("Rating:")
-		fmt.Println("(5) Excellent")
-		fmt.Println("(4) Very Good")
-		fmt.Println("(3) Kindle only / Self-published")
-		fmt.Println("(2) ''Interesting' / What was that?")
-		fmt.Println("(1) Not good. Had to put it down.")
+		for _, level := range models.RatingLevels() {
+			if level.Score == 0 {
+				continue
+			}
+			if level.Description != "" {
+				fmt.Printf("(%d) %s / %s\n", level.Score, level.Label, level.Description)
+			} else {
+				fmt.Printf("(%d) %s\n", level.Score, level.Label)
+			}
+		}
 
 		var ratingNumber int64
 		var ratingError error
@@ -200,23 +214,12 @@ func addBookWithAuthorTui(db *gorm.DB, author models.Author, siteCoverImagesDir
 		for rating == models.Unknown {
 			ratingNumber, ratingError = takeLabeledNumberInput("Enter rating", 0)
 			if ratingError != nil {
-				fmt.Println("Please enter a rating between 1 and 5.")
+				fmt.Println("Please enter one of the numbers above.")
 				continue
 			}
-			switch ratingNumber {
-			case 1:
-				rating = models.NotGood
-			case 2:
-				rating = models.Interesting
-			case 3:
-				rating = models.Kindle
-			case 4:
-				rating = models.VeryGood
-			case 5:
-				rating = models.Excellent
-			}
+			rating = models.RatingFromNumber(ratingNumber)
 			if rating == models.Unknown {
-				fmt.Println("Please enter a rating between 1 and 5.")
+				fmt.Println("Please enter one of the numbers above.")
 				continue
 			}
 			newBook.Rating = rating.String()
@@ -273,7 +276,7 @@ func addBookWithAuthorTui(db *gorm.DB, author models.Author, siteCoverImagesDir
 		fmt.Println("Error adding author to book!", dbError)
 		return dbError
 	}
-	updateBookFromOpenLibrary(db, newBook, siteCoverImagesDir)
+	updateBookFromOpenLibrary(db, metadata, newBook, siteCoverImagesDir)
 	return nil
 }
 
@@ -290,17 +293,21 @@ func findAuthorTui(db *gorm.DB) (models.Author, error) {
 		err = scanner.Err()
 		if err == nil {
 			authorName = scanner.Text()
-			var newAuthors []models.Author
-			result := db.Where("full_name like ?", authorName).Find(&newAuthors)
-			if result.Error != nil {
+			// Pseudonyms and other spellings find the author too
+			newAuthors, findError := models.FindAuthorsByName(db, authorName)
+			if findError != nil {
 				authorToUse.FullName = authorName
-				return authorToUse, result.Error
+				return authorToUse, findError
 			}
 			if len(newAuthors) > 0 {
 				fmt.Println("Authors matching ", authorName, ", pick one:")
 				chosenId := int64(newAuthors[0].ID)
 				for _, a := range newAuthors {
-					fmt.Println(a.ID, ": ", a.FullName)
+					if alias, isAlias := a.AliasMatching(authorName); isAlias {
+						fmt.Println(a.ID, ": ", a.FullName, " (", alias.Type.Label(), ": ", alias.Name, ")")
+					} else {
+						fmt.Println(a.ID, ": ", a.FullName)
+					}
 				}
 				authorChoiceLabel := fmt.Sprint("\nType the ID of the author to use, '0' to search again,  or (enter) to choose the first(", chosenId, "):")
 				enteredId, idError := takeLabeledNumberInput(authorChoiceLabel, chosenId)
@@ -352,7 +359,7 @@ const (
 )
 
 // Really basic 80s style text entry
-func MainMenuTui(db *gorm.DB, siteCoverImagesDir string) {
+func MainMenuTui(db *gorm.DB, metadata models.MetadataProvider, siteCoverImagesDir string) {
 	var err error
 	var ch choice
 	for ch != Quit && err == nil {
@@ -372,7 +379,7 @@ func MainMenuTui(db *gorm.DB, siteCoverImagesDir string) {
 				fmt.Println("Error getting author: ", authorError, ", try again.")
 				continue
 			}
-			bookError := addBookWithAuthorTui(db, author, siteCoverImagesDir)
+			bookError := addBookWithAuthorTui(db, metadata, author, siteCoverImagesDir)
 			if bookError != nil {
 				fmt.Println("Error adding book: ", bookError, ", try again.")
 				continue
@@ -380,15 +387,15 @@ func MainMenuTui(db *gorm.DB, siteCoverImagesDir string) {
 				fmt.Println("Success: Book added.")
 			}
 		} else if ch == SearchBook {
-			searchBookTui()
+			searchBookTui(metadata)
 		} else if ch == UpdateBook {
-			updateBookTui(db, siteCoverImagesDir)
+			updateBookTui(db, metadata, siteCoverImagesDir)
 		} else if ch == UpdateAll {
 			allBooks, err := models.LoadAllBooks(db)
 			if err != nil {
 				fmt.Println("can't retrieve books from sfwr db: ", err)
 			} else {
-				UpdateMissingCoversAndBookData(db, allBooks, siteCoverImagesDir)
+				UpdateMissingCoversAndBookData(db, metadata, allBooks, siteCoverImagesDir)
 			}
 		} else if ch == Quit {
 			fmt.Println("Quitting")
@@ -0,0 +1,771 @@
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccdavis/sfwr/models"
+	"gorm.io/gorm"
+)
+
+// The JSON API for scripts. Everything under it answers in JSON, errors included, and
+// openapi.json describes the rest.
+const apiPrefix = "/api/v1/"
+
+const (
+	defaultAPIPageSize = 50
+	maxAPIPageSize     = 200
+	maxAPIRequestSize  = 1 << 20
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type APIAuthorRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type APIBookRef struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+}
+
+type APICover struct {
+	Source string `json:"source"`
+	Small  string `json:"small"`
+	Medium string `json:"medium"`
+	Large  string `json:"large"`
+}
+
+type APIBook struct {
+	ID        uint           `json:"id"`
+	Title     string         `json:"title"`
+	Subtitle  string         `json:"subtitle"`
+	Authors   []APIAuthorRef `json:"authors"`
+	Byline    string         `json:"byline"` // Like "Niven & Pournelle" or "ed. Gardner Dozois"
+	PubYear   *int64         `json:"pub_year"`
+	Rating    string         `json:"rating"`
+	Format    string         `json:"format"` // Empty when not known
+	Review    string         `json:"review"`
+	Tags      []string       `json:"tags"`
+	Cover     *APICover      `json:"cover"`
+	DateAdded time.Time      `json:"date_added"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+type APIAuthor struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Surname   string `json:"surname"`
+	BookCount int    `json:"book_count"`
+}
+
+// A single author comes with their books, which lists leave out.
+type APIAuthorDetail struct {
+	APIAuthor
+	Books []APIBookRef `json:"books"`
+}
+
+type APIRating struct {
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	BookCount int64  `json:"book_count"`
+}
+
+type APIPage struct {
+	Page       int   `json:"page"`
+	PerPage    int   `json:"per_page"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+type apiList struct {
+	Data interface{} `json:"data"`
+	Meta APIPage     `json:"meta"`
+}
+
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+// Fields left out aren't changed by a PATCH, and are cleared by a PUT. Give either author_ids,
+// where the first is the author and the rest co-authors, or credits with their roles.
+type APIBookInput struct {
+	Title     *string           `json:"title"`
+	Subtitle  *string           `json:"subtitle"`
+	AuthorIDs *[]uint           `json:"author_ids"`
+	Credits   *[]APICreditInput `json:"credits"`
+	PubYear   optionalYear      `json:"pub_year"`
+	Rating    *string           `json:"rating"`
+	Format    *string           `json:"format"`
+	Review    *string           `json:"review"`
+	Tags      *[]string         `json:"tags"`
+}
+
+type APICreditInput struct {
+	AuthorID uint   `json:"author_id"`
+	Role     string `json:"role"`
+}
+
+type APIAuthorInput struct {
+	Name *string `json:"name"`
+}
+
+// A year that can be given, cleared with null, or left out, which a plain pointer can't tell apart.
+type optionalYear struct {
+	Set  bool
+	Year *int64
+}
+
+func (y *optionalYear) UnmarshalJSON(data []byte) error {
+	y.Set = true
+	return json.Unmarshal(data, &y.Year)
+}
+
+func (ws *WebServer) apiHandler(w http.ResponseWriter, r *http.Request) {
+	resource, idStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	switch resource {
+	case "openapi.json":
+		if r.Method != http.MethodGet || idStr != "" {
+			ws.apiNotFoundOrNotAllowed(w, idStr, "GET")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	case "ratings":
+		if r.Method != http.MethodGet || idStr != "" {
+			ws.apiNotFoundOrNotAllowed(w, idStr, "GET")
+			return
+		}
+		ws.apiListRatings(w)
+	case "books":
+		if idStr == "" {
+			switch r.Method {
+			case http.MethodGet:
+				ws.apiListBooks(w, r)
+			case http.MethodPost:
+				ws.apiSaveBook(w, r, 0, true)
+			default:
+				ws.apiMethodNotAllowed(w, "GET, POST")
+			}
+			return
+		}
+		id, ok := ws.apiId(w, idStr, "book")
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			ws.apiGetBook(w, id)
+		case http.MethodPut:
+			ws.apiSaveBook(w, r, id, true)
+		case http.MethodPatch:
+			ws.apiSaveBook(w, r, id, false)
+		case http.MethodDelete:
+			ws.apiDeleteBook(w, id)
+		default:
+			ws.apiMethodNotAllowed(w, "GET, PUT, PATCH, DELETE")
+		}
+	case "authors":
+		if idStr == "" {
+			switch r.Method {
+			case http.MethodGet:
+				ws.apiListAuthors(w, r)
+			case http.MethodPost:
+				ws.apiSaveAuthor(w, r, 0)
+			default:
+				ws.apiMethodNotAllowed(w, "GET, POST")
+			}
+			return
+		}
+		id, ok := ws.apiId(w, idStr, "author")
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			ws.apiGetAuthor(w, id)
+		case http.MethodPut, http.MethodPatch:
+			ws.apiSaveAuthor(w, r, id)
+		case http.MethodDelete:
+			ws.apiDeleteAuthor(w, id)
+		default:
+			ws.apiMethodNotAllowed(w, "GET, PUT, PATCH, DELETE")
+		}
+	default:
+		ws.writeJSONError(w, "No such API endpoint: "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func (ws *WebServer) apiMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	ws.writeJSONError(w, "Method not allowed, use "+allowed, http.StatusMethodNotAllowed)
+}
+
+// For resources that have no items of their own, like /ratings/5.
+func (ws *WebServer) apiNotFoundOrNotAllowed(w http.ResponseWriter, idStr string, allowed string) {
+	if idStr != "" {
+		ws.writeJSONError(w, "No such API endpoint", http.StatusNotFound)
+		return
+	}
+	ws.apiMethodNotAllowed(w, allowed)
+}
+
+func (ws *WebServer) apiId(w http.ResponseWriter, idStr string, kind string) (uint, bool) {
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil || id == 0 {
+		ws.writeJSONError(w, fmt.Sprintf("Invalid %s ID: %q", kind, idStr), http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Writes a 404 for a missing record and a 500 for anything else, reporting whether there was an error.
+func (ws *WebServer) apiLoadFailed(w http.ResponseWriter, err error, kind string, id uint) bool {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ws.writeJSONError(w, fmt.Sprintf("No %s with ID %d", kind, id), http.StatusNotFound)
+		return true
+	}
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to load %s: %v", kind, err), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+func (ws *WebServer) decodeAPIRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			ws.writeJSONError(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+		} else {
+			ws.writeJSONError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		}
+		return false
+	}
+	return true
+}
+
+func (ws *WebServer) writeValidationErrors(w http.ResponseWriter, status int, fields []FieldError) {
+	ws.writeJSONErrorEnvelope(w, JSONError{
+		Status:  status,
+		Message: fmt.Sprintf("%d field(s) aren't valid", len(fields)),
+		Fields:  fields,
+	})
+}
+
+// Reads page and per_page, which count from 1 and default to the first 50.
+func parsePagination(r *http.Request) (int, int, []FieldError) {
+	var problems []FieldError
+	page, perPage := 1, defaultAPIPageSize
+	if s := r.URL.Query().Get("page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			problems = append(problems, FieldError{"page", "must be a whole number from 1"})
+		}
+		page = n
+	}
+	if s := r.URL.Query().Get("per_page"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAPIPageSize {
+			problems = append(problems, FieldError{"per_page", fmt.Sprintf("must be a whole number from 1 to %d", maxAPIPageSize)})
+		}
+		perPage = n
+	}
+	return page, perPage, problems
+}
+
+func pageMeta(page int, perPage int, total int64) APIPage {
+	return APIPage{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: int((total + int64(perPage) - 1) / int64(perPage)),
+	}
+}
+
+// Reads a sort like "title" or "-year", newest or last first when it starts with a minus.
+func parseSort(by string, fallback string, columns map[string][]string) (string, error) {
+	if by == "" {
+		by = fallback
+	}
+	direction := "ASC"
+	if strings.HasPrefix(by, "-") {
+		direction = "DESC"
+		by = by[1:]
+	}
+	fields, ok := columns[by]
+	if !ok {
+		var known []string
+		for name := range columns {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return "", fmt.Errorf("can't sort by %q, use one of %s, with a leading - to reverse", by, strings.Join(known, ", "))
+	}
+	var order []string
+	for _, field := range fields {
+		order = append(order, field+" "+direction)
+	}
+	return strings.Join(order, ", "), nil
+}
+
+var bookSortColumns = map[string][]string{
+	"title":  {"books.main_title", "books.sub_title"},
+	"author": {"books.author_surname", "books.author_full_name"},
+	"year":   {"books.pub_date"},
+	"added":  {"books.date_added"},
+	"id":     {"books.id"},
+}
+
+var authorSortColumns = map[string][]string{
+	"name": {"authors.surname", "authors.full_name"},
+	"id":   {"authors.id"},
+}
+
+func (ws *WebServer) apiListBooks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, perPage, problems := parsePagination(r)
+
+	var scopes []func(*gorm.DB) *gorm.DB
+	if rating := query.Get("rating"); rating != "" {
+		if _, err := models.StringToRating(rating); err != nil {
+			problems = append(problems, FieldError{"rating", "must be one of " + strings.Join(models.RatingSlugs(), ", ")})
+		}
+		scopes = append(scopes, models.WithRating(rating))
+	}
+	if format := query.Get("format"); format != "" {
+		if _, err := models.ParseBookFormat(format); err != nil {
+			problems = append(problems, FieldError{"format", err.Error()})
+		}
+		scopes = append(scopes, models.WithFormat(models.BookFormat(format)))
+	}
+	if decade := query.Get("decade"); decade != "" {
+		scope, err := models.InDecade(decade)
+		if err != nil {
+			problems = append(problems, FieldError{"decade", err.Error()})
+		}
+		scopes = append(scopes, scope)
+	}
+	if author := query.Get("author"); author != "" {
+		authorId, err := strconv.ParseUint(author, 10, 32)
+		if err != nil {
+			problems = append(problems, FieldError{"author", "must be an author ID"})
+		}
+		scopes = append(scopes, models.ByAuthor(uint(authorId)))
+	}
+	if tag := query.Get("tag"); tag != "" {
+		scopes = append(scopes, models.WithTag(models.Slugify(tag)))
+	}
+	order, err := parseSort(query.Get("sort"), "-added", bookSortColumns)
+	if err != nil {
+		problems = append(problems, FieldError{"sort", err.Error()})
+	}
+	if len(problems) > 0 {
+		ws.writeValidationErrors(w, http.StatusBadRequest, problems)
+		return
+	}
+
+	var total int64
+	if err := ws.db.Model(&models.Book{}).Scopes(scopes...).Count(&total).Error; err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to count books: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var books []models.Book
+	err = ws.db.Scopes(scopes...).Select("books.*").Preload("Credits.Author").Preload("Tags").
+		Order(order).Order("books.id").Limit(perPage).Offset((page - 1) * perPage).Find(&books).Error
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to load books: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := []APIBook{}
+	for _, b := range books {
+		data = append(data, toAPIBook(b))
+	}
+	ws.writeJSON(w, http.StatusOK, apiList{Data: data, Meta: pageMeta(page, perPage, total)})
+}
+
+func (ws *WebServer) loadAPIBook(id uint) (models.Book, error) {
+	var book models.Book
+	err := ws.db.Preload("Credits.Author").Preload("Tags").First(&book, id).Error
+	return book, err
+}
+
+func (ws *WebServer) apiGetBook(w http.ResponseWriter, id uint) {
+	book, err := ws.loadAPIBook(id)
+	if ws.apiLoadFailed(w, err, "book", id) {
+		return
+	}
+	ws.writeJSON(w, http.StatusOK, apiItem{Data: toAPIBook(book)})
+}
+
+// Creates the book when id is 0. A full save, for POST and PUT, needs the title, authors and
+// rating and clears anything else left out.
+func (ws *WebServer) apiSaveBook(w http.ResponseWriter, r *http.Request, id uint, full bool) {
+	var book models.Book
+	if id != 0 {
+		err := ws.db.First(&book, id).Error
+		if ws.apiLoadFailed(w, err, "book", id) {
+			return
+		}
+	}
+
+	var input APIBookInput
+	if !ws.decodeAPIRequest(w, r, &input) {
+		return
+	}
+	credits, problems := ws.validateBookInput(input, full)
+	if len(problems) > 0 {
+		ws.writeValidationErrors(w, http.StatusUnprocessableEntity, problems)
+		return
+	}
+
+	if full {
+		book.SubTitle = ""
+		book.Format = ""
+		book.Review = ""
+		book.PubDate = models.Missing
+	}
+	if input.Title != nil {
+		book.MainTitle = strings.TrimSpace(*input.Title)
+	}
+	if input.Subtitle != nil {
+		book.SubTitle = strings.TrimSpace(*input.Subtitle)
+	}
+	if input.Rating != nil {
+		book.Rating = *input.Rating
+	}
+	if input.Format != nil {
+		book.Format = models.BookFormat(*input.Format)
+	}
+	if input.Review != nil {
+		book.Review = *input.Review
+	}
+	if input.PubYear.Set {
+		book.PubDate = models.Missing
+		if input.PubYear.Year != nil {
+			book.PubDate = *input.PubYear.Year
+		}
+	}
+	err := ws.db.Transaction(func(tx *gorm.DB) error {
+		if book.ID == 0 {
+			book.DateAdded = time.Now()
+			if err := tx.Create(&book).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Save(&book).Error; err != nil {
+			return err
+		}
+		if len(credits) > 0 {
+			if err := models.SetBookCredits(tx, &book, credits); err != nil {
+				return err
+			}
+		}
+		if input.Tags != nil || full {
+			var names []string
+			if input.Tags != nil {
+				names = models.ParseTagNames(strings.Join(*input.Tags, ","))
+			}
+			return models.SetBookTags(tx, &book, names)
+		}
+		return nil
+	})
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to save book: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := ws.loadAPIBook(book.ID)
+	if ws.apiLoadFailed(w, err, "book", book.ID) {
+		return
+	}
+	status := http.StatusOK
+	if id == 0 {
+		w.Header().Set("Location", fmt.Sprintf("%sbooks/%d", apiPrefix, book.ID))
+		status = http.StatusCreated
+	}
+	ws.writeJSON(w, status, apiItem{Data: toAPIBook(saved)})
+}
+
+// Checks every field, returning the credits in the order given.
+func (ws *WebServer) validateBookInput(input APIBookInput, full bool) ([]models.Credit, []FieldError) {
+	var problems []FieldError
+	if (input.Title != nil && strings.TrimSpace(*input.Title) == "") || (full && input.Title == nil) {
+		problems = append(problems, FieldError{"title", "is required"})
+	}
+	if input.Rating != nil || full {
+		if input.Rating == nil {
+			problems = append(problems, FieldError{"rating", "is required"})
+		} else if _, err := models.StringToRating(*input.Rating); err != nil {
+			problems = append(problems, FieldError{"rating", "must be one of " + strings.Join(models.RatingSlugs(), ", ")})
+		}
+	}
+	if input.Format != nil && *input.Format != "" {
+		if _, err := models.ParseBookFormat(*input.Format); err != nil {
+			problems = append(problems, FieldError{"format", err.Error()})
+		}
+	}
+	if input.PubYear.Year != nil && *input.PubYear.Year == models.Missing {
+		problems = append(problems, FieldError{"pub_year", "use null for an unknown year"})
+	}
+
+	var credits []models.Credit
+	field := "author_ids"
+	checked := len(problems)
+	switch {
+	case input.AuthorIDs != nil && input.Credits != nil:
+		problems = append(problems, FieldError{"credits", "give author_ids or credits, not both"})
+		return nil, problems
+	case input.Credits != nil:
+		field = "credits"
+		for _, c := range *input.Credits {
+			role, err := models.ParseCreditRole(c.Role)
+			if err != nil {
+				problems = append(problems, FieldError{field, err.Error()})
+				continue
+			}
+			credits = append(credits, models.Credit{AuthorID: c.AuthorID, Role: role})
+		}
+	case input.AuthorIDs != nil:
+		for i, authorId := range *input.AuthorIDs {
+			role := models.CreditCoAuthor
+			if i == 0 {
+				role = models.CreditAuthor
+			}
+			credits = append(credits, models.Credit{AuthorID: authorId, Role: role})
+		}
+	}
+	if input.AuthorIDs == nil && input.Credits == nil && !full {
+		return nil, problems
+	}
+	if len(credits) == 0 && len(problems) == checked {
+		problems = append(problems, FieldError{field, "needs at least one author"})
+	}
+	seen := make(map[uint]bool)
+	for _, c := range credits {
+		var author models.Author
+		if err := ws.db.First(&author, c.AuthorID).Error; err != nil {
+			problems = append(problems, FieldError{field, fmt.Sprintf("no author with ID %d", c.AuthorID)})
+		} else if seen[c.AuthorID] {
+			problems = append(problems, FieldError{field, fmt.Sprintf("author %d is credited twice", c.AuthorID)})
+		}
+		seen[c.AuthorID] = true
+	}
+	return credits, problems
+}
+
+func (ws *WebServer) apiDeleteBook(w http.ResponseWriter, id uint) {
+	var book models.Book
+	if ws.apiLoadFailed(w, ws.db.First(&book, id).Error, "book", id) {
+		return
+	}
+	if err := ws.db.Delete(&book).Error; err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to delete book: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ws *WebServer) apiListAuthors(w http.ResponseWriter, r *http.Request) {
+	page, perPage, problems := parsePagination(r)
+	order, err := parseSort(r.URL.Query().Get("sort"), "name", authorSortColumns)
+	if err != nil {
+		problems = append(problems, FieldError{"sort", err.Error()})
+	}
+	if len(problems) > 0 {
+		ws.writeValidationErrors(w, http.StatusBadRequest, problems)
+		return
+	}
+
+	var total int64
+	if err := ws.db.Model(&models.Author{}).Count(&total).Error; err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to count authors: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var authors []models.Author
+	err = ws.db.Preload("Books").Order(order).Order("authors.id").Limit(perPage).Offset((page - 1) * perPage).Find(&authors).Error
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to load authors: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := []APIAuthor{}
+	for _, a := range authors {
+		data = append(data, toAPIAuthor(a))
+	}
+	ws.writeJSON(w, http.StatusOK, apiList{Data: data, Meta: pageMeta(page, perPage, total)})
+}
+
+func (ws *WebServer) apiGetAuthor(w http.ResponseWriter, id uint) {
+	var author models.Author
+	err := ws.db.Preload("Books").First(&author, id).Error
+	if ws.apiLoadFailed(w, err, "author", id) {
+		return
+	}
+	ws.writeJSON(w, http.StatusOK, apiItem{Data: toAPIAuthorDetail(author)})
+}
+
+// Creates the author when id is 0. The name is the only field, so PUT and PATCH are the same.
+func (ws *WebServer) apiSaveAuthor(w http.ResponseWriter, r *http.Request, id uint) {
+	var author models.Author
+	if id != 0 {
+		err := ws.db.First(&author, id).Error
+		if ws.apiLoadFailed(w, err, "author", id) {
+			return
+		}
+	}
+
+	var input APIAuthorInput
+	if !ws.decodeAPIRequest(w, r, &input) {
+		return
+	}
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		ws.writeValidationErrors(w, http.StatusUnprocessableEntity, []FieldError{{"name", "is required"}})
+		return
+	}
+
+	oldName := author.FullName
+	author.FullName = strings.TrimSpace(*input.Name)
+	author.Surname = models.ExtractSurname(author.FullName)
+	err := ws.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&author).Error; err != nil {
+			return err
+		}
+		if id == 0 {
+			return nil
+		}
+		// Books filed under the old name follow the rename, one at a time so each book's history records it
+		bookIds := tx.Table("book_authors").Select("book_id").Where("author_id = ?", author.ID)
+		var books []models.Book
+		if err := tx.Where("id IN (?) AND author_full_name = ?", bookIds, oldName).Find(&books).Error; err != nil {
+			return err
+		}
+		for _, book := range books {
+			err := tx.Model(&book).Updates(map[string]interface{}{"author_full_name": author.FullName, "author_surname": author.Surname}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to save author: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if id == 0 {
+		w.Header().Set("Location", fmt.Sprintf("%sauthors/%d", apiPrefix, author.ID))
+		status = http.StatusCreated
+	}
+	ws.db.Preload("Books").First(&author, author.ID)
+	ws.writeJSON(w, status, apiItem{Data: toAPIAuthorDetail(author)})
+}
+
+// Authors who still have books can't be deleted, since the books would be left without one.
+func (ws *WebServer) apiDeleteAuthor(w http.ResponseWriter, id uint) {
+	var author models.Author
+	err := ws.db.Preload("Books").First(&author, id).Error
+	if ws.apiLoadFailed(w, err, "author", id) {
+		return
+	}
+	if len(author.Books) > 0 {
+		ws.writeJSONError(w, fmt.Sprintf("%s still has %d book(s), move them to another author first", author.FullName, len(author.Books)), http.StatusConflict)
+		return
+	}
+	if err := ws.db.Delete(&author).Error; err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to delete author: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Every rating, best first, with how many books have it.
+func (ws *WebServer) apiListRatings(w http.ResponseWriter) {
+	var counts []struct {
+		Rating string
+		Count  int64
+	}
+	if err := ws.db.Model(&models.Book{}).Select("rating, COUNT(*) AS count").Group("rating").Scan(&counts).Error; err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Failed to count ratings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	bookCounts := make(map[string]int64)
+	for _, c := range counts {
+		bookCounts[c.Rating] = c.Count
+	}
+
+	var data []APIRating
+	for _, rating := range models.Ratings() {
+		data = append(data, APIRating{Slug: rating.String(), Name: rating.Display(), BookCount: bookCounts[rating.String()]})
+	}
+	ws.writeJSON(w, http.StatusOK, apiItem{Data: data})
+}
+
+func toAPIBook(b models.Book) APIBook {
+	book := APIBook{
+		ID:        b.ID,
+		Title:     b.MainTitle,
+		Subtitle:  b.SubTitle,
+		Authors:   []APIAuthorRef{},
+		Byline:    b.AuthorDisplayName(),
+		Rating:    b.Rating,
+		Format:    string(b.Format),
+		Review:    b.Review,
+		Tags:      []string{},
+		DateAdded: b.DateAdded,
+		UpdatedAt: b.UpdatedAt,
+	}
+	if b.PubDate != models.Missing && b.PubDate != 0 {
+		year := b.PubDate
+		book.PubYear = &year
+	}
+	for _, c := range b.OrderedCredits() {
+		book.Authors = append(book.Authors, APIAuthorRef{ID: c.AuthorID, Name: c.Author.FullName, Role: string(c.Role)})
+	}
+	for _, t := range b.SortedTags() {
+		book.Tags = append(book.Tags, t.Name)
+	}
+	if b.HasCover() {
+		source := models.CoverSourceOpenLibrary
+		if b.HasUploadedCover() {
+			source = models.CoverSourceUploaded
+		}
+		book.Cover = &APICover{
+			Source: source,
+			Small:  b.MakeCoverImageFilename("/saved_cover_images", models.SmallCover),
+			Medium: b.MakeCoverImageFilename("/saved_cover_images", models.MediumCover),
+			Large:  b.MakeCoverImageFilename("/saved_cover_images", models.LargeCover),
+		}
+	}
+	return book
+}
+
+func toAPIAuthor(a models.Author) APIAuthor {
+	return APIAuthor{
+		ID:        a.ID,
+		Name:      a.FullName,
+		Surname:   a.Surname,
+		BookCount: len(a.Books),
+	}
+}
+
+func toAPIAuthorDetail(a models.Author) APIAuthorDetail {
+	author := APIAuthorDetail{APIAuthor: toAPIAuthor(a), Books: []APIBookRef{}}
+	for _, b := range a.Books {
+		author.Books = append(author.Books, APIBookRef{ID: b.ID, Title: b.FormatTitle()})
+	}
+	return author
+}
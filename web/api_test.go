@@ -0,0 +1,408 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ccdavis/sfwr/models"
+)
+
+func apiRequest(t *testing.T, ws *WebServer, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rr := httptest.NewRecorder()
+	ws.apiHandler(rr, req)
+	return rr
+}
+
+func decodeAPIResponse(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Expected a JSON response, got %q: %s", ct, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rr.Body.String(), err)
+	}
+}
+
+func expectAPIError(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) JSONError {
+	t.Helper()
+	if rr.Code != status {
+		t.Fatalf("Expected status %d, got %d: %s", status, rr.Code, rr.Body.String())
+	}
+	var e JSONError
+	decodeAPIResponse(t, rr, &e)
+	if e.Status != status || e.Code != code || e.Message == "" {
+		t.Errorf("Expected a %d %s error envelope, got %+v", status, code, e)
+	}
+	return e
+}
+
+func TestAPIBookLifecycle(t *testing.T) {
+	ws := setupTestServer()
+	niven := models.Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := models.Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	ws.db.Create(&niven)
+	ws.db.Create(&pournelle)
+
+	rr := apiRequest(t, ws, "POST", "/api/v1/books", `{
+		"title": "The Mote in God's Eye",
+		"author_ids": [`+idString(pournelle.ID)+`, `+idString(niven.ID)+`],
+		"pub_year": 1974,
+		"rating": "Very-Good",
+		"tags": ["first contact", "Space Opera"]
+	}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct{ Data APIBook }
+	decodeAPIResponse(t, rr, &created)
+	book := created.Data
+	if rr.Header().Get("Location") != "/api/v1/books/"+idString(book.ID) {
+		t.Errorf("Unexpected Location %q", rr.Header().Get("Location"))
+	}
+	if book.Title != "The Mote in God's Eye" || book.PubYear == nil || *book.PubYear != 1974 || len(book.Authors) != 2 {
+		t.Errorf("Unexpected book: %+v", book)
+	}
+	if strings.Join(book.Tags, ",") != "Space Opera,first contact" {
+		t.Errorf("Unexpected tags %v", book.Tags)
+	}
+	if book.Byline != "Pournelle & Niven" || book.Authors[0].Role != "author" || book.Authors[1].Role != "co_author" {
+		t.Errorf("Expected the authors credited in order, got %q %+v", book.Byline, book.Authors)
+	}
+	var stored models.Book
+	ws.db.First(&stored, book.ID)
+	if stored.AuthorFullName != "Jerry Pournelle" {
+		t.Errorf("Expected the book filed under its first author, got %q", stored.AuthorFullName)
+	}
+
+	// PATCH only changes what it's given
+	rr = apiRequest(t, ws, "PATCH", "/api/v1/books/"+idString(book.ID), `{"rating": "Excellent", "pub_year": null}`)
+	var patched struct{ Data APIBook }
+	decodeAPIResponse(t, rr, &patched)
+	if rr.Code != http.StatusOK || patched.Data.Rating != "Excellent" || patched.Data.PubYear != nil || len(patched.Data.Tags) != 2 {
+		t.Errorf("Unexpected PATCH result %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = apiRequest(t, ws, "PATCH", "/api/v1/books/"+idString(book.ID), `{"credits": [{"author_id": `+idString(niven.ID)+`, "role": "editor"}]}`)
+	decodeAPIResponse(t, rr, &patched)
+	if rr.Code != http.StatusOK || patched.Data.Byline != "ed. Larry Niven" || len(patched.Data.Authors) != 1 {
+		t.Errorf("Unexpected credits PATCH result %d: %s", rr.Code, rr.Body.String())
+	}
+	body := `{"author_ids": [` + idString(niven.ID) + `], "credits": [{"author_id": ` + idString(niven.ID) + `, "role": "ghostwriter"}]}`
+	expectAPIError(t, apiRequest(t, ws, "PATCH", "/api/v1/books/"+idString(book.ID), body), http.StatusUnprocessableEntity, "unprocessable_entity")
+
+	// PUT replaces the whole book, clearing what's left out
+	rr = apiRequest(t, ws, "PUT", "/api/v1/books/"+idString(book.ID), `{"title": "Mote", "author_ids": [`+idString(niven.ID)+`], "rating": "Kindle"}`)
+	var replaced struct{ Data APIBook }
+	decodeAPIResponse(t, rr, &replaced)
+	if rr.Code != http.StatusOK || replaced.Data.Title != "Mote" || len(replaced.Data.Tags) != 0 || len(replaced.Data.Authors) != 1 {
+		t.Errorf("Unexpected PUT result %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = apiRequest(t, ws, "GET", "/api/v1/books/"+idString(book.ID), "")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 getting the book, got %d", rr.Code)
+	}
+
+	rr = apiRequest(t, ws, "DELETE", "/api/v1/books/"+idString(book.ID), "")
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting the book, got %d", rr.Code)
+	}
+	expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/books/"+idString(book.ID), ""), http.StatusNotFound, "not_found")
+	expectAPIError(t, apiRequest(t, ws, "DELETE", "/api/v1/books/"+idString(book.ID), ""), http.StatusNotFound, "not_found")
+}
+
+func idString(id uint) string {
+	return fmt.Sprint(id)
+}
+
+func TestAPIListBooks(t *testing.T) {
+	ws := setupTestServer()
+	niven := models.Author{FullName: "Larry Niven", Surname: "Niven"}
+	leGuin := models.Author{FullName: "Ursula K. Le Guin", Surname: "Le Guin"}
+	ws.db.Create(&niven)
+	ws.db.Create(&leGuin)
+	for _, b := range []struct {
+		title  string
+		year   int64
+		rating string
+		author models.Author
+		tags   []string
+	}{
+		{"Ringworld", 1970, "Excellent", niven, []string{"Big Dumb Object"}},
+		{"Protector", 1973, "Very-Good", niven, nil},
+		{"The Dispossessed", 1974, "Excellent", leGuin, []string{"anarchy"}},
+		{"The Lathe of Heaven", 1971, "Excellent", leGuin, nil},
+		{"Lost Manuscript", models.Missing, "Kindle", leGuin, nil},
+		{"The Integral Trees", 1984, "Interesting", niven, nil},
+	} {
+		book := models.Book{MainTitle: b.title, PubDate: b.year, Rating: b.rating, AuthorFullName: b.author.FullName, AuthorSurname: b.author.Surname}
+		ws.db.Create(&book)
+		ws.db.Model(&book).Association("Authors").Append(&b.author)
+		models.SetBookTags(ws.db, &book, b.tags)
+	}
+
+	titles := func(query string) ([]string, APIPage) {
+		t.Helper()
+		rr := apiRequest(t, ws, "GET", "/api/v1/books"+query, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var list struct {
+			Data []APIBook
+			Meta APIPage
+		}
+		decodeAPIResponse(t, rr, &list)
+		var found []string
+		for _, b := range list.Data {
+			found = append(found, b.Title)
+		}
+		return found, list.Meta
+	}
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"?sort=title", "Lost Manuscript, Protector, Ringworld, The Dispossessed, The Integral Trees, The Lathe of Heaven"},
+		{"?sort=-year&decade=1970s", "The Dispossessed, Protector, The Lathe of Heaven, Ringworld"},
+		{"?rating=Excellent&sort=title", "Ringworld, The Dispossessed, The Lathe of Heaven"},
+		{"?rating=Excellent&author=" + idString(leGuin.ID) + "&sort=year", "The Lathe of Heaven, The Dispossessed"},
+		{"?tag=big+dumb+object", "Ringworld"},
+		{"?decade=Unknown", "Lost Manuscript"},
+		{"?sort=title&per_page=2&page=2", "Ringworld, The Dispossessed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got, _ := titles(tt.query); strings.Join(got, ", ") != tt.expected {
+				t.Errorf("Got %v, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	// Tagged books come back as themselves, not with the IDs of the joined tables
+	rr := apiRequest(t, ws, "GET", "/api/v1/books?tag=anarchy", "")
+	var tagged struct{ Data []APIBook }
+	decodeAPIResponse(t, rr, &tagged)
+	var dispossessed models.Book
+	ws.db.Where("main_title = ?", "The Dispossessed").First(&dispossessed)
+	if len(tagged.Data) != 1 || tagged.Data[0].ID != dispossessed.ID || len(tagged.Data[0].Authors) != 1 {
+		t.Errorf("Unexpected tagged book: %+v", tagged.Data)
+	}
+
+	_, meta := titles("?per_page=4&page=2")
+	if meta != (APIPage{Page: 2, PerPage: 4, Total: 6, TotalPages: 2}) {
+		t.Errorf("Unexpected page meta %+v", meta)
+	}
+	if got, meta := titles("?page=9"); len(got) != 0 || meta.Total != 6 {
+		t.Errorf("Expected an empty page past the end, got %v %+v", got, meta)
+	}
+
+	e := expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/books?rating=Meh&decade=1975&sort=colour&per_page=1000", ""), http.StatusBadRequest, "bad_request")
+	var fields []string
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field)
+	}
+	if strings.Join(fields, ",") != "per_page,rating,decade,sort" {
+		t.Errorf("Expected every bad parameter reported, got %+v", e.Fields)
+	}
+}
+
+func TestAPIRejectsBadRequests(t *testing.T) {
+	ws := setupTestServer()
+	author := models.Author{FullName: "Iain M. Banks", Surname: "Banks"}
+	ws.db.Create(&author)
+
+	e := expectAPIError(t, apiRequest(t, ws, "POST", "/api/v1/books", `{"title": " ", "author_ids": [999], "rating": "Meh"}`),
+		http.StatusUnprocessableEntity, "unprocessable_entity")
+	if len(e.Fields) != 3 {
+		t.Errorf("Expected the title, rating and author reported, got %+v", e.Fields)
+	}
+	e = expectAPIError(t, apiRequest(t, ws, "POST", "/api/v1/books", `{"subtitle": "A Culture Novel"}`),
+		http.StatusUnprocessableEntity, "unprocessable_entity")
+	if len(e.Fields) != 3 {
+		t.Errorf("Expected the missing title, rating and authors reported, got %+v", e.Fields)
+	}
+
+	expectAPIError(t, apiRequest(t, ws, "POST", "/api/v1/books", `{"title": "Excession", "ratng": "Excellent"}`), http.StatusBadRequest, "bad_request")
+	expectAPIError(t, apiRequest(t, ws, "POST", "/api/v1/books", `not json`), http.StatusBadRequest, "bad_request")
+	expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/books/abc", ""), http.StatusBadRequest, "bad_request")
+	expectAPIError(t, apiRequest(t, ws, "PATCH", "/api/v1/books/42", `{"rating": "Excellent"}`), http.StatusNotFound, "not_found")
+	expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/publishers", ""), http.StatusNotFound, "not_found")
+	expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/ratings/1", ""), http.StatusNotFound, "not_found")
+
+	rr := apiRequest(t, ws, "DELETE", "/api/v1/books", "")
+	expectAPIError(t, rr, http.StatusMethodNotAllowed, "method_not_allowed")
+	if rr.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("Expected the allowed methods listed, got %q", rr.Header().Get("Allow"))
+	}
+
+	var count int64
+	ws.db.Model(&models.Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no books created by bad requests, got %d", count)
+	}
+}
+
+func TestAPIAuthors(t *testing.T) {
+	ws := setupTestServer()
+
+	rr := apiRequest(t, ws, "POST", "/api/v1/authors", `{"name": "C J Cherryh"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct{ Data APIAuthorDetail }
+	decodeAPIResponse(t, rr, &created)
+	if created.Data.Surname != "Cherryh" || created.Data.Books == nil {
+		t.Errorf("Unexpected author %+v", created.Data)
+	}
+	id := idString(created.Data.ID)
+
+	rr = apiRequest(t, ws, "POST", "/api/v1/books", `{"title": "Downbelow Station", "author_ids": [`+id+`], "rating": "Excellent"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = apiRequest(t, ws, "PATCH", "/api/v1/authors/"+id, `{"name": "C. J. Cherryh"}`)
+	var renamed struct{ Data APIAuthorDetail }
+	decodeAPIResponse(t, rr, &renamed)
+	if renamed.Data.Name != "C. J. Cherryh" || len(renamed.Data.Books) != 1 || renamed.Data.Books[0].Title != "Downbelow Station" {
+		t.Errorf("Unexpected renamed author %+v", renamed.Data)
+	}
+	var book models.Book
+	ws.db.First(&book)
+	if book.AuthorFullName != "C. J. Cherryh" {
+		t.Errorf("Expected the book to follow the rename, got %q", book.AuthorFullName)
+	}
+
+	rr = apiRequest(t, ws, "GET", "/api/v1/authors", "")
+	var list struct {
+		Data []APIAuthor
+		Meta APIPage
+	}
+	decodeAPIResponse(t, rr, &list)
+	if len(list.Data) != 1 || list.Data[0].BookCount != 1 || list.Meta.Total != 1 {
+		t.Errorf("Unexpected author list %s", rr.Body.String())
+	}
+
+	expectAPIError(t, apiRequest(t, ws, "DELETE", "/api/v1/authors/"+id, ""), http.StatusConflict, "conflict")
+	ws.db.Delete(&book)
+	if rr := apiRequest(t, ws, "DELETE", "/api/v1/authors/"+id, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting an author without books, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAPIRatings(t *testing.T) {
+	ws := setupTestServer()
+	ws.db.Create(&models.Book{MainTitle: "Hyperion", Rating: "Excellent"})
+	ws.db.Create(&models.Book{MainTitle: "Ilium", Rating: "Excellent"})
+	ws.db.Create(&models.Book{MainTitle: "Olympos", Rating: "Not-Good"})
+
+	rr := apiRequest(t, ws, "GET", "/api/v1/ratings", "")
+	var ratings struct{ Data []APIRating }
+	decodeAPIResponse(t, rr, &ratings)
+	if len(ratings.Data) != len(models.Ratings()) {
+		t.Fatalf("Expected every rating, got %+v", ratings.Data)
+	}
+	if ratings.Data[0] != (APIRating{Slug: "Excellent", Name: "Excellent", BookCount: 2}) {
+		t.Errorf("Expected Excellent first with 2 books, got %+v", ratings.Data[0])
+	}
+	if last := ratings.Data[len(ratings.Data)-1]; last != (APIRating{Slug: "Not-Good", Name: "Not Good", BookCount: 1}) {
+		t.Errorf("Expected Not Good last with 1 book, got %+v", last)
+	}
+}
+
+func TestAPIBookFormats(t *testing.T) {
+	ws := setupTestServer()
+	author := models.Author{FullName: "Hugh Howey", Surname: "Howey"}
+	ws.db.Create(&author)
+	ws.db.Create(&models.Book{MainTitle: "Dune", Rating: "Excellent", Format: models.Hardcover})
+
+	rr := apiRequest(t, ws, "POST", "/api/v1/books", `{"title": "Wool", "author_ids": [`+idString(author.ID)+`], "rating": "Very-Good", "format": "self_published"}`)
+	var created struct{ Data APIBook }
+	decodeAPIResponse(t, rr, &created)
+	if created.Data.Format != "self_published" || created.Data.Rating != "Very-Good" {
+		t.Errorf("Expected the format apart from the rating, got %+v", created.Data)
+	}
+
+	rr = apiRequest(t, ws, "GET", "/api/v1/books?format=self_published", "")
+	var list struct{ Data []APIBook }
+	decodeAPIResponse(t, rr, &list)
+	if len(list.Data) != 1 || list.Data[0].Title != "Wool" {
+		t.Errorf("Expected only Wool, got %+v", list.Data)
+	}
+
+	expectAPIError(t, apiRequest(t, ws, "GET", "/api/v1/books?format=scroll", ""), http.StatusBadRequest, "bad_request")
+	expectAPIError(t, apiRequest(t, ws, "PATCH", "/api/v1/books/"+idString(created.Data.ID), `{"format": "scroll"}`),
+		http.StatusUnprocessableEntity, "unprocessable_entity")
+	rr = apiRequest(t, ws, "PATCH", "/api/v1/books/"+idString(created.Data.ID), `{"format": ""}`)
+	decodeAPIResponse(t, rr, &created)
+	if created.Data.Format != "" {
+		t.Errorf("Expected the format cleared, got %q", created.Data.Format)
+	}
+}
+
+// Every operation the document describes has to be one the API answers.
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	ws := setupTestServer()
+	rr := apiRequest(t, ws, "GET", "/api/v1/openapi.json", "")
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Security   []map[string][]string                 `json:"security"`
+		Components struct {
+			SecuritySchemes map[string]json.RawMessage `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	decodeAPIResponse(t, rr, &doc)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+	for _, requirement := range doc.Security {
+		for scheme := range requirement {
+			if _, ok := doc.Components.SecuritySchemes[scheme]; !ok {
+				t.Errorf("The %s security scheme isn't described", scheme)
+			}
+		}
+	}
+	if _, ok := doc.Components.SecuritySchemes["bearerToken"]; !ok || len(doc.Security) == 0 {
+		t.Error("Expected API tokens to be documented as bearer tokens")
+	}
+
+	for _, path := range []string{"/books", "/books/{id}", "/authors", "/authors/{id}", "/ratings", "/openapi.json"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("%s isn't documented", path)
+		}
+	}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			var operation struct {
+				Responses map[string]json.RawMessage `json:"responses"`
+			}
+			if err := json.Unmarshal(operations[method], &operation); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := operation.Responses["401"]; !ok {
+				t.Errorf("%s %s doesn't document 401", method, path)
+			}
+			if _, ok := operation.Responses["403"]; !ok && method != "get" {
+				t.Errorf("%s %s doesn't document 403", method, path)
+			}
+			rr := apiRequest(t, ws, strings.ToUpper(method), "/api/v1"+strings.ReplaceAll(path, "{id}", "1"), "{}")
+			if rr.Code == http.StatusMethodNotAllowed || strings.Contains(rr.Body.String(), "No such API endpoint") {
+				t.Errorf("%s %s is documented but not served: %d %s", method, path, rr.Code, rr.Body.String())
+			}
+		}
+	}
+}
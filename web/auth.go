@@ -0,0 +1,503 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ccdavis/sfwr/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// What someone signed in can do. Read-only users can look at everything but change nothing.
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleReadOnly Role = "readonly"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleAdmin, RoleReadOnly:
+		return Role(s), nil
+	}
+	return "", fmt.Errorf("unknown role %q, use %s or %s", s, RoleAdmin, RoleReadOnly)
+}
+
+const (
+	sessionCookie = "sfwr_session"
+	csrfField     = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+
+	MinPasswordLength = 8
+	// Wrong passwords from one address before it has to wait, and how long it waits
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+	// Enough for a cover upload, the largest thing any form sends
+	maxFormSize = models.MaxCoverUploadSize + 1<<20
+)
+
+// The hashed passwords and API tokens, kept in their own file so they never go out with the site.
+// Passwords are bcrypt hashes. Tokens are long and random, so a SHA-256 hash is enough and keeps
+// checking them fast.
+type Credentials struct {
+	AdminPassword    string `toml:"admin_password"`
+	ReadOnlyPassword string `toml:"readonly_password"`
+	AdminToken       string `toml:"admin_token"`
+	ReadOnlyToken    string `toml:"readonly_token"`
+}
+
+// A missing file means no credentials have been set yet.
+func LoadCredentials(file string) (Credentials, error) {
+	var creds Credentials
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		return creds, nil
+	}
+	meta, err := toml.DecodeFile(file, &creds)
+	if err != nil {
+		return creds, fmt.Errorf("can't read credentials %s: %w", file, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return creds, fmt.Errorf("unknown settings in credentials %s: %v", file, undecoded)
+	}
+	return creds, nil
+}
+
+// Only the owner can read the file.
+func (c Credentials) Save(file string) error {
+	var data bytes.Buffer
+	data.WriteString("# Written by sfwr -set-password and -new-api-token. Keep it out of git.\n")
+	if err := toml.NewEncoder(&data).Encode(c); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data.Bytes(), 0600)
+}
+
+func (c Credentials) HasAdminPassword() bool {
+	return c.AdminPassword != ""
+}
+
+func (c *Credentials) SetPassword(role Role, password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("the password needs at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if role == RoleAdmin {
+		c.AdminPassword = string(hash)
+	} else {
+		c.ReadOnlyPassword = string(hash)
+	}
+	return nil
+}
+
+// Makes a new token for the role, replacing any it had. Only its hash is kept, so it's shown once.
+func (c *Credentials) NewAPIToken(role Role) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	if role == RoleAdmin {
+		c.AdminToken = hashToken(token)
+	} else {
+		c.ReadOnlyToken = hashToken(token)
+	}
+	return token, nil
+}
+
+// The admin password is tried first, so it still works if both passwords are the same.
+func (c Credentials) roleForPassword(password string) (Role, bool) {
+	if c.AdminPassword != "" && bcrypt.CompareHashAndPassword([]byte(c.AdminPassword), []byte(password)) == nil {
+		return RoleAdmin, true
+	}
+	if c.ReadOnlyPassword != "" && bcrypt.CompareHashAndPassword([]byte(c.ReadOnlyPassword), []byte(password)) == nil {
+		return RoleReadOnly, true
+	}
+	return "", false
+}
+
+func (c Credentials) roleForToken(token string) (Role, bool) {
+	hash := []byte(hashToken(token))
+	if c.AdminToken != "" && subtle.ConstantTimeCompare(hash, []byte(c.AdminToken)) == 1 {
+		return RoleAdmin, true
+	}
+	if c.ReadOnlyToken != "" && subtle.ConstantTimeCompare(hash, []byte(c.ReadOnlyToken)) == 1 {
+		return RoleReadOnly, true
+	}
+	return "", false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// A signed in browser. Each has its own CSRF token, which every form that changes anything has to send back.
+type Session struct {
+	Role      Role
+	CSRFToken string
+	SignedIn  bool // False for the automatic sessions given out when there's no admin password
+	expires   time.Time
+}
+
+func (s *Session) CanModify() bool {
+	return s.Role == RoleAdmin
+}
+
+// Sessions only live in memory, so restarting the server signs everyone out. Only signed in
+// sessions are kept; the automatic ones given out without an admin password are worked out from
+// their ID, so browsers and scripts that never send the cookie back don't fill up memory.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	lifetime time.Duration
+	key      []byte // Signs the CSRF tokens of automatic sessions
+}
+
+func newSessionStore(lifetime time.Duration) *sessionStore {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("Can't make a session key: %v", err))
+	}
+	return &sessionStore{sessions: make(map[string]*Session), lifetime: lifetime, key: key}
+}
+
+// Starts a signed in session, dropping any that have expired.
+func (s *sessionStore) create(role Role) (string, *Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &Session{Role: role, CSRFToken: csrf, SignedIn: true, expires: now.Add(s.lifetime)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for oldId, old := range s.sessions {
+		if now.After(old.expires) {
+			delete(s.sessions, oldId)
+		}
+	}
+	s.sessions[id] = session
+	return id, session, nil
+}
+
+// The admin session for an ID given out without an admin password. Its CSRF token is
+// a signature of the ID, which other sites can't forge without reading the cookie.
+func (s *sessionStore) automatic(id string) *Session {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(id))
+	return &Session{Role: RoleAdmin, CSRFToken: base64.RawURLEncoding.EncodeToString(mac.Sum(nil))}
+}
+
+func (s *sessionStore) get(id string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.expires) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return session
+}
+
+func (s *sessionStore) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// Counts wrong passwords by address, so passwords can't be guessed at the speed of the network.
+// After maxLoginFailures the address is locked out until loginLockout has passed since its last
+// failure; signing in successfully starts the count again.
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{failures: make(map[string]*loginFailures)}
+}
+
+// How much longer the address has to wait, 0 if it can try now.
+func (l *loginLimiter) lockedOut(address string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[address]
+	if !ok || f.count < maxLoginFailures {
+		return 0
+	}
+	return max(0, loginLockout-time.Since(f.last))
+}
+
+func (l *loginLimiter) failed(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	// Addresses that stopped trying are forgotten, so the map only holds recent ones
+	for other, f := range l.failures {
+		if now.Sub(f.last) > loginLockout {
+			delete(l.failures, other)
+		}
+	}
+	f, ok := l.failures[address]
+	if !ok {
+		f = &loginFailures{}
+		l.failures[address] = f
+	}
+	f.count++
+	f.last = now
+}
+
+func (l *loginLimiter) succeeded(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, address)
+}
+
+// The address the request came from, without its port. Proxy headers aren't trusted, since
+// anyone can send them.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type sessionKey struct{}
+
+// The session of a request that came through the auth layer, nil for tests calling handlers directly
+// and requests using an API token.
+func sessionFrom(r *http.Request) *Session {
+	session, _ := r.Context().Value(sessionKey{}).(*Session)
+	return session
+}
+
+// Until an admin password is set, anyone who can reach the server is an admin, which is why it
+// then only listens on localhost.
+func (ws *WebServer) authRequired() bool {
+	return ws.credentials.HasAdminPassword()
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix)
+}
+
+// Decides who's asking before any handler runs. The API also takes an API token as a bearer token,
+// which needs no CSRF token since browsers never send it on their own.
+func (ws *WebServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && isAPIRequest(r) {
+			role, ok := ws.credentials.roleForToken(bearer)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				ws.writeJSONError(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			if !isSafeMethod(r.Method) && role != RoleAdmin {
+				ws.writeJSONError(w, "This API token is read-only", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := ws.currentSession(w, r)
+		if session == nil {
+			ws.requireLogin(w, r)
+			return
+		}
+
+		if !isSafeMethod(r.Method) {
+			if !session.CanModify() {
+				ws.forbid(w, r, "You're signed in read-only, so you can't change anything")
+				return
+			}
+			if !ws.validCSRFToken(w, r, session) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	})
+}
+
+// Without an admin password every browser is given an admin session without signing in.
+func (ws *WebServer) currentSession(w http.ResponseWriter, r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err == nil {
+		if session := ws.sessions.get(cookie.Value); session != nil {
+			return session
+		}
+	}
+	if ws.authRequired() {
+		return nil
+	}
+	if err == nil && cookie.Value != "" {
+		return ws.sessions.automatic(cookie.Value)
+	}
+	id, err := randomToken()
+	if err != nil {
+		return nil
+	}
+	ws.setSessionCookie(w, r, id)
+	return ws.sessions.automatic(id)
+}
+
+func (ws *WebServer) setSessionCookie(w http.ResponseWriter, r *http.Request, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ws.sessions.lifetime.Seconds()),
+	})
+}
+
+func (ws *WebServer) requireLogin(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ws.writeJSONError(w, "Sign in or send an API token", http.StatusUnauthorized)
+		return
+	}
+	next := ""
+	if r.Method == http.MethodGet {
+		next = "?next=" + url.QueryEscape(r.URL.RequestURI())
+	}
+	http.Redirect(w, r, "/login"+next, http.StatusSeeOther)
+}
+
+func (ws *WebServer) forbid(w http.ResponseWriter, r *http.Request, message string) {
+	if isAPIRequest(r) || strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		ws.writeJSONError(w, message, http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusForbidden)
+	ws.renderError(w, r, "Not allowed", errors.New(message))
+}
+
+// Forms send the token as a field and scripts as a header.
+func (ws *WebServer) validCSRFToken(w http.ResponseWriter, r *http.Request, session *Session) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+		if err := r.ParseMultipartForm(maxFormSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "The form is too large", http.StatusRequestEntityTooLarge)
+				return false
+			}
+		}
+		token = r.PostFormValue(csrfField)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+		ws.forbid(w, r, "The form has expired or didn't come from this site. Reload the page and try again")
+		return false
+	}
+	return true
+}
+
+func (ws *WebServer) loginHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	// Only ever back to a page on this site
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+	if !ws.authRequired() {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	data := PageData{Title: "Sign In", Next: next}
+	if r.Method != http.MethodPost {
+		ws.renderTemplate(w, r, "login", data)
+		return
+	}
+
+	address := clientAddress(r)
+	if wait := ws.logins.lockedOut(address); wait > 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data.Error = fmt.Sprintf("Too many wrong passwords. Try again in %d minutes", int(wait.Minutes())+1)
+		ws.renderTemplate(w, r, "login", data)
+		return
+	}
+	role, ok := ws.credentials.roleForPassword(r.PostFormValue("password"))
+	if !ok {
+		ws.logins.failed(address)
+		w.WriteHeader(http.StatusUnauthorized)
+		data.Error = "Wrong password"
+		ws.renderTemplate(w, r, "login", data)
+		return
+	}
+	ws.logins.succeeded(address)
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		ws.sessions.delete(cookie.Value)
+	}
+	id, _, err := ws.sessions.create(role)
+	if err != nil {
+		ws.renderError(w, r, "Failed to sign in", err)
+		return
+	}
+	ws.setSessionCookie(w, r, id)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (ws *WebServer) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		ws.sessions.delete(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
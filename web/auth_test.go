@@ -0,0 +1,391 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccdavis/sfwr/config"
+	"github.com/ccdavis/sfwr/models"
+)
+
+func setupAuthTestServer(t *testing.T, creds Credentials) *WebServer {
+	cfg := config.Default()
+	cfg.Paths.TemplateDir = "../templates"
+	cfg.Paths.SavedImagesDir = t.TempDir()
+	ws := NewConfiguredWebServer(setupTestDB(), cfg, nil)
+	ws.SetCredentials(creds)
+	return ws
+}
+
+// Sends requests through the whole server, keeping its session cookie like a browser would.
+type testBrowser struct {
+	t       *testing.T
+	handler http.Handler
+	ws      *WebServer
+	session *http.Cookie
+}
+
+func newTestBrowser(t *testing.T, ws *WebServer) *testBrowser {
+	return &testBrowser{t: t, handler: ws.Handler(), ws: ws}
+}
+
+func (b *testBrowser) do(method string, path string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	b.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if b.session != nil {
+		req.AddCookie(b.session)
+	}
+	rr := httptest.NewRecorder()
+	b.handler.ServeHTTP(rr, req)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			b.session = cookie
+			if cookie.MaxAge < 0 {
+				b.session = nil
+			}
+		}
+	}
+	return rr
+}
+
+func (b *testBrowser) csrfToken() string {
+	b.t.Helper()
+	if b.session == nil {
+		b.t.Fatal("No session")
+	}
+	session := b.ws.sessions.get(b.session.Value)
+	if session == nil && !b.ws.authRequired() {
+		session = b.ws.sessions.automatic(b.session.Value)
+	}
+	if session == nil {
+		b.t.Fatal("Session has expired")
+	}
+	return session.CSRFToken
+}
+
+func (b *testBrowser) signIn(password string) *httptest.ResponseRecorder {
+	b.t.Helper()
+	return b.do("POST", "/login", url.Values{"password": {password}, "next": {"/books"}}, nil)
+}
+
+func testCredentials(t *testing.T) Credentials {
+	var creds Credentials
+	if err := creds.SetPassword(RoleAdmin, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := creds.SetPassword(RoleReadOnly, "battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	return creds
+}
+
+func TestOpenServerStillChecksCSRFTokens(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	browser := newTestBrowser(t, ws)
+
+	rr := browser.do("GET", "/authors/new", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the form without signing in, got %d", rr.Code)
+	}
+	token := browser.csrfToken()
+	if !strings.Contains(rr.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Error("Expected the form to carry the session's CSRF token")
+	}
+	if strings.Contains(rr.Body.String(), "Sign Out") {
+		t.Error("Expected no way to sign out when nobody signed in")
+	}
+
+	rr = browser.do("POST", "/authors/create", url.Values{"full_name": {"Forged Author"}}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a form without a token to be refused, got %d", rr.Code)
+	}
+	rr = browser.do("POST", "/authors/create", url.Values{"full_name": {"Forged Author"}, "csrf_token": {"guess"}}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a form with the wrong token to be refused, got %d", rr.Code)
+	}
+	// Another site posting from the same browser can't read the token
+	stranger := newTestBrowser(t, ws)
+	stranger.handler = browser.handler
+	rr = stranger.do("POST", "/authors/create", url.Values{"full_name": {"Forged Author"}, "csrf_token": {token}}, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected another session's token to be refused, got %d", rr.Code)
+	}
+	var count int64
+	ws.db.Model(&models.Author{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("Expected no authors created by forged forms, got %d", count)
+	}
+
+	rr = browser.do("POST", "/authors/create", url.Values{"full_name": {"Real Author"}, "csrf_token": {token}}, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected the form with its token to work, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Scripts on the page send it as a header instead
+	rr = browser.do("POST", "/books/search-openlibrary", nil, http.Header{"Content-Type": {"application/json"}})
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), `"error"`) {
+		t.Errorf("Expected a JSON refusal without the header, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = browser.do("POST", "/books/search-openlibrary", nil, http.Header{"Content-Type": {"application/json"}, csrfHeader: {token}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the request to reach the handler with the header, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+// Browsers and scripts that never send the cookie back mustn't leave a session behind each time.
+func TestOpenServerKeepsNoSessions(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	for range 10 {
+		if rr := newTestBrowser(t, ws).do("GET", "/", nil, nil); rr.Code != http.StatusOK {
+			t.Fatalf("Expected the home page, got %d", rr.Code)
+		}
+	}
+	if len(ws.sessions.sessions) != 0 {
+		t.Errorf("Expected no sessions kept for an open server, got %d", len(ws.sessions.sessions))
+	}
+}
+
+func TestExpiredSessionsAreDropped(t *testing.T) {
+	store := newSessionStore(time.Hour)
+	old, _, err := store.create(RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.sessions[old].expires = time.Now().Add(-time.Minute)
+	if _, _, err := store.create(RoleReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.sessions[old]; ok || len(store.sessions) != 1 {
+		t.Errorf("Expected the expired session to be dropped, %d sessions left", len(store.sessions))
+	}
+}
+
+func TestSignInRolesAndSignOut(t *testing.T) {
+	ws := setupAuthTestServer(t, testCredentials(t))
+	book := models.Book{MainTitle: "Ringworld", Rating: "Excellent"}
+	ws.db.Create(&book)
+	deletePath := "/books/delete/" + idString(book.ID)
+	browser := newTestBrowser(t, ws)
+
+	rr := browser.do("GET", "/books?sort=title", nil, nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/login?next=%2Fbooks%3Fsort%3Dtitle" {
+		t.Errorf("Expected a redirect to sign in, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	for _, path := range []string{"/saved_cover_images/1-M.jpg", "/preview-site/index.html"} {
+		if rr := browser.do("GET", path, nil, nil); rr.Code != http.StatusSeeOther {
+			t.Errorf("Expected %s to need signing in, got %d", path, rr.Code)
+		}
+	}
+	if rr := browser.do("POST", deletePath, url.Values{}, nil); rr.Code != http.StatusSeeOther || browser.session != nil {
+		t.Errorf("Expected deleting without signing in to be refused, got %d", rr.Code)
+	}
+
+	if rr := browser.do("GET", "/login", nil, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `type="password"`) {
+		t.Errorf("Expected the sign in page, got %d", rr.Code)
+	}
+	if rr := browser.signIn("wrong password"); rr.Code != http.StatusUnauthorized || browser.session != nil {
+		t.Errorf("Expected a wrong password to be refused, got %d", rr.Code)
+	}
+
+	// Read-only users can look but not touch
+	rr = browser.signIn("battery staple")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/books" || browser.session == nil {
+		t.Fatalf("Expected to be signed in read-only, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := browser.do("GET", "/books", nil, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected read-only users to browse, got %d", rr.Code)
+	}
+	if rr := browser.do("GET", "/", nil, nil); !strings.Contains(rr.Body.String(), "Sign Out (read-only)") {
+		t.Error("Expected the page to show a read-only user how to sign out")
+	}
+	if rr := browser.do("POST", deletePath, url.Values{"csrf_token": {browser.csrfToken()}}, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected read-only users not to delete books, got %d", rr.Code)
+	}
+	var count int64
+	ws.db.Model(&models.Book{}).Count(&count)
+	if count != 1 {
+		t.Fatal("Expected the book to survive a read-only user")
+	}
+
+	// Signing in again as the admin replaces the read-only session
+	oldSession := browser.session.Value
+	if rr := browser.signIn("correct horse"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected to be signed in as the admin, got %d", rr.Code)
+	}
+	if ws.sessions.get(oldSession) != nil {
+		t.Error("Expected the old session to end")
+	}
+	if rr := browser.do("POST", deletePath, url.Values{"csrf_token": {browser.csrfToken()}}, nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected the admin to delete the book, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	session := browser.session.Value
+	if rr := browser.do("POST", "/logout", url.Values{"csrf_token": {browser.csrfToken()}}, nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected to sign out, got %d", rr.Code)
+	}
+	if ws.sessions.get(session) != nil {
+		t.Error("Expected signing out to end the session")
+	}
+	browser.session = &http.Cookie{Name: sessionCookie, Value: session}
+	if rr := browser.do("GET", "/books", nil, nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected the old cookie not to work after signing out, got %d", rr.Code)
+	}
+}
+
+func TestSignInOnlyRedirectsWithinTheSite(t *testing.T) {
+	ws := setupAuthTestServer(t, testCredentials(t))
+	for _, next := range []string{"https://example.com/", "//example.com/", "/\\example.com"} {
+		browser := newTestBrowser(t, ws)
+		rr := browser.do("POST", "/login", url.Values{"password": {"correct horse"}, "next": {next}}, nil)
+		if rr.Header().Get("Location") != "/" {
+			t.Errorf("Expected %s to be replaced with /, got %s", next, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestWrongPasswordsLockOutTheAddress(t *testing.T) {
+	ws := setupAuthTestServer(t, testCredentials(t))
+	browser := newTestBrowser(t, ws)
+	for range maxLoginFailures {
+		if rr := browser.signIn("wrong password"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected a wrong password to be refused, got %d", rr.Code)
+		}
+	}
+	rr := browser.signIn("correct horse")
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "Try again in 15 minutes") {
+		t.Fatalf("Expected even the right password to wait, got %d", rr.Code)
+	}
+	if browser.session != nil {
+		t.Error("Expected no session while locked out")
+	}
+
+	// Other addresses can still sign in
+	other := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"password": {"correct horse"}}.Encode()))
+	other.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	other.RemoteAddr = "198.51.100.7:4321"
+	rr = httptest.NewRecorder()
+	ws.Handler().ServeHTTP(rr, other)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected another address to sign in, got %d", rr.Code)
+	}
+
+	// Once the lockout has passed, the right password works and the count starts again
+	ws.logins.failures["192.0.2.1"].last = time.Now().Add(-loginLockout)
+	if rr := browser.signIn("correct horse"); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected to sign in after the lockout, got %d", rr.Code)
+	}
+	if _, ok := ws.logins.failures["192.0.2.1"]; ok {
+		t.Error("Expected signing in to clear the failures")
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	creds := testCredentials(t)
+	adminToken, err := creds.NewAPIToken(RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnlyToken, err := creds.NewAPIToken(RoleReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := setupAuthTestServer(t, creds)
+	author := models.Author{FullName: "Vernor Vinge", Surname: "Vinge"}
+	ws.db.Create(&author)
+	body := `{"title": "A Fire Upon the Deep", "author_ids": [` + idString(author.ID) + `], "rating": "Excellent"}`
+
+	request := func(method string, token string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1/books", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		ws.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+
+	expectAPIError(t, request("GET", "", nil), http.StatusUnauthorized, "unauthorized")
+	expectAPIError(t, request("GET", "not-a-token", nil), http.StatusUnauthorized, "unauthorized")
+	if rr := request("GET", readOnlyToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the read-only token to list books, got %d", rr.Code)
+	}
+	expectAPIError(t, request("POST", readOnlyToken, nil), http.StatusForbidden, "forbidden")
+	// Bearer tokens aren't sent by browsers on their own, so they don't need a CSRF token
+	if rr := request("POST", adminToken, nil); rr.Code != http.StatusCreated {
+		t.Errorf("Expected the admin token to add a book, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Signed in browsers use the API like the forms, with a CSRF token
+	browser := newTestBrowser(t, ws)
+	browser.signIn("correct horse")
+	rr := browser.do("POST", "/api/v1/authors", nil, http.Header{"Content-Type": {"application/json"}})
+	expectAPIError(t, rr, http.StatusForbidden, "forbidden")
+}
+
+func TestCredentialsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".sfwr_credentials.toml")
+
+	creds, err := LoadCredentials(file)
+	if err != nil || creds.HasAdminPassword() {
+		t.Fatalf("Expected no credentials before the file exists, got %+v %v", creds, err)
+	}
+	if err := creds.SetPassword(RoleAdmin, "short"); err == nil {
+		t.Error("Expected a short password to be refused")
+	}
+	if err := creds.SetPassword(RoleAdmin, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	token, err := creds.NewAPIToken(RoleReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(creds.AdminPassword, "correct horse") || strings.Contains(creds.ReadOnlyToken, token) {
+		t.Error("Expected only hashes to be kept")
+	}
+	if err := creds.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected only the owner to read the credentials, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := LoadCredentials(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, ok := loaded.roleForPassword("correct horse"); !ok || role != RoleAdmin {
+		t.Errorf("Expected the saved admin password to work, got %v %v", role, ok)
+	}
+	if role, ok := loaded.roleForToken(token); !ok || role != RoleReadOnly {
+		t.Errorf("Expected the saved token to work, got %v %v", role, ok)
+	}
+	if _, ok := loaded.roleForPassword("battery staple"); ok {
+		t.Error("Expected an unknown password not to work")
+	}
+
+	os.WriteFile(file, []byte("admin_pasword = \"x\"\n"), 0600)
+	if _, err := LoadCredentials(file); err == nil {
+		t.Error("Expected a misspelled setting to be an error")
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Error("Expected an unknown role to be an error")
+	}
+}
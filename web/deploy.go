@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 
@@ -18,15 +19,19 @@ func (ws *WebServer) deployToGitHub() (string, error) {
 		return "", fmt.Errorf("not in a git repository: %v", err)
 	}
 
+	database := ws.settings().Paths.Database
+	savedImagesDir := ws.settings().Paths.SavedImagesDir
+
 	// Stage database file
-	cmd = exec.Command("git", "add", "sfwr_database.db")
+	cmd = exec.Command("git", "add", database)
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("failed to stage database: %v", err)
 	}
 
-	// Stage cover images directory
-	if _, err := os.Stat("saved_cover_images"); err == nil {
-		cmd = exec.Command("git", "add", "saved_cover_images")
+	// Stage the saved covers' JPEGs, including any removed. Their masters stay local, and the
+	// site's sizes are made from them when it's built
+	if _, err := os.Stat(savedImagesDir); err == nil {
+		cmd = exec.Command("git", "add", "--all", "--", path.Join(savedImagesDir, "*.jpg"))
 		if err := cmd.Run(); err != nil {
 			// Non-fatal: images might already be committed
 			fmt.Printf("Warning: could not stage images: %v\n", err)
@@ -61,8 +66,12 @@ func (ws *WebServer) deployToGitHub() (string, error) {
 }
 
 func (ws *WebServer) buildStatic() (string, error) {
-	// Run the sfwr build command
-	cmd := exec.Command("./sfwr", "-build")
+	// Run the sfwr build command, with the same config as this server
+	args := []string{"-build"}
+	if ws.settings().File != "" {
+		args = append(args, "-config", ws.settings().File)
+	}
+	cmd := exec.Command("./sfwr", args...)
 
 	// Explicitly capture stdout and stderr to prevent any leakage
 	var stdout, stderr bytes.Buffer
@@ -75,8 +84,8 @@ func (ws *WebServer) buildStatic() (string, error) {
 		return "", fmt.Errorf("failed to build static site: %v\n%s", err, combinedOutput)
 	}
 
-	// Note: ./sfwr -build already copies cover images to output/public/images/cover_images
-	return "Static site built successfully in output/public", nil
+	// Note: ./sfwr -build already copies cover images into the output directory
+	return "Static site built successfully in " + ws.settings().Paths.OutputDir, nil
 }
 
 func copyDir(src, dst string) error {
@@ -117,11 +126,12 @@ type GitCommit struct {
 // GetRecentCommits returns recent deployment commits from git history
 func (ws *WebServer) GetRecentCommits() ([]GitCommit, error) {
 	// Only get commits with [DEPLOY] tag
-	cmd := exec.Command("git", "log", "--grep=[DEPLOY]", "--oneline", "-n", "20", "--", "sfwr_database.db")
+	database := ws.settings().Paths.Database
+	cmd := exec.Command("git", "log", "--grep=[DEPLOY]", "--oneline", "-n", "20", "--", database)
 	output, err := cmd.Output()
 	if err != nil {
 		// Fallback to all commits if no deploy commits found
-		cmd = exec.Command("git", "log", "--oneline", "-n", "20", "--", "sfwr_database.db")
+		cmd = exec.Command("git", "log", "--oneline", "-n", "20", "--", database)
 		output, err = cmd.Output()
 		if err != nil {
 			return nil, fmt.Errorf("failed to get git history: %v", err)
@@ -175,22 +185,43 @@ func (ws *WebServer) GetRecentCommits() ([]GitCommit, error) {
 
 // RollbackToCommit rolls back the database to a specific commit
 func (ws *WebServer) RollbackToCommit(commitHash string) error {
+	database := ws.settings().Paths.Database
+
 	// Check for uncommitted changes
-	cmd := exec.Command("git", "diff", "--exit-code", "sfwr_database.db")
+	cmd := exec.Command("git", "diff", "--exit-code", database)
 	if err := cmd.Run(); err != nil {
 		// There are uncommitted changes - warn the user
 		return fmt.Errorf("you have unsaved changes. Please deploy first to save your current state, then rollback")
 	}
 
 	// Checkout the database file from the specified commit
-	cmd = exec.Command("git", "checkout", commitHash, "--", "sfwr_database.db")
+	cmd = exec.Command("git", "checkout", commitHash, "--", database)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to rollback database: %v\n%s", err, output)
 	}
 
 	// Also try to checkout cover images from that commit
-	cmd = exec.Command("git", "checkout", commitHash, "--", "saved_cover_images")
+	cmd = exec.Command("git", "checkout", commitHash, "--", ws.settings().Paths.SavedImagesDir)
 	cmd.Run() // Ignore errors as images directory might not exist in that commit
 
-	return nil
+	return ws.useCheckedOutDatabase(database)
+}
+
+// The checked out database is a new file, which pooled connections still holding the old one won't
+// see, and it may be from before a migration, so it's brought up to date before the server uses it.
+func (ws *WebServer) useCheckedOutDatabase(database string) error {
+	sqlDB, err := ws.db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxIdleConns(0)
+	sqlDB.SetMaxIdleConns(2) // database/sql's default
+	if _, err := models.MigrateUp(ws.db, models.MigrationBackupName(database)); err != nil {
+		return fmt.Errorf("rolled back, but can't migrate the database: %w", err)
+	}
+	if err := models.LoadRatingLevels(ws.db); err != nil {
+		return err
+	}
+	_, err = models.SetupBookSearch(ws.db)
+	return err
 }
\ No newline at end of file
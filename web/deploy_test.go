@@ -248,23 +248,20 @@ func TestRollbackToCommit(t *testing.T) {
 		t.Fatal("Failed to rollback:", err)
 	}
 
-	// Reload database connection after rollback
-	sqlDB, _ := db.DB()
-	sqlDB.Close()
-
-	testDBPath := "sfwr_database.db"
-	db, err = gorm.Open(sqlite.Open(testDBPath), &gorm.Config{})
-	if err != nil {
-		t.Fatal("Failed to reopen database after rollback:", err)
-	}
-	ws.db = db
-
-	// Verify we're back to 5 books
+	// The server keeps using its connection, which now sees the checked out database
 	var count3 int64
-	db.Model(&models.Book{}).Count(&count3)
+	ws.db.Model(&models.Book{}).Count(&count3)
 	if count3 != 5 {
 		t.Errorf("Expected 5 books after rollback, got %d", count3)
 	}
+
+	// The commit predates migrations, so they're applied to what was checked out
+	if pending, err := models.PendingMigrations(ws.db); err != nil || len(pending) != 0 {
+		t.Errorf("Expected the rolled back database to be migrated, got %d pending, %v", len(pending), err)
+	}
+	if !ws.db.Migrator().HasTable(&models.RatingLevel{}) {
+		t.Error("Expected the rating levels table after migrating")
+	}
 }
 
 func TestRollbackWithUncommittedChanges(t *testing.T) {
@@ -318,6 +315,13 @@ func TestDeployToGitHub(t *testing.T) {
 	}
 	db.Create(&book)
 
+	// A cover as fetched: the JPEGs are committed, the master isn't
+	imageDir := ws.settings().Paths.SavedImagesDir
+	os.MkdirAll(imageDir, 0755)
+	for _, name := range []string{"42-L.jpg", "42-master", "42-L.webp"} {
+		os.WriteFile(filepath.Join(imageDir, name), []byte(name), 0644)
+	}
+
 	// Mock git remote (will fail on push, but that's ok for test)
 	cmd := exec.Command("git", "remote", "add", "origin", "https://github.com/test/test.git")
 	cmd.Run()
@@ -345,6 +349,11 @@ func TestDeployToGitHub(t *testing.T) {
 	if !strings.Contains(lastCommit, "1 authors") {
 		t.Error("Deployment commit doesn't show correct author count")
 	}
+
+	output, _ = exec.Command("git", "ls-files", imageDir).Output()
+	if strings.TrimSpace(string(output)) != filepath.Join(imageDir, "42-L.jpg") {
+		t.Errorf("Expected only the cover's JPEG to be committed, got %q", output)
+	}
 }
 
 func TestBuildStatic(t *testing.T) {
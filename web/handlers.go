@@ -2,14 +2,18 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ccdavis/sfwr/config"
 	"github.com/ccdavis/sfwr/models"
 	"github.com/ccdavis/sfwr/pages"
 	"gorm.io/gorm"
@@ -19,67 +23,172 @@ type WebServer struct {
 	db          *gorm.DB
 	templates   *template.Template
 	imageDir    string
+	config      *config.Config
+	metadata    models.MetadataProvider
+	credentials Credentials
+	sessions    *sessionStore
+	logins      *loginLimiter
 }
 
 type PageData struct {
-	Title     string
-	Books     []models.Book
-	Authors   []models.Author
-	Book      *models.Book
-	Author    *models.Author
-	Decades   []pages.DecadeInfo
-	Decade    *pages.DecadeInfo
-	Message   string
-	Error     string
-	SortBy    string
-	Commits   []GitCommit
+	Title      string
+	Books      []models.Book
+	Authors    []models.Author
+	Book       *models.Book
+	Author     *models.Author
+	Decades    []pages.DecadeInfo
+	Decade     *pages.DecadeInfo
+	Message    string
+	Error      string
+	SortBy     string
+	Commits    []GitCommit
+	AllSeries  []models.Series
+	Series     *models.Series
+	Tags       []models.Tag
+	TagFilter  string
+	Format     string // The book list's format filter
+	Query      string
+	Results    []models.SearchResult
+	Duplicates []models.DuplicateAuthors
+	Ratings    []models.RatingLevel
+	Formats    []models.BookFormat
+	PurgeAfter int    // Days deleted things stay in the trash, 0 for until purged by hand
+	History    []models.AuditEntry
+	Next       string // Where to go after signing in
+	CSRFToken  string
+	SignedIn   bool
+	ReadOnly   bool
 }
 
 func NewWebServer(db *gorm.DB, imageDir string) *WebServer {
+	cfg := config.Default()
+	cfg.Paths.SavedImagesDir = imageDir
+	return NewConfiguredWebServer(db, cfg, models.NewOpenLibrary())
+}
+
+// Book details and covers come from the metadata provider, which tests replace with a fake.
+func NewConfiguredWebServer(db *gorm.DB, cfg config.Config, metadata models.MetadataProvider) *WebServer {
 	ws := &WebServer{
-		db:       db,
-		imageDir: imageDir,
+		db:       models.WithAuditSource(db, models.AuditSourceWeb),
+		imageDir: cfg.Paths.SavedImagesDir,
+		config:   &cfg,
+		metadata: metadata,
 	}
 	ws.loadTemplates()
 	return ws
 }
 
+// Servers made without a config use the defaults.
+func (ws *WebServer) settings() config.Config {
+	if ws.config == nil {
+		return config.Default()
+	}
+	return *ws.config
+}
+
+// A copy of the server whose changes are recorded as coming from the source.
+func (ws *WebServer) withAuditSource(source string) *WebServer {
+	tagged := *ws
+	tagged.db = models.WithAuditSource(ws.db, source)
+	return &tagged
+}
+
+func (ws *WebServer) metadataProvider() models.MetadataProvider {
+	if ws.metadata == nil {
+		return models.NewOpenLibrary()
+	}
+	return ws.metadata
+}
+
+// Passwords and API tokens to check. With no admin password the server is open to anyone who can reach it.
+func (ws *WebServer) SetCredentials(creds Credentials) {
+	ws.credentials = creds
+}
+
 func (ws *WebServer) loadTemplates() {
 	var err error
-	ws.templates, err = template.ParseGlob("templates/web/*.html")
+	ws.templates, err = template.ParseGlob(ws.settings().WebTemplate("*.html"))
 	if err != nil {
 		panic(fmt.Sprintf("Failed to load web templates: %v", err))
 	}
 }
 
-func (ws *WebServer) ServeHTTP(port string) error {
-	http.HandleFunc("/", ws.homeHandler)
-	http.HandleFunc("/books", ws.listBooksHandler)
-	http.HandleFunc("/books/new", ws.newBookHandler)
-	http.HandleFunc("/books/create", ws.createBookHandler)
-	http.HandleFunc("/books/edit/", ws.editBookHandler)
-	http.HandleFunc("/books/update/", ws.updateBookHandler)
-	http.HandleFunc("/books/delete/", ws.deleteBookHandler)
-	http.HandleFunc("/authors", ws.listAuthorsHandler)
-	http.HandleFunc("/authors/new", ws.newAuthorHandler)
-	http.HandleFunc("/authors/create", ws.createAuthorHandler)
-	http.HandleFunc("/authors/edit/", ws.editAuthorHandler)
-	http.HandleFunc("/authors/update/", ws.updateAuthorHandler)
-	http.HandleFunc("/decades", ws.listDecadesHandler)
-	http.HandleFunc("/decades/", ws.decadeHandler)
-	http.HandleFunc("/books/search-openlibrary", ws.searchOpenLibraryHandler)
-	http.HandleFunc("/books/update-from-openlibrary", ws.updateFromOpenLibraryHandler)
-	http.HandleFunc("/books/create-from-openlibrary", ws.createFromOpenLibraryHandler)
-	http.HandleFunc("/deploy", ws.deployHandler)
-	http.HandleFunc("/build-local", ws.buildLocalHandler)
-	http.HandleFunc("/preview", ws.previewHandler)
-	http.HandleFunc("/backups", ws.backupsHandler)
-	http.HandleFunc("/rollback", ws.rollbackHandler)
-	http.Handle("/saved_cover_images/", http.StripPrefix("/saved_cover_images/", http.FileServer(http.Dir(ws.imageDir))))
-	http.Handle("/preview-site/", http.StripPrefix("/preview-site/", http.FileServer(http.Dir("output/public"))))
+// Every route, behind the auth layer.
+func (ws *WebServer) Handler() http.Handler {
+	if ws.sessions == nil {
+		ws.sessions = newSessionStore(time.Duration(ws.settings().Auth.SessionHours) * time.Hour)
+	}
+	if ws.logins == nil {
+		ws.logins = newLoginLimiter()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ws.homeHandler)
+	mux.HandleFunc("/login", ws.loginHandler)
+	mux.HandleFunc("/logout", ws.logoutHandler)
+	mux.HandleFunc("/books", ws.listBooksHandler)
+	mux.HandleFunc("/books/new", ws.newBookHandler)
+	mux.HandleFunc("/books/create", ws.createBookHandler)
+	mux.HandleFunc("/books/edit/", ws.editBookHandler)
+	mux.HandleFunc("/books/update/", ws.updateBookHandler)
+	mux.HandleFunc("/books/delete/", ws.deleteBookHandler)
+	mux.HandleFunc("/books/readings/add/", ws.addReadingSessionHandler)
+	mux.HandleFunc("/books/readings/delete/", ws.deleteReadingSessionHandler)
+	mux.HandleFunc("/books/contents/add/", ws.addContentsHandler)
+	mux.HandleFunc("/books/contents/rate/", ws.rateContentsHandler)
+	mux.HandleFunc("/books/contents/delete/", ws.deleteContentsHandler)
+	mux.HandleFunc("/books/cover/upload/", ws.uploadCoverHandler)
+	mux.HandleFunc("/books/cover/openlibrary/", ws.useOpenLibraryCoverHandler)
+	mux.HandleFunc("/authors", ws.listAuthorsHandler)
+	mux.HandleFunc("/authors/new", ws.newAuthorHandler)
+	mux.HandleFunc("/authors/create", ws.createAuthorHandler)
+	mux.HandleFunc("/authors/edit/", ws.editAuthorHandler)
+	mux.HandleFunc("/authors/update/", ws.updateAuthorHandler)
+	mux.HandleFunc("/authors/merge", ws.mergeAuthorsHandler)
+	mux.HandleFunc("/authors/aliases/add/", ws.addAuthorAliasHandler)
+	mux.HandleFunc("/authors/aliases/delete/", ws.deleteAuthorAliasHandler)
+	mux.HandleFunc("/series", ws.listSeriesHandler)
+	mux.HandleFunc("/series/new", ws.newSeriesHandler)
+	mux.HandleFunc("/series/create", ws.createSeriesHandler)
+	mux.HandleFunc("/series/edit/", ws.editSeriesHandler)
+	mux.HandleFunc("/series/update/", ws.updateSeriesHandler)
+	mux.HandleFunc("/series/assign/", ws.assignSeriesBookHandler)
+	mux.HandleFunc("/series/remove/", ws.removeSeriesBookHandler)
+	mux.HandleFunc("/ratings", ws.listRatingsHandler)
+	mux.HandleFunc("/ratings/create", ws.createRatingHandler)
+	mux.HandleFunc("/ratings/update/", ws.updateRatingHandler)
+	mux.HandleFunc("/ratings/delete/", ws.deleteRatingHandler)
+	mux.HandleFunc("/trash", ws.trashHandler)
+	mux.HandleFunc("/trash/books/restore/", ws.restoreBookHandler)
+	mux.HandleFunc("/trash/books/purge/", ws.purgeBookHandler)
+	mux.HandleFunc("/trash/authors/restore/", ws.restoreAuthorHandler)
+	mux.HandleFunc("/trash/authors/purge/", ws.purgeAuthorHandler)
+	mux.HandleFunc("/history/revert/", ws.revertHistoryHandler)
+	mux.HandleFunc("/search", ws.searchHandler)
+	mux.HandleFunc("/decades", ws.listDecadesHandler)
+	mux.HandleFunc("/decades/", ws.decadeHandler)
+	mux.HandleFunc("/books/search-openlibrary", ws.searchOpenLibraryHandler)
+	mux.HandleFunc("/books/update-from-openlibrary", ws.updateFromOpenLibraryHandler)
+	mux.HandleFunc("/books/create-from-openlibrary", ws.createFromOpenLibraryHandler)
+	mux.HandleFunc("/deploy", ws.deployHandler)
+	mux.HandleFunc("/build-local", ws.buildLocalHandler)
+	mux.HandleFunc("/preview", ws.previewHandler)
+	mux.HandleFunc("/backups", ws.backupsHandler)
+	mux.HandleFunc("/rollback", ws.rollbackHandler)
+	mux.HandleFunc(apiPrefix, ws.withAuditSource(models.AuditSourceAPI).apiHandler)
+	mux.Handle("/saved_cover_images/", http.StripPrefix("/saved_cover_images/", http.FileServer(http.Dir(ws.imageDir))))
+	mux.Handle("/preview-site/", http.StripPrefix("/preview-site/", http.FileServer(http.Dir(ws.settings().Paths.OutputDir))))
+	return ws.authenticate(mux)
+}
 
+func (ws *WebServer) ServeHTTP(port string) error {
+	address := ":" + port
+	if !ws.authRequired() {
+		address = "127.0.0.1:" + port
+		fmt.Println("No admin password is set, so only this computer can connect. Set one with -set-password admin")
+	}
+	go ws.purgeTrashDaily()
 	fmt.Printf("Web server starting on http://localhost:%s\n", port)
-	return http.ListenAndServe(":"+port, nil)
+	return http.ListenAndServe(address, ws.Handler())
 }
 
 func (ws *WebServer) homeHandler(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +196,7 @@ func (ws *WebServer) homeHandler(w http.ResponseWriter, r *http.Request) {
 		Title:   "SFWR Book Management",
 		Message: "Welcome to the SFWR Book Management System",
 	}
-	ws.renderTemplate(w, "home", data)
+	ws.renderTemplate(w, r, "home", data)
 }
 
 func (ws *WebServer) listBooksHandler(w http.ResponseWriter, r *http.Request) {
@@ -95,49 +204,100 @@ func (ws *WebServer) listBooksHandler(w http.ResponseWriter, r *http.Request) {
 	if sortBy == "" {
 		sortBy = "recent"
 	}
+	tagFilter := r.URL.Query().Get("tag")
+	formatFilter := r.URL.Query().Get("format")
 
 	var books []models.Book
 	var err error
+
+	query := ws.db.Preload("Authors").Preload("Credits.Author").Preload("Tags")
+	if tagFilter != "" {
+		query = query.Scopes(models.WithTag(tagFilter))
+	}
+	if formatFilter != "" {
+		query = query.Scopes(models.WithFormat(models.BookFormat(formatFilter)))
+	}
 	
 	switch sortBy {
 	case "recent":
-		err = ws.db.Preload("Authors").Order("date_added DESC").Find(&books).Error
+		err = query.Order("date_added DESC").Find(&books).Error
 	case "title":
-		err = ws.db.Preload("Authors").Order("main_title ASC").Find(&books).Error
+		err = query.Order("main_title ASC").Find(&books).Error
 	case "author":
-		err = ws.db.Preload("Authors").Order("author_full_name ASC").Find(&books).Error
+		err = query.Order("author_full_name ASC").Find(&books).Error
 	case "year":
-		err = ws.db.Preload("Authors").Order("pub_date DESC").Find(&books).Error
+		err = query.Order("pub_date DESC").Find(&books).Error
 	default:
-		err = ws.db.Preload("Authors").Order("date_added DESC").Find(&books).Error
+		err = query.Order("date_added DESC").Find(&books).Error
+	}
+
+	if err != nil {
+		ws.renderError(w, r, "Failed to load books", err)
+		return
+	}
+
+	tags, err := models.LoadAllTags(ws.db)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load tags", err)
+		return
 	}
 
+	data := PageData{
+		Title:     "All Books",
+		Books:     books,
+		SortBy:    sortBy,
+		Tags:      tags,
+		TagFilter: tagFilter,
+		Format:    formatFilter,
+	}
+	ws.renderTemplate(w, r, "book_list", data)
+}
+
+func (ws *WebServer) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	results, err := models.SearchBooks(ws.db, query, ws.settings().Lists.SearchResults)
 	if err != nil {
-		ws.renderError(w, "Failed to load books", err)
+		ws.renderError(w, r, "Search failed", err)
 		return
 	}
 
 	data := PageData{
-		Title:  "All Books",
-		Books:  books,
-		SortBy: sortBy,
+		Title:   "Search",
+		Query:   query,
+		Results: results,
 	}
-	ws.renderTemplate(w, "book_list", data)
+	ws.renderTemplate(w, r, "search", data)
 }
 
 func (ws *WebServer) newBookHandler(w http.ResponseWriter, r *http.Request) {
 	var authors []models.Author
-	result := ws.db.Order("full_name ASC").Find(&authors)
+	result := ws.db.Preload("Aliases").Order("full_name ASC").Find(&authors)
 	if result.Error != nil {
-		ws.renderError(w, "Failed to load authors", result.Error)
+		ws.renderError(w, r, "Failed to load authors", result.Error)
+		return
+	}
+
+	tags, err := models.LoadAllTags(ws.db)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load tags", err)
 		return
 	}
 
 	data := PageData{
 		Title:   "Add New Book",
 		Authors: authors,
+		Tags:    tags,
+	}
+	ws.renderTemplate(w, r, "book_form", data)
+}
+
+// The book form's format, which can be left empty.
+func parseFormatForm(r *http.Request) (models.BookFormat, error) {
+	if r.FormValue("format") == "" {
+		return "", nil
 	}
-	ws.renderTemplate(w, "book_form", data)
+	return models.ParseBookFormat(r.FormValue("format"))
 }
 
 func (ws *WebServer) createBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -146,26 +306,27 @@ func (ws *WebServer) createBookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorID, err := strconv.ParseUint(r.FormValue("author_id"), 10, 32)
+	r.ParseForm()
+	credits, err := parseCreditsForm(r)
 	if err != nil {
-		ws.renderError(w, "Invalid author selected", err)
+		ws.renderError(w, r, "Invalid author selected", err)
 		return
 	}
 
-	var author models.Author
-	if err := ws.db.First(&author, authorID).Error; err != nil {
-		ws.renderError(w, "Author not found", err)
+	book := models.Book{
+		MainTitle: r.FormValue("main_title"),
+		SubTitle:  r.FormValue("sub_title"),
+		Rating:    r.FormValue("rating"),
+		Review:    r.FormValue("review"),
+		DateAdded: time.Now(),
+	}
+	if _, err := models.StringToRating(book.Rating); err != nil {
+		ws.renderError(w, r, "Invalid rating", err)
 		return
 	}
-
-	book := models.Book{
-		MainTitle:      r.FormValue("main_title"),
-		SubTitle:       r.FormValue("sub_title"),
-		AuthorFullName: author.FullName,
-		AuthorSurname:  author.Surname,
-		Rating:         r.FormValue("rating"),
-		Review:         r.FormValue("review"),
-		DateAdded:      time.Now(),
+	if book.Format, err = parseFormatForm(r); err != nil {
+		ws.renderError(w, r, "Invalid format", err)
+		return
 	}
 
 	pubYear, err := strconv.ParseInt(r.FormValue("pub_date"), 10, 64)
@@ -175,35 +336,86 @@ func (ws *WebServer) createBookHandler(w http.ResponseWriter, r *http.Request) {
 		book.PubDate = pubYear
 	}
 
-	result := ws.db.Create(&book)
-	if result.Error != nil {
-		ws.renderError(w, "Failed to create book", result.Error)
+	err = ws.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&book).Error; err != nil {
+			return err
+		}
+		return models.SetBookCredits(tx, &book, credits)
+	})
+	if err != nil {
+		ws.renderError(w, r, "Failed to create book", err)
 		return
 	}
 
-	ws.db.Model(&book).Association("Authors").Append(&author)
+	if err := models.SetBookTags(ws.db, &book, models.ParseTagNames(r.FormValue("tags"))); err != nil {
+		ws.renderError(w, r, "Failed to save tags", err)
+		return
+	}
 
 	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Book created successfully", book.ID), http.StatusSeeOther)
 }
 
+// The people credited on the book form, in order. Each author_id has an author_role beside it;
+// without roles the first person is the author and the rest are co-authors.
+func parseCreditsForm(r *http.Request) ([]models.Credit, error) {
+	ids := r.Form["author_id"]
+	roles := r.Form["author_role"]
+	var credits []models.Credit
+	for i, idStr := range ids {
+		if strings.TrimSpace(idStr) == "" {
+			continue
+		}
+		authorID, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		credit := models.Credit{AuthorID: uint(authorID), Role: models.CreditCoAuthor}
+		if len(credits) == 0 {
+			credit.Role = models.CreditAuthor
+		}
+		if len(roles) == len(ids) {
+			if credit.Role, err = models.ParseCreditRole(roles[i]); err != nil {
+				return nil, err
+			}
+		}
+		credits = append(credits, credit)
+	}
+	if len(credits) == 0 {
+		return nil, errors.New("choose at least one author")
+	}
+	return credits, nil
+}
+
 func (ws *WebServer) editBookHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/books/edit/")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		ws.renderError(w, "Invalid book ID", err)
+		ws.renderError(w, r, "Invalid book ID", err)
 		return
 	}
 
 	var book models.Book
-	if err := ws.db.Preload("Authors").First(&book, id).Error; err != nil {
-		ws.renderError(w, "Book not found", err)
+	if err := ws.db.Preload("Authors").Preload("Credits.Author").Preload("Contents.Work.Authors").Preload("SeriesEntries.Series").Preload("Tags").Preload("ReadingSessions").First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
 		return
 	}
 
 	var authors []models.Author
-	result := ws.db.Order("full_name ASC").Find(&authors)
+	result := ws.db.Preload("Aliases").Order("full_name ASC").Find(&authors)
 	if result.Error != nil {
-		ws.renderError(w, "Failed to load authors", result.Error)
+		ws.renderError(w, r, "Failed to load authors", result.Error)
+		return
+	}
+
+	tags, err := models.LoadAllTags(ws.db)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load tags", err)
+		return
+	}
+
+	history, err := models.LoadAuditHistory(ws.db, models.AuditBook, book.ID)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load the book's history", err)
 		return
 	}
 
@@ -211,9 +423,11 @@ func (ws *WebServer) editBookHandler(w http.ResponseWriter, r *http.Request) {
 		Title:   "Edit Book",
 		Book:    &book,
 		Authors: authors,
+		Tags:    tags,
+		History: history,
 		Message: r.URL.Query().Get("message"),
 	}
-	ws.renderTemplate(w, "book_form", data)
+	ws.renderTemplate(w, r, "book_form", data)
 }
 
 func (ws *WebServer) updateBookHandler(w http.ResponseWriter, r *http.Request) {
@@ -225,34 +439,35 @@ func (ws *WebServer) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/books/update/")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		ws.renderError(w, "Invalid book ID", err)
+		ws.renderError(w, r, "Invalid book ID", err)
 		return
 	}
 
 	var book models.Book
-	if err := ws.db.Preload("Authors").First(&book, id).Error; err != nil {
-		ws.renderError(w, "Book not found", err)
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
 		return
 	}
 
-	authorID, err := strconv.ParseUint(r.FormValue("author_id"), 10, 32)
+	r.ParseForm()
+	credits, err := parseCreditsForm(r)
 	if err != nil {
-		ws.renderError(w, "Invalid author selected", err)
-		return
-	}
-
-	var author models.Author
-	if err := ws.db.First(&author, authorID).Error; err != nil {
-		ws.renderError(w, "Author not found", err)
+		ws.renderError(w, r, "Invalid author selected", err)
 		return
 	}
 
 	book.MainTitle = r.FormValue("main_title")
 	book.SubTitle = r.FormValue("sub_title")
-	book.AuthorFullName = author.FullName
-	book.AuthorSurname = author.Surname
 	book.Rating = r.FormValue("rating")
 	book.Review = r.FormValue("review")
+	if _, err := models.StringToRating(book.Rating); err != nil {
+		ws.renderError(w, r, "Invalid rating", err)
+		return
+	}
+	if book.Format, err = parseFormatForm(r); err != nil {
+		ws.renderError(w, r, "Invalid format", err)
+		return
+	}
 
 	pubYear, err := strconv.ParseInt(r.FormValue("pub_date"), 10, 64)
 	if err != nil {
@@ -261,13 +476,21 @@ func (ws *WebServer) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 		book.PubDate = pubYear
 	}
 
-	result := ws.db.Save(&book)
-	if result.Error != nil {
-		ws.renderError(w, "Failed to update book", result.Error)
+	err = ws.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&book).Error; err != nil {
+			return err
+		}
+		return models.SetBookCredits(tx, &book, credits)
+	})
+	if err != nil {
+		ws.renderError(w, r, "Failed to update book", err)
 		return
 	}
 
-	ws.db.Model(&book).Association("Authors").Replace(&author)
+	if err := models.SetBookTags(ws.db, &book, models.ParseTagNames(r.FormValue("tags"))); err != nil {
+		ws.renderError(w, r, "Failed to save tags", err)
+		return
+	}
 
 	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Book updated successfully", book.ID), http.StatusSeeOther)
 }
@@ -281,24 +504,267 @@ func (ws *WebServer) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/books/delete/")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		ws.renderError(w, "Invalid book ID", err)
+		ws.renderError(w, r, "Invalid book ID", err)
 		return
 	}
 
-	result := ws.db.Delete(&models.Book{}, id)
-	if result.Error != nil {
-		ws.renderError(w, "Failed to delete book", result.Error)
+	var book models.Book
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+	if err := ws.db.Delete(&book).Error; err != nil {
+		ws.renderError(w, r, "Failed to delete book", err)
+		return
+	}
+
+	http.Redirect(w, r, "/books?message=Book moved to the trash", http.StatusSeeOther)
+}
+
+func (ws *WebServer) addReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/readings/add/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book ID", err)
+		return
+	}
+
+	var book models.Book
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+
+	startDate, err := models.ParseReadingDate(r.FormValue("start_date"))
+	if err != nil {
+		ws.renderError(w, r, "Invalid start date", err)
+		return
+	}
+	finishDate, err := models.ParseReadingDate(r.FormValue("finish_date"))
+	if err != nil {
+		ws.renderError(w, r, "Invalid finish date", err)
+		return
+	}
+
+	session := models.ReadingSession{
+		BookID:     book.ID,
+		StartDate:  startDate,
+		FinishDate: finishDate,
+		Format:     r.FormValue("format"),
+		Note:       strings.TrimSpace(r.FormValue("note")),
+	}
+	if err := models.LogReadingSession(ws.db, &session); err != nil {
+		ws.renderError(w, r, "Failed to log reading session", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Reading session logged", book.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) deleteReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/readings/delete/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid reading session ID", err)
+		return
+	}
+
+	var session models.ReadingSession
+	if err := ws.db.First(&session, id).Error; err != nil {
+		ws.renderError(w, r, "Reading session not found", err)
+		return
+	}
+
+	if err := models.DeleteReadingSession(ws.db, session.ID); err != nil {
+		ws.renderError(w, r, "Failed to delete reading session", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Reading session deleted", session.BookID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) addContentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/contents/add/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book ID", err)
+		return
+	}
+
+	var book models.Book
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+
+	length, err := models.ParseWorkLength(r.FormValue("work_length"))
+	if err != nil {
+		ws.renderError(w, r, "Invalid story length", err)
+		return
+	}
+	pubYear, err := strconv.ParseInt(r.FormValue("work_pub_date"), 10, 64)
+	if err != nil {
+		pubYear = models.Missing
+	}
+
+	err = ws.db.Transaction(func(tx *gorm.DB) error {
+		work, err := models.FindOrCreateWork(tx, r.FormValue("work_title"), models.ParseWorkAuthorNames(r.FormValue("work_authors")), pubYear, length)
+		if err != nil {
+			return err
+		}
+		_, err = models.AddToContents(tx, book, work, r.FormValue("work_rating"))
+		return err
+	})
+	if err != nil {
+		ws.renderError(w, r, "Failed to add the story", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Story added to the contents", book.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) rateContentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/contents/rate/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid contents entry ID", err)
+		return
+	}
+
+	var entry models.ContentsEntry
+	if err := ws.db.First(&entry, id).Error; err != nil {
+		ws.renderError(w, r, "Contents entry not found", err)
+		return
+	}
+
+	if err := models.RateContentsEntry(ws.db, entry.ID, r.FormValue("work_rating")); err != nil {
+		ws.renderError(w, r, "Failed to rate the story", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Story rating saved", entry.BookID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) deleteContentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/contents/delete/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid contents entry ID", err)
+		return
+	}
+
+	var entry models.ContentsEntry
+	if err := ws.db.First(&entry, id).Error; err != nil {
+		ws.renderError(w, r, "Contents entry not found", err)
+		return
+	}
+
+	if err := models.RemoveFromContents(ws.db, entry.ID); err != nil {
+		ws.renderError(w, r, "Failed to remove the story", err)
 		return
 	}
 
-	http.Redirect(w, r, "/books?message=Book deleted successfully", http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Story removed from the contents", entry.BookID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) uploadCoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/cover/upload/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book ID", err)
+		return
+	}
+
+	var book models.Book
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+
+	// Room for the multipart headers on top of the image
+	r.Body = http.MaxBytesReader(w, r.Body, models.MaxCoverUploadSize+1<<20)
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		ws.renderError(w, r, "No cover image uploaded, or it's too large", err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ws.renderError(w, r, "Failed to read the uploaded cover", err)
+		return
+	}
+
+	if err := models.UploadCover(ws.db, ws.imageDir, &book, data); err != nil {
+		ws.renderError(w, r, "Failed to save the uploaded cover", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Cover uploaded", book.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) useOpenLibraryCoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/books/cover/openlibrary/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book ID", err)
+		return
+	}
+
+	var book models.Book
+	if err := ws.db.First(&book, id).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+
+	if err := models.UseOpenLibraryCover(ws.db, &book); err != nil {
+		ws.renderError(w, r, "Failed to switch to the Open Library cover", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/edit/%d?message=Using the Open Library cover", book.ID), http.StatusSeeOther)
 }
 
 func (ws *WebServer) listAuthorsHandler(w http.ResponseWriter, r *http.Request) {
 	var authors []models.Author
 	result := ws.db.Preload("Books").Find(&authors)
 	if result.Error != nil {
-		ws.renderError(w, "Failed to load authors", result.Error)
+		ws.renderError(w, r, "Failed to load authors", result.Error)
 		return
 	}
 
@@ -307,14 +773,14 @@ func (ws *WebServer) listAuthorsHandler(w http.ResponseWriter, r *http.Request)
 		Authors: authors,
 		Message: r.URL.Query().Get("message"),
 	}
-	ws.renderTemplate(w, "author_list", data)
+	ws.renderTemplate(w, r, "author_list", data)
 }
 
 func (ws *WebServer) newAuthorHandler(w http.ResponseWriter, r *http.Request) {
 	data := PageData{
 		Title: "Add New Author",
 	}
-	ws.renderTemplate(w, "author_form", data)
+	ws.renderTemplate(w, r, "author_form", data)
 }
 
 func (ws *WebServer) createAuthorHandler(w http.ResponseWriter, r *http.Request) {
@@ -325,7 +791,7 @@ func (ws *WebServer) createAuthorHandler(w http.ResponseWriter, r *http.Request)
 
 	fullName := strings.TrimSpace(r.FormValue("full_name"))
 	if fullName == "" {
-		ws.renderError(w, "Author name is required", fmt.Errorf("empty author name"))
+		ws.renderError(w, r, "Author name is required", fmt.Errorf("empty author name"))
 		return
 	}
 
@@ -336,7 +802,7 @@ func (ws *WebServer) createAuthorHandler(w http.ResponseWriter, r *http.Request)
 
 	result := ws.db.Create(&author)
 	if result.Error != nil {
-		ws.renderError(w, "Failed to create author", result.Error)
+		ws.renderError(w, r, "Failed to create author", result.Error)
 		return
 	}
 
@@ -347,27 +813,33 @@ func (ws *WebServer) editAuthorHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/authors/edit/")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		ws.renderError(w, "Invalid author ID", err)
+		ws.renderError(w, r, "Invalid author ID", err)
 		return
 	}
 
 	var author models.Author
-	if err := ws.db.Preload("Books").First(&author, id).Error; err != nil {
-		ws.renderError(w, "Author not found", err)
+	if err := ws.db.Preload("Books").Preload("Aliases").First(&author, id).Error; err != nil {
+		ws.renderError(w, r, "Author not found", err)
 		return
 	}
 
 	// Get all books for reassignment
 	var allBooks []models.Book
-	if err := ws.db.Preload("Authors").Order("main_title ASC").Find(&allBooks).Error; err != nil {
-		ws.renderError(w, "Failed to load books", err)
+	if err := ws.db.Preload("Authors").Preload("Credits.Author").Order("main_title ASC").Find(&allBooks).Error; err != nil {
+		ws.renderError(w, r, "Failed to load books", err)
 		return
 	}
 
 	// Get all authors except the current one for reassignment options
 	var otherAuthors []models.Author
 	if err := ws.db.Where("id != ?", id).Order("full_name ASC").Find(&otherAuthors).Error; err != nil {
-		ws.renderError(w, "Failed to load authors", err)
+		ws.renderError(w, r, "Failed to load authors", err)
+		return
+	}
+
+	history, err := models.LoadAuditHistory(ws.db, models.AuditAuthor, author.ID)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load the author's history", err)
 		return
 	}
 
@@ -376,9 +848,10 @@ func (ws *WebServer) editAuthorHandler(w http.ResponseWriter, r *http.Request) {
 		Author:  &author,
 		Books:   allBooks,
 		Authors: otherAuthors,
+		History: history,
 		Message: r.URL.Query().Get("message"),
 	}
-	ws.renderTemplate(w, "author_edit", data)
+	ws.renderTemplate(w, r, "author_edit", data)
 }
 
 func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request) {
@@ -390,28 +863,32 @@ func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request)
 	idStr := strings.TrimPrefix(r.URL.Path, "/authors/update/")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		ws.renderError(w, "Invalid author ID", err)
+		ws.renderError(w, r, "Invalid author ID", err)
 		return
 	}
 
 	var author models.Author
 	if err := ws.db.Preload("Books").First(&author, id).Error; err != nil {
-		ws.renderError(w, "Author not found", err)
+		ws.renderError(w, r, "Author not found", err)
 		return
 	}
 
 	// Update author name
 	fullName := strings.TrimSpace(r.FormValue("full_name"))
 	if fullName == "" {
-		ws.renderError(w, "Author name is required", fmt.Errorf("empty author name"))
+		ws.renderError(w, r, "Author name is required", fmt.Errorf("empty author name"))
 		return
 	}
 
+	oldName := author.FullName
 	author.FullName = fullName
 	author.Surname = models.ExtractSurname(fullName)
 
-	// Update all books with this author's name change
+	// Update the books filed under this author with the name change
 	for _, book := range author.Books {
+		if book.AuthorFullName != oldName {
+			continue
+		}
 		book.AuthorFullName = author.FullName
 		book.AuthorSurname = author.Surname
 		ws.db.Save(&book)
@@ -419,7 +896,7 @@ func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request)
 
 	// Save the author
 	if err := ws.db.Save(&author).Error; err != nil {
-		ws.renderError(w, "Failed to update author", err)
+		ws.renderError(w, r, "Failed to update author", err)
 		return
 	}
 
@@ -431,16 +908,22 @@ func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request)
 		bookIDs := r.Form["add_book_ids"]
 		if len(bookIDs) > 0 {
 			var books []models.Book
-			if err := ws.db.Find(&books, bookIDs).Error; err != nil {
-				ws.renderError(w, "Failed to find books", err)
+			if err := ws.db.Preload("Credits").Find(&books, bookIDs).Error; err != nil {
+				ws.renderError(w, r, "Failed to find books", err)
 				return
 			}
+			// Credit this author after whoever the book already credits
 			for _, book := range books {
-				ws.db.Model(&author).Association("Books").Append(&book)
-				// Update the book's author fields
-				book.AuthorFullName = author.FullName
-				book.AuthorSurname = author.Surname
-				ws.db.Save(&book)
+				credits := book.OrderedCredits()
+				role := models.CreditCoAuthor
+				if len(credits) == 0 {
+					role = models.CreditAuthor
+				}
+				credits = append(credits, models.Credit{AuthorID: author.ID, Role: role})
+				if err := models.SetBookCredits(ws.db, &book, credits); err != nil {
+					ws.renderError(w, r, "Failed to add "+book.MainTitle, err)
+					return
+				}
 			}
 		}
 
@@ -452,31 +935,41 @@ func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request)
 		if len(bookIDs) > 0 && newAuthorID != "" {
 			newAuthorIDUint, err := strconv.ParseUint(newAuthorID, 10, 32)
 			if err != nil {
-				ws.renderError(w, "Invalid new author ID", err)
+				ws.renderError(w, r, "Invalid new author ID", err)
 				return
 			}
 
 			var newAuthor models.Author
 			if err := ws.db.First(&newAuthor, newAuthorIDUint).Error; err != nil {
-				ws.renderError(w, "New author not found", err)
+				ws.renderError(w, r, "New author not found", err)
 				return
 			}
 
 			var books []models.Book
-			if err := ws.db.Find(&books, bookIDs).Error; err != nil {
-				ws.renderError(w, "Failed to find books", err)
+			if err := ws.db.Preload("Credits").Find(&books, bookIDs).Error; err != nil {
+				ws.renderError(w, r, "Failed to find books", err)
 				return
 			}
 
+			// The new author takes this author's place and role in each book's credits
 			for _, book := range books {
-				// Remove from current author
-				ws.db.Model(&author).Association("Books").Delete(&book)
-				// Add to new author
-				ws.db.Model(&newAuthor).Association("Books").Append(&book)
-				// Update the book's author fields
-				book.AuthorFullName = newAuthor.FullName
-				book.AuthorSurname = newAuthor.Surname
-				ws.db.Save(&book)
+				if !author.HasBook(book) {
+					continue
+				}
+				var credits []models.Credit
+				for _, c := range book.OrderedCredits() {
+					if c.AuthorID == newAuthor.ID {
+						continue
+					}
+					if c.AuthorID == author.ID {
+						c.AuthorID = newAuthor.ID
+					}
+					credits = append(credits, c)
+				}
+				if err := models.SetBookCredits(ws.db, &book, credits); err != nil {
+					ws.renderError(w, r, "Failed to reassign "+book.MainTitle, err)
+					return
+				}
 			}
 		}
 	}
@@ -484,9 +977,411 @@ func (ws *WebServer) updateAuthorHandler(w http.ResponseWriter, r *http.Request)
 	http.Redirect(w, r, fmt.Sprintf("/authors/edit/%d?message=Author updated successfully", author.ID), http.StatusSeeOther)
 }
 
-func (ws *WebServer) renderTemplate(w http.ResponseWriter, name string, data PageData) {
+func (ws *WebServer) addAuthorAliasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/authors", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/authors/aliases/add/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid author ID", err)
+		return
+	}
+
+	var author models.Author
+	if err := ws.db.First(&author, id).Error; err != nil {
+		ws.renderError(w, r, "Author not found", err)
+		return
+	}
+
+	aliasType, err := models.ParseAliasType(r.FormValue("alias_type"))
+	if err != nil {
+		ws.renderError(w, r, "Invalid alias type", err)
+		return
+	}
+	if _, err := models.AddAuthorAlias(ws.db, author, r.FormValue("alias_name"), aliasType); err != nil {
+		ws.renderError(w, r, "Failed to add alias", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/authors/edit/%d?message=Alias added", author.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) deleteAuthorAliasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/authors", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/authors/aliases/delete/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid alias ID", err)
+		return
+	}
+
+	var alias models.AuthorAlias
+	if err := ws.db.First(&alias, id).Error; err != nil {
+		ws.renderError(w, r, "Alias not found", err)
+		return
+	}
+
+	if err := ws.db.Delete(&alias).Error; err != nil {
+		ws.renderError(w, r, "Failed to delete alias", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/authors/edit/%d?message=Alias deleted", alias.AuthorID), http.StatusSeeOther)
+}
+
+// Suggests authors that look like duplicates, and merges the two chosen.
+func (ws *WebServer) mergeAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		duplicates, err := models.SuggestDuplicateAuthors(ws.db)
+		if err != nil {
+			ws.renderError(w, r, "Failed to look for duplicate authors", err)
+			return
+		}
+		var authors []models.Author
+		if err := ws.db.Order("full_name ASC").Find(&authors).Error; err != nil {
+			ws.renderError(w, r, "Failed to load authors", err)
+			return
+		}
+		data := PageData{
+			Title:      "Merge Authors",
+			Authors:    authors,
+			Duplicates: duplicates,
+			Message:    r.URL.Query().Get("message"),
+		}
+		ws.renderTemplate(w, r, "author_merge", data)
+		return
+	}
+
+	keepId, err := strconv.ParseUint(r.FormValue("keep_id"), 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid author ID to keep", err)
+		return
+	}
+	mergeId, err := strconv.ParseUint(r.FormValue("merge_id"), 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid author ID to merge", err)
+		return
+	}
+	report, err := models.MergeAuthors(ws.db, uint(keepId), uint(mergeId))
+	if err != nil {
+		ws.renderError(w, r, "Failed to merge authors", err)
+		return
+	}
+
+	message := fmt.Sprintf("Merged %s into this author, moving %d books and %d stories", report.Merged.FullName, report.BooksMoved, report.WorksMoved)
+	http.Redirect(w, r, fmt.Sprintf("/authors/edit/%d?message=%s", keepId, url.QueryEscape(message)), http.StatusSeeOther)
+}
+
+func (ws *WebServer) listSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	allSeries, err := models.LoadAllSeries(ws.db)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load series", err)
+		return
+	}
+
+	data := PageData{
+		Title:     "All Series",
+		AllSeries: allSeries,
+		Message:   r.URL.Query().Get("message"),
+	}
+	ws.renderTemplate(w, r, "series_list", data)
+}
+
+func (ws *WebServer) newSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	data := PageData{
+		Title: "Add New Series",
+	}
+	ws.renderTemplate(w, r, "series_form", data)
+}
+
+func (ws *WebServer) createSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/series/new", http.StatusSeeOther)
+		return
+	}
+
+	series, err := models.CreateSeries(ws.db, r.FormValue("name"), strings.TrimSpace(r.FormValue("description")))
+	if err != nil {
+		ws.renderError(w, r, "Failed to create series", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/series/edit/%d?message=Series created successfully", series.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) editSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/series/edit/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid series ID", err)
+		return
+	}
+
+	series, err := models.LoadSeries(ws.db, uint(id))
+	if err != nil {
+		ws.renderError(w, r, "Series not found", err)
+		return
+	}
+
+	// All books are offered for assignment to the series
+	var allBooks []models.Book
+	if err := ws.db.Preload("Credits.Author").Order("main_title ASC").Find(&allBooks).Error; err != nil {
+		ws.renderError(w, r, "Failed to load books", err)
+		return
+	}
+
+	data := PageData{
+		Title:   "Edit Series",
+		Series:  &series,
+		Books:   allBooks,
+		Message: r.URL.Query().Get("message"),
+	}
+	ws.renderTemplate(w, r, "series_form", data)
+}
+
+func (ws *WebServer) updateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/series", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/series/update/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid series ID", err)
+		return
+	}
+
+	var series models.Series
+	if err := ws.db.First(&series, id).Error; err != nil {
+		ws.renderError(w, r, "Series not found", err)
+		return
+	}
+
+	if err := series.Update(ws.db, r.FormValue("name"), strings.TrimSpace(r.FormValue("description"))); err != nil {
+		ws.renderError(w, r, "Failed to update series", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/series/edit/%d?message=Series updated successfully", series.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) assignSeriesBookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/series", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/series/assign/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid series ID", err)
+		return
+	}
+
+	bookID, err := strconv.ParseUint(r.FormValue("book_id"), 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book selected", err)
+		return
+	}
+
+	position, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("position")), 64)
+	if err != nil {
+		ws.renderError(w, r, "Position must be a number such as 3 or 2.5", err)
+		return
+	}
+
+	var series models.Series
+	if err := ws.db.First(&series, id).Error; err != nil {
+		ws.renderError(w, r, "Series not found", err)
+		return
+	}
+
+	var book models.Book
+	if err := ws.db.First(&book, bookID).Error; err != nil {
+		ws.renderError(w, r, "Book not found", err)
+		return
+	}
+
+	if err := models.AddBookToSeries(ws.db, series.ID, book.ID, position); err != nil {
+		ws.renderError(w, r, "Failed to add book to series", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/series/edit/%d?message=Book added to series", series.ID), http.StatusSeeOther)
+}
+
+func (ws *WebServer) removeSeriesBookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/series", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/series/remove/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid series ID", err)
+		return
+	}
+
+	bookID, err := strconv.ParseUint(r.FormValue("book_id"), 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid book selected", err)
+		return
+	}
+
+	if err := models.RemoveBookFromSeries(ws.db, uint(id), uint(bookID)); err != nil {
+		ws.renderError(w, r, "Failed to remove book from series", err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/series/edit/%d?message=Book removed from series", id), http.StatusSeeOther)
+}
+
+// The rating scheme, with a form for each level and one to add another.
+func (ws *WebServer) listRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	data := PageData{
+		Title:   "Ratings",
+		Message: r.URL.Query().Get("message"),
+	}
+	ws.renderTemplate(w, r, "ratings", data)
+}
+
+// Fills in the rating level's editable fields from the form. The slug is only read on creation.
+func parseRatingLevelForm(r *http.Request, level *models.RatingLevel) error {
+	sortOrder, err := strconv.Atoi(strings.TrimSpace(r.FormValue("sort_order")))
+	if err != nil {
+		return fmt.Errorf("the sort order must be a number: %w", err)
+	}
+	score := 0
+	if s := strings.TrimSpace(r.FormValue("score")); s != "" {
+		if score, err = strconv.Atoi(s); err != nil {
+			return fmt.Errorf("the score must be a number: %w", err)
+		}
+	}
+	level.Label = r.FormValue("label")
+	level.Description = r.FormValue("description")
+	level.CssClass = r.FormValue("css_class")
+	level.SortOrder = sortOrder
+	level.Score = score
+	return nil
+}
+
+func (ws *WebServer) createRatingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/ratings", http.StatusSeeOther)
+		return
+	}
+
+	level := models.RatingLevel{Slug: r.FormValue("slug")}
+	if err := parseRatingLevelForm(r, &level); err != nil {
+		ws.renderError(w, r, "Invalid rating", err)
+		return
+	}
+	if err := models.SaveRatingLevel(ws.db, &level); err != nil {
+		ws.renderError(w, r, "Failed to add rating", err)
+		return
+	}
+
+	http.Redirect(w, r, "/ratings?message=Rating added", http.StatusSeeOther)
+}
+
+func (ws *WebServer) updateRatingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/ratings", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/ratings/update/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid rating ID", err)
+		return
+	}
+
+	var level models.RatingLevel
+	if err := ws.db.First(&level, id).Error; err != nil {
+		ws.renderError(w, r, "Rating not found", err)
+		return
+	}
+	if err := parseRatingLevelForm(r, &level); err != nil {
+		ws.renderError(w, r, "Invalid rating", err)
+		return
+	}
+	if err := models.SaveRatingLevel(ws.db, &level); err != nil {
+		ws.renderError(w, r, "Failed to save rating", err)
+		return
+	}
+
+	http.Redirect(w, r, "/ratings?message=Rating saved", http.StatusSeeOther)
+}
+
+func (ws *WebServer) deleteRatingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/ratings", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/ratings/delete/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid rating ID", err)
+		return
+	}
+
+	if err := models.DeleteRatingLevel(ws.db, uint(id)); err != nil {
+		ws.renderError(w, r, "Failed to delete rating", err)
+		return
+	}
+
+	http.Redirect(w, r, "/ratings?message=Rating deleted", http.StatusSeeOther)
+}
+
+// Puts one field of a book or author back as it was before a change, then returns to its edit page.
+func (ws *WebServer) revertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/books", http.StatusSeeOther)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/history/revert/")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid change ID", err)
+		return
+	}
+
+	entry, err := models.RevertAuditEntry(ws.db, uint(id))
+	if err != nil {
+		ws.renderError(w, r, "Failed to revert the change", err)
+		return
+	}
+
+	editPage := "/books/edit/"
+	if entry.Entity == models.AuditAuthor {
+		editPage = "/authors/edit/"
+	}
+	http.Redirect(w, r, fmt.Sprintf("%s%d?message=%s reverted", editPage, entry.EntityID, entry.FieldLabel()), http.StatusSeeOther)
+}
+
+func (ws *WebServer) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data PageData) {
+	if session := sessionFrom(r); session != nil {
+		data.CSRFToken = session.CSRFToken
+		data.SignedIn = session.SignedIn
+		data.ReadOnly = !session.CanModify()
+	}
+	data.Ratings = models.RatingLevels()
+	data.Formats = models.BookFormats
+
 	// Parse base template + specific page template
-	tmpl, err := template.ParseFiles("templates/web/base.html", "templates/web/"+name+".html")
+	tmpl, err := template.ParseFiles(ws.settings().WebTemplate("base.html"), ws.settings().WebTemplate(name+".html"))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Template parse error for %s: %v", name, err), http.StatusInternalServerError)
 		return
@@ -499,12 +1394,12 @@ func (ws *WebServer) renderTemplate(w http.ResponseWriter, name string, data Pag
 	}
 }
 
-func (ws *WebServer) renderError(w http.ResponseWriter, message string, err error) {
+func (ws *WebServer) renderError(w http.ResponseWriter, r *http.Request, message string, err error) {
 	data := PageData{
 		Title: "Error",
 		Error: fmt.Sprintf("%s: %v", message, err),
 	}
-	ws.renderTemplate(w, "error", data)
+	ws.renderTemplate(w, r, "error", data)
 }
 
 // OpenLibrary API request/response structures
@@ -537,6 +1432,7 @@ type UpdateRequest struct {
 type CreateRequest struct {
 	AuthorID       uint             `json:"authorId"`
 	Rating         string           `json:"rating"`
+	Format         string           `json:"format"`
 	Review         string           `json:"review"`
 	SelectedResult SearchResultItem `json:"selectedResult"`
 }
@@ -563,8 +1459,11 @@ func (ws *WebServer) searchOpenLibraryHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Search Open Library using the existing model function
-	searchResults := models.SearchBook(req.Title, req.Author)
+	searchResults, err := ws.metadataProvider().SearchBooks(req.Title, req.Author)
+	if err != nil {
+		ws.writeJSONError(w, fmt.Sprintf("Open Library search failed: %v", err), http.StatusBadGateway)
+		return
+	}
 	
 	// Convert to response format and add cover URLs
 	var responseItems []SearchResultItem
@@ -639,7 +1538,7 @@ func (ws *WebServer) updateFromOpenLibraryHandler(w http.ResponseWriter, r *http
 	if updatedBook.HasCoverImageId() {
 		go func() {
 			// Run in background to avoid blocking the response
-			models.CaptureAllSizeCovers(updatedBook, ws.imageDir)
+			models.CaptureAllSizeCovers(ws.metadataProvider(), updatedBook, ws.imageDir)
 		}()
 	}
 
@@ -667,6 +1566,18 @@ func (ws *WebServer) createFromOpenLibraryHandler(w http.ResponseWriter, r *http
 		ws.writeJSONError(w, "Author and rating are required", http.StatusBadRequest)
 		return
 	}
+	if _, err := models.StringToRating(req.Rating); err != nil {
+		ws.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var format models.BookFormat
+	if req.Format != "" {
+		var err error
+		if format, err = models.ParseBookFormat(req.Format); err != nil {
+			ws.writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Find the author
 	var author models.Author
@@ -681,6 +1592,7 @@ func (ws *WebServer) createFromOpenLibraryHandler(w http.ResponseWriter, r *http
 		AuthorFullName: author.FullName,
 		AuthorSurname:  author.Surname,
 		Rating:         req.Rating,
+		Format:         format,
 		Review:         req.Review,
 		DateAdded:      time.Now(),
 	}
@@ -730,7 +1642,7 @@ func (ws *WebServer) createFromOpenLibraryHandler(w http.ResponseWriter, r *http
 	if updatedBook.HasCoverImageId() {
 		go func() {
 			// Run in background to avoid blocking the response
-			models.CaptureAllSizeCovers(updatedBook, ws.imageDir)
+			models.CaptureAllSizeCovers(ws.metadataProvider(), updatedBook, ws.imageDir)
 		}()
 	}
 
@@ -745,7 +1657,7 @@ func (ws *WebServer) createFromOpenLibraryHandler(w http.ResponseWriter, r *http
 
 func (ws *WebServer) listDecadesHandler(w http.ResponseWriter, r *http.Request) {
 	var books []models.Book
-	err := ws.db.Preload("Authors").Find(&books).Error
+	err := ws.db.Preload("Authors").Preload("Credits.Author").Find(&books).Error
 	if err != nil {
 		http.Error(w, "Error fetching books", http.StatusInternalServerError)
 		return
@@ -780,7 +1692,7 @@ func (ws *WebServer) listDecadesHandler(w http.ResponseWriter, r *http.Request)
 		Title:   "All Decades",
 		Decades: decades,
 	}
-	ws.renderTemplate(w, "decades", data)
+	ws.renderTemplate(w, r, "decades", data)
 }
 
 func (ws *WebServer) decadeHandler(w http.ResponseWriter, r *http.Request) {
@@ -791,7 +1703,7 @@ func (ws *WebServer) decadeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var books []models.Book
-	err := ws.db.Preload("Authors").Find(&books).Error
+	err := ws.db.Preload("Authors").Preload("Credits.Author").Find(&books).Error
 	if err != nil {
 		http.Error(w, "Error fetching books", http.StatusInternalServerError)
 		return
@@ -813,14 +1725,40 @@ func (ws *WebServer) decadeHandler(w http.ResponseWriter, r *http.Request) {
 		Title:  "Books from " + decade,
 		Decade: &decadeInfo,
 	}
-	ws.renderTemplate(w, "decade", data)
+	ws.renderTemplate(w, r, "decade", data)
+}
+
+// Every JSON error is sent like this. The message is under "error", which is all the
+// Open Library endpoints used to send, so pages reading it keep working.
+type JSONError struct {
+	Status  int          `json:"status"`
+	Code    string       `json:"code"`
+	Message string       `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// What's wrong with one field of a request, so scripts can report every problem at once.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (ws *WebServer) writeJSONError(w http.ResponseWriter, message string, statusCode int) {
+	ws.writeJSONErrorEnvelope(w, JSONError{Status: statusCode, Message: message})
+}
+
+// The code defaults to the status text, like "not_found" or "unprocessable_entity".
+func (ws *WebServer) writeJSONErrorEnvelope(w http.ResponseWriter, e JSONError) {
+	if e.Code == "" {
+		e.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(e.Status), " ", "_"))
+	}
+	ws.writeJSON(w, e.Status, e)
+}
+
+func (ws *WebServer) writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	response := map[string]string{"error": message}
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(body)
 }
 
 func (ws *WebServer) deployHandler(w http.ResponseWriter, r *http.Request) {
@@ -835,7 +1773,7 @@ func (ws *WebServer) deployHandler(w http.ResponseWriter, r *http.Request) {
 			Title: "SFWR Book Management",
 			Error: fmt.Sprintf("Deployment failed: %v", err),
 		}
-		ws.renderTemplate(w, "home", data)
+		ws.renderTemplate(w, r, "home", data)
 		return
 	}
 
@@ -843,7 +1781,7 @@ func (ws *WebServer) deployHandler(w http.ResponseWriter, r *http.Request) {
 		Title:   "SFWR Book Management",
 		Message: message,
 	}
-	ws.renderTemplate(w, "home", data)
+	ws.renderTemplate(w, r, "home", data)
 }
 
 func (ws *WebServer) buildLocalHandler(w http.ResponseWriter, r *http.Request) {
@@ -936,7 +1874,7 @@ func (ws *WebServer) backupsHandler(w http.ResponseWriter, r *http.Request) {
 			Title: "Database Backups",
 			Error: fmt.Sprintf("Failed to get backup history: %v", err),
 		}
-		ws.renderTemplate(w, "backups", data)
+		ws.renderTemplate(w, r, "backups", data)
 		return
 	}
 
@@ -944,7 +1882,7 @@ func (ws *WebServer) backupsHandler(w http.ResponseWriter, r *http.Request) {
 		Title:   "Database Backups",
 		Commits: commits,
 	}
-	ws.renderTemplate(w, "backups", data)
+	ws.renderTemplate(w, r, "backups", data)
 }
 
 func (ws *WebServer) rollbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -959,7 +1897,7 @@ func (ws *WebServer) rollbackHandler(w http.ResponseWriter, r *http.Request) {
 			Title: "Database Backups",
 			Error: "No commit specified for rollback",
 		}
-		ws.renderTemplate(w, "backups", data)
+		ws.renderTemplate(w, r, "backups", data)
 		return
 	}
 
@@ -968,7 +1906,7 @@ func (ws *WebServer) rollbackHandler(w http.ResponseWriter, r *http.Request) {
 			Title: "Database Backups",
 			Error: fmt.Sprintf("Rollback failed: %v", err),
 		}
-		ws.renderTemplate(w, "backups", data)
+		ws.renderTemplate(w, r, "backups", data)
 		return
 	}
 
@@ -983,5 +1921,5 @@ func (ws *WebServer) rollbackHandler(w http.ResponseWriter, r *http.Request) {
 		data.Commits = commits
 	}
 
-	ws.renderTemplate(w, "backups", data)
+	ws.renderTemplate(w, r, "backups", data)
 }
\ No newline at end of file
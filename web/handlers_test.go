@@ -1,15 +1,23 @@
 package web
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ccdavis/sfwr/models"
+	"github.com/ccdavis/sfwr/openlibrarytest"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -20,7 +28,7 @@ func setupTestDB() *gorm.DB {
 		panic("Failed to connect to test database")
 	}
 
-	err = db.AutoMigrate(&models.Book{}, &models.Author{}, &models.OpenLibraryBookAuthor{}, &models.OpenLibraryBookIsbn{})
+	err = models.MigrateSchema(db)
 	if err != nil {
 		panic("Failed to migrate test database")
 	}
@@ -333,6 +341,310 @@ func TestListAuthors(t *testing.T) {
 	}
 }
 
+func TestMergeAuthorsHandler(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	keep := models.Author{FullName: "C. J. Cherryh", Surname: "Cherryh"}
+	merge := models.Author{FullName: "CJ Cherryh", Surname: "Cherryh"}
+	ws.db.Create(&keep)
+	ws.db.Create(&merge)
+	ws.db.Create(&models.Book{MainTitle: "Cyteen", AuthorFullName: keep.FullName, Authors: []models.Author{keep}})
+	ws.db.Create(&models.Book{MainTitle: "Foreigner", AuthorFullName: merge.FullName, Authors: []models.Author{merge}})
+	browser := newTestBrowser(t, ws)
+
+	rr := browser.do("GET", "/authors/merge", nil, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Merge into C. J. Cherryh") {
+		t.Fatalf("Expected the Cherryhs suggested as duplicates, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	form := url.Values{"keep_id": {idString(keep.ID)}, "merge_id": {idString(keep.ID)}, "csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/authors/merge", form, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Failed to merge") {
+		t.Errorf("Expected an author not to merge into themselves, got %d", rr.Code)
+	}
+
+	form.Set("merge_id", idString(merge.ID))
+	rr = browser.do("POST", "/authors/merge", form, nil)
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/authors/edit/"+idString(keep.ID)+"?message=Merged+CJ+Cherryh") {
+		t.Fatalf("Expected a redirect to the kept author, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	var kept models.Author
+	ws.db.Preload("Books").First(&kept, keep.ID)
+	if len(kept.Books) != 2 {
+		t.Errorf("Expected both books with the kept author, got %d", len(kept.Books))
+	}
+
+	rr = browser.do("GET", "/authors/edit/"+idString(keep.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), "Also Known As") || !strings.Contains(rr.Body.String(), "CJ Cherryh") {
+		t.Error("Expected the edit page to list the merged name")
+	}
+}
+
+func TestAuthorAliasHandlers(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	sheldon := models.Author{FullName: "Alice Sheldon", Surname: "Sheldon"}
+	ws.db.Create(&sheldon)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+	addPath := "/authors/aliases/add/" + idString(sheldon.ID)
+
+	form := url.Values{"alias_name": {"James Tiptree Jr."}, "alias_type": {"pseudonym"}, "csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", addPath, form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the alias to be added, got %d: %s", rr.Code, rr.Body.String())
+	}
+	form.Set("alias_type", "nickname")
+	form.Set("alias_name", "Tip")
+	if rr := browser.do("POST", addPath, form, nil); !strings.Contains(rr.Body.String(), "Invalid alias type") {
+		t.Errorf("Expected an unknown type to be refused, got %d", rr.Code)
+	}
+
+	var alias models.AuthorAlias
+	ws.db.Where("author_id = ?", sheldon.ID).First(&alias)
+	if alias.Name != "James Tiptree Jr." || alias.Type != models.AliasPseudonym {
+		t.Fatalf("Unexpected alias %+v", alias)
+	}
+
+	rr := browser.do("GET", "/authors/edit/"+idString(sheldon.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), "James Tiptree Jr. (pseudonym)") {
+		t.Error("Expected the edit page to list the alias")
+	}
+	// Typing the pseudonym in the book form picks Alice Sheldon
+	rr = browser.do("GET", "/books/new", nil, nil)
+	if !strings.Contains(rr.Body.String(), `<option value="James Tiptree Jr." data-id="`+idString(sheldon.ID)+`">`) {
+		t.Error("Expected the author picker to offer the alias")
+	}
+
+	form = url.Values{"csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/authors/aliases/delete/"+idString(alias.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the alias to be deleted, got %d", rr.Code)
+	}
+	var count int64
+	ws.db.Model(&models.AuthorAlias{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no aliases left, got %d", count)
+	}
+}
+
+
+func TestBookCreditsForm(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	niven := models.Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := models.Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	dozois := models.Author{FullName: "Gardner Dozois", Surname: "Dozois"}
+	ws.db.Create(&niven)
+	ws.db.Create(&pournelle)
+	ws.db.Create(&dozois)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{
+		"main_title":  {"Lucifer's Hammer"},
+		"author_id":   {idString(niven.ID), "", idString(pournelle.ID)},
+		"author_role": {"author", "co_author", "co_author"},
+		"rating":      {"Very-Good"},
+		"csrf_token":  {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/books/create", form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var book models.Book
+	ws.db.Preload("Credits.Author").Where("main_title = ?", "Lucifer's Hammer").First(&book)
+	if book.AuthorDisplayName() != "Niven & Pournelle" || book.AuthorFullName != "Larry Niven" {
+		t.Errorf("Expected both authors credited, got %q filed under %q", book.AuthorDisplayName(), book.AuthorFullName)
+	}
+
+	rr := browser.do("GET", "/books/edit/"+idString(book.ID), nil, nil)
+	if strings.Count(rr.Body.String(), `class="credit-row"`) != 2 || !strings.Contains(rr.Body.String(), `<option value="co_author" selected>`) {
+		t.Error("Expected the edit form to show a row for each person credited")
+	}
+
+	form.Set("main_title", "The Year's Best Science Fiction")
+	form["author_id"] = []string{idString(dozois.ID)}
+	form["author_role"] = []string{"editor"}
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be updated, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = browser.do("GET", "/books", nil, nil)
+	if !strings.Contains(rr.Body.String(), "By ed. Gardner Dozois") {
+		t.Error("Expected the book list to show the editor")
+	}
+
+	form["author_id"] = []string{idString(niven.ID), idString(niven.ID)}
+	form["author_role"] = []string{"author", "co_author"}
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); !strings.Contains(rr.Body.String(), "Failed to update book") {
+		t.Errorf("Expected the same author twice to be refused, got %d", rr.Code)
+	}
+	form["author_role"] = []string{"author", "ghostwriter"}
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); !strings.Contains(rr.Body.String(), "Invalid author selected") {
+		t.Errorf("Expected an unknown role to be refused, got %d", rr.Code)
+	}
+}
+
+func TestAnthologyContentsHandlers(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	ellison := models.Author{FullName: "Harlan Ellison", Surname: "Ellison"}
+	ws.db.Create(&ellison)
+	book := models.Book{MainTitle: "Dangerous Visions", AuthorFullName: ellison.FullName, Authors: []models.Author{ellison}}
+	ws.db.Create(&book)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{
+		"work_title":    {"Aye, and Gomorrah"},
+		"work_authors":  {"Samuel R. Delany"},
+		"work_pub_date": {"1967"},
+		"work_length":   {"short_story"},
+		"work_rating":   {"Excellent"},
+		"csrf_token":    {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/books/contents/add/"+idString(book.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the story to be added, got %d: %s", rr.Code, rr.Body.String())
+	}
+	form.Set("work_title", "Riders of the Purple Wage")
+	form.Set("work_authors", "Philip José Farmer")
+	form.Set("work_length", "epic")
+	if rr := browser.do("POST", "/books/contents/add/"+idString(book.ID), form, nil); !strings.Contains(rr.Body.String(), "Invalid story length") {
+		t.Errorf("Expected an unknown length to be refused, got %d", rr.Code)
+	}
+
+	rr := browser.do("GET", "/books/edit/"+idString(book.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), "Aye, and Gomorrah by Samuel R. Delany (short story, 1967)") {
+		t.Fatal("Expected the edit page to list the contents")
+	}
+
+	var entry models.ContentsEntry
+	ws.db.First(&entry)
+	form = url.Values{"work_rating": {"Kindle"}, "csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/books/contents/rate/"+idString(entry.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the rating to be saved, got %d", rr.Code)
+	}
+	ws.db.First(&entry, entry.ID)
+	if entry.Rating != "Kindle" {
+		t.Errorf("Expected the story rated Kindle, got %q", entry.Rating)
+	}
+
+	form = url.Values{"csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/books/contents/delete/"+idString(entry.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the story to be removed, got %d", rr.Code)
+	}
+	var count int64
+	ws.db.Model(&models.ContentsEntry{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no contents left, got %d", count)
+	}
+}
+
+func TestRatingHandlers(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	defer models.UseRatingLevels(models.DefaultRatingLevels())
+	author := models.Author{FullName: "Samuel R. Delany", Surname: "Delany"}
+	ws.db.Create(&author)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{
+		"slug":       {"Masterpiece"},
+		"label":      {"Masterpiece"},
+		"sort_order": {"0"},
+		"score":      {"6"},
+		"css_class":  {"rating-masterpiece"},
+		"csrf_token": {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/ratings/create", form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the rating to be added, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := browser.do("POST", "/ratings/create", form, nil); !strings.Contains(rr.Body.String(), "Failed to add rating") {
+		t.Error("Expected a repeated slug to be refused")
+	}
+	var level models.RatingLevel
+	ws.db.Where("slug = ?", "Masterpiece").First(&level)
+
+	rr := browser.do("GET", "/books/new", nil, nil)
+	if !strings.Contains(rr.Body.String(), `value="Masterpiece"`) || !strings.Contains(rr.Body.String(), "Masterpiece (6)") {
+		t.Error("Expected the book form to offer the new rating")
+	}
+
+	book := url.Values{
+		"main_title": {"Dhalgren"},
+		"author_id":  {idString(author.ID)},
+		"rating":     {"Masterpiece"},
+		"csrf_token": {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/books/create", book, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a book with the new rating to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	book.Set("rating", "Meh")
+	if rr := browser.do("POST", "/books/create", book, nil); !strings.Contains(rr.Body.String(), "Invalid rating") {
+		t.Error("Expected an unknown rating to be refused")
+	}
+
+	form = url.Values{"label": {"A Masterpiece"}, "sort_order": {"0"}, "score": {""}, "csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/ratings/update/"+idString(level.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the rating to be saved, got %d: %s", rr.Code, rr.Body.String())
+	}
+	ws.db.First(&level, level.ID)
+	if level.Label != "A Masterpiece" || level.Score != 0 || level.Slug != "Masterpiece" {
+		t.Errorf("Unexpected saved rating %+v", level)
+	}
+
+	form = url.Values{"csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/ratings/delete/"+idString(level.ID), form, nil); !strings.Contains(rr.Body.String(), "still used by 1 books") {
+		t.Error("Expected a rating a book has not to be deleted")
+	}
+	ws.db.Model(&models.Book{}).Where("main_title = ?", "Dhalgren").Update("rating", "Excellent")
+	if rr := browser.do("POST", "/ratings/delete/"+idString(level.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the rating to be deleted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := browser.do("GET", "/ratings", nil, nil); strings.Contains(rr.Body.String(), "Masterpiece") || !strings.Contains(rr.Body.String(), "Very Good") {
+		t.Error("Expected the ratings page to list the remaining ratings")
+	}
+}
+
+func TestBookFormatForm(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	author := models.Author{FullName: "Andy Weir", Surname: "Weir"}
+	ws.db.Create(&author)
+	ws.db.Create(&models.Book{MainTitle: "Dune", Rating: "Excellent", Format: models.Hardcover})
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{
+		"main_title": {"The Martian"},
+		"author_id":  {idString(author.ID)},
+		"rating":     {"Excellent"},
+		"format":     {"self_published"},
+		"csrf_token": {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/books/create", form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var book models.Book
+	ws.db.Where("main_title = ?", "The Martian").First(&book)
+	if book.Format != models.SelfPublished || book.Rating != "Excellent" {
+		t.Errorf("Expected the format apart from the rating, got %q %q", book.Format, book.Rating)
+	}
+
+	rr := browser.do("GET", "/books/edit/"+idString(book.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), `<option value="self_published" selected>Self-published</option>`) {
+		t.Error("Expected the edit form to show the format")
+	}
+	rr = browser.do("GET", "/books?format=self_published", nil, nil)
+	if !strings.Contains(rr.Body.String(), "The Martian") || strings.Contains(rr.Body.String(), "Dune") {
+		t.Error("Expected the book list to filter by format")
+	}
+
+	form.Set("format", "scroll")
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); !strings.Contains(rr.Body.String(), "Invalid format") {
+		t.Error("Expected an unknown format to be refused")
+	}
+	form.Set("format", "")
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be updated, got %d", rr.Code)
+	}
+	ws.db.First(&book, book.ID)
+	if book.Format != "" {
+		t.Errorf("Expected the format cleared, got %q", book.Format)
+	}
+}
+
 func TestDeployHandler(t *testing.T) {
 	ws := setupTestServer()
 
@@ -523,4 +835,428 @@ func TestUpdateBookWithMultipleAuthors(t *testing.T) {
 	if len(updatedBook.Authors) > 0 && updatedBook.Authors[0].ID != author2.ID {
 		t.Errorf("Expected author to be changed to author2")
 	}
-}
\ No newline at end of file
+}
+func TestCreateSeriesAndAssignBook(t *testing.T) {
+	ws := setupTestServer()
+
+	book := models.Book{
+		MainTitle:      "Shards of Honor",
+		AuthorFullName: "Lois McMaster Bujold",
+		AuthorSurname:  "Bujold",
+		Rating:         "Very-Good",
+	}
+	ws.db.Create(&book)
+
+	data := url.Values{}
+	data.Set("name", "Vorkosigan Saga")
+	data.Set("description", "Miles and family")
+
+	req, err := http.NewRequest("POST", "/series/create", strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.createSeriesHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	var series models.Series
+	if err := ws.db.Where("name = ?", "Vorkosigan Saga").First(&series).Error; err != nil {
+		t.Fatalf("Series was not created in database: %v", err)
+	}
+	if series.Slug != "vorkosigan-saga" {
+		t.Errorf("Expected slug 'vorkosigan-saga', got '%s'", series.Slug)
+	}
+
+	data = url.Values{}
+	data.Set("book_id", fmt.Sprintf("%d", book.ID))
+	data.Set("position", "2.5")
+
+	req, err = http.NewRequest("POST", fmt.Sprintf("/series/assign/%d", series.ID), strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ws.assignSeriesBookHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	loaded, err := models.LoadSeries(ws.db, series.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != 1 {
+		t.Fatalf("Expected 1 book in series, got %d", len(loaded.Entries))
+	}
+	if loaded.Entries[0].Position != 2.5 || loaded.Entries[0].BookID != book.ID {
+		t.Errorf("Unexpected series entry: %+v", loaded.Entries[0])
+	}
+
+	// A position that isn't a number is rejected
+	data.Set("position", "second")
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/series/assign/%d", series.ID), strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ws.assignSeriesBookHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status == http.StatusSeeOther {
+		t.Error("Expected an invalid position not to redirect as a success")
+	}
+}
+
+func TestUpdateBookTagsAndFilter(t *testing.T) {
+	ws := setupTestServer()
+
+	author := models.Author{
+		FullName: "William Gibson",
+		Surname:  "Gibson",
+	}
+	ws.db.Create(&author)
+
+	book := models.Book{
+		MainTitle:      "Neuromancer",
+		AuthorFullName: author.FullName,
+		AuthorSurname:  author.Surname,
+		Rating:         "Excellent",
+	}
+	ws.db.Create(&book)
+
+	data := url.Values{}
+	data.Set("main_title", "Neuromancer")
+	data.Set("author_id", fmt.Sprintf("%d", author.ID))
+	data.Set("rating", "Excellent")
+	data.Set("tags", "cyberpunk, AI, Cyberpunk")
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/books/update/%d", book.ID), strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.updateBookHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	var updated models.Book
+	ws.db.Preload("Tags").First(&updated, book.ID)
+	if updated.TagNames() != "AI, cyberpunk" {
+		t.Errorf("Expected tags 'AI, cyberpunk', got '%s'", updated.TagNames())
+	}
+
+	var tagged []models.Book
+	ws.db.Scopes(models.WithTag("ai")).Find(&tagged)
+	if len(tagged) != 1 || tagged[0].ID != book.ID {
+		t.Errorf("Expected the book to be found by its tag, got %d books", len(tagged))
+	}
+}
+
+func TestAddAndDeleteReadingSession(t *testing.T) {
+	ws := setupTestServer()
+
+	book := models.Book{MainTitle: "Dune", Rating: "Excellent"}
+	ws.db.Create(&book)
+
+	data := url.Values{}
+	data.Set("start_date", "2024-01-05")
+	data.Set("finish_date", "2024-02-01")
+	data.Set("format", "ebook")
+	data.Set("note", "Second read")
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/books/readings/add/%d", book.ID), strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.addReadingSessionHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+
+	var session models.ReadingSession
+	if err := ws.db.Where("book_id = ?", book.ID).First(&session).Error; err != nil {
+		t.Fatal("Reading session was not saved:", err)
+	}
+	if session.FormatFinishDate() != "2024-02-01" || session.Format != "ebook" || session.Note != "Second read" {
+		t.Errorf("Reading session saved incorrectly: %+v", session)
+	}
+
+	req, err = http.NewRequest("POST", fmt.Sprintf("/books/readings/delete/%d", session.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ws.deleteReadingSessionHandler).ServeHTTP(rr, req)
+
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, fmt.Sprintf("/books/edit/%d", book.ID)) {
+		t.Errorf("Expected redirect back to the book, got %s", location)
+	}
+
+	var count int64
+	ws.db.Model(&models.ReadingSession{}).Where("book_id = ?", book.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected reading session to be deleted, found %d", count)
+	}
+}
+
+func TestAddReadingSessionRejectsBadDates(t *testing.T) {
+	ws := setupTestServer()
+
+	book := models.Book{MainTitle: "Dune", Rating: "Excellent"}
+	ws.db.Create(&book)
+
+	data := url.Values{}
+	data.Set("start_date", "2024-03-01")
+	data.Set("finish_date", "2024-02-01")
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/books/readings/add/%d", book.ID), strings.NewReader(data.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.addReadingSessionHandler).ServeHTTP(rr, req)
+
+	if rr.Code == http.StatusSeeOther {
+		t.Error("Expected a session finishing before it started to be rejected")
+	}
+
+	var count int64
+	ws.db.Model(&models.ReadingSession{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected no reading sessions, found %d", count)
+	}
+}
+
+func setupTestServerWithOpenLibrary(t *testing.T) (*WebServer, *openlibrarytest.Server) {
+	server := openlibrarytest.NewServer()
+	t.Cleanup(server.Close)
+	ws := setupTestServer()
+	ws.imageDir = t.TempDir()
+	ws.metadata = &models.OpenLibrary{BaseUrl: server.URL, CoversUrl: server.URL, Client: server.Client()}
+	return ws, server
+}
+
+func TestSearchOpenLibrary(t *testing.T) {
+	ws, server := setupTestServerWithOpenLibrary(t)
+
+	body := `{"title": "The Left Hand of Darkness", "author": "Ursula K. Le Guin"}`
+	req, err := http.NewRequest("POST", "/books/search-openlibrary", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.searchOpenLibraryHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var response SearchResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 1 || response.Results[0].CoverEditionKey != "OL7274279M" || response.Results[0].CoverImageID != "8231856" {
+		t.Errorf("Unexpected search results %+v", response.Results)
+	}
+
+	server.FailNext("/search.json", 1)
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/books/search-openlibrary", strings.NewReader(body))
+	http.HandlerFunc(ws.searchOpenLibraryHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadGateway {
+		t.Errorf("Expected a failed search to return %v, got %v", http.StatusBadGateway, status)
+	}
+}
+
+func TestCreateFromOpenLibrary(t *testing.T) {
+	ws, _ := setupTestServerWithOpenLibrary(t)
+
+	author := models.Author{FullName: "Frank Herbert", Surname: "Herbert"}
+	ws.db.Create(&author)
+
+	body := fmt.Sprintf(`{"authorId": %d, "rating": "Excellent", "selectedResult": {"title": "Dune",
+		"authors": ["Frank Herbert"], "first_year_published": 1965, "cover_edition_key": "OL26242482M", "cover_image_id": "11481354"}}`, author.ID)
+	req, err := http.NewRequest("POST", "/books/create-from-openlibrary", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.createFromOpenLibraryHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+	var book models.Book
+	if err := ws.db.Where("main_title = ?", "Dune").First(&book).Error; err != nil {
+		t.Fatal("Book was not created:", err)
+	}
+	if book.OlCoverEditionId != "OL26242482M" || book.OlCoverId != 11481354 || book.PubDate != 1965 {
+		t.Errorf("Book saved incorrectly: %+v", book)
+	}
+
+	// Covers are downloaded in the background
+	coverFile := filepath.Join(ws.imageDir, "11481354-L.jpg")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(coverFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Cover image was never saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func coverUploadRequest(t *testing.T, bookId uint, image []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("cover", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(image)
+	form.Close()
+	req, err := http.NewRequest("POST", fmt.Sprintf("/books/cover/upload/%d", bookId), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestUploadCover(t *testing.T) {
+	ws := setupTestServer()
+	ws.imageDir = t.TempDir()
+
+	book := models.Book{MainTitle: "Self Published", Rating: "Kindle", OlCoverId: models.Missing}
+	ws.db.Create(&book)
+
+	cover := image.NewRGBA(image.Rect(0, 0, 200, 300))
+	var pngData bytes.Buffer
+	png.Encode(&pngData, cover)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ws.uploadCoverHandler).ServeHTTP(rr, coverUploadRequest(t, book.ID, pngData.Bytes()))
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusSeeOther, rr.Body.String())
+	}
+
+	var updated models.Book
+	ws.db.First(&updated, book.ID)
+	if !updated.HasUploadedCover() {
+		t.Fatalf("Expected an uploaded cover, got %q", updated.CoverSource)
+	}
+	if _, err := os.Stat(updated.MakeCoverImageFilename(ws.imageDir, models.MediumCover)); err != nil {
+		t.Errorf("Uploaded cover not saved: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ws.uploadCoverHandler).ServeHTTP(rr, coverUploadRequest(t, book.ID, []byte("not an image")))
+	if rr.Code == http.StatusSeeOther {
+		t.Error("Expected a file that isn't an image to be rejected")
+	}
+}
+
+func TestBookHistoryAndRevert(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	author := models.Author{FullName: "Vonda N. McIntyre", Surname: "McIntyre"}
+	ws.db.Create(&author)
+	book := models.Book{MainTitle: "Dreamsnake", Rating: "Very-Good", PubDate: 1978, Authors: []models.Author{author}}
+	ws.db.Create(&book)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{
+		"main_title": {"Dreamsnake"},
+		"author_id":  {idString(author.ID)},
+		"rating":     {"Excellent"},
+		"pub_date":   {"1978"},
+		"review":     {"Healers and their snakes."},
+		"csrf_token": {browser.csrfToken()},
+	}
+	if rr := browser.do("POST", "/books/update/"+idString(book.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be updated, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := browser.do("GET", "/books/edit/"+idString(book.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), "Healers and their snakes.") || !strings.Contains(rr.Body.String(), "/history/revert/") {
+		t.Fatalf("Expected the edit page to show the book's history: %s", rr.Body.String())
+	}
+
+	history, _ := models.LoadAuditHistory(ws.db, models.AuditBook, book.ID)
+	var ratingChange models.AuditEntry
+	for _, entry := range history {
+		if entry.Field == "rating" && entry.NewValue == "Excellent" {
+			ratingChange = entry
+		}
+	}
+	if ratingChange.Source != models.AuditSourceWeb || ratingChange.OldValue != "Very-Good" {
+		t.Fatalf("Expected the rating change to be recorded as from the web, got %+v", ratingChange)
+	}
+	revert := url.Values{"csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/history/revert/"+idString(ratingChange.ID), revert, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the rating to be reverted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var saved models.Book
+	ws.db.First(&saved, book.ID)
+	if saved.Rating != "Very-Good" || saved.Review != "Healers and their snakes." {
+		t.Errorf("Expected only the rating to be put back, got %q and %q", saved.Rating, saved.Review)
+	}
+
+	header := http.Header{csrfHeader: {browser.csrfToken()}}
+	if rr := browser.do("DELETE", "/api/v1/books/"+idString(book.ID), nil, header); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected the book to be deleted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	history, _ = models.LoadAuditHistory(ws.db, models.AuditBook, book.ID)
+	if history[0].Field != "deleted_at" || history[0].Source != models.AuditSourceAPI {
+		t.Errorf("Expected the deletion to be recorded as from the API, got %+v", history[0])
+	}
+}
+
+func TestAuthorHistory(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	author := models.Author{FullName: "Kate Wilhelm", Surname: "Wilhelm"}
+	ws.db.Create(&author)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+
+	form := url.Values{"full_name": {"Kate Wilhelm Knight"}, "csrf_token": {browser.csrfToken()}}
+	if rr := browser.do("POST", "/authors/update/"+idString(author.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the author to be updated, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := browser.do("GET", "/authors/edit/"+idString(author.ID), nil, nil)
+	if !strings.Contains(rr.Body.String(), "Kate Wilhelm Knight") || !strings.Contains(rr.Body.String(), "Full name") {
+		t.Errorf("Expected the edit page to show the rename: %s", rr.Body.String())
+	}
+
+	history, _ := models.LoadAuditHistory(ws.db, models.AuditAuthor, author.ID)
+	var rename models.AuditEntry
+	for _, entry := range history {
+		if entry.Field == "full_name" && entry.OldValue == "Kate Wilhelm" {
+			rename = entry
+		}
+	}
+	revert := url.Values{"csrf_token": {browser.csrfToken()}}
+	rr = browser.do("POST", "/history/revert/"+idString(rename.ID), revert, nil)
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(rr.Header().Get("Location"), "/authors/edit/") {
+		t.Fatalf("Expected to go back to the author's page, got %d: %s", rr.Code, rr.Header().Get("Location"))
+	}
+	ws.db.First(&author, author.ID)
+	if author.FullName != "Kate Wilhelm" {
+		t.Errorf("Expected the name to be put back, got %s", author.FullName)
+	}
+}
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ccdavis/sfwr/models"
+)
+
+func (ws *WebServer) trashHandler(w http.ResponseWriter, r *http.Request) {
+	trash, err := models.LoadTrash(ws.db)
+	if err != nil {
+		ws.renderError(w, r, "Failed to load the trash", err)
+		return
+	}
+	data := PageData{
+		Title:      "Trash",
+		Books:      trash.Books,
+		Authors:    trash.Authors,
+		Message:    r.URL.Query().Get("message"),
+		PurgeAfter: ws.settings().Trash.PurgeAfterDays,
+	}
+	ws.renderTemplate(w, r, "trash", data)
+}
+
+// Reads the ID from a trash action's path. Anything but a POST goes back to the trash.
+func (ws *WebServer) trashItemId(w http.ResponseWriter, r *http.Request, prefix string) (uint, bool) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, prefix), 10, 32)
+	if err != nil {
+		ws.renderError(w, r, "Invalid ID", err)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (ws *WebServer) restoreBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ws.trashItemId(w, r, "/trash/books/restore/")
+	if !ok {
+		return
+	}
+	if err := models.RestoreBook(ws.db, id); err != nil {
+		ws.renderError(w, r, "Failed to restore book", err)
+		return
+	}
+	http.Redirect(w, r, "/trash?message=Book restored", http.StatusSeeOther)
+}
+
+func (ws *WebServer) purgeBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ws.trashItemId(w, r, "/trash/books/purge/")
+	if !ok {
+		return
+	}
+	if err := models.PurgeBook(ws.db, ws.imageDir, id); err != nil {
+		ws.renderError(w, r, "Failed to purge book", err)
+		return
+	}
+	http.Redirect(w, r, "/trash?message=Book purged", http.StatusSeeOther)
+}
+
+func (ws *WebServer) restoreAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ws.trashItemId(w, r, "/trash/authors/restore/")
+	if !ok {
+		return
+	}
+	if err := models.RestoreAuthor(ws.db, id); err != nil {
+		ws.renderError(w, r, "Failed to restore author", err)
+		return
+	}
+	http.Redirect(w, r, "/trash?message=Author restored", http.StatusSeeOther)
+}
+
+func (ws *WebServer) purgeAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ws.trashItemId(w, r, "/trash/authors/purge/")
+	if !ok {
+		return
+	}
+	if err := models.PurgeAuthor(ws.db, id); err != nil {
+		ws.renderError(w, r, "Failed to purge author", err)
+		return
+	}
+	http.Redirect(w, r, "/trash?message=Author purged", http.StatusSeeOther)
+}
+
+// Purges what has been in the trash longer than the config allows, at startup and then once a day.
+func (ws *WebServer) purgeTrashDaily() {
+	days := ws.settings().Trash.PurgeAfterDays
+	if days == 0 {
+		return
+	}
+	for {
+		ws.purgeOldTrash(days)
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+func (ws *WebServer) purgeOldTrash(days int) {
+	report, err := models.PurgeTrashBefore(models.WithAuditSource(ws.db, models.AuditSourcePurge), ws.imageDir, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		fmt.Printf("Warning: can't purge the trash: %v\n", err)
+	}
+	if report.Books > 0 || report.Authors > 0 {
+		fmt.Printf("Purged %d books and %d authors deleted more than %d days ago\n", report.Books, report.Authors, days)
+	}
+	for _, kept := range report.Kept {
+		fmt.Printf("Left in the trash: %v\n", kept)
+	}
+}
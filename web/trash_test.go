@@ -0,0 +1,108 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ccdavis/sfwr/models"
+)
+
+func TestTrashHandlers(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	author := models.Author{FullName: "Joanna Russ", Surname: "Russ"}
+	ws.db.Create(&author)
+	kept := models.Book{MainTitle: "The Female Man", AuthorFullName: author.FullName, Authors: []models.Author{author}}
+	purged := models.Book{MainTitle: "Picnic on Paradise", AuthorFullName: author.FullName, Authors: []models.Author{author}}
+	ws.db.Create(&kept)
+	ws.db.Create(&purged)
+	browser := newTestBrowser(t, ws)
+	browser.do("GET", "/", nil, nil)
+	form := url.Values{"csrf_token": {browser.csrfToken()}}
+
+	for _, book := range []models.Book{kept, purged} {
+		if rr := browser.do("POST", "/books/delete/"+idString(book.ID), form, nil); rr.Code != http.StatusSeeOther {
+			t.Fatalf("Expected the book to be deleted, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	rr := browser.do("GET", "/trash", nil, nil)
+	if !strings.Contains(rr.Body.String(), "The Female Man") || !strings.Contains(rr.Body.String(), "Picnic on Paradise") {
+		t.Fatalf("Expected the deleted books in the trash: %s", rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "more than 30 days ago") {
+		t.Error("Expected the trash to say when things are purged")
+	}
+
+	if rr := browser.do("POST", "/trash/books/restore/"+idString(kept.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be restored, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := ws.db.First(&models.Book{}, kept.ID).Error; err != nil {
+		t.Error("The restored book can't be found:", err)
+	}
+	if rr := browser.do("POST", "/trash/books/purge/"+idString(purged.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the book to be purged, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var count int64
+	ws.db.Unscoped().Model(&models.Book{}).Where("id = ?", purged.ID).Count(&count)
+	if count != 0 {
+		t.Error("The purged book is still in the database")
+	}
+	if rr := browser.do("POST", "/trash/books/purge/"+idString(kept.ID), form, nil); !strings.Contains(rr.Body.String(), "not in the trash") {
+		t.Error("Expected a book that isn't in the trash not to be purged")
+	}
+
+	ws.db.Delete(&author)
+	if rr := browser.do("POST", "/trash/authors/purge/"+idString(author.ID), form, nil); !strings.Contains(rr.Body.String(), "still has books") {
+		t.Error("Expected an author with a book not to be purged")
+	}
+	if rr := browser.do("POST", "/trash/authors/restore/"+idString(author.ID), form, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected the author to be restored, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := browser.do("GET", "/trash", nil, nil); !strings.Contains(rr.Body.String(), "The trash is empty") {
+		t.Error("Expected the trash to be empty")
+	}
+}
+
+// Books are shown by everyone credited, even authors who are in the trash themselves.
+func TestTrashShowsCredits(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	niven := models.Author{FullName: "Larry Niven", Surname: "Niven"}
+	pournelle := models.Author{FullName: "Jerry Pournelle", Surname: "Pournelle"}
+	ws.db.Create(&niven)
+	ws.db.Create(&pournelle)
+	book := models.Book{MainTitle: "The Mote in God's Eye", Rating: "Excellent"}
+	ws.db.Create(&book)
+	credits := []models.Credit{{AuthorID: niven.ID}, {AuthorID: pournelle.ID, Role: models.CreditCoAuthor}}
+	if err := models.SetBookCredits(ws.db, &book, credits); err != nil {
+		t.Fatal(err)
+	}
+	ws.db.Delete(&book)
+	ws.db.Delete(&pournelle)
+
+	rr := newTestBrowser(t, ws).do("GET", "/trash", nil, nil)
+	if !strings.Contains(rr.Body.String(), "<strong>Author:</strong> Niven &amp; Pournelle") {
+		t.Errorf("Expected both authors of the deleted book: %s", rr.Body.String())
+	}
+}
+
+func TestPurgeOldTrash(t *testing.T) {
+	ws := setupAuthTestServer(t, Credentials{})
+	old := models.Book{MainTitle: "Old"}
+	recent := models.Book{MainTitle: "Recent"}
+	ws.db.Create(&old)
+	ws.db.Create(&recent)
+	ws.db.Delete(&old)
+	ws.db.Delete(&recent)
+	ws.db.Unscoped().Model(&old).Update("deleted_at", time.Now().AddDate(0, 0, -31))
+
+	ws.purgeOldTrash(30)
+	trash, err := models.LoadTrash(ws.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Books) != 1 || trash.Books[0].MainTitle != "Recent" {
+		t.Errorf("Expected only the recently deleted book to be left, got %v", trash.Books)
+	}
+}